@@ -13,6 +13,7 @@ import (
 
 	"github.com/funkygao/gafka"
 	"github.com/funkygao/gafka/cmd/actord/controller"
+	"github.com/funkygao/gafka/cmd/actord/executor"
 	"github.com/funkygao/gafka/cmd/kateway/hh"
 	"github.com/funkygao/gafka/cmd/kateway/hh/disk"
 	"github.com/funkygao/gafka/cmd/kateway/meta"
@@ -47,6 +48,11 @@ func init() {
 	flag.StringVar(&Options.InfluxDbname, "influxdb", "", "influxdb db name")
 	flag.StringVar(&Options.ListenAddr, "addr", ":9065", "monitor http server addr")
 	flag.StringVar(&Options.HintedHandoffDir, "hhdirs", "hh", "hinted handoff dirs separated by comma")
+	flag.StringVar(&Options.JobStore, "jstore", "mysql", "job store <mysql|disk>")
+	flag.StringVar(&Options.JobStoreDir, "jdir", "jobdata", "disk job store dir shared with kateway")
+	flag.StringVar(&Options.Assignor, "assign", "range", "resource assignment strategy of all actors <range|sticky>")
+	flag.IntVar(&Options.Capacity, "capacity", controller.DefaultActorCapacity, "actor weight in sticky assignment")
+	flag.IntVar(&Options.XaMaxCheckbacks, "xachecks", executor.XaMaxCheckbacks, "max unknown checkbacks of a XA prepared message before it is kept unresolved")
 	flag.Parse()
 
 	if Options.ShowVersion {
@@ -110,7 +116,9 @@ func Main() {
 	}
 	log.Trace("pub store[%s] started", store.DefaultPubStore.Name())
 
-	c := controller.New(zkzone, Options.ListenAddr, Options.ManagerType)
+	executor.XaMaxCheckbacks = Options.XaMaxCheckbacks
+	c := controller.New(zkzone, Options.ListenAddr, Options.ManagerType, Options.JobStore, Options.JobStoreDir,
+		Options.Assignor, Options.Capacity)
 
 	cfg := disk.DefaultConfig()
 	cfg.Dirs = strings.Split(Options.HintedHandoffDir, ",")
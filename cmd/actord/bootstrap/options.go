@@ -11,4 +11,9 @@ var Options struct {
 	ListenAddr       string
 	ManagerType      string
 	HintedHandoffDir string
+	JobStore         string
+	JobStoreDir      string
+	Assignor         string
+	Capacity         int
+	XaMaxCheckbacks  int
 }
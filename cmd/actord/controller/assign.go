@@ -1,11 +1,140 @@
 package controller
 
 import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
 	"sort"
 
 	"github.com/funkygao/gafka/zk"
+	log "github.com/funkygao/log4go"
 )
 
+// DefaultActorCapacity is the weight of an actor that declares no capacity.
+const DefaultActorCapacity = 100
+
+// Assignor decides which resources each actor executes.
+// Every actor runs it on the same input independently, so it must be deterministic
+// and all the actors of a zone must use the same assignor.
+type Assignor interface {
+	Name() string
+
+	// Assign distributes resources over actors, capacities is keyed by actor id.
+	Assign(actors zk.ActorList, capacities map[string]int, resources zk.ResourceList) map[string]zk.ResourceList
+}
+
+// NewAssignor returns the assignor by name: range or sticky.
+func NewAssignor(name string) (Assignor, error) {
+	switch name {
+	case "range":
+		return rangeAssignor{}, nil
+
+	case "sticky":
+		return stickyAssignor{}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown assignor: %s", name)
+	}
+}
+
+// assign decides the resources of each actor with the capacities the actors registered.
+// The capacity is what an actor declares at startup, the observed load of the actors is not
+// taken into account.
+// Every actor must decide on the same capacities, so if the registration of any actor can't be
+// read, e,g. it just left, the rebalance is skipped and retried.
+func (this *controller) assign(actors zk.ActorList, resources zk.ResourceList) (map[string]zk.ResourceList, error) {
+	registrations := this.orchestrator.ChildrenWithData(zk.PubsubActors)
+	capacities := make(map[string]int, len(actors))
+	for _, id := range actors {
+		zdata, present := registrations[id]
+		if !present {
+			return nil, fmt.Errorf("actor %s: registration not found", id)
+		}
+
+		var actor struct {
+			Assignor string `json:"assignor"`
+			Capacity int    `json:"capacity"`
+		}
+		if err := json.Unmarshal(zdata.Data(), &actor); err != nil {
+			return nil, fmt.Errorf("actor %s: %s", id, err)
+		}
+
+		if actor.Assignor == "" {
+			// actor started before assignor is pluggable
+			actor.Assignor = "range"
+		}
+		if actor.Assignor != this.assignor.Name() {
+			// resources might be left unclaimed
+			log.Warn("actor %s assignor %s differs from mine %s", id, actor.Assignor, this.assignor.Name())
+		}
+		capacities[id] = actor.Capacity
+	}
+
+	return this.assignor.Assign(actors, capacities, resources), nil
+}
+
+// rangeAssignor splits the sorted resources into contiguous ranges of the sorted actors.
+// It ignores capacities, and adding an actor reshuffles almost every resource.
+type rangeAssignor struct{}
+
+func (rangeAssignor) Name() string {
+	return "range"
+}
+
+func (rangeAssignor) Assign(actors zk.ActorList, capacities map[string]int, resources zk.ResourceList) map[string]zk.ResourceList {
+	return assignResourcesToActors(actors, resources)
+}
+
+// stickyAssignor is weighted rendezvous hashing: a resource goes to the actor with the
+// highest weighted hash score of the pair. When an actor joins or leaves, only the resources
+// it gains or loses move, and an actor gets resources in proportion to its capacity.
+type stickyAssignor struct{}
+
+func (stickyAssignor) Name() string {
+	return "sticky"
+}
+
+func (stickyAssignor) Assign(actors zk.ActorList, capacities map[string]int, resources zk.ResourceList) map[string]zk.ResourceList {
+	decision := make(map[string]zk.ResourceList)
+	if len(actors) == 0 {
+		return decision
+	}
+
+	sort.Sort(resources)
+	for _, resource := range resources {
+		var (
+			owner     string
+			bestScore = math.Inf(-1)
+		)
+		for _, actor := range actors {
+			capacity, present := capacities[actor]
+			if !present || capacity <= 0 {
+				capacity = DefaultActorCapacity
+			}
+
+			score := rendezvousScore(actor, resource, capacity)
+			if score > bestScore || (score == bestScore && actor < owner) {
+				owner, bestScore = actor, score
+			}
+		}
+
+		decision[owner] = append(decision[owner], resource)
+	}
+
+	return decision
+}
+
+// rendezvousScore is -w/ln(h) where h is the pair hash uniformly mapped to (0, 1).
+func rendezvousScore(actor, resource string, weight int) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(actor))
+	h.Write([]byte{0})
+	h.Write([]byte(resource))
+	u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+	return -float64(weight) / math.Log(u)
+}
+
 func assignResourcesToActors(actors zk.ActorList, resources zk.ResourceList) (decision map[string]zk.ResourceList) {
 	decision = make(map[string]zk.ResourceList)
 
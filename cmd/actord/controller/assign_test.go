@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/funkygao/assert"
@@ -34,3 +35,96 @@ func TestAssignResourcesToActors_ActorMoreThanJobQueues(t *testing.T) {
 	assert.Equal(t, 0, len(decision["2"]))
 	assert.Equal(t, 1, len(decision["1"]))
 }
+
+func genActorsAndResources(nActors, nResources int) (zk.ActorList, zk.ResourceList) {
+	actors := make(zk.ActorList, nActors)
+	for i := range actors {
+		actors[i] = fmt.Sprintf("host%d:95f333fb-731c-9c95-c598-8d6b99a9ec%02d", i, i)
+	}
+	resources := make(zk.ResourceList, nResources)
+	for i := range resources {
+		resources[i] = fmt.Sprintf("app%d.topic%d.v1", i%7, i)
+	}
+	return actors, resources
+}
+
+// moved counts the resources whose owner changed between the decisions.
+func moved(before, after map[string]zk.ResourceList) int {
+	owners := make(map[string]string)
+	for actor, resources := range before {
+		for _, r := range resources {
+			owners[r] = actor
+		}
+	}
+
+	n := 0
+	for actor, resources := range after {
+		for _, r := range resources {
+			if owners[r] != actor {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestAssignorMovementOnActorJoin(t *testing.T) {
+	for _, name := range []string{"range", "sticky"} {
+		actors, resources := genActorsAndResources(11, 1000)
+		assignor, err := NewAssignor(name)
+		assert.Equal(t, nil, err)
+
+		before := assignor.Assign(actors[:10], nil, resources)
+		after := assignor.Assign(actors, nil, resources)
+		n := moved(before, after)
+		t.Logf("%s moved %d/%d", name, n, len(resources))
+
+		switch name {
+		case "range":
+			assert.Equal(t, true, n > len(resources)/4)
+
+		case "sticky":
+			// ideally 1000/11, only those gained by the new actor
+			assert.Equal(t, len(after[actors[10]]), n)
+			assert.Equal(t, true, n < len(resources)/11*2)
+		}
+	}
+}
+
+func TestAssignorMovementOnActorLeave(t *testing.T) {
+	actors, resources := genActorsAndResources(10, 1000)
+	assignor, _ := NewAssignor("sticky")
+	before := assignor.Assign(actors, nil, resources)
+	after := assignor.Assign(actors[1:], nil, resources)
+
+	// only the resources of the gone actor move
+	assert.Equal(t, len(before[actors[0]]), moved(before, after))
+}
+
+func TestStickyAssignorWeighted(t *testing.T) {
+	actors, resources := genActorsAndResources(3, 3000)
+	capacities := map[string]int{actors[0]: 200} // others default
+	decision := stickyAssignor{}.Assign(actors, capacities, resources)
+
+	total := 0
+	for _, r := range decision {
+		total += len(r)
+	}
+	assert.Equal(t, len(resources), total)
+	t.Logf("%d %d %d", len(decision[actors[0]]), len(decision[actors[1]]), len(decision[actors[2]]))
+	// expected 1500:750:750
+	assert.Equal(t, true, len(decision[actors[0]]) > 1300 && len(decision[actors[0]]) < 1700)
+	assert.Equal(t, true, len(decision[actors[1]]) > 600 && len(decision[actors[1]]) < 900)
+
+	// deterministic regardless of input order
+	reversed := make(zk.ActorList, len(actors))
+	for i, a := range actors {
+		reversed[len(actors)-1-i] = a
+	}
+	assert.Equal(t, 0, moved(decision, stickyAssignor{}.Assign(reversed, capacities, resources)))
+}
+
+func TestNewAssignor(t *testing.T) {
+	_, err := NewAssignor("random")
+	assert.NotEqual(t, nil, err)
+}
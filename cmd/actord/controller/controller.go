@@ -5,11 +5,16 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/funkygao/fae/config"
 	"github.com/funkygao/fae/servant/mysql"
 	"github.com/funkygao/gafka"
+	"github.com/funkygao/gafka/cmd/actord/executor"
+	"github.com/funkygao/gafka/cmd/kateway/job"
+	jobdisk "github.com/funkygao/gafka/cmd/kateway/job/disk"
+	jm "github.com/funkygao/gafka/cmd/kateway/job/mysql"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
 	mdummy "github.com/funkygao/gafka/cmd/kateway/manager/dummy"
 	mmysql "github.com/funkygao/gafka/cmd/kateway/manager/mysql"
@@ -29,38 +34,71 @@ type Controller interface {
 
 type controller struct {
 	orchestrator *zk.Orchestrator
-	mc           *mysql.MysqlCluster
+	mc           *mysql.MysqlCluster // nil if job store is not mysql
+	jobStore     job.JobStore
+	assignor     Assignor
 	quiting      chan struct{}
 	auditor      log.Logger
 
-	ListenAddr string `json:"addr"`
-	Version    string `json:"version"`
+	ListenAddr   string `json:"addr"`
+	Version      string `json:"version"`
+	AssignorName string `json:"assignor"`
+	Capacity     int    `json:"capacity"` // weight in sticky assignment
 
-	ActorN, JobQueueN, WebhookN    sync2.AtomicInt32
-	JobExecutorN, WebhookExecutorN sync2.AtomicInt32
+	ActorN, JobQueueN, WebhookN, RetryN            sync2.AtomicInt32
+	JobExecutorN, WebhookExecutorN, RetryExecutorN sync2.AtomicInt32
 
 	ident   string // cache
 	shortId string // cache
-}
 
-func New(zkzone *zk.ZkZone, listenAddr string, managerType string) Controller {
-	// mysql cluster config
-	b, err := zkzone.KatewayJobClusterConfig()
-	if err != nil {
-		panic(err)
-	}
-	var mcc = &config.ConfigMysql{}
-	if err = mcc.From(b); err != nil {
-		panic(err)
-	}
+	webhooksLock sync.RWMutex
+	webhooks     map[string]*executor.WebhookExecutor // running webhook executors, key is topic
+}
 
+func New(zkzone *zk.ZkZone, listenAddr string, managerType string, jobStoreType, jobStoreDir string,
+	assignorName string, capacity int) Controller {
 	this := &controller{
 		quiting:      make(chan struct{}),
 		orchestrator: zkzone.NewOrchestrator(),
-		mc:           mysql.New(mcc),
 		ListenAddr:   listenAddr,
 		Version:      gafka.BuildId,
+		AssignorName: assignorName,
+		Capacity:     capacity,
+		webhooks:     make(map[string]*executor.WebhookExecutor),
+	}
+
+	var err error
+	if this.assignor, err = NewAssignor(assignorName); err != nil {
+		panic(err)
+	}
+
+	// actor never generates job id
+	switch jobStoreType {
+	case "mysql":
+		// mysql cluster config
+		b, err := zkzone.KatewayJobClusterConfig()
+		if err != nil {
+			panic(err)
+		}
+		var mcc = &config.ConfigMysql{}
+		if err = mcc.From(b); err != nil {
+			panic(err)
+		}
+
+		this.mc = mysql.New(mcc)
+		if this.jobStore, err = jm.New("0", mcc); err != nil {
+			panic(err)
+		}
+
+	case "disk":
+		if this.jobStore, err = jobdisk.New("0", jobStoreDir); err != nil {
+			panic(err)
+		}
+
+	default:
+		panic("unknown job store: " + jobStoreType)
 	}
+
 	this.ident, err = this.generateIdent()
 	if err != nil {
 		panic(err)
@@ -100,6 +138,11 @@ func (this *controller) RunForever() (err error) {
 	}
 	log.Trace("manager[%s] started", manager.Default.Name())
 
+	if err = this.jobStore.Start(); err != nil {
+		return
+	}
+	log.Trace("job store[%s] started", this.jobStore.Name())
+
 	go this.runWebServer()
 
 	jobDispatchQuit := make(chan struct{})
@@ -108,14 +151,23 @@ func (this *controller) RunForever() (err error) {
 	webhookDispatchQuit := make(chan struct{})
 	go this.dispatchWebhooks(webhookDispatchQuit)
 
+	retryDispatchQuit := make(chan struct{})
+	go this.dispatchRetries(retryDispatchQuit)
+
 	select {
 	case <-jobDispatchQuit:
 		log.Warn("dispatchJobQueues quit")
 
 	case <-webhookDispatchQuit:
 		log.Warn("dispatchWebhooks quit")
+
+	case <-retryDispatchQuit:
+		log.Warn("dispatchRetries quit")
 	}
 
+	this.jobStore.Stop()
+	log.Trace("job store[%s] stopped", this.jobStore.Name())
+
 	manager.Default.Stop()
 	log.Trace("manager[%s] stopped", manager.Default.Name())
 
@@ -0,0 +1,148 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/zk"
+	"github.com/funkygao/golib/sync2"
+	log "github.com/funkygao/log4go"
+	zklib "github.com/samuel/go-zookeeper/zk"
+)
+
+// dispatcher assigns a kind of resources among the actors and runs the executors
+// of the resources assigned to this actor.
+type dispatcher struct {
+	kind      string // e,g. job queue
+	ownerRoot string // zk root of the resource owners
+
+	// watch returns the resources to assign and a channel notified on their changes.
+	watch func() (zk.ResourceList, <-chan zklib.Event, error)
+
+	// execute runs the executor of a claimed resource until stopper is closed.
+	execute func(resource string, stopper <-chan struct{})
+
+	resourceN, executorN *sync2.AtomicInt32
+}
+
+// dispatch rebalances the resources on each resource or actor change until the controller quits.
+func (this *controller) dispatch(d dispatcher, quit chan<- struct{}) {
+	defer close(quit)
+
+REBALANCE:
+	for {
+		// each loop is a new rebalance process
+
+		select {
+		case <-this.quiting:
+			break REBALANCE
+		default:
+		}
+
+		resources, resourceChanges, err := d.watch()
+		if err != nil {
+			log.Error("watch %s: %s", d.kind, err)
+			time.Sleep(time.Second)
+			continue REBALANCE
+		}
+		d.resourceN.Set(int32(len(resources)))
+
+		actors, actorChanges, err := this.orchestrator.WatchActors()
+		if err != nil {
+			log.Error("watch actors: %s", err)
+			time.Sleep(time.Second)
+			continue REBALANCE
+		}
+		this.ActorN.Set(int32(len(actors)))
+
+		log.Info("deciding: found %d %s, %d actors", len(resources), d.kind, len(actors))
+		decision, err := this.assign(actors, resources)
+		if err != nil {
+			log.Error("assign %s: %s", d.kind, err)
+			time.Sleep(time.Second)
+			continue REBALANCE
+		}
+		mine := decision[this.Id()]
+
+		if len(mine) == 0 {
+			// standby mode
+			log.Warn("decided: no %s assignment, awaiting rebalance...", d.kind)
+		} else {
+			log.Info("decided: claiming %d/%d %s", len(resources), len(mine), d.kind)
+		}
+
+		var (
+			wg               sync.WaitGroup
+			executorsStopper = make(chan struct{})
+		)
+		for _, resource := range mine {
+			wg.Add(1)
+			d.executorN.Add(1)
+			log.Trace("invoking executor for %s", resource)
+			go this.invokeExecutor(d, resource, &wg, executorsStopper)
+		}
+
+		select {
+		case <-this.quiting:
+			close(executorsStopper)
+			wg.Wait()
+			break REBALANCE
+
+		case <-resourceChanges:
+			log.Info("rebalance due to %s changes", d.kind)
+
+			close(executorsStopper)
+			wg.Wait()
+
+		case <-actorChanges:
+			log.Info("rebalance due to actor changes")
+
+			stillAlive, err := this.orchestrator.ActorRegistered(this.Id())
+			if err != nil {
+				log.Error(err)
+			} else if !stillAlive {
+				this.orchestrator.RegisterActor(this.Id(), this.Bytes())
+			}
+
+			close(executorsStopper)
+			wg.Wait()
+		}
+	}
+
+	log.Info("controller[%s] dispatch %s stopped", this.Id(), d.kind)
+}
+
+// invokeExecutor claims the ownership of a resource and runs its executor.
+func (this *controller) invokeExecutor(d dispatcher, resource string, wg *sync.WaitGroup, stopper <-chan struct{}) {
+	defer func() {
+		wg.Done()
+		d.executorN.Add(-1)
+	}()
+
+	var err error
+	for retries := 0; retries < 3; retries++ {
+		log.Trace("claiming owner of %s #%d", resource, retries)
+		if err = this.orchestrator.ClaimResource(this.Id(), d.ownerRoot, resource); err == nil {
+			log.Info("claimed owner of %s", resource)
+			break
+		} else if err == zk.ErrClaimedByOthers {
+			log.Error("%s #%d", err, retries)
+			time.Sleep(time.Second)
+		} else {
+			log.Error("%s #%d", err, retries)
+			return
+		}
+	}
+
+	if err != nil {
+		// still err(ErrClaimedByOthers) encountered after max retries
+		return
+	}
+
+	defer func() {
+		this.orchestrator.ReleaseResource(this.Id(), d.ownerRoot, resource)
+		log.Info("de-claimed owner of %s", resource)
+	}()
+
+	d.execute(resource, stopper)
+}
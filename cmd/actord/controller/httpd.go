@@ -1,13 +1,16 @@
 package controller
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
 
 	log "github.com/funkygao/log4go"
 )
 
 func (this *controller) runWebServer() {
 	http.HandleFunc("/v1/status", this.statusHandler)
+	http.HandleFunc("/v1/webhook/deliveries", this.webhookDeliveriesHandler)
 	log.Info("web server on %s ready", this.ListenAddr)
 	err := http.ListenAndServe(this.ListenAddr, nil)
 	if err != nil {
@@ -21,3 +24,28 @@ func (this *controller) statusHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Write(this.Bytes())
 }
+
+// GET /v1/webhook/deliveries?topic=xx&n=100&failed=1
+// the recent deliveries of a webhook owned by this actor, the latest first.
+func (this *controller) webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf8")
+	w.Header().Set("Server", "actord")
+
+	q := r.URL.Query()
+	topic := q.Get("topic")
+	n, err := strconv.Atoi(q.Get("n"))
+	if err != nil || n <= 0 {
+		n = 100
+	}
+
+	this.webhooksLock.RLock()
+	exe, present := this.webhooks[topic]
+	this.webhooksLock.RUnlock()
+	if !present {
+		http.Error(w, "webhook not owned by this actor", http.StatusNotFound)
+		return
+	}
+
+	b, _ := json.Marshal(exe.Deliveries(n, q.Get("failed") == "1"))
+	w.Write(b)
+}
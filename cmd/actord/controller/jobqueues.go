@@ -2,132 +2,46 @@ package controller
 
 import (
 	"sync"
-	"time"
 
 	"github.com/funkygao/gafka/cmd/actord/executor"
 	"github.com/funkygao/gafka/zk"
 	log "github.com/funkygao/log4go"
+	zklib "github.com/samuel/go-zookeeper/zk"
 )
 
 func (this *controller) dispatchJobQueues(quit chan<- struct{}) {
-	defer close(quit)
-
-REBALANCE:
-	for {
-		// each loop is a new rebalance process
-
-		select {
-		case <-this.quiting:
-			break REBALANCE
-		default:
-		}
-
-		jobQueues, jobQueueChanges, err := this.orchestrator.WatchResources(zk.PubsubJobQueues)
-		if err != nil {
-			log.Error("watch job queues: %s", err)
-			time.Sleep(time.Second)
-			continue REBALANCE
-		}
-		this.JobQueueN.Set(int32(len(jobQueues)))
-
-		actors, actorChanges, err := this.orchestrator.WatchActors()
-		if err != nil {
-			log.Error("watch actors: %s", err)
-			time.Sleep(time.Second)
-			continue REBALANCE
-		}
-		this.ActorN.Set(int32(len(actors)))
-
-		log.Info("deciding: found %d job queues, %d actors", len(jobQueues), len(actors))
-		decision := assignResourcesToActors(actors, jobQueues)
-		myJobQueues := decision[this.Id()]
-
-		if len(myJobQueues) == 0 {
-			// standby mode
-			log.Warn("decided: no job assignment, awaiting rebalance...")
-		} else {
-			log.Info("decided: claiming %d/%d job queues", len(jobQueues), len(myJobQueues))
-		}
-
-		var (
-			wg              sync.WaitGroup
-			executorStopper = make(chan struct{})
-		)
-		for _, jobQueue := range myJobQueues {
-			wg.Add(1)
-			this.JobExecutorN.Add(1)
-			log.Trace("invoking executor for %s", jobQueue)
-			go this.invokeJobExexutor(jobQueue, &wg, executorStopper)
-		}
-
-		select {
-		case <-this.quiting:
-			close(executorStopper)
-			wg.Wait()
-			break REBALANCE
-
-		case <-jobQueueChanges:
-			log.Info("rebalance due to job queue changes")
-
-			close(executorStopper)
-			wg.Wait()
-
-		case <-actorChanges:
-			log.Info("rebalance due to actor changes")
-
-			stillAlive, err := this.orchestrator.ActorRegistered(this.Id())
-			if err != nil {
-				log.Error(err)
-			} else if !stillAlive {
-				this.orchestrator.RegisterActor(this.Id(), this.Bytes())
-			}
-
-			close(executorStopper)
-			wg.Wait()
-		}
-	}
-
-	log.Info("controller[%s] dispatchJobQueues stopped", this.Id())
-	return
+	this.dispatch(dispatcher{
+		kind:      "job queues",
+		ownerRoot: zk.PubsubJobQueueOwners,
+		watch: func() (zk.ResourceList, <-chan zklib.Event, error) {
+			return this.orchestrator.WatchResources(zk.PubsubJobQueues)
+		},
+		execute:   this.runJobQueue,
+		resourceN: &this.JobQueueN,
+		executorN: &this.JobExecutorN,
+	}, quit)
 }
 
-func (this *controller) invokeJobExexutor(jobQueue string, wg *sync.WaitGroup, stopper <-chan struct{}) {
-	defer func() {
-		wg.Done()
-		this.JobExecutorN.Add(-1)
-	}()
-
-	var err error
-	for retries := 0; retries < 3; retries++ {
-		log.Trace("claiming owner of %s #%d", jobQueue, retries)
-		if err = this.orchestrator.ClaimResource(this.Id(), zk.PubsubJobQueueOwners, jobQueue); err == nil {
-			log.Info("claimed owner of %s", jobQueue)
-			break
-		} else if err == zk.ErrClaimedByOthers {
-			log.Error("%s #%d", err, retries)
-			time.Sleep(time.Second)
-		} else {
-			log.Error("%s #%d", err, retries)
-			return
-		}
-	}
-
+func (this *controller) runJobQueue(jobQueue string, stopper <-chan struct{}) {
+	cluster, err := this.orchestrator.JobQueueCluster(jobQueue)
 	if err != nil {
-		// still err(ErrClaimedByOthers) encountered after max retries
-		return
+		log.Error(err)
 	}
 
-	defer func(q string) {
-		this.orchestrator.ReleaseResource(this.Id(), zk.PubsubJobQueueOwners, q)
-		log.Info("de-claimed owner of %s", q)
-	}(jobQueue)
+	// the xa sweeper shares the job queue ownership, XA is supported by mysql job store only
+	var xaWg sync.WaitGroup
+	if this.mc != nil {
+		xaWg.Add(1)
+		go func() {
+			defer xaWg.Done()
 
-	cluster, err := this.orchestrator.JobQueueCluster(jobQueue)
-	if err != nil {
-		log.Error(err)
+			xa := executor.NewXaExecutor(this.shortId, cluster, jobQueue, this.mc, this.orchestrator, stopper, this.auditor)
+			xa.Run()
+		}()
 	}
 
-	exe := executor.NewJobExecutor(this.shortId, cluster, jobQueue, this.mc, stopper, this.auditor)
+	exe := executor.NewJobExecutor(this.shortId, cluster, jobQueue, this.jobStore, stopper, this.auditor)
 	exe.Run()
 
+	xaWg.Wait()
 }
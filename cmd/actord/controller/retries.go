@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"github.com/funkygao/gafka/cmd/actord/executor"
+	"github.com/funkygao/gafka/zk"
+	log "github.com/funkygao/log4go"
+	zklib "github.com/samuel/go-zookeeper/zk"
+)
+
+func (this *controller) dispatchRetries(quit chan<- struct{}) {
+	// retry policies are optional, the root might not exist yet
+	if err := this.orchestrator.EnsurePathExists(zk.PubsubRetries); err != nil {
+		log.Error("%s: %s", zk.PubsubRetries, err)
+	}
+
+	this.dispatch(dispatcher{
+		kind:      "retries",
+		ownerRoot: zk.PubsubRetryOwners,
+		watch: func() (zk.ResourceList, <-chan zklib.Event, error) {
+			return this.orchestrator.WatchResources(zk.PubsubRetries)
+		},
+		execute:   this.runRetry,
+		resourceN: &this.RetryN,
+		executorN: &this.RetryExecutorN,
+	}, quit)
+}
+
+func (this *controller) runRetry(topic string, stopper <-chan struct{}) {
+	retry, err := this.orchestrator.RetryInfo(topic)
+	if err != nil {
+		log.Error("%s: %s", topic, err)
+		return
+	}
+
+	exe := executor.NewRetryExecutor(this.shortId, retry.Cluster, topic, retry.Dead, retry.Backoff, stopper, this.auditor)
+	exe.Run()
+}
@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"sync"
 	"time"
 
 	"github.com/funkygao/gafka/cmd/actord/executor"
@@ -11,149 +10,134 @@ import (
 )
 
 func (this *controller) dispatchWebhooks(quit chan<- struct{}) {
-	defer close(quit)
+	this.dispatch(dispatcher{
+		kind:      "webhooks",
+		ownerRoot: zk.PubsubWebhookOwners,
+		watch:     this.watchActiveWebhooks,
+		execute:   this.runWebhook,
+		resourceN: &this.WebhookN,
+		executorN: &this.WebhookExecutorN,
+	}, quit)
+}
 
-REBALANCE:
-	for {
-		// each loop is a new rebalance process
+// watchActiveWebhooks watches the webhooks excluding the disabled ones, e,g. to protect against deadloop.
+func (this *controller) watchActiveWebhooks() (zk.ResourceList, <-chan zklib.Event, error) {
+	webhooks, webhookChanges, err := this.orchestrator.WatchResources(zk.PubsubWebhooks)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	offs, offChanges, err := this.orchestrator.WatchResources(zk.PubsubWebhooksOff)
+	if err != nil && err != zklib.ErrNoNode {
+		return nil, nil, err
+	}
+	offMap := make(map[string]struct{}, len(offs))
+	for _, off := range offs {
+		offMap[off] = struct{}{}
+	}
+	activeHooks := make(zk.ResourceList, 0, len(webhooks))
+	for _, hook := range webhooks {
+		if _, present := offMap[hook]; !present {
+			activeHooks = append(activeHooks, hook)
+		}
+	}
+
+	changes := make(chan zklib.Event, 1)
+	go func() {
 		select {
+		case evt := <-webhookChanges:
+			changes <- evt
+		case evt := <-offChanges:
+			changes <- evt
 		case <-this.quiting:
-			break REBALANCE
-		default:
 		}
+	}()
 
-		webhooks, webhookChanges, err := this.orchestrator.WatchResources(zk.PubsubWebhooks)
-		if err != nil {
-			log.Error("watch webhooks: %s", err)
-			time.Sleep(time.Second)
-			continue REBALANCE
-		}
+	return activeHooks, changes, nil
+}
 
-		actors, actorChanges, err := this.orchestrator.WatchActors()
-		if err != nil {
-			log.Error("watch actors: %s", err)
-			time.Sleep(time.Second)
-			continue REBALANCE
-		}
-		this.ActorN.Set(int32(len(actors)))
-
-		// some webhooks might be disabled
-		// e,g. to protect against deadloop
-		offs, offChanges, err := this.orchestrator.WatchResources(zk.PubsubWebhooksOff)
-		if err != nil && err != zklib.ErrNoNode {
-			log.Error("watch disabled webhooks: %s", err)
-			time.Sleep(time.Second)
-			continue REBALANCE
-		}
-		offMap := make(map[string]struct{}, len(offs))
-		for _, off := range offs {
-			offMap[off] = struct{}{}
+func (this *controller) runWebhook(topic string, stopper <-chan struct{}) {
+	var (
+		exe        *executor.WebhookExecutor
+		exeDone    chan struct{}
+		exeStopper = make(chan struct{})
+	)
+	defer func() {
+		if exe != nil {
+			close(exeStopper)
+			<-exeDone
+			this.unregisterWebhookExecutor(topic)
 		}
-		activeHooks := make(zk.ResourceList, 0, len(webhooks))
-		for _, hook := range webhooks {
-			if _, present := offMap[hook]; !present {
-				activeHooks = append(activeHooks, hook)
+	}()
+
+	// the executor is reloaded on each webhook znode change
+	for {
+		hook, hookChanges, err := this.orchestrator.WatchWebhook(topic)
+		if err == zklib.ErrNoNode {
+			log.Info("%s webhook deleted", topic)
+			return
+		} else if err != nil {
+			log.Error("%s: %s", topic, err)
+
+			select {
+			case <-stopper:
+				return
+			case <-time.After(time.Second):
+				continue
 			}
 		}
 
-		this.WebhookN.Set(int32(len(activeHooks)))
-
-		log.Info("deciding: found %d webhooks, %d actors", len(activeHooks), len(actors))
-		decision := assignResourcesToActors(actors, activeHooks)
-		myWebhooks := decision[this.Id()]
-
-		if len(myWebhooks) == 0 {
-			// standby mode
-			log.Warn("decided: no webhook assignment, awaiting rebalance...")
-		} else {
-			log.Info("decided: claiming %d/%d webhooks", len(activeHooks), len(myWebhooks))
+		if exe != nil {
+			if err = exe.Reload(*hook); err == executor.ErrRestartRequired {
+				// e,g. delivery mode changed
+				log.Info("%s restarting webhook executor", topic)
+
+				close(exeStopper)
+				<-exeDone
+				this.unregisterWebhookExecutor(topic)
+				exe, exeStopper = nil, make(chan struct{})
+			} else if err != nil {
+				log.Error("%s reload: %s", topic, err)
+			}
 		}
 
-		var (
-			wg               sync.WaitGroup
-			executorsStopper = make(chan struct{})
-		)
-		for _, topic := range myWebhooks {
-			wg.Add(1)
-			this.WebhookExecutorN.Add(1)
-			log.Trace("invoking executor for %s", topic)
-			go this.invokeWebhookExecutor(topic, &wg, executorsStopper)
+		if exe == nil {
+			exe = executor.NewWebhookExecutor(this.shortId, topic, *hook, exeStopper, this.auditor)
+			exeDone = make(chan struct{})
+			this.webhooksLock.Lock()
+			this.webhooks[topic] = exe
+			this.webhooksLock.Unlock()
+
+			go func(exe *executor.WebhookExecutor, done chan<- struct{}) {
+				exe.Run()
+				close(done)
+			}(exe, exeDone)
 		}
 
 		select {
-		case <-this.quiting:
-			close(executorsStopper)
-			wg.Wait()
-			break REBALANCE
-
-		case <-offChanges:
-			log.Info("rebalance due to disabled webhooks changes")
-
-			close(executorsStopper)
-			wg.Wait()
-
-		case <-webhookChanges:
-			log.Info("rebalance due to webhooks changes")
+		case <-stopper:
+			return
 
-			close(executorsStopper)
-			wg.Wait()
+		case <-hookChanges:
+			log.Trace("%s webhook changed", topic)
 
-		case <-actorChanges:
-			log.Info("rebalance due to actor changes")
+		case <-exeDone:
+			// quit on its own, e,g. invalid webhook: restart it on webhook change
+			exe = nil
+			this.unregisterWebhookExecutor(topic)
 
-			stillAlive, err := this.orchestrator.ActorRegistered(this.Id())
-			if err != nil {
-				log.Error(err)
-			} else if !stillAlive {
-				this.orchestrator.RegisterActor(this.Id(), this.Bytes())
+			select {
+			case <-stopper:
+				return
+			case <-hookChanges:
+			case <-time.After(time.Minute):
 			}
-
-			close(executorsStopper)
-			wg.Wait()
 		}
 	}
-
-	log.Info("controller[%s] dispatchWebhooks stopped", this.Id())
-	return
 }
 
-func (this *controller) invokeWebhookExecutor(topic string, wg *sync.WaitGroup, stopper <-chan struct{}) {
-	defer func() {
-		wg.Done()
-		this.WebhookExecutorN.Add(-1)
-	}()
-
-	hook, err := this.orchestrator.WebhookInfo(topic)
-	if err != nil {
-		log.Error("%s: %s", topic, err)
-		return
-	}
-
-	for retries := 0; retries < 3; retries++ {
-		log.Trace("claiming owner of %s #%d", topic, retries)
-		if err = this.orchestrator.ClaimResource(this.Id(), zk.PubsubWebhookOwners, topic); err == nil {
-			log.Info("claimed owner of %s", topic)
-			break
-		} else if err == zk.ErrClaimedByOthers {
-			log.Error("%s #%d", err, retries)
-			time.Sleep(time.Second)
-		} else {
-			log.Error("%s #%d", err, retries)
-			return
-		}
-	}
-
-	if err != nil {
-		// still err(ErrClaimedByOthers) encountered after max retries
-		return
-	}
-
-	defer func(topic string) {
-		this.orchestrator.ReleaseResource(this.Id(), zk.PubsubWebhookOwners, topic)
-		log.Info("de-claimed owner of %s", topic)
-	}(topic)
-
-	exe := executor.NewWebhookExecutor(this.shortId, hook.Cluster, topic, hook.Endpoints, stopper, this.auditor)
-	exe.Run()
+func (this *controller) unregisterWebhookExecutor(topic string) {
+	this.webhooksLock.Lock()
+	delete(this.webhooks, topic)
+	this.webhooksLock.Unlock()
 }
@@ -1,78 +1,91 @@
 package executor
 
 import (
-	"fmt"
+	"hash/fnv"
 	"sync"
 	"time"
 
-	"github.com/funkygao/fae/servant/mysql"
+	"github.com/funkygao/gafka/cmd/kateway/gateway"
 	"github.com/funkygao/gafka/cmd/kateway/hh"
 	"github.com/funkygao/gafka/cmd/kateway/job"
-	jm "github.com/funkygao/gafka/cmd/kateway/job/mysql"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/gafka/cmd/kateway/store"
+	"github.com/funkygao/go-metrics"
 	log "github.com/funkygao/log4go"
 )
 
 const (
-	LagWarnThreshold   = 3  // in sec
-	HandlerConcurrentN = 10 // FIXME breaks the delivery order guarantee
+	LagWarnThreshold   = 3   // in sec
+	HandlerConcurrentN = 10  // jobs of the same partition key always go to the same lane
+	JobBatchSize       = 100 // max jobs taken from and settled to job store at once
 )
 
+var (
+	jobBatchSize    = metrics.NewRegisteredHistogram("actord.job.batch.size", nil, metrics.NewExpDecaySample(1028, 0.015))
+	jobBatchLatency = metrics.NewRegisteredHistogram("actord.job.batch.latency", nil, metrics.NewExpDecaySample(1028, 0.015)) // job store time in ms
+)
+
+// dueJob is a job dispatched to a lane in a tick.
+type dueJob struct {
+	job.JobItem
+	tick int64
+}
+
 // JobExecutor polls a single JobQueue and handle each Job.
 type JobExecutor struct {
 	parentId       string // controller short id
 	cluster, topic string
-	mc             *mysql.MysqlCluster
+	jobStore       job.JobStore
 	stopper        <-chan struct{}
-	dueJobs        chan job.JobItem
+	lanes          []chan dueJob
 	auditor        log.Logger
 
 	// cached values
 	appid string
-	aid   int
-	table string
 	ident string
 }
 
-func NewJobExecutor(parentId, cluster, topic string, mc *mysql.MysqlCluster,
+func NewJobExecutor(parentId, cluster, topic string, jobStore job.JobStore,
 	stopper <-chan struct{}, auditor log.Logger) *JobExecutor {
 	this := &JobExecutor{
 		parentId: parentId,
 		cluster:  cluster,
 		topic:    topic,
-		mc:       mc,
+		jobStore: jobStore,
 		stopper:  stopper,
-		dueJobs:  make(chan job.JobItem, 200),
+		lanes:    make([]chan dueJob, HandlerConcurrentN),
 		auditor:  auditor,
 	}
+	for i := range this.lanes {
+		this.lanes[i] = make(chan dueJob, JobBatchSize)
+	}
 
 	return this
 }
 
-// poll mysql for due jobs and send to kafka.
+// poll job store for due jobs and send to kafka.
 func (this *JobExecutor) Run() {
 	this.appid = manager.Default.TopicAppid(this.topic)
 	if this.appid == "" {
 		log.Warn("invalid topic: %s", this.topic)
 		return
 	}
-	this.aid = jm.App_id(this.appid)
-	this.table = jm.JobTable(this.topic)
 	this.ident = this.topic
 
 	log.Trace("starting %s", this.Ident())
 
+	if err := this.jobStore.Open(this.appid, this.topic); err != nil {
+		log.Error("%s: %v", this.ident, err)
+	}
+
 	var (
 		wg   sync.WaitGroup
-		item job.JobItem
 		tick = time.NewTicker(time.Second)
-		sql  = fmt.Sprintf("SELECT job_id,payload,ctime,due_time FROM %s WHERE due_time<=?", this.table)
 	)
 
-	for i := 0; i < HandlerConcurrentN; i++ {
+	for _, lane := range this.lanes {
 		wg.Add(1)
-		go this.handleDueJobs(&wg)
+		go this.handleDueJobs(lane, &wg)
 	}
 
 	for {
@@ -83,95 +96,240 @@ func (this *JobExecutor) Run() {
 			return
 
 		case now := <-tick.C:
-			rows, err := this.mc.Query(jm.AppPool, this.topic, this.aid, sql, now.Unix())
+			items, err := this.jobStore.Due(this.appid, this.topic, now.Unix())
 			if err != nil {
 				log.Error("%s: %v", this.ident, err)
-				continue
 			}
 
-			for rows.Next() {
-				err = rows.Scan(&item.JobId, &item.Payload, &item.Ctime, &item.DueTime)
-				if err == nil {
-					log.Debug("%s due %s", this.ident, item)
-					if lag := now.Unix() - item.DueTime; lag > LagWarnThreshold {
-						log.Warn("%s lag %ds %s", this.ident, lag, item)
-					}
-
-					this.dueJobs <- item
-				} else {
-					log.Error("%s: %s", this.ident, err)
+			// items are in due time order, and each lane handles its jobs sequentially
+			for _, item := range items {
+				log.Debug("%s due %s", this.ident, item)
+				if lag := now.Unix() - item.DueTime; lag > LagWarnThreshold {
+					log.Warn("%s lag %ds %s", this.ident, lag, item)
 				}
-			}
 
-			if err = rows.Err(); err != nil {
-				log.Error("%s: %s", this.ident, err)
+				select {
+				case this.lanes[this.laneOf(item)] <- dueJob{JobItem: item, tick: now.Unix()}:
+				case <-this.stopper:
+				}
 			}
-
-			rows.Close()
 		}
 	}
 
 }
 
-// TODO batch DELETE/INSERT for better performance.
-func (this *JobExecutor) handleDueJobs(wg *sync.WaitGroup) {
+// laneOf returns the lane index of a job: hash of partition key if keyed, else spread by job id.
+func (this *JobExecutor) laneOf(item job.JobItem) int {
+	if item.Key == "" {
+		return int(uint64(item.JobId) % uint64(len(this.lanes)))
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(item.Key))
+	return int(h.Sum32() % uint32(len(this.lanes)))
+}
+
+// handleDueJobs fires the jobs of a lane in batches, each batch is taken from and
+// settled to job store with multi-row statements.
+func (this *JobExecutor) handleDueJobs(lane <-chan dueJob, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	var (
-		// zabbix maintains a in-memory delete queue
-		// delete from history_uint where itemid=? and clock<min_clock
-		sqlDeleteJob = fmt.Sprintf("DELETE FROM %s WHERE job_id=?", this.table)
+	// partition key => tick in which a job of the key failed to fire.
+	// the following jobs of the key are held until the next tick so that they never
+	// overtake the failed one
+	blocked := make(map[string]int64)
+	batch := make([]dueJob, 0, JobBatchSize)
 
-		sqlInsertArchive = fmt.Sprintf("INSERT INTO %s(job_id,payload,ctime,due_time,etime,actor_id) VALUES(?,?,?,?,?,?)",
-			jm.HistoryTable(this.topic))
-		sqlReinject = fmt.Sprintf("INSERT INTO %s(job_id, payload, ctime, due_time) VALUES(?,?,?,?)", this.table)
-	)
 	for {
 		select {
 		case <-this.stopper:
 			return
 
-		case item := <-this.dueJobs:
-			now := time.Now()
-			affectedRows, _, err := this.mc.Exec(jm.AppPool, this.table, this.aid, sqlDeleteJob, item.JobId)
-			if err != nil {
-				log.Error("%s: %s", this.ident, err)
-				continue
+		case due := <-lane:
+			batch = append(batch[:0], due)
+
+		drain:
+			for len(batch) < JobBatchSize {
+				select {
+				case due = <-lane:
+					batch = append(batch, due)
+				default:
+					break drain
+				}
 			}
-			if affectedRows == 0 {
-				// 2 possibilities:
-				// - client Cancel job wins
-				// - this handler is too slow and the job fetched twice in tick
-				continue
+
+			this.fireBatch(batch, blocked)
+		}
+	}
+}
+
+func (this *JobExecutor) fireBatch(batch []dueJob, blocked map[string]int64) {
+	items := make([]job.JobItem, 0, len(batch))
+	ticks := make(map[int64]int64, len(batch)) // job id => tick
+	for _, due := range batch {
+		if due.Key != "" {
+			if tick, present := blocked[due.Key]; present {
+				if tick == due.tick {
+					log.Debug("%s held %s", this.ident, due.JobItem)
+					continue
+				}
+
+				delete(blocked, due.Key)
 			}
+		}
 
-			log.Debug("%s land %s", this.ident, item)
-			_, _, err = store.DefaultPubStore.SyncPub(this.cluster, this.topic, nil, item.Payload)
-			if err != nil {
-				err = hh.Default.Append(this.cluster, this.topic, nil, item.Payload)
+		items = append(items, due.JobItem)
+		ticks[due.JobId] = due.tick
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	t0 := time.Now()
+	taken, err := this.jobStore.TakeBatch(this.appid, this.topic, items)
+	if err != nil {
+		log.Error("%s: %s", this.ident, err)
+		for _, item := range items {
+			if item.Key != "" {
+				blocked[item.Key] = ticks[item.JobId]
 			}
-			if err != nil {
-				// pub fails and hinted handoff also fails: reinject job back to mysql
-				log.Error("%s: %s", this.ident, err)
-				this.mc.Exec(jm.AppPool, this.table, this.aid, sqlReinject,
-					item.JobId, item.Payload, item.Ctime, item.DueTime)
-				continue
+		}
+		return
+	}
+	storeLatency := time.Since(t0)
+
+	// recurrence is decided by the cron table when the jobs fire, so that a recurring
+	// job is never archived as one-off
+	t0 = time.Now()
+	schedules, err := this.cronSchedules(taken)
+	if err != nil {
+		log.Error("%s: %s", this.ident, err)
+		if err = this.jobStore.SettleBatch(this.appid, this.topic, job.FiredBatch{Failed: taken}, time.Now().Unix(), this.parentId); err != nil {
+			log.Error("%s: %s", this.ident, err)
+		}
+		for _, item := range taken {
+			if item.Key != "" {
+				blocked[item.Key] = ticks[item.JobId]
 			}
+		}
+		return
+	}
+	storeLatency += time.Since(t0)
 
-			log.Debug("%s fired %s", this.ident, item)
-			this.auditor.Trace(item.String())
+	// the jobs not taken, 2 possibilities:
+	// - client Cancel/Reschedule/UpdatePayload job wins
+	// - this handler is too slow and the job fetched twice in ticks
+	var (
+		fired job.FiredBatch
+		now   = time.Now()
+	)
+	for _, item := range taken {
+		if item.Key != "" {
+			if tick, present := blocked[item.Key]; present && tick == ticks[item.JobId] {
+				// a former job of the key failed in this batch
+				fired.Failed = append(fired.Failed, item)
+				continue
+			}
+		}
 
-			// mv job to archive table
-			_, _, err = this.mc.Exec(jm.AppPool, this.table, this.aid, sqlInsertArchive,
-				item.JobId, item.Payload, item.Ctime, item.DueTime, now.Unix(), this.parentId)
-			if err != nil {
-				log.Error("%s: %s", this.ident, err)
-			} else {
-				log.Debug("%s archived %s", this.ident, item)
+		log.Debug("%s land %s", this.ident, item)
+		if err = this.fire(item); err != nil {
+			// pub fails and hinted handoff also fails: reinject job back to job store
+			log.Error("%s: %s", this.ident, err)
+			fired.Failed = append(fired.Failed, item)
+			if item.Key != "" {
+				blocked[item.Key] = ticks[item.JobId]
 			}
+			continue
+		}
+
+		log.Debug("%s fired %s", this.ident, item)
+		this.auditor.Trace(item.String())
+
+		if schedule, present := schedules[item.JobId]; present {
+			// recurring job has no archive, its definition is kept in cron table
+			item.DueTime = this.nextOccurrence(item, schedule, now)
+			fired.Recurring = append(fired.Recurring, item)
+		} else {
+			fired.Fired = append(fired.Fired, item)
+		}
+	}
+
+	t0 = time.Now()
+	if err = this.jobStore.SettleBatch(this.appid, this.topic, fired, now.Unix(), this.parentId); err != nil {
+		log.Error("%s: %s", this.ident, err)
+		return
+	}
+	storeLatency += time.Since(t0)
+
+	log.Debug("%s settled %d/%d fired, %d recurring, %d failed", this.ident,
+		len(fired.Fired), len(items), len(fired.Recurring), len(fired.Failed))
 
+	jobBatchSize.Update(int64(len(taken)))
+	jobBatchLatency.Update(storeLatency.Nanoseconds() / 1e6)
+}
+
+// fire publishes a job to kafka, falls back to hinted handoff if kafka is unavailable.
+func (this *JobExecutor) fire(item job.JobItem) (err error) {
+	var key []byte
+	if item.Key != "" {
+		key = []byte(item.Key)
+	}
+	payload := item.Payload
+	if item.Tag != "" {
+		payload = gateway.EncodeMessage(gateway.NewMessageHeaders(item.Tag, "", "", false), payload)
+	}
+
+	_, _, err = store.DefaultPubStore.SyncPub(this.cluster, this.topic, key, payload)
+	if err != nil {
+		err = hh.Default.Append(this.cluster, this.topic, key, payload)
+	}
+	return
+}
+
+// cronSchedules returns the schedules of the recurring jobs among the taken jobs.
+func (this *JobExecutor) cronSchedules(items []job.JobItem) (map[int64]job.Schedule, error) {
+	jobIds := make([]int64, 0, len(items))
+	for _, item := range items {
+		jobIds = append(jobIds, item.JobId)
+	}
+
+	specs, err := this.jobStore.CronSpecs(this.appid, this.topic, jobIds)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make(map[int64]job.Schedule, len(specs))
+	for jobId, spec := range specs {
+		schedule, err := job.ParseSchedule(spec)
+		if err != nil {
+			log.Error("%s cron %d %s: %v", this.ident, jobId, spec, err)
+			continue
 		}
+
+		schedules[jobId] = schedule
+	}
+
+	return schedules, nil
+}
+
+// nextOccurrence returns the due time of the next occurrence of a fired recurring job,
+// 0 if it never fires again.
+func (this *JobExecutor) nextOccurrence(item job.JobItem, schedule job.Schedule, now time.Time) int64 {
+	from := time.Unix(item.DueTime, 0)
+	if from.Before(now) {
+		// lagging behind, skip the missed occurrences
+		from = now
 	}
+
+	next := schedule.Next(from)
+	if next.IsZero() {
+		log.Warn("%s cron %d will never fire again", this.ident, item.JobId)
+		return 0
+	}
+
+	log.Debug("%s cron %d next %s", this.ident, item.JobId, next)
+	return next.Unix()
 }
 
 func (this *JobExecutor) Ident() string {
@@ -0,0 +1,271 @@
+package executor
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/gateway"
+	"github.com/funkygao/gafka/cmd/kateway/meta"
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	"github.com/funkygao/gafka/sla"
+	"github.com/funkygao/gafka/zk"
+	"github.com/funkygao/kafka-cg/consumergroup"
+	log "github.com/funkygao/log4go"
+)
+
+const (
+	retryGroupName  = "_retry"
+	maxPendingRetry = 10000 // stop consuming when so many messages await their backoff
+	retryPubBackoff = time.Second
+	retryMaxBury    = 10 // give up burying after so many failed attempts
+)
+
+type retryItem struct {
+	msg     *sarama.ConsumerMessage
+	headers gateway.MessageHeaders
+	bodyIdx int
+	due     time.Time
+	done    bool
+}
+
+// RetryExecutor consumes a retry shadow topic and redelivers each scheduled message
+// to the subscriber after its retry backoff by re-publishing it as ready.
+// After the last attempt, the message is buried to the dead shadow topic.
+type RetryExecutor struct {
+	parentId       string // controller short id
+	cluster, topic string
+	deadTopic      string
+	backoff        string
+	stopper        <-chan struct{}
+	auditor        log.Logger
+
+	sla     *sla.TopicSla
+	fetcher *consumergroup.ConsumerGroup
+
+	// messages of the same attempt share the same backoff, so each
+	// attempt queue is naturally ordered by due time
+	attempts   [][]*retryItem
+	partitions map[int32][]*retryItem // in offset order for committing
+	pendingN   int
+}
+
+func NewRetryExecutor(parentId, cluster, topic, deadTopic, backoff string,
+	stopper <-chan struct{}, auditor log.Logger) *RetryExecutor {
+	return &RetryExecutor{
+		parentId:   parentId,
+		cluster:    cluster,
+		topic:      topic,
+		deadTopic:  deadTopic,
+		backoff:    backoff,
+		stopper:    stopper,
+		auditor:    auditor,
+		sla:        sla.DefaultSla(),
+		partitions: make(map[int32][]*retryItem),
+	}
+}
+
+func (this *RetryExecutor) Run() {
+	if err := this.sla.ParseRetryBackoff(this.backoff); err != nil {
+		log.Warn("%s disabled retry: backoff %s %s", this.topic, this.backoff, err)
+		return
+	}
+	this.attempts = make([][]*retryItem, len(this.sla.RetryBackoff))
+
+	cf := consumergroup.NewConfig()
+	cf.Net.DialTimeout = time.Second * 10
+	cf.Net.WriteTimeout = time.Second * 10
+	cf.Net.ReadTimeout = time.Second * 10
+	cf.ChannelBufferSize = 100
+	cf.Consumer.Return.Errors = true
+	cf.Consumer.MaxProcessingTime = time.Second * 2 // chan recv timeout
+	cf.Zookeeper.Chroot = meta.Default.ZkChroot(this.cluster)
+	cf.Zookeeper.Timeout = zk.DefaultZkSessionTimeout()
+	cf.Offsets.CommitInterval = time.Minute
+	cf.Offsets.ProcessingTimeout = time.Second
+	cf.Offsets.ResetOffsets = false
+	cf.Offsets.Initial = sarama.OffsetOldest
+	cg, err := consumergroup.JoinConsumerGroup(retryGroupName, []string{this.topic}, meta.Default.ZkAddrs(), cf)
+	if err != nil {
+		log.Error("%s stopped: %s", this.topic, err)
+		return
+	}
+	this.fetcher = cg
+	defer cg.Close()
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		msgs := cg.Messages()
+		if this.pendingN >= maxPendingRetry {
+			// backpressure: wait for the due messages to make room
+			msgs = nil
+		}
+
+		timer.Reset(this.nextDue())
+
+		select {
+		case <-this.stopper:
+			log.Debug("%s stopping with %d pending", this.topic, this.pendingN)
+			return
+
+		case err := <-cg.Errors():
+			log.Error("%s %s", this.topic, err)
+
+		case msg := <-msgs:
+			this.schedule(msg)
+
+		case <-timer.C:
+			this.redeliverDue()
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+func (this *RetryExecutor) schedule(msg *sarama.ConsumerMessage) {
+	item := &retryItem{msg: msg}
+	this.partitions[msg.Partition] = append(this.partitions[msg.Partition], item)
+	this.pendingN++
+
+	headers, bodyIdx, err := gateway.DecodeMessage(msg.Value)
+	if err != nil || !headers.RetryScheduled() {
+		// ready messages published by myself or buried without retry policy
+		if err != nil {
+			log.Error("%s %d/%d %s", this.topic, msg.Partition, msg.Offset, err)
+		}
+
+		this.land(item)
+		return
+	}
+
+	item.headers, item.bodyIdx = headers, bodyIdx
+	attempt := headers.Retries()
+	delay, ok := this.sla.RetryDelay(attempt)
+	if !ok {
+		// retry attempts exhausted
+		this.bury(item)
+		return
+	}
+
+	item.due = time.Unix(0, headers.PubTime()*int64(time.Millisecond)).Add(delay)
+	this.attempts[attempt-1] = append(this.attempts[attempt-1], item)
+}
+
+// nextDue returns how long to wait for the earliest scheduled message.
+func (this *RetryExecutor) nextDue() time.Duration {
+	next := time.Hour
+	now := time.Now()
+	for _, q := range this.attempts {
+		if len(q) == 0 {
+			continue
+		}
+
+		if d := q[0].due.Sub(now); d < next {
+			next = d
+		}
+	}
+
+	if next < 0 {
+		next = 0
+	}
+	return next
+}
+
+func (this *RetryExecutor) redeliverDue() {
+	now := time.Now()
+	for i, q := range this.attempts {
+		for len(q) > 0 && !q[0].due.After(now) {
+			if !this.redeliver(q[0]) {
+				// try again later
+				q[0].due = now.Add(retryPubBackoff)
+				break
+			}
+
+			q = q[1:]
+		}
+
+		this.attempts[i] = q
+	}
+}
+
+func (this *RetryExecutor) redeliver(item *retryItem) bool {
+	headers := make(gateway.MessageHeaders, len(item.headers)+1)
+	for k, v := range item.headers {
+		headers[k] = v
+	}
+	headers[gateway.HeaderRetryReady] = "1"
+
+	value := gateway.EncodeMessage(headers, item.msg.Value[item.bodyIdx:])
+	if _, _, err := store.DefaultPubStore.SyncPub(this.cluster, this.topic, item.msg.Key, value); err != nil {
+		log.Error("%s redeliver %d/%d #%d %s", this.topic, item.msg.Partition, item.msg.Offset,
+			item.headers.Retries(), err)
+		return false
+	}
+
+	this.auditor.Trace("retry %s %d/%d #%d", this.topic, item.msg.Partition, item.msg.Offset, item.headers.Retries())
+	this.land(item)
+	return true
+}
+
+// bury moves the message to dead letter topic, the retry attempt is kept in the envelope.
+func (this *RetryExecutor) bury(item *retryItem) {
+	headers := make(gateway.MessageHeaders, len(item.headers))
+	for k, v := range item.headers {
+		headers[k] = v
+	}
+	headers[gateway.HeaderPubTime] = strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+
+	value := gateway.EncodeMessage(headers, item.msg.Value[item.bodyIdx:])
+	for i := 1; ; i++ {
+		_, _, err := store.DefaultPubStore.SyncPub(this.cluster, this.deadTopic, item.msg.Key, value)
+		if err == nil {
+			break
+		}
+
+		log.Error("%s bury %d/%d -> %s #%d %s", this.topic, item.msg.Partition, item.msg.Offset, this.deadTopic, i, err)
+		if i >= retryMaxBury {
+			// e,g. dead topic not found: skip it instead of blocking the partition forever,
+			// the message is still in the retry topic at the audited offset
+			this.auditor.Trace("lost %s %d/%d #%d -> %s", this.topic, item.msg.Partition, item.msg.Offset,
+				item.headers.Retries(), this.deadTopic)
+			this.land(item)
+			return
+		}
+
+		select {
+		case <-this.stopper:
+			// the offset is not committed, will be buried again after rebalance
+			return
+		case <-time.After(retryPubBackoff):
+		}
+	}
+
+	this.auditor.Trace("bury %s %d/%d #%d -> %s", this.topic, item.msg.Partition, item.msg.Offset,
+		item.headers.Retries(), this.deadTopic)
+	this.land(item)
+}
+
+// land marks the message done and commits the offset of the longest done prefix of its partition.
+func (this *RetryExecutor) land(item *retryItem) {
+	item.done = true
+	this.pendingN--
+
+	q := this.partitions[item.msg.Partition]
+	var last *retryItem
+	for len(q) > 0 && q[0].done {
+		last = q[0]
+		q = q[1:]
+	}
+	this.partitions[item.msg.Partition] = q
+
+	if last != nil {
+		this.fetcher.CommitUpto(last.msg)
+	}
+}
@@ -1,13 +1,17 @@
 package executor
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -15,44 +19,84 @@ import (
 	"github.com/funkygao/gafka/cmd/kateway/gateway"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/gafka/cmd/kateway/meta"
+	"github.com/funkygao/gafka/cmd/kateway/store"
 	"github.com/funkygao/gafka/mpool"
 	"github.com/funkygao/gafka/zk"
-	"github.com/funkygao/golib/breaker"
 	"github.com/funkygao/kafka-cg/consumergroup"
 	log "github.com/funkygao/log4go"
 )
 
 const (
 	groupName = "_webhook"
+
+	deliveryLogSize = 1000
+
+	// messages queued for an endpoint, a failing endpoint holds back the others
+	// only after its backlog is full
+	endpointBacklog = 100
+
+	maxWebhookBury = 10 // give up burying after so many failed attempts
+)
+
+var (
+	ErrCircuitOpen     = errors.New("circuit open")
+	ErrEmptyEndpoints  = errors.New("empty endpoints")
+	ErrRestartRequired = errors.New("webhook change requires executor restart")
 )
 
+// WebhookExecutor pushes the messages of a topic to its webhook endpoints.
+// Each endpoint has its own delivery queue, a failed delivery is retried with exponential
+// backoff, and buried to the dead letter topic with the endpoint after exhausting its retries.
+// The webhook can be reloaded while running without rejoining the consumer group.
+//
+// In ordered and parallel mode, all the endpoints share a consumer group and a message is
+// committed after it is delivered to every endpoint. In fanout mode, each endpoint has
+// its own consumer group so that a slow endpoint never holds back the others.
 type WebhookExecutor struct {
 	parentId       string // controller short id
 	cluster, topic string
-	endpoints      []string
 	stopper        <-chan struct{}
 	auditor        log.Logger
 
+	cfLock sync.RWMutex
+	hook   zk.WebhookMeta
+	cf     *hookConfig
+
+	deliveries *deliveryLog
+
 	appid, appSignature, userAgent string
 
-	circuits   map[string]*breaker.Consecutive
-	fetcher    *consumergroup.ConsumerGroup
-	msgCh      chan *sarama.ConsumerMessage
 	httpClient *http.Client // it has builtin pooling
 }
 
-func NewWebhookExecutor(parentId, cluster, topic string, endpoints []string,
+// webhookStream is a consumer group of the topic that delivers to its endpoints.
+type webhookStream struct {
+	group    string
+	endpoint string // the only endpoint in fanout mode, empty means all endpoints
+	fetcher  *consumergroup.ConsumerGroup
+	offsets  *offsetTracker
+}
+
+// hookMessage is a message being delivered to the endpoints of a stream.
+type hookMessage struct {
+	cf      *hookConfig
+	msg     *sarama.ConsumerMessage
+	headers gateway.MessageHeaders
+	bodyIdx int
+	pending int32 // endpoints not yet done
+}
+
+func NewWebhookExecutor(parentId, topic string, hook zk.WebhookMeta,
 	stopper <-chan struct{}, auditor log.Logger) *WebhookExecutor {
 	this := &WebhookExecutor{
-		parentId:  parentId,
-		cluster:   cluster,
-		topic:     topic,
-		stopper:   stopper,
-		endpoints: endpoints,
-		auditor:   auditor,
-		userAgent: fmt.Sprintf("actor.%s", gafka.BuildId),
-		msgCh:     make(chan *sarama.ConsumerMessage, 20),
-		circuits:  make(map[string]*breaker.Consecutive, len(endpoints)),
+		parentId:   parentId,
+		cluster:    hook.Cluster,
+		topic:      topic,
+		stopper:    stopper,
+		hook:       hook,
+		auditor:    auditor,
+		deliveries: newDeliveryLog(deliveryLogSize),
+		userAgent:  fmt.Sprintf("actor.%s", gafka.BuildId),
 		httpClient: &http.Client{
 			Timeout: time.Second * 4,
 			Transport: &http.Transport{
@@ -67,26 +111,22 @@ func NewWebhookExecutor(parentId, cluster, topic string, endpoints []string,
 		},
 	}
 
-	for _, ep := range endpoints {
-		this.circuits[ep] = &breaker.Consecutive{
-			RetryTimeout:     time.Second * 5,
-			FailureAllowance: 5,
-		}
-	}
-
+	this.appid = manager.Default.TopicAppid(topic)
 	return this
 }
 
 func (this *WebhookExecutor) Run() {
-	// TODO watch the znode change, its endpoint might change any time
-	if len(this.endpoints) == 0 {
-		log.Warn("%s disabled webhook: empty endpoints", this.topic)
+	if this.appid == "" {
+		log.Warn("invalid topic: %s", this.topic)
 		return
 	}
 
-	this.appid = manager.Default.TopicAppid(this.topic)
-	if this.appid == "" {
-		log.Warn("invalid topic: %s", this.topic)
+	var err error
+	this.cfLock.Lock()
+	this.cf, err = newHookConfig(this.topic, this.appid, this.hook, nil)
+	this.cfLock.Unlock()
+	if err != nil {
+		log.Warn("%s disabled webhook: %s", this.topic, err)
 		return
 	}
 
@@ -95,6 +135,50 @@ func (this *WebhookExecutor) Run() {
 		log.Warn("%s/%s invalid app signature", this.topic, this.appid)
 	}
 
+	cf := this.config()
+	var wg sync.WaitGroup
+	if cf.mode == zk.WebhookModeFanout {
+		for _, ep := range cf.hook.Endpoints {
+			wg.Add(1)
+			go this.consume(&wg, fanoutGroupName(ep), ep, cf.mode, cf.workers)
+		}
+	} else {
+		wg.Add(1)
+		go this.consume(&wg, groupName, "", cf.mode, cf.workers)
+	}
+
+	wg.Wait()
+	log.Debug("%s stopped", this.topic)
+}
+
+// fanoutGroupName returns the consumer group of a fanout endpoint.
+func fanoutGroupName(endpoint string) string {
+	h := fnv.New32a()
+	h.Write([]byte(endpoint))
+	return fmt.Sprintf("%s.%08x", groupName, h.Sum32())
+}
+
+// seedFanoutOffsets starts a fanout group that has no committed offsets yet from where the shared
+// group is, e,g. when switched from serial/parallel to fanout mode or a new endpoint is added.
+// Partitions the shared group never consumed start from the newest message.
+func (this *WebhookExecutor) seedFanoutOffsets(group string) {
+	zkcluster := meta.Default.ZkCluster(this.cluster)
+	if len(zkcluster.ConsumerOffsetsOfGroup(group)[this.topic]) > 0 {
+		return
+	}
+
+	for partition, offset := range zkcluster.ConsumerOffsetsOfGroup(groupName)[this.topic] {
+		if err := zkcluster.InitConsumerGroupOffset(this.topic, group, partition, offset); err != nil {
+			log.Error("%s/%s seed offset %s/%d: %s", this.topic, group, partition, offset, err)
+		} else {
+			log.Info("%s/%s seeded offset %s/%d from %s", this.topic, group, partition, offset, groupName)
+		}
+	}
+}
+
+func (this *WebhookExecutor) consume(wg *sync.WaitGroup, group, endpoint string, mode string, workers int) {
+	defer wg.Done()
+
 	cf := consumergroup.NewConfig()
 	cf.Net.DialTimeout = time.Second * 10
 	cf.Net.WriteTimeout = time.Second * 10
@@ -108,99 +192,355 @@ func (this *WebhookExecutor) Run() {
 	cf.Offsets.ProcessingTimeout = time.Second
 	cf.Offsets.ResetOffsets = false
 	cf.Offsets.Initial = sarama.OffsetOldest
-	cg, err := consumergroup.JoinConsumerGroup(groupName, []string{this.topic}, meta.Default.ZkAddrs(), cf)
+	if endpoint != "" {
+		// a new fanout endpoint must not replay the whole retained topic
+		this.seedFanoutOffsets(group)
+		cf.Offsets.Initial = sarama.OffsetNewest
+	}
+	cg, err := consumergroup.JoinConsumerGroup(group, []string{this.topic}, meta.Default.ZkAddrs(), cf)
 	if err != nil {
-		log.Error("%s stopped: %s", this.topic, err)
+		log.Error("%s/%s stopped: %s", this.topic, group, err)
 		return
 	}
-	this.fetcher = cg
+	defer cg.Close()
 
-	var wg sync.WaitGroup
-	for i := 0; i < 1; i++ {
-		wg.Add(1)
-		go this.pump(&wg)
+	stream := &webhookStream{
+		group:    group,
+		endpoint: endpoint,
+		fetcher:  cg,
+		offsets:  newOffsetTracker(cg.CommitUpto),
+	}
+
+	// ordered: a partition always goes to the same lane
+	// parallel: all the workers share a lane
+	lanes := make([]chan *sarama.ConsumerMessage, workers)
+	for i := range lanes {
+		if mode == zk.WebhookModeParallel && i > 0 {
+			lanes[i] = lanes[0]
+		} else {
+			lanes[i] = make(chan *sarama.ConsumerMessage, 20)
+		}
+	}
+
+	var pumps sync.WaitGroup
+	for i := range lanes {
+		pumps.Add(1)
+		go this.pump(&pumps, stream, lanes[i])
 	}
 
 	for {
 		select {
 		case <-this.stopper:
-			log.Debug("%s stopping", this.topic)
-			wg.Wait()
+			log.Debug("%s/%s stopping", this.topic, group)
+			pumps.Wait()
 			return
 
 		case err := <-cg.Errors():
-			log.Error("%s %s", this.topic, err)
+			log.Error("%s/%s %s", this.topic, group, err)
 			// TODO
 
 		case msg := <-cg.Messages():
-			this.msgCh <- msg
+			stream.offsets.track(msg)
+
+			select {
+			case lanes[int(msg.Partition)%len(lanes)] <- msg:
+			case <-this.stopper:
+				// the pump might be gone, msg is not committed and will be delivered again
+			}
 		}
+	}
+}
 
+// Reload applies the changed webhook to the running executor: endpoints are added or
+// removed, and the message being delivered completes with the former settings.
+// The executor keeps its settings if the webhook is invalid.
+func (this *WebhookExecutor) Reload(hook zk.WebhookMeta) error {
+	this.cfLock.Lock()
+	defer this.cfLock.Unlock()
+
+	cf, err := newHookConfig(this.topic, this.appid, hook, this.cf)
+	if err != nil {
+		return err
+	}
+	if this.cf != nil && this.cf.needRestart(cf) {
+		return ErrRestartRequired
 	}
 
+	this.hook, this.cf = hook, cf
+	log.Info("%s reloaded webhook: %+v", this.topic, hook.Endpoints)
+	return nil
 }
 
-func (this *WebhookExecutor) pump(wg *sync.WaitGroup) {
-	defer wg.Done()
+func (this *WebhookExecutor) config() *hookConfig {
+	this.cfLock.RLock()
+	defer this.cfLock.RUnlock()
+	return this.cf
+}
+
+// pump dispatches the messages of a lane to the delivery queues of the endpoints.
+func (this *WebhookExecutor) pump(wg *sync.WaitGroup, stream *webhookStream, msgCh <-chan *sarama.ConsumerMessage) {
+	var (
+		senders sync.WaitGroup
+		queues  = make(map[string]chan *hookMessage) // key is endpoint
+	)
+	defer func() {
+		for _, q := range queues {
+			close(q)
+		}
+		senders.Wait()
+		wg.Done()
+	}()
 
 	for {
 		select {
 		case <-this.stopper:
 			return
 
-		case msg := <-this.msgCh:
-			for _, ep := range this.endpoints {
-				this.pushToEndpoint(msg, ep)
+		case msg := <-msgCh:
+			cf := this.config()
+			headers, bodyIdx, err := gateway.DecodeMessage(msg.Value)
+			if err != nil || !cf.tagFilter.Match(headers.Tags()) {
+				if err != nil {
+					log.Error("%s %s", this.topic, err)
+				}
+
+				// skipped messages still move the offset ahead
+				this.done(stream, msg)
+				continue
+			}
+
+			endpoints := cf.hook.Endpoints
+			if stream.endpoint != "" {
+				endpoints = []string{stream.endpoint}
 			}
 
-			this.fetcher.CommitUpto(msg)
+			m := &hookMessage{cf: cf, msg: msg, headers: headers, bodyIdx: bodyIdx, pending: int32(len(endpoints))}
+			for _, ep := range endpoints {
+				q, present := queues[ep]
+				if !present {
+					q = make(chan *hookMessage, endpointBacklog)
+					queues[ep] = q
+					senders.Add(1)
+					go this.send(&senders, stream, ep, q)
+				}
+
+				select {
+				case q <- m:
+				case <-this.stopper:
+					// the offset is not committed, will be delivered again after rebalance
+					return
+				}
+			}
+
+			if len(queues) > len(endpoints) {
+				// endpoints removed by reload finish their backlog and quit
+				for ep, q := range queues {
+					if !cf.hasEndpoint(ep) {
+						close(q)
+						delete(queues, ep)
+					}
+				}
+			}
 		}
 	}
-
 }
 
-func (this *WebhookExecutor) pushToEndpoint(msg *sarama.ConsumerMessage, uri string) (ok bool) {
-	log.Debug("%s sending[%s] %s", this.topic, uri, string(msg.Value))
+// send delivers the queued messages to an endpoint in order, a message is done after all
+// of its endpoints are done.
+func (this *WebhookExecutor) send(wg *sync.WaitGroup, stream *webhookStream, endpoint string, q <-chan *hookMessage) {
+	defer wg.Done()
+
+	for {
+		select {
+		case <-this.stopper:
+			return
+
+		case m, ok := <-q:
+			if !ok {
+				return
+			}
 
-	if this.circuits[uri].Open() {
-		log.Warn("%s %s circuit open", this.topic, uri)
-		return false
+			d, stopped := this.deliver(m.cf, m.msg, m.headers, m.bodyIdx, endpoint)
+			if stopped {
+				// the offset is not committed, will be delivered again after rebalance
+				return
+			}
+
+			if d.Failed() {
+				if d.Dead, stopped = this.bury(m, endpoint); stopped {
+					return
+				}
+			}
+			this.deliveries.add(d)
+
+			if atomic.AddInt32(&m.pending, -1) == 0 {
+				this.done(stream, m.msg)
+			}
+		}
 	}
+}
+
+func (this *WebhookExecutor) done(stream *webhookStream, msg *sarama.ConsumerMessage) {
+	if err := stream.offsets.done(msg); err != nil {
+		log.Error("%s/%s commit %d/%d: %s", this.topic, stream.group, msg.Partition, msg.Offset, err)
+	}
+}
+
+// deliver pushes a message to an endpoint until success or retries exhausted.
+// A message that can't be rendered by the template is not retried, it fails the same way each time.
+// stopped is true if the executor is stopped while awaiting retry backoff.
+func (this *WebhookExecutor) deliver(cf *hookConfig, msg *sarama.ConsumerMessage, headers gateway.MessageHeaders,
+	bodyIdx int, uri string) (d Delivery, stopped bool) {
+	d = Delivery{Endpoint: uri, Partition: msg.Partition, Offset: msg.Offset}
+	defer func() {
+		d.Time = time.Now().Unix()
+	}()
 
 	body := mpool.BytesBufferGet()
 	defer mpool.BytesBufferPut(body)
 
 	body.Reset()
-	body.Write(msg.Value)
+	if err := renderBody(body, cf.template, cf.index, this.topic, msg.Partition, msg.Offset,
+		msg.Key, headers, msg.Value[bodyIdx:]); err != nil {
+		d.Error = err.Error()
+		log.Error("%s %s %d/%d render: %s", this.topic, uri, msg.Partition, msg.Offset, err)
+		return
+	}
+
+	backoff := cf.backoff
+	for attempt := 0; attempt <= cf.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-this.stopper:
+				return d, true
+			case <-time.After(backoff):
+			}
+
+			if backoff *= 2; backoff > zk.MaxWebhookBackoff {
+				backoff = zk.MaxWebhookBackoff
+			}
+		}
+
+		t0 := time.Now()
+		status, err := this.pushToEndpoint(cf, msg, headers, body.Bytes(), uri)
+		d.Status, d.Latency, d.Attempts = status, time.Since(t0).Nanoseconds()/1e6, attempt+1
+		if err == nil {
+			d.Error = ""
+			break
+		}
+
+		d.Error = err.Error()
+		log.Warn("%s %s %d/%d #%d: %s", this.topic, uri, msg.Partition, msg.Offset, attempt, err)
+	}
+
+	return
+}
+
+// bury moves a message that failed to deliver to an endpoint to the dead letter topic,
+// the endpoint is kept in the envelope so that it can be redelivered to that endpoint only.
+// buried is false if there is no dead letter topic or burying keeps failing.
+// stopped is true if the executor is stopped before burying.
+func (this *WebhookExecutor) bury(m *hookMessage, endpoint string) (buried, stopped bool) {
+	deadTopic, msg := m.cf.hook.Dead, m.msg
+	if deadTopic == "" {
+		log.Warn("%s dropped %d/%d for %s: no dead letter topic", this.topic, msg.Partition, msg.Offset, endpoint)
+		return
+	}
+
+	headers := make(gateway.MessageHeaders, len(m.headers)+1)
+	for k, v := range m.headers {
+		headers[k] = v
+	}
+	headers[gateway.HeaderEndpoint] = endpoint
+	value := gateway.EncodeMessage(headers, msg.Value[m.bodyIdx:])
+
+	for i := 1; ; i++ {
+		_, _, err := store.DefaultPubStore.SyncPub(this.cluster, deadTopic, msg.Key, value)
+		if err == nil {
+			break
+		}
+
+		log.Error("%s bury %d/%d -> %s #%d %s", this.topic, msg.Partition, msg.Offset, deadTopic, i, err)
+		if i >= maxWebhookBury {
+			// e,g. dead topic deleted: skip it instead of holding back the endpoint forever
+			this.auditor.Trace("lost %s %d/%d %s -> %s", this.topic, msg.Partition, msg.Offset, endpoint, deadTopic)
+			return
+		}
+
+		select {
+		case <-this.stopper:
+			return false, true
+		case <-time.After(retryPubBackoff):
+		}
+	}
 
-	// TODO user defined post body schema, e,g. ElasticSearch
-	req, err := http.NewRequest("POST", uri, body)
+	this.auditor.Trace("bury %s %d/%d %s -> %s", this.topic, msg.Partition, msg.Offset, endpoint, deadTopic)
+	return true, false
+}
+
+// Deliveries returns at most n recent deliveries, the latest first.
+func (this *WebhookExecutor) Deliveries(n int, failedOnly bool) []Delivery {
+	return this.deliveries.recent(n, failedOnly)
+}
+
+func (this *WebhookExecutor) pushToEndpoint(cf *hookConfig, msg *sarama.ConsumerMessage, headers gateway.MessageHeaders,
+	body []byte, uri string) (status int, err error) {
+	log.Debug("%s sending[%s] %s", this.topic, uri, string(body))
+
+	if cf.circuits[uri].Open() {
+		return 0, ErrCircuitOpen
+	}
+
+	// receiver verifies the delivery with hmac-sha256(secret, timestamp + "." + body)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	signed := mpool.BytesBufferGet()
+	defer mpool.BytesBufferPut(signed)
+
+	signed.Reset()
+	signed.WriteString(ts)
+	signed.WriteByte('.')
+	signed.Write(body)
+	signature := manager.Default.Sign(cf.signAppid, signed.Bytes())
+
+	req, err := http.NewRequest("POST", uri, bytes.NewReader(body))
 	if err != nil {
-		this.circuits[uri].Fail()
-		return false
+		cf.circuits[uri].Fail()
+		return
 	}
 
+	// custom headers go first so that the builtin ones win, except that the raw
+	// template has no builtin Content-Type and takes the custom one if any
+	for k, v := range cf.hook.Headers {
+		req.Header.Set(k, v)
+	}
+	if ct := contentType(cf.template); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
 	req.Header.Set(gateway.HttpHeaderOffset, strconv.FormatInt(msg.Offset, 10))
 	req.Header.Set(gateway.HttpHeaderPartition, strconv.FormatInt(int64(msg.Partition), 10))
+	req.Header.Set(gateway.HttpHeaderTimestamp, ts)
+	if signature != "" {
+		req.Header.Set(gateway.HttpHeaderSignature, signature)
+	}
 	req.Header.Set("User-Agent", this.userAgent)
 	req.Header.Set("X-App-Signature", this.appSignature)
+	headers.WriteHttpHeader(req.Header)
 	response, err := this.httpClient.Do(req)
 	if err != nil {
-		log.Error("%s %s %s", this.topic, uri, err)
-		this.circuits[uri].Fail()
-		return false
+		cf.circuits[uri].Fail()
+		return
 	}
 
 	io.Copy(ioutil.Discard, response.Body)
 	response.Body.Close()
 
-	if response.StatusCode >= 300 {
-		this.circuits[uri].Fail()
-		log.Error("%s %s response: %s", this.topic, uri, http.StatusText(response.StatusCode))
-		return
+	status = response.StatusCode
+	if status >= 300 {
+		cf.circuits[uri].Fail()
+		return status, fmt.Errorf("response: %s", http.StatusText(status))
 	}
 
 	// audit
 	log.Info("pushed %s/%d %d", this.topic, msg.Partition, msg.Offset)
-	return true
+	return
 }
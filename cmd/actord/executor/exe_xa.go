@@ -0,0 +1,385 @@
+package executor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/funkygao/fae/servant/mysql"
+	"github.com/funkygao/gafka"
+	"github.com/funkygao/gafka/cmd/kateway/hh"
+	"github.com/funkygao/gafka/cmd/kateway/job"
+	jm "github.com/funkygao/gafka/cmd/kateway/job/mysql"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	"github.com/funkygao/gafka/zk"
+	log "github.com/funkygao/log4go"
+	zklib "github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	XaCheckbackAfter       = 10  // in sec, how long a prepared message waits before checkback
+	XaCheckbackBatchN      = 20  // max prepared messages to checkback in each round
+	XaCheckbackParallelism = 4   // max concurrent checkbacks
+	XaDeliverBatchN        = 100 // max committed messages to deliver in each tick
+)
+
+// XaMaxCheckbacks is how many unknown checkbacks a prepared message gets, after which it is
+// kept unresolved for the producer to commit or rollback instead of being delivered or dropped.
+var XaMaxCheckbacks = 15
+
+// XaExecutor delivers committed XA messages of a single JobQueue and
+// checkbacks the producer for prepared messages whose state is unknown.
+type XaExecutor struct {
+	parentId       string // controller short id
+	cluster, topic string
+	mc             *mysql.MysqlCluster
+	orchestrator   *zk.Orchestrator
+	stopper        <-chan struct{}
+	auditor        log.Logger
+	userAgent      string
+	httpClient     *http.Client
+
+	// cached values
+	appid string
+	aid   int
+	table string
+	ident string
+}
+
+func NewXaExecutor(parentId, cluster, topic string, mc *mysql.MysqlCluster, orchestrator *zk.Orchestrator,
+	stopper <-chan struct{}, auditor log.Logger) *XaExecutor {
+	return &XaExecutor{
+		parentId:     parentId,
+		cluster:      cluster,
+		topic:        topic,
+		mc:           mc,
+		orchestrator: orchestrator,
+		stopper:      stopper,
+		auditor:      auditor,
+		userAgent:    fmt.Sprintf("actor.%s", gafka.BuildId),
+		httpClient: &http.Client{
+			Timeout: time.Second * 4,
+			Transport: &http.Transport{
+				Dial: (&net.Dialer{
+					Timeout: time.Second * 4,
+				}).Dial,
+				ResponseHeaderTimeout: time.Second * 4,
+				TLSHandshakeTimeout:   time.Second * 4,
+			},
+		},
+	}
+}
+
+func (this *XaExecutor) Run() {
+	this.appid = manager.Default.TopicAppid(this.topic)
+	if this.appid == "" {
+		log.Warn("invalid topic: %s", this.topic)
+		return
+	}
+	this.aid = jm.App_id(this.appid)
+	this.table = jm.XaTable(this.topic)
+	this.ident = "xa:" + this.topic
+
+	log.Trace("starting %s", this.Ident())
+
+	if !this.createTable() {
+		return
+	}
+
+	// slow checkbacks must not delay the delivery of committed messages
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		this.checkbackLoop()
+	}()
+
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-this.stopper:
+			log.Debug("%s stopping", this.ident)
+			wg.Wait()
+			return
+
+		case <-tick.C:
+			this.deliverCommitted()
+		}
+	}
+}
+
+// createTable creates the xa table on demand because job queues created before XA have no such table.
+// Returns false if stopped before the table is created.
+func (this *XaExecutor) createTable() bool {
+	for {
+		_, _, err := this.mc.Exec(jm.AppPool, this.table, this.aid, jm.XaTableSchema(this.topic))
+		if err == nil {
+			return true
+		}
+
+		log.Error("%s: %v", this.ident, err)
+
+		select {
+		case <-this.stopper:
+			return false
+		case <-time.After(time.Second * 10):
+		}
+	}
+}
+
+func (this *XaExecutor) checkbackLoop() {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-this.stopper:
+			return
+
+		case now := <-tick.C:
+			this.checkbackPrepared(now)
+		}
+	}
+}
+
+type xaItem struct {
+	xaId    int64
+	payload []byte
+	checks  int
+	ctime   int64
+	mtime   int64
+}
+
+func (this *XaExecutor) deliverCommitted() {
+	sql := fmt.Sprintf("SELECT xa_id,payload,ctime,mtime FROM %s WHERE state=? LIMIT %d", this.table, XaDeliverBatchN)
+	rows, err := this.mc.Query(jm.AppPool, this.table, this.aid, sql, job.XaCommitted)
+	if err != nil {
+		log.Error("%s: %v", this.ident, err)
+		return
+	}
+
+	var items []xaItem
+	for rows.Next() {
+		var item xaItem
+		if err = rows.Scan(&item.xaId, &item.payload, &item.ctime, &item.mtime); err != nil {
+			log.Error("%s: %s", this.ident, err)
+			continue
+		}
+
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		log.Error("%s: %s", this.ident, err)
+	}
+	rows.Close()
+
+	var (
+		sqlDelete        = fmt.Sprintf("DELETE FROM %s WHERE xa_id=? AND state=?", this.table)
+		sqlInsertArchive = fmt.Sprintf("INSERT INTO %s(job_id,payload,ctime,due_time,etime,actor_id) VALUES(?,?,?,?,?,?)",
+			jm.HistoryTable(this.topic))
+	)
+	for _, item := range items {
+		// publish before delete: a crash in between redelivers the message instead of losing it
+		_, _, err = store.DefaultPubStore.SyncPub(this.cluster, this.topic, nil, item.payload)
+		if err != nil {
+			err = hh.Default.Append(this.cluster, this.topic, nil, item.payload)
+		}
+		if err != nil {
+			// pub fails and hinted handoff also fails: the row stays committed and is retried next tick
+			log.Error("%s %d: %s", this.ident, item.xaId, err)
+			continue
+		}
+
+		log.Debug("%s fired %d", this.ident, item.xaId)
+		this.auditor.Trace("%s fired %d", this.ident, item.xaId)
+
+		affectedRows, _, err := this.mc.Exec(jm.AppPool, this.table, this.aid, sqlDelete, item.xaId, job.XaCommitted)
+		if err != nil {
+			// will be delivered again next tick
+			log.Error("%s %d delivered but not deleted: %s", this.ident, item.xaId, err)
+			continue
+		}
+		if affectedRows == 0 {
+			// should never happen: each job queue has a single owner
+			continue
+		}
+
+		_, _, err = this.mc.Exec(jm.AppPool, jm.HistoryTable(this.topic), this.aid, sqlInsertArchive,
+			item.xaId, item.payload, item.ctime, item.mtime, time.Now().Unix(), this.parentId)
+		if err != nil {
+			log.Error("%s: %s", this.ident, err)
+		}
+	}
+}
+
+func (this *XaExecutor) checkbackPrepared(now time.Time) {
+	sql := fmt.Sprintf("SELECT xa_id,checks FROM %s WHERE state=? AND mtime<=? LIMIT %d",
+		this.table, XaCheckbackBatchN)
+	rows, err := this.mc.Query(jm.AppPool, this.table, this.aid, sql,
+		job.XaPrepared, now.Unix()-XaCheckbackAfter)
+	if err != nil {
+		log.Error("%s: %v", this.ident, err)
+		return
+	}
+
+	var items []xaItem
+	for rows.Next() {
+		var item xaItem
+		if err = rows.Scan(&item.xaId, &item.checks); err != nil {
+			log.Error("%s: %s", this.ident, err)
+			continue
+		}
+
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		log.Error("%s: %s", this.ident, err)
+	}
+	rows.Close()
+
+	if len(items) == 0 {
+		return
+	}
+
+	// the checkback url is registered by the admin only, never by Pub clients
+	var checkback string
+	xa, err := this.orchestrator.XaInfo(this.topic)
+	switch err {
+	case nil:
+		checkback = xa.Checkback
+
+	case zklib.ErrNoNode:
+		log.Warn("%s has no registered checkback", this.ident)
+
+	default:
+		log.Error("%s: %v", this.ident, err)
+		return
+	}
+
+	var (
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, XaCheckbackParallelism)
+	)
+	for _, item := range items {
+		select {
+		case <-this.stopper:
+			wg.Wait()
+			return
+		case semaphore <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(item xaItem) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			this.settle(item, checkback)
+		}(item)
+	}
+	wg.Wait()
+}
+
+// settle checkbacks a prepared message and commits, rollbacks or keeps it by the answer.
+func (this *XaExecutor) settle(item xaItem, checkback string) {
+	var (
+		sqlCommit     = fmt.Sprintf("UPDATE %s SET state=?,mtime=? WHERE xa_id=? AND state=?", this.table)
+		sqlRollback   = fmt.Sprintf("DELETE FROM %s WHERE xa_id=? AND state=?", this.table)
+		sqlUnknown    = fmt.Sprintf("UPDATE %s SET checks=checks+1,mtime=? WHERE xa_id=? AND state=?", this.table)
+		sqlUnresolved = fmt.Sprintf("UPDATE %s SET state=?,checks=checks+1,mtime=? WHERE xa_id=? AND state=?", this.table)
+		err           error
+	)
+
+	var state string
+	if checkback != "" {
+		state = this.checkback(item, checkback)
+	}
+	switch state {
+	case "commit":
+		_, _, err = this.mc.Exec(jm.AppPool, this.table, this.aid, sqlCommit,
+			job.XaCommitted, time.Now().Unix(), item.xaId, job.XaPrepared)
+
+	case "rollback":
+		_, _, err = this.mc.Exec(jm.AppPool, this.table, this.aid, sqlRollback,
+			item.xaId, job.XaPrepared)
+
+	default:
+		if item.checks+1 >= XaMaxCheckbacks {
+			// the transaction might have committed, never drop it silently
+			state = "unresolved"
+			log.Error("%s %d unresolved after %d checkbacks, awaiting producer commit or rollback",
+				this.ident, item.xaId, item.checks+1)
+
+			_, _, err = this.mc.Exec(jm.AppPool, this.table, this.aid, sqlUnresolved,
+				job.XaUnresolved, time.Now().Unix(), item.xaId, job.XaPrepared)
+		} else {
+			state = "unknown"
+			_, _, err = this.mc.Exec(jm.AppPool, this.table, this.aid, sqlUnknown,
+				time.Now().Unix(), item.xaId, job.XaPrepared)
+		}
+	}
+
+	if err != nil {
+		log.Error("%s %d %s: %s", this.ident, item.xaId, state, err)
+		return
+	}
+
+	this.auditor.Trace("%s checkback %d #%d %s", this.ident, item.xaId, item.checks+1, state)
+}
+
+// checkback asks the producer the transaction state of a prepared message.
+// Returns commit, rollback or empty string if unknown.
+func (this *XaExecutor) checkback(item xaItem, checkback string) string {
+	u, err := url.Parse(checkback)
+	if err != nil {
+		log.Error("%s %d checkback: %s", this.ident, item.xaId, err)
+		return ""
+	}
+
+	q := u.Query()
+	q.Set("id", strconv.FormatInt(item.xaId, 10))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		log.Error("%s %d checkback: %s", this.ident, item.xaId, err)
+		return ""
+	}
+
+	req.Header.Set("User-Agent", this.userAgent)
+	req.Header.Set("X-Topic", this.topic)
+	response, err := this.httpClient.Do(req)
+	if err != nil {
+		log.Error("%s %d checkback: %s", this.ident, item.xaId, err)
+		return ""
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(response.Body, 64))
+	response.Body.Close()
+	if err != nil || response.StatusCode != http.StatusOK {
+		log.Error("%s %d checkback: %s %v", this.ident, item.xaId, response.Status, err)
+		return ""
+	}
+
+	switch state := string(bytes.TrimSpace(b)); state {
+	case "commit", "rollback":
+		return state
+	}
+
+	return ""
+}
+
+func (this *XaExecutor) Ident() string {
+	return this.ident
+}
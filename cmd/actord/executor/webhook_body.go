@@ -0,0 +1,69 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/funkygao/gafka/cmd/kateway/gateway"
+	"github.com/funkygao/gafka/zk"
+)
+
+// envelope is the post body of json template.
+type envelope struct {
+	Topic     string                 `json:"topic"`
+	Partition int32                  `json:"partition"`
+	Offset    int64                  `json:"offset"`
+	Key       string                 `json:"key,omitempty"`
+	Headers   gateway.MessageHeaders `json:"headers,omitempty"`
+	Value     string                 `json:"value"`
+}
+
+// renderBody writes the post body of a message according to the webhook template.
+func renderBody(w *bytes.Buffer, template, index, topic string, partition int32, offset int64,
+	key []byte, headers gateway.MessageHeaders, body []byte) error {
+	switch template {
+	case zk.WebhookTemplateJson:
+		return json.NewEncoder(w).Encode(envelope{
+			Topic:     topic,
+			Partition: partition,
+			Offset:    offset,
+			Key:       string(key),
+			Headers:   headers,
+			Value:     string(body),
+		})
+
+	case zk.WebhookTemplateEs:
+		// the doc id makes redelivery idempotent
+		action := map[string]map[string]string{
+			"index": {"_index": index, "_id": fmt.Sprintf("%d-%d", partition, offset)},
+		}
+		if err := json.NewEncoder(w).Encode(action); err != nil {
+			return err
+		}
+
+		// bulk api is newline delimited, compact also validates the doc
+		if err := json.Compact(w, body); err != nil {
+			return err
+		}
+		return w.WriteByte('\n')
+
+	default:
+		_, err := w.Write(body)
+		return err
+	}
+}
+
+// contentType returns the http Content-Type of a webhook template.
+func contentType(template string) string {
+	switch template {
+	case zk.WebhookTemplateJson:
+		return "application/json"
+
+	case zk.WebhookTemplateEs:
+		return "application/x-ndjson"
+
+	default:
+		return ""
+	}
+}
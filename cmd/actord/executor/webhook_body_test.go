@@ -0,0 +1,26 @@
+package executor
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/zk"
+)
+
+func TestRenderBody(t *testing.T) {
+	var w bytes.Buffer
+	assert.Equal(t, nil, renderBody(&w, zk.WebhookTemplateRaw, "", "app1.foo.v1", 1, 2, nil, nil, []byte("hello")))
+	assert.Equal(t, "hello", w.String())
+
+	w.Reset()
+	assert.Equal(t, nil, renderBody(&w, zk.WebhookTemplateJson, "", "app1.foo.v1", 1, 2, []byte("k"), nil, []byte(`say "hi"`)))
+	assert.Equal(t, `{"topic":"app1.foo.v1","partition":1,"offset":2,"key":"k","value":"say \"hi\""}`+"\n", w.String())
+
+	w.Reset()
+	assert.Equal(t, nil, renderBody(&w, zk.WebhookTemplateEs, "orders", "app1.foo.v1", 1, 2, nil, nil, []byte("{\"a\": 1,\n \"b\": 2}")))
+	assert.Equal(t, `{"index":{"_id":"1-2","_index":"orders"}}`+"\n"+`{"a":1,"b":2}`+"\n", w.String())
+
+	w.Reset()
+	assert.NotEqual(t, nil, renderBody(&w, zk.WebhookTemplateEs, "orders", "app1.foo.v1", 1, 2, nil, nil, []byte("hello")))
+}
@@ -0,0 +1,103 @@
+package executor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/gateway"
+	"github.com/funkygao/gafka/zk"
+	"github.com/funkygao/golib/breaker"
+)
+
+// hookConfig is the live settings of a webhook executor, replaced as a whole on reload.
+type hookConfig struct {
+	hook      zk.WebhookMeta
+	mode      string
+	workers   int
+	retries   int
+	backoff   time.Duration // of the 1st retry
+	template  string
+	index     string
+	signAppid string // whose secret signs the deliveries
+	tagFilter *gateway.TagFilter
+	circuits  map[string]*breaker.Consecutive
+}
+
+// newHookConfig validates a webhook and builds its config.
+// The endpoints that still exist in old keep their circuits.
+func newHookConfig(topic, appid string, hook zk.WebhookMeta, old *hookConfig) (*hookConfig, error) {
+	if len(hook.Endpoints) == 0 {
+		return nil, ErrEmptyEndpoints
+	}
+
+	var err error
+	cf := &hookConfig{
+		hook:      hook,
+		index:     hook.Index,
+		signAppid: hook.Appid,
+		circuits:  make(map[string]*breaker.Consecutive, len(hook.Endpoints)),
+	}
+	if cf.mode, cf.workers, err = hook.DeliveryMode(); err != nil {
+		return nil, err
+	}
+	if cf.retries, cf.backoff, err = hook.RetryPolicy(); err != nil {
+		return nil, err
+	}
+	if cf.template, err = hook.BodyTemplate(); err != nil {
+		return nil, err
+	}
+	if cf.tagFilter, err = gateway.CompileTagFilter(hook.Filter); err != nil {
+		return nil, fmt.Errorf("filter %s %s", hook.Filter, err)
+	}
+
+	if cf.index == "" {
+		cf.index = topic
+	}
+	if cf.signAppid == "" {
+		// webhook created before deliveries are signed
+		cf.signAppid = appid
+	}
+
+	for _, ep := range hook.Endpoints {
+		if old != nil {
+			if circuit, present := old.circuits[ep]; present {
+				cf.circuits[ep] = circuit
+				continue
+			}
+		}
+
+		cf.circuits[ep] = &breaker.Consecutive{
+			RetryTimeout:     time.Second * 5,
+			FailureAllowance: 5,
+		}
+	}
+
+	return cf, nil
+}
+
+func (this *hookConfig) hasEndpoint(endpoint string) bool {
+	_, present := this.circuits[endpoint]
+	return present
+}
+
+// needRestart checks if the consumer groups or workers of the executor have to change.
+func (this *hookConfig) needRestart(cf *hookConfig) bool {
+	if this.mode != cf.mode || this.workers != cf.workers {
+		return true
+	}
+
+	if this.mode != zk.WebhookModeFanout {
+		return false
+	}
+
+	// each fanout endpoint has its own consumer group
+	if len(this.hook.Endpoints) != len(cf.hook.Endpoints) {
+		return true
+	}
+	for _, ep := range cf.hook.Endpoints {
+		if _, present := this.circuits[ep]; !present {
+			return true
+		}
+	}
+	return false
+}
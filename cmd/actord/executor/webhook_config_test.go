@@ -0,0 +1,54 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/zk"
+)
+
+func TestNewHookConfig(t *testing.T) {
+	hook := zk.WebhookMeta{Endpoints: []string{"http://a.com", "http://b.com"}}
+	cf, err := newHookConfig("app1.foo.v1", "app1", hook, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, zk.DefaultWebhookRetries, cf.retries)
+	assert.Equal(t, zk.WebhookTemplateRaw, cf.template)
+	assert.Equal(t, "app1.foo.v1", cf.index)
+	assert.Equal(t, "app1", cf.signAppid)
+	assert.Equal(t, 2, len(cf.circuits))
+
+	// reload keeps the circuits of existing endpoints
+	hook.Endpoints = []string{"http://b.com", "http://c.com"}
+	hook.Appid = "app2"
+	reloaded, err := newHookConfig("app1.foo.v1", "app1", hook, cf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "app2", reloaded.signAppid)
+	assert.Equal(t, 2, len(reloaded.circuits))
+	assert.Equal(t, true, cf.circuits["http://b.com"] == reloaded.circuits["http://b.com"])
+	_, present := reloaded.circuits["http://a.com"]
+	assert.Equal(t, false, present)
+
+	assert.Equal(t, false, cf.needRestart(reloaded))
+	hook.Mode = zk.WebhookModeParallel
+	parallel, err := newHookConfig("app1.foo.v1", "app1", hook, cf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, cf.needRestart(parallel))
+
+	// each fanout endpoint has its own consumer group
+	hook.Mode = zk.WebhookModeFanout
+	fanout, _ := newHookConfig("app1.foo.v1", "app1", hook, nil)
+	assert.Equal(t, false, fanout.needRestart(fanout))
+	hook.Endpoints = []string{"http://b.com", "http://d.com"}
+	changed, _ := newHookConfig("app1.foo.v1", "app1", hook, fanout)
+	assert.Equal(t, true, fanout.needRestart(changed))
+	hook.Mode = ""
+
+	hook.Endpoints = nil
+	_, err = newHookConfig("app1.foo.v1", "app1", hook, cf)
+	assert.Equal(t, ErrEmptyEndpoints, err)
+
+	hook.Endpoints = []string{"http://a.com"}
+	hook.Template = "xml"
+	_, err = newHookConfig("app1.foo.v1", "app1", hook, cf)
+	assert.Equal(t, zk.ErrInvalidTemplate, err)
+}
@@ -0,0 +1,67 @@
+package executor
+
+import (
+	"sync"
+)
+
+// Delivery is the outcome of pushing a message to a webhook endpoint.
+type Delivery struct {
+	Time      int64  `json:"time"` // when the delivery finished
+	Endpoint  string `json:"endpoint"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Status    int    `json:"status"`  // http status of the last attempt, 0 if no response
+	Latency   int64  `json:"latency"` // of the last attempt in ms
+	Attempts  int    `json:"attempts"`
+	Error     string `json:"error,omitempty"`
+	Dead      bool   `json:"dead,omitempty"` // buried to dead letter topic after exhausting retries
+}
+
+func (this Delivery) Failed() bool {
+	return this.Error != ""
+}
+
+// deliveryLog keeps the recent deliveries of a webhook in a ring buffer.
+type deliveryLog struct {
+	mu      sync.Mutex
+	entries []Delivery
+	next    int
+	full    bool
+}
+
+func newDeliveryLog(size int) *deliveryLog {
+	return &deliveryLog{entries: make([]Delivery, size)}
+}
+
+func (this *deliveryLog) add(d Delivery) {
+	this.mu.Lock()
+	this.entries[this.next] = d
+	this.next++
+	if this.next == len(this.entries) {
+		this.next = 0
+		this.full = true
+	}
+	this.mu.Unlock()
+}
+
+// recent returns at most n deliveries, the latest first.
+func (this *deliveryLog) recent(n int, failedOnly bool) []Delivery {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	size := this.next
+	if this.full {
+		size = len(this.entries)
+	}
+
+	r := make([]Delivery, 0, n)
+	for i := 1; i <= size && len(r) < n; i++ {
+		d := this.entries[(this.next-i+len(this.entries))%len(this.entries)]
+		if failedOnly && !d.Failed() {
+			continue
+		}
+
+		r = append(r, d)
+	}
+	return r
+}
@@ -0,0 +1,34 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestDeliveryLog(t *testing.T) {
+	l := newDeliveryLog(3)
+	assert.Equal(t, 0, len(l.recent(10, false)))
+
+	for i := int64(0); i < 5; i++ {
+		d := Delivery{Offset: i}
+		if i%2 == 0 {
+			d.Error = "500"
+		}
+		l.add(d)
+	}
+
+	r := l.recent(10, false)
+	assert.Equal(t, 3, len(r))
+	assert.Equal(t, int64(4), r[0].Offset)
+	assert.Equal(t, int64(2), r[2].Offset)
+
+	r = l.recent(1, false)
+	assert.Equal(t, 1, len(r))
+	assert.Equal(t, int64(4), r[0].Offset)
+
+	r = l.recent(10, true)
+	assert.Equal(t, 2, len(r))
+	assert.Equal(t, int64(4), r[0].Offset)
+	assert.Equal(t, int64(2), r[1].Offset)
+}
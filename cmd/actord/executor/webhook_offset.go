@@ -0,0 +1,61 @@
+package executor
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// offsetTracker commits the offset of a partition only after all the messages
+// fetched before it are done, because concurrent deliveries complete out of order.
+type offsetTracker struct {
+	mu         sync.Mutex
+	commit     func(*sarama.ConsumerMessage) error
+	partitions map[int32][]*trackedMsg // in fetch order
+}
+
+type trackedMsg struct {
+	msg  *sarama.ConsumerMessage
+	done bool
+}
+
+func newOffsetTracker(commit func(*sarama.ConsumerMessage) error) *offsetTracker {
+	return &offsetTracker{
+		commit:     commit,
+		partitions: make(map[int32][]*trackedMsg),
+	}
+}
+
+// track must be called in the fetch order before the message is delivered.
+func (this *offsetTracker) track(msg *sarama.ConsumerMessage) {
+	this.mu.Lock()
+	this.partitions[msg.Partition] = append(this.partitions[msg.Partition], &trackedMsg{msg: msg})
+	this.mu.Unlock()
+}
+
+// done marks a message done and commits up to the last done message before any pending one.
+func (this *offsetTracker) done(msg *sarama.ConsumerMessage) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	q := this.partitions[msg.Partition]
+	for _, t := range q {
+		if t.msg == msg {
+			t.done = true
+			break
+		}
+	}
+
+	var upto *sarama.ConsumerMessage
+	for len(q) > 0 && q[0].done {
+		upto = q[0].msg
+		q[0] = nil
+		q = q[1:]
+	}
+	this.partitions[msg.Partition] = q
+	if upto == nil {
+		return nil
+	}
+
+	return this.commit(upto)
+}
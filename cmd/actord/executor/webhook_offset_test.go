@@ -0,0 +1,40 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/assert"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	var committed []int64
+	tracker := newOffsetTracker(func(msg *sarama.ConsumerMessage) error {
+		committed = append(committed, msg.Offset)
+		return nil
+	})
+
+	msgs := make([]*sarama.ConsumerMessage, 4)
+	for i := range msgs {
+		msgs[i] = &sarama.ConsumerMessage{Partition: 0, Offset: int64(i)}
+		tracker.track(msgs[i])
+	}
+	p1 := &sarama.ConsumerMessage{Partition: 1, Offset: 9}
+	tracker.track(p1)
+
+	// out of order completion never commits beyond a pending message
+	tracker.done(msgs[2])
+	assert.Equal(t, 0, len(committed))
+	tracker.done(msgs[1])
+	assert.Equal(t, 0, len(committed))
+	tracker.done(msgs[0])
+	assert.Equal(t, []int64{2}, committed)
+
+	// partitions are independent
+	tracker.done(p1)
+	assert.Equal(t, []int64{2, 9}, committed)
+
+	tracker.done(msgs[3])
+	assert.Equal(t, []int64{2, 9, 3}, committed)
+	assert.Equal(t, 0, len(tracker.partitions[0]))
+}
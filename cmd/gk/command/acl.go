@@ -0,0 +1,143 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/funkygao/columnize"
+	"github.com/funkygao/gafka/cmd/kateway/acl"
+	"github.com/funkygao/gafka/cmd/kateway/acl/zkacl"
+	"github.com/funkygao/gafka/ctx"
+	"github.com/funkygao/gafka/zk"
+	"github.com/funkygao/gocli"
+)
+
+type Acl struct {
+	Ui  cli.Ui
+	Cmd string
+}
+
+func (this *Acl) Run(args []string) (exitCode int) {
+	var (
+		zone   string
+		add    bool
+		delId  string
+		filter string
+		rule   acl.Rule
+	)
+	cmdFlags := flag.NewFlagSet("acl", flag.ContinueOnError)
+	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
+	cmdFlags.StringVar(&zone, "z", ctx.DefaultZone(), "")
+	cmdFlags.BoolVar(&add, "add", false, "")
+	cmdFlags.StringVar(&delId, "del", "", "")
+	cmdFlags.StringVar(&filter, "p", "", "")
+	cmdFlags.StringVar(&rule.Principal, "principal", "", "")
+	cmdFlags.StringVar(&rule.Resource, "resource", "", "")
+	cmdFlags.StringVar(&rule.Group, "group", "", "")
+	cmdFlags.StringVar(&rule.Operation, "op", "", "")
+	cmdFlags.StringVar(&rule.Effect, "effect", acl.Allow, "")
+	cmdFlags.StringVar(&rule.Note, "note", "", "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	zkzone := zk.NewZkZone(zk.DefaultConfig(zone, ctx.ZoneZkAddrs(zone)))
+	defer zkzone.Close()
+
+	// kateways pick up the changes on their next refresh
+	authorizer := zkacl.New(zkzone, 0)
+	switch {
+	case add:
+		r, err := authorizer.Add(rule)
+		if err != nil {
+			this.Ui.Error(err.Error())
+			return 1
+		}
+
+		this.Ui.Info(fmt.Sprintf("rule#%s added", r.Id))
+
+	case delId != "":
+		if err := authorizer.Remove(delId); err != nil {
+			this.Ui.Error(err.Error())
+			return 1
+		}
+
+		this.Ui.Info(fmt.Sprintf("rule#%s removed", delId))
+
+	default:
+		rules, err := authorizer.Rules()
+		if err != nil {
+			this.Ui.Error(err.Error())
+			return 1
+		}
+
+		this.displayRules(rules, filter)
+	}
+
+	return
+}
+
+func (this *Acl) displayRules(rules []acl.Rule, principal string) {
+	lines := []string{"Id|Principal|Resource|Group|Op|Effect|Ctime|Note"}
+	for _, r := range rules {
+		if principal != "" && !acl.Match(r.Principal, principal) {
+			continue
+		}
+
+		group := r.Group
+		if group == "" {
+			group = "-"
+		}
+		lines = append(lines, fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s|%s", r.Id, r.Principal, r.Resource,
+			group, r.Operation, r.Effect, time.Unix(r.Ctime, 0).Format("2006-01-02 15:04:05"), r.Note))
+	}
+
+	this.Ui.Output(columnize.SimpleFormat(lines))
+}
+
+func (*Acl) Synopsis() string {
+	return "Manage kateway acl rules"
+}
+
+func (this *Acl) Help() string {
+	help := fmt.Sprintf(`
+Usage: %s acl [options]
+
+    %s
+
+    A deny rule wins over an allow rule.
+    When no rule matches, the ownership checks of kateway apply.
+
+Options:
+
+    -z zone
+
+    -p appid
+      Display only the rules applying to the app.
+
+    -add
+      Add a rule.
+
+      -principal appid pattern
+        * matches any sequence, e,g. app*
+
+      -resource appid.topic.ver pattern
+        e,g. app1.orders.*
+
+      -group consumer group pattern
+        Default matches any group.
+
+      -op <pub|sub|admin|bury|reset-offset|*>
+
+      -effect <allow|deny>
+        Default allow.
+
+      -note text
+
+    -del rule id
+
+`, this.Cmd, this.Synopsis())
+	return strings.TrimSpace(help)
+}
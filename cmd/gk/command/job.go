@@ -28,6 +28,7 @@ type Job struct {
 	mc     *mysql.MysqlCluster
 	zkzone *zk.ZkZone
 	due    int
+	topic  string
 }
 
 func (this *Job) Run(args []string) (exitCode int) {
@@ -35,6 +36,8 @@ func (this *Job) Run(args []string) (exitCode int) {
 		zone    string
 		appid   string
 		initJob string
+		jobId   string
+		due     int64
 	)
 	cmdFlags := flag.NewFlagSet("job", flag.ContinueOnError)
 	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
@@ -42,6 +45,9 @@ func (this *Job) Run(args []string) (exitCode int) {
 	cmdFlags.StringVar(&appid, "app", "", "")
 	cmdFlags.IntVar(&this.due, "d", 0, "")
 	cmdFlags.StringVar(&initJob, "init", "", "")
+	cmdFlags.StringVar(&this.topic, "t", "", "")
+	cmdFlags.StringVar(&jobId, "id", "", "")
+	cmdFlags.Int64Var(&due, "reschedule", 0, "")
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
@@ -52,6 +58,21 @@ func (this *Job) Run(args []string) (exitCode int) {
 		return
 	}
 
+	if jobId != "" {
+		if appid == "" || this.topic == "" {
+			this.Ui.Error("-app and -t required")
+			return 2
+		}
+
+		this.displayJob(appid, jobId, due)
+		return
+	}
+
+	if appid != "" && this.topic != "" {
+		this.displayTopicJobs(appid)
+		return
+	}
+
 	if appid != "" {
 		this.displayAppJobs(appid)
 		return
@@ -141,6 +162,84 @@ func (this *Job) displayAppJobs(appid string) {
 	}
 }
 
+func (this *Job) displayJob(appid, jobId string, due int64) {
+	store := this.jobStore()
+	defer store.Stop()
+
+	if due > 0 {
+		swallow(store.Reschedule(appid, this.topic, jobId, due))
+		this.Ui.Info(fmt.Sprintf("%s rescheduled to %s", jobId, time.Unix(due, 0)))
+	}
+
+	item, err := store.Get(appid, this.topic, jobId)
+	if err == job.ErrNoSuchJob {
+		this.Ui.Warn(fmt.Sprintf("%s not found: never added, deleted or a paused recurring job", jobId))
+		return
+	}
+	swallow(err)
+
+	lines := []string{"JobId|State|Ctime|Due|Fired|Actor|Payload"}
+	lines = append(lines, this.jobLine(item))
+	this.Ui.Output(columnize.SimpleFormat(lines))
+}
+
+func (this *Job) displayTopicJobs(appid string) {
+	store := this.jobStore()
+	defer store.Stop()
+
+	lines := []string{"JobId|State|Ctime|Due|Fired|Actor|Payload"}
+	for _, state := range []string{job.JobPending, job.JobFired} {
+		opt := job.ListOption{State: state, Limit: 100}
+		if this.due > 0 {
+			opt.DueTo = time.Now().Unix() + int64(this.due)
+		}
+
+		for {
+			items, cursor, err := store.List(appid, this.topic, opt)
+			swallow(err)
+
+			for _, item := range items {
+				lines = append(lines, this.jobLine(item))
+			}
+
+			if cursor == "" {
+				break
+			}
+			opt.Cursor = cursor
+		}
+	}
+
+	if len(lines) > 1 {
+		this.Ui.Output(columnize.SimpleFormat(lines))
+	}
+}
+
+func (this *Job) jobLine(item job.JobItem) string {
+	var fired string
+	if item.Etime > 0 {
+		fired = time.Unix(item.Etime, 0).Format("01-02 15:04:05")
+	}
+
+	return fmt.Sprintf("%d|%s|%s|%s|%s|%s|%s", item.JobId, item.State,
+		time.Unix(item.Ctime, 0).Format("01-02 15:04:05"),
+		time.Unix(item.DueTime, 0).Format("01-02 15:04:05"),
+		fired, item.ActorId, item.PayloadString(50))
+}
+
+func (this *Job) jobStore() job.JobStore {
+	b, err := this.zkzone.KatewayJobClusterConfig()
+	swallow(err)
+
+	var mcc = &config.ConfigMysql{}
+	swallow(mcc.From(b))
+
+	// gk never adds jobs, the id generator is not used
+	store, err := jm.New("0", mcc)
+	swallow(err)
+	swallow(store.Start())
+	return store
+}
+
 func (this *Job) forSortedJobQueues(f func(jobQueue string)) {
 	jobQueues := this.zkzone.ChildrenWithData(zk.PubsubJobQueues)
 	sortedName := make([]string, 0, len(jobQueues))
@@ -316,6 +415,17 @@ Options:
     -d <due time in seconds>
       List jobs due from now within how many seconds.
 
+    -t <topic>
+      Work with -app, list the pending and fired jobs of a topic.
+      e,g.
+        gk job -app 100 -t 100.foobar.v2
+
+    -id <job id>
+      Work with -app and -t, display the lifecycle state of a job: pending, due or fired.
+
+    -reschedule <unix timestamp>
+      Work with -id, change the due time of a pending job.
+
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
@@ -1,23 +1,175 @@
 package command
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"net"
+	"net/http"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/funkygao/columnize"
+	"github.com/funkygao/gafka/ctx"
+	"github.com/funkygao/gafka/zk"
 	"github.com/funkygao/gocli"
+	"github.com/funkygao/gorequest"
 )
 
 type Webhook struct {
 	Ui  cli.Ui
 	Cmd string
+
+	zkzone *zk.ZkZone
 }
 
 func (this *Webhook) Run(args []string) (exitCode int) {
+	var (
+		zone       string
+		topic      string
+		n          int
+		failedOnly bool
+	)
+	cmdFlags := flag.NewFlagSet("webhook", flag.ContinueOnError)
+	cmdFlags.Usage = func() { this.Ui.Output(this.Help()) }
+	cmdFlags.StringVar(&zone, "z", ctx.DefaultZone(), "")
+	cmdFlags.StringVar(&topic, "t", "", "")
+	cmdFlags.IntVar(&n, "n", 20, "")
+	cmdFlags.BoolVar(&failedOnly, "failed", false, "")
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	this.zkzone = zk.NewZkZone(zk.DefaultConfig(zone, ctx.ZoneZkAddrs(zone)))
+	if topic != "" {
+		this.displayDeliveries(topic, n, failedOnly)
+		return
+	}
+
+	this.displayWebhooks()
 	return
 }
 
+func (this *Webhook) displayWebhooks() {
+	webhooks := this.zkzone.ChildrenWithData(zk.PubsubWebhooks)
+	owners := this.zkzone.ChildrenWithData(zk.PubsubWebhookOwners)
+	sortedName := make([]string, 0, len(webhooks))
+	for name := range webhooks {
+		sortedName = append(sortedName, name)
+	}
+	sort.Strings(sortedName)
+
+	lines := []string{"Topic|Endpoints|Mode|Filter|Retries|Backoff|Dead|Actor|Mtime"}
+	for _, topic := range sortedName {
+		zdata := webhooks[topic]
+		var hook zk.WebhookMeta
+		hook.From(zdata.Data())
+		retries, backoff, err := hook.RetryPolicy()
+		policy := fmt.Sprintf("%d|%s", retries, backoff)
+		if err != nil {
+			policy = fmt.Sprintf("%v|", err)
+		}
+
+		mode, workers, err := hook.DeliveryMode()
+		if err == nil {
+			mode = fmt.Sprintf("%s/%d", mode, workers)
+		} else {
+			mode = err.Error()
+		}
+
+		owner := "-"
+		if o, present := owners[topic]; present {
+			owner = string(o.Data())
+		}
+
+		lines = append(lines, fmt.Sprintf("%s|%+v|%s|%s|%s|%s|%s|%s", topic, hook.Endpoints,
+			mode, hook.Filter, policy, hook.Dead, owner, zdata.Mtime()))
+	}
+
+	this.Ui.Output(columnize.SimpleFormat(lines))
+}
+
+// displayDeliveries shows the recent deliveries of a webhook from the actor which owns it.
+func (this *Webhook) displayDeliveries(topic string, n int, failedOnly bool) {
+	owners := this.zkzone.ChildrenWithData(zk.PubsubWebhookOwners)
+	owner, present := owners[topic]
+	if !present {
+		this.Ui.Error(fmt.Sprintf("%s not owned by any actor", topic))
+		return
+	}
+
+	actorId := string(owner.Data())
+	actors := this.zkzone.ChildrenWithData(zk.PubsubActors)
+	actor, present := actors[actorId]
+	if !present {
+		this.Ui.Error(fmt.Sprintf("actor %s not found", actorId))
+		return
+	}
+
+	var info struct {
+		Addr string `json:"addr"`
+	}
+	if err := json.Unmarshal(actor.Data(), &info); err != nil {
+		this.Ui.Error(err.Error())
+		return
+	}
+
+	// actor id is hostname:uuid, the addr might be without host
+	host, port, err := net.SplitHostPort(info.Addr)
+	if err != nil {
+		this.Ui.Error(err.Error())
+		return
+	}
+	if host == "" {
+		host = strings.SplitN(actorId, ":", 2)[0]
+	}
+
+	uri := fmt.Sprintf("http://%s/v1/webhook/deliveries?topic=%s&n=%d", net.JoinHostPort(host, port), topic, n)
+	if failedOnly {
+		uri += "&failed=1"
+	}
+	resp, body, errs := gorequest.New().Get(uri).End()
+	if len(errs) > 0 {
+		for _, err = range errs {
+			this.Ui.Error(err.Error())
+		}
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		this.Ui.Error(fmt.Sprintf("%s %s", resp.Status, body))
+		return
+	}
+
+	var deliveries []struct {
+		Time      int64  `json:"time"`
+		Endpoint  string `json:"endpoint"`
+		Partition int32  `json:"partition"`
+		Offset    int64  `json:"offset"`
+		Status    int    `json:"status"`
+		Latency   int64  `json:"latency"`
+		Attempts  int    `json:"attempts"`
+		Error     string `json:"error"`
+		Dead      bool   `json:"dead"`
+	}
+	if err = json.Unmarshal([]byte(body), &deliveries); err != nil {
+		this.Ui.Error(err.Error())
+		return
+	}
+
+	lines := []string{"Time|Endpoint|Partition|Offset|Status|Latency|Attempts|Dead|Error"}
+	for _, d := range deliveries {
+		lines = append(lines, fmt.Sprintf("%s|%s|%d|%d|%d|%dms|%d|%v|%s",
+			time.Unix(d.Time, 0).Format("01-02 15:04:05"), d.Endpoint, d.Partition, d.Offset,
+			d.Status, d.Latency, d.Attempts, d.Dead, d.Error))
+	}
+
+	this.Ui.Info(fmt.Sprintf("%s deliveries from %s", topic, actorId))
+	this.Ui.Output(columnize.SimpleFormat(lines))
+}
+
 func (*Webhook) Synopsis() string {
-	return "Display kateway webhooks TODO"
+	return "Display kateway webhooks and their recent deliveries"
 }
 
 func (this *Webhook) Help() string {
@@ -26,6 +178,19 @@ Usage: %s webhook [options]
 
     %s
 
+Options:
+
+    -z zone
+
+    -t topic
+      Display the recent deliveries of a webhook from the actor which owns it.
+
+    -n limit
+      Default 20.
+
+    -failed
+      Only display the failed deliveries.
+
 `, this.Cmd, this.Synopsis())
 	return strings.TrimSpace(help)
 }
@@ -339,6 +339,13 @@ func init() {
 			}, nil
 		},
 
+		"acl": func() (cli.Command, error) {
+			return &command.Acl{
+				Ui:  ui,
+				Cmd: cmd,
+			}, nil
+		},
+
 		"mount": func() (cli.Command, error) {
 			return &command.Mount{
 				Ui:  ui,
@@ -0,0 +1,158 @@
+package acl
+
+import (
+	"strings"
+)
+
+// The operations guarded by the rules.
+const (
+	OpPub         = "pub"
+	OpSub         = "sub"
+	OpAdmin       = "admin"
+	OpBury        = "bury"
+	OpResetOffset = "reset-offset"
+	OpAll         = "*"
+)
+
+// The effects of a rule.
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Rule allows or denies an operation on the matching resources to the matching principals.
+type Rule struct {
+	Id        string `json:"id"`
+	Principal string `json:"principal"`       // appid pattern
+	Resource  string `json:"resource"`        // appid.topic.ver pattern
+	Group     string `json:"group,omitempty"` // consumer group pattern, empty matches any
+	Operation string `json:"op"`
+	Effect    string `json:"effect"`
+	Note      string `json:"note,omitempty"`
+	Ctime     int64  `json:"ctime"`
+}
+
+func (this Rule) Validate() error {
+	switch this.Operation {
+	case OpPub, OpSub, OpAdmin, OpBury, OpResetOffset, OpAll:
+	default:
+		return ErrInvalidOperation
+	}
+
+	if this.Effect != Allow && this.Effect != Deny {
+		return ErrInvalidEffect
+	}
+
+	if this.Principal == "" || this.Resource == "" {
+		return ErrEmptyPattern
+	}
+
+	return nil
+}
+
+// Matches checks if the rule applies to an operation of principal on resource by group.
+func (this Rule) Matches(principal, op, resource, group string) bool {
+	if this.Operation != OpAll && this.Operation != op {
+		return false
+	}
+
+	if this.Group != "" && !Match(this.Group, group) {
+		return false
+	}
+
+	return Match(this.Principal, principal) && Match(this.Resource, resource)
+}
+
+// Decision is the outcome of the rules on an operation.
+type Decision int
+
+const (
+	NoMatch Decision = iota
+	Allowed
+	Denied
+)
+
+func (this Decision) String() string {
+	switch this {
+	case Allowed:
+		return Allow
+	case Denied:
+		return Deny
+	default:
+		return "nomatch"
+	}
+}
+
+// Evaluate decides an operation by the rules, returning the deciding rule if any.
+// A deny rule wins over an allow rule.
+func Evaluate(rules []Rule, principal, op, resource, group string) (Decision, *Rule) {
+	var allowed *Rule
+	for i := range rules {
+		if !rules[i].Matches(principal, op, resource, group) {
+			continue
+		}
+
+		if rules[i].Effect == Deny {
+			return Denied, &rules[i]
+		}
+		if allowed == nil {
+			allowed = &rules[i]
+		}
+	}
+
+	if allowed != nil {
+		return Allowed, allowed
+	}
+	return NoMatch, nil
+}
+
+// Match reports whether s matches pattern, where * matches any sequence of characters.
+func Match(pattern, s string) bool {
+	if pattern == "*" {
+		return true
+	}
+
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
+
+// Authorizer keeps the rules of all the kateways.
+type Authorizer interface {
+	// Name of the authorizer implementation.
+	Name() string
+
+	Start() error
+	Stop()
+
+	// Check decides an operation of principal on resource by group.
+	Check(principal, op, resource, group string) (Decision, *Rule)
+
+	// Rules returns all the rules.
+	Rules() ([]Rule, error)
+
+	// Add validates and stores a new rule, returning it with its id.
+	Add(rule Rule) (Rule, error)
+
+	// Remove deletes a rule by its id.
+	Remove(id string) error
+}
+
+var Default Authorizer
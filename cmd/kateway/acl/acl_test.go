@@ -0,0 +1,62 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestMatch(t *testing.T) {
+	assert.Equal(t, true, Match("*", ""))
+	assert.Equal(t, true, Match("app1.orders.v1", "app1.orders.v1"))
+	assert.Equal(t, false, Match("app1.orders.v1", "app1.orders.v2"))
+	assert.Equal(t, true, Match("app1.*", "app1.orders.v1"))
+	assert.Equal(t, false, Match("app1.*", "app10.orders.v1"))
+	assert.Equal(t, true, Match("*.orders.*", "app1.orders.v1"))
+	assert.Equal(t, false, Match("*.orders.*", "app1.payments.v1"))
+	assert.Equal(t, true, Match("app*.v1", "app1.orders.v1"))
+	assert.Equal(t, false, Match("a*a", "a"))
+	assert.Equal(t, true, Match("a*a", "aa"))
+}
+
+func TestValidate(t *testing.T) {
+	r := Rule{Principal: "app1", Resource: "app1.*", Operation: OpPub, Effect: Allow}
+	assert.Equal(t, nil, r.Validate())
+
+	r.Operation = "publish"
+	assert.Equal(t, ErrInvalidOperation, r.Validate())
+
+	r.Operation, r.Effect = OpAll, "permit"
+	assert.Equal(t, ErrInvalidEffect, r.Validate())
+
+	r.Effect, r.Resource = Deny, ""
+	assert.Equal(t, ErrEmptyPattern, r.Validate())
+}
+
+func TestEvaluate(t *testing.T) {
+	rules := []Rule{
+		{Id: "1", Principal: "app2", Resource: "app1.orders.*", Operation: OpSub, Effect: Allow},
+		{Id: "2", Principal: "*", Resource: "app1.orders.v1", Group: "test*", Operation: OpAll, Effect: Deny},
+		{Id: "3", Principal: "ops", Resource: "*", Operation: OpResetOffset, Effect: Allow},
+	}
+
+	d, rule := Evaluate(rules, "app2", OpSub, "app1.orders.v2", "billing")
+	assert.Equal(t, Allowed, d)
+	assert.Equal(t, "1", rule.Id)
+
+	// deny wins
+	d, rule = Evaluate(rules, "app2", OpSub, "app1.orders.v1", "test1")
+	assert.Equal(t, Denied, d)
+	assert.Equal(t, "2", rule.Id)
+
+	d, _ = Evaluate(rules, "app2", OpPub, "app1.orders.v2", "")
+	assert.Equal(t, NoMatch, d)
+
+	d, rule = Evaluate(rules, "ops", OpResetOffset, "app3.foo.v1", "g1")
+	assert.Equal(t, Allowed, d)
+	assert.Equal(t, "3", rule.Id)
+
+	d, _ = Evaluate(nil, "app2", OpSub, "app1.orders.v2", "")
+	assert.Equal(t, NoMatch, d)
+	assert.Equal(t, "deny", Denied.String())
+}
@@ -0,0 +1,11 @@
+// Package acl authorizes the operations of the authenticated apps by rules.
+//
+// A rule allows or denies an operation on the topics matching a resource pattern
+// to the apps matching a principal pattern, where * matches any sequence:
+//
+//	{"principal":"app2","resource":"app1.orders.*","group":"billing*","op":"sub","effect":"allow"}
+//
+// The resource of a topic is appid.topic.ver of its owner, the same as its kafka topic.
+// A deny rule wins over an allow rule. When no rule matches, the ownership checks of
+// the manager apply as before, and an allowing rule grants the operation without them.
+package acl
@@ -0,0 +1,14 @@
+package acl
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidOperation = errors.New("invalid op, pub|sub|admin|bury|reset-offset|*")
+	ErrInvalidEffect    = errors.New("invalid effect, allow|deny")
+	ErrEmptyPattern     = errors.New("empty principal or resource")
+	ErrRuleNotFound     = errors.New("acl rule not found")
+	ErrConcurrentUpdate = errors.New("acl updated concurrently, try again")
+	ErrDenied           = errors.New("denied by acl")
+)
@@ -0,0 +1,250 @@
+package zkacl
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/acl"
+	"github.com/funkygao/gafka/zk"
+	log "github.com/funkygao/log4go"
+	zklib "github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	// maxCasRetries is how many times an update retries on concurrent updates.
+	maxCasRetries = 3
+
+	// maxCachedDecisions bounds the decision cache, which is reset when full.
+	maxCachedDecisions = 10000
+)
+
+type decision struct {
+	d    acl.Decision
+	rule *acl.Rule
+}
+
+type zkAuthorizer struct {
+	zkzone  *zk.ZkZone
+	refresh time.Duration
+
+	mu         sync.RWMutex
+	data       []byte
+	rules      []acl.Rule
+	generation int                 // of the rules
+	decisions  map[string]decision // cleared whenever the rules change
+
+	wg         sync.WaitGroup
+	shutdownCh chan struct{}
+}
+
+func New(zkzone *zk.ZkZone, refresh time.Duration) acl.Authorizer {
+	return &zkAuthorizer{
+		zkzone:     zkzone,
+		refresh:    refresh,
+		decisions:  make(map[string]decision),
+		shutdownCh: make(chan struct{}),
+	}
+}
+
+func (this *zkAuthorizer) Name() string {
+	return "zk"
+}
+
+func (this *zkAuthorizer) Start() error {
+	// warm up
+	if err := this.refreshCache(); err != nil {
+		return err
+	}
+
+	this.wg.Add(1)
+	go func() {
+		ticker := time.NewTicker(this.refresh)
+		defer func() {
+			ticker.Stop()
+			this.wg.Done()
+		}()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := this.refreshCache(); err != nil {
+					// keep authorizing with the local rules until zk recovers
+					log.Warn("acl refresh: %s", err)
+				}
+
+			case <-this.shutdownCh:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (this *zkAuthorizer) Stop() {
+	close(this.shutdownCh)
+	this.wg.Wait()
+}
+
+func (this *zkAuthorizer) refreshCache() error {
+	data, _, err := this.zkzone.KatewayAcls()
+	if err != nil {
+		if err != zklib.ErrNoNode {
+			return err
+		}
+		data = nil
+	}
+
+	this.mu.RLock()
+	unchanged := bytes.Equal(this.data, data)
+	this.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	rules, err := parseRules(data)
+	if err != nil {
+		return err
+	}
+
+	log.Trace("acl refreshed: %d rules", len(rules))
+	this.apply(data, rules)
+	return nil
+}
+
+func (this *zkAuthorizer) apply(data []byte, rules []acl.Rule) {
+	this.mu.Lock()
+	this.data, this.rules = data, rules
+	this.generation++
+	this.decisions = make(map[string]decision)
+	this.mu.Unlock()
+}
+
+func parseRules(data []byte) (rules []acl.Rule, err error) {
+	if len(data) == 0 {
+		return
+	}
+
+	err = json.Unmarshal(data, &rules)
+	return
+}
+
+func (this *zkAuthorizer) Check(principal, op, resource, group string) (acl.Decision, *acl.Rule) {
+	key := principal + "\x00" + op + "\x00" + resource + "\x00" + group
+
+	this.mu.RLock()
+	if c, present := this.decisions[key]; present {
+		this.mu.RUnlock()
+		return c.d, c.rule
+	}
+	rules, generation := this.rules, this.generation
+	this.mu.RUnlock()
+
+	d, rule := acl.Evaluate(rules, principal, op, resource, group)
+
+	this.mu.Lock()
+	if this.generation == generation {
+		// the rules are not changed during evaluation
+		if len(this.decisions) >= maxCachedDecisions {
+			this.decisions = make(map[string]decision)
+		}
+		this.decisions[key] = decision{d: d, rule: rule}
+	}
+	this.mu.Unlock()
+
+	return d, rule
+}
+
+func (this *zkAuthorizer) Rules() ([]acl.Rule, error) {
+	data, _, err := this.zkzone.KatewayAcls()
+	if err == zklib.ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return parseRules(data)
+}
+
+func (this *zkAuthorizer) Add(rule acl.Rule) (acl.Rule, error) {
+	if err := rule.Validate(); err != nil {
+		return rule, err
+	}
+
+	err := this.update(func(rules []acl.Rule, version int32) ([]acl.Rule, error) {
+		rule.Id = nextId(rules, version)
+		rule.Ctime = time.Now().Unix()
+		return append(rules, rule), nil
+	})
+	return rule, err
+}
+
+func (this *zkAuthorizer) Remove(id string) error {
+	return this.update(func(rules []acl.Rule, version int32) ([]acl.Rule, error) {
+		for i, r := range rules {
+			if r.Id == id {
+				return append(rules[:i], rules[i+1:]...), nil
+			}
+		}
+
+		return nil, acl.ErrRuleNotFound
+	})
+}
+
+// update applies fn to the rules stored in zk with compare-and-set, retrying on concurrent updates.
+// version is of the znode the rules are read from, -1 if not created yet.
+func (this *zkAuthorizer) update(fn func(rules []acl.Rule, version int32) ([]acl.Rule, error)) error {
+	for i := 0; i < maxCasRetries; i++ {
+		data, version, err := this.zkzone.KatewayAcls()
+		switch err {
+		case nil:
+
+		case zklib.ErrNoNode:
+			version = -1
+
+		default:
+			return err
+		}
+
+		rules, err := parseRules(data)
+		if err != nil {
+			return err
+		}
+		if rules, err = fn(rules, version); err != nil {
+			return err
+		}
+
+		data, _ = json.Marshal(rules)
+		switch err = this.zkzone.SetKatewayAcls(data, version); err {
+		case nil:
+			this.apply(data, rules)
+			return nil
+
+		case zklib.ErrNodeExists, zklib.ErrBadVersion:
+			log.Debug("acl updated concurrently, #%d retry", i+1)
+
+		default:
+			return err
+		}
+	}
+
+	return acl.ErrConcurrentUpdate
+}
+
+// nextId returns the id of a new rule added to the rules of znode version.
+// Each update bumps the znode version, so version+2 is never reused even if the rule
+// of the max id is removed. The max numeric id plus 1 covers the ids issued before, or
+// by a znode deleted and created again.
+func nextId(rules []acl.Rule, version int32) string {
+	next := int(version) + 2
+	for _, r := range rules {
+		if id, err := strconv.Atoi(r.Id); err == nil && id >= next {
+			next = id + 1
+		}
+	}
+
+	return strconv.Itoa(next)
+}
@@ -0,0 +1,42 @@
+package zkacl
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/acl"
+)
+
+func TestCheckCache(t *testing.T) {
+	a := New(nil, 0).(*zkAuthorizer)
+	d, _ := a.Check("app2", acl.OpSub, "app1.orders.v1", "g1")
+	assert.Equal(t, acl.NoMatch, d)
+	assert.Equal(t, 1, len(a.decisions))
+
+	// new rules reset the cache
+	a.apply([]byte("x"), []acl.Rule{{Id: "1", Principal: "app2", Resource: "app1.*", Operation: acl.OpSub, Effect: acl.Deny}})
+	assert.Equal(t, 0, len(a.decisions))
+
+	d, rule := a.Check("app2", acl.OpSub, "app1.orders.v1", "g1")
+	assert.Equal(t, acl.Denied, d)
+	assert.Equal(t, "1", rule.Id)
+	d, _ = a.Check("app2", acl.OpSub, "app1.orders.v1", "g1")
+	assert.Equal(t, acl.Denied, d)
+	assert.Equal(t, 1, len(a.decisions))
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := parseRules(nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(rules))
+
+	rules, err = parseRules([]byte(`[{"id":"3","principal":"app1","resource":"*","op":"pub","effect":"allow"},{"id":"12"}]`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(rules))
+	assert.Equal(t, "13", nextId(rules, 5))
+	assert.Equal(t, "1", nextId(nil, -1))
+
+	// the id of a removed rule is not reused
+	assert.Equal(t, "22", nextId(rules, 20))
+	assert.Equal(t, "22", nextId(rules[:1], 20))
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -13,6 +15,15 @@ import (
 )
 
 func (this *Client) AddJob(payload []byte, delay string, opt PubOption) (jobId string, err error) {
+	return this.addJob(payload, "delay", delay, opt)
+}
+
+// AddCronJob adds a recurring job, spec is either '@every 1m' or a 5 fields cron expression.
+func (this *Client) AddCronJob(payload []byte, spec string, opt PubOption) (jobId string, err error) {
+	return this.addJob(payload, "cron", spec, opt)
+}
+
+func (this *Client) addJob(payload []byte, key, val string, opt PubOption) (jobId string, err error) {
 	buf := mpool.BytesBufferGet()
 	defer mpool.BytesBufferPut(buf)
 
@@ -25,7 +36,10 @@ func (this *Client) AddJob(payload []byte, delay string, opt PubOption) (jobId s
 	u.Host = this.cf.Pub.Endpoint
 	u.Path = fmt.Sprintf("/v1/jobs/%s/%s", opt.Topic, opt.Ver)
 	q := u.Query()
-	q.Set("delay", delay)
+	q.Set(key, val)
+	if opt.JobKey != "" {
+		q.Set("key", opt.JobKey)
+	}
 	u.RawQuery = q.Encode()
 
 	req, err = http.NewRequest("POST", u.String(), buf)
@@ -33,8 +47,11 @@ func (this *Client) AddJob(payload []byte, delay string, opt PubOption) (jobId s
 		return
 	}
 
-	req.Header.Set("AppId", this.cf.AppId)
-	req.Header.Set("Pubkey", this.cf.Secret)
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderPubkey, this.cf.Secret)
+	if opt.Tag != "" {
+		req.Header.Set(gateway.HttpHeaderMsgTag, opt.Tag)
+	}
 
 	var response *http.Response
 	response, err = this.pubConn.Do(req)
@@ -81,8 +98,156 @@ func (this *Client) DeleteJob(jobId string, opt PubOption) (err error) {
 		return
 	}
 
-	req.Header.Set("AppId", this.cf.AppId)
-	req.Header.Set("Pubkey", this.cf.Secret)
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderPubkey, this.cf.Secret)
+
+	var response *http.Response
+	response, err = this.pubConn.Do(req)
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	// reuse the connection
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(string(b))
+	}
+
+	return nil
+}
+
+func (this *Client) PauseCronJob(jobId string, opt PubOption) error {
+	return this.updateCronJob(jobId, "pause", opt)
+}
+
+func (this *Client) ResumeCronJob(jobId string, opt PubOption) error {
+	return this.updateCronJob(jobId, "resume", opt)
+}
+
+func (this *Client) updateCronJob(jobId, op string, opt PubOption) (err error) {
+	var req *http.Request
+	var u url.URL
+	u.Scheme = this.cf.Pub.Scheme
+	u.Host = this.cf.Pub.Endpoint
+	u.Path = fmt.Sprintf("/v1/crons/%s/%s", opt.Topic, opt.Ver)
+	q := u.Query()
+	q.Set("id", jobId)
+	q.Set("op", op)
+	u.RawQuery = q.Encode()
+
+	req, err = http.NewRequest("PUT", u.String(), nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderPubkey, this.cf.Secret)
+
+	var response *http.Response
+	response, err = this.pubConn.Do(req)
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	// reuse the connection
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(string(b))
+	}
+
+	return nil
+}
+
+// JobInfo is the lifecycle state of a job.
+type JobInfo struct {
+	JobId   string `json:"id"`
+	State   string `json:"state"` // pending, due or fired
+	Ctime   int64  `json:"ctime"`
+	Due     int64  `json:"due"`
+	Etime   int64  `json:"etime"` // when the job fired
+	ActorId string `json:"actor"`
+	Key     string `json:"key"`
+	Tag     string `json:"tag"`
+	Payload string `json:"payload"`
+}
+
+func (this *Client) GetJob(jobId string, opt PubOption) (info JobInfo, err error) {
+	var req *http.Request
+	var u url.URL
+	u.Scheme = this.cf.Pub.Scheme
+	u.Host = this.cf.Pub.Endpoint
+	u.Path = fmt.Sprintf("/v1/jobs/%s/%s", opt.Topic, opt.Ver)
+	q := u.Query()
+	q.Set("id", jobId)
+	u.RawQuery = q.Encode()
+
+	req, err = http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderPubkey, this.cf.Secret)
+
+	var response *http.Response
+	response, err = this.pubConn.Do(req)
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	// reuse the connection
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		err = errors.New(string(b))
+		return
+	}
+
+	err = json.Unmarshal(b, &info)
+	return
+}
+
+// RescheduleJob changes the due time of a pending job and replaces its payload if not nil.
+func (this *Client) RescheduleJob(jobId string, due int64, payload []byte, opt PubOption) (err error) {
+	var req *http.Request
+	var u url.URL
+	u.Scheme = this.cf.Pub.Scheme
+	u.Host = this.cf.Pub.Endpoint
+	u.Path = fmt.Sprintf("/v1/jobs/%s/%s", opt.Topic, opt.Ver)
+	q := u.Query()
+	q.Set("id", jobId)
+	if due > 0 {
+		q.Set("due", fmt.Sprintf("%d", due))
+	}
+	u.RawQuery = q.Encode()
+
+	req, err = http.NewRequest("PUT", u.String(), bytes.NewReader(payload))
+	if err != nil {
+		return
+	}
+
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderPubkey, this.cf.Secret)
 
 	var response *http.Response
 	response, err = this.pubConn.Do(req)
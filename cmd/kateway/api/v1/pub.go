@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -13,10 +14,13 @@ import (
 )
 
 type PubOption struct {
-	Topic, Ver string
-	Async      bool
-	AckAll     bool
-	Tag        string
+	Topic, Ver  string
+	Async       bool
+	AckAll      bool
+	Tag         string
+	MsgId       string // optional, retry with the same MsgId will not duplicate the message
+	ContentType string // optional, content type of the message carried to subscribers
+	JobKey      string // optional, jobs of the same partition key fire in due time order
 }
 
 // Pub publish a keyed message to specified versioned topic.
@@ -52,6 +56,12 @@ func (this *Client) Pub(key string, msg []byte, opt PubOption) (err error) {
 	if opt.Tag != "" {
 		req.Header.Set(gateway.HttpHeaderMsgTag, opt.Tag)
 	}
+	if opt.MsgId != "" {
+		req.Header.Set(gateway.HttpHeaderMsgId, opt.MsgId)
+	}
+	if opt.ContentType != "" {
+		req.Header.Set(gateway.HttpHeaderContentType, opt.ContentType)
+	}
 
 	var response *http.Response
 	response, err = this.pubConn.Do(req)
@@ -84,3 +94,63 @@ func (this *Client) Pub(key string, msg []byte, opt PubOption) (err error) {
 
 	return nil
 }
+
+// PubBatch publish a batch of keyed/tagged messages to specified versioned topic
+// in a single request, each message gets its own result.
+func (this *Client) PubBatch(msgs []gateway.BatchMessage, opt PubOption) (results []gateway.PubResult, err error) {
+	buf := mpool.BytesBufferGet()
+	defer mpool.BytesBufferPut(buf)
+
+	buf.Reset()
+	if err = gateway.EncodeBatchMessages(buf, msgs); err != nil {
+		return
+	}
+
+	var u url.URL
+	u.Scheme = this.cf.Pub.Scheme
+	u.Host = this.cf.Pub.Endpoint
+	u.Path = fmt.Sprintf("/v1/msgs/%s/%s/batch", opt.Topic, opt.Ver)
+	if !opt.AckAll {
+		q := u.Query()
+		q.Set("ack", "local")
+		u.RawQuery = q.Encode()
+	}
+
+	var req *http.Request
+	req, err = http.NewRequest("POST", u.String(), buf)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderPubkey, this.cf.Secret)
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	var response *http.Response
+	response, err = this.pubConn.Do(req)
+	if response != nil {
+		// reuse the connection
+		defer response.Body.Close()
+	}
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	if response.StatusCode != http.StatusCreated {
+		err = errors.New(string(b))
+		return
+	}
+
+	if this.cf.Debug {
+		log.Printf("--> [%s] %s", response.Status, string(b))
+	}
+
+	err = json.Unmarshal(b, &results)
+	return
+}
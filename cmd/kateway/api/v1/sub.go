@@ -1,12 +1,16 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/funkygao/gafka/cmd/kateway/gateway"
 	"github.com/funkygao/gafka/sla"
@@ -21,7 +25,9 @@ type SubOption struct {
 	Reset      string // newest | oldest
 	Shadow     string
 	Wait       string
-	Tag        string // tag filter
+	Tag        string        // tag filter
+	Format     string        // batch response format, e,g. gateway.ContentTypeProtobuf
+	Visibility time.Duration // if set, messages must be Ack'ed within it or will be redelivered
 	AutoClose  bool
 	Mux        bool
 }
@@ -47,6 +53,9 @@ func (this *Client) Sub(opt SubOption, h SubHandler) error {
 	if opt.Wait != "" {
 		q.Set("wait", opt.Wait)
 	}
+	if opt.Visibility > 0 {
+		q.Set("visibility", strconv.Itoa(int(opt.Visibility/time.Second)))
+	}
 	if opt.Mux {
 		q.Set("mux", "1")
 	}
@@ -64,6 +73,9 @@ func (this *Client) Sub(opt SubOption, h SubHandler) error {
 	if opt.Tag != "" {
 		req.Header.Set(gateway.HttpHeaderMsgTag, opt.Tag)
 	}
+	if opt.Format != "" {
+		req.Header.Set("Accept", opt.Format)
+	}
 	for {
 		response, err := this.subConn.Do(req)
 		if err != nil {
@@ -102,10 +114,18 @@ type SubXResult struct {
 	Offset    string
 	Partition string
 	Tag       string
+	Key       string
+	PubTime   string
+	Retries   string // retry attempt of the message from a retry queue with retry policy
+
+	// Records of batch Sub with full metadata per message.
+	// Partition and Offset of the last record should be set for delayed ack.
+	Records []gateway.Record
 }
 
 func (this *SubXResult) Reset() {
 	this.Bury = ""
+	this.Records = nil
 }
 
 // SubX is advanced Sub with features of delayed ack and shadow bury.
@@ -129,6 +149,9 @@ func (this *Client) SubX(opt SubOption, h SubXHandler) error {
 	if opt.Wait != "" {
 		q.Set("wait", opt.Wait)
 	}
+	if opt.Visibility > 0 {
+		q.Set("visibility", strconv.Itoa(int(opt.Visibility/time.Second)))
+	}
 	u.RawQuery = q.Encode()
 
 	req := gorequest.New()
@@ -141,6 +164,9 @@ func (this *Client) SubX(opt SubOption, h SubXHandler) error {
 	if opt.Tag != "" {
 		req.Set(gateway.HttpHeaderMsgTag, opt.Tag)
 	}
+	if opt.Format != "" {
+		req.Set("Accept", opt.Format)
+	}
 	r := &SubXResult{}
 	for {
 		response, b, errs := req.EndBytes()
@@ -164,6 +190,16 @@ func (this *Client) SubX(opt SubOption, h SubXHandler) error {
 		r.Partition = response.Header.Get(gateway.HttpHeaderPartition)
 		r.Offset = response.Header.Get(gateway.HttpHeaderOffset)
 		r.Tag = response.Header.Get(gateway.HttpHeaderMsgTag)
+		r.Key = response.Header.Get(gateway.HttpHeaderMsgKey)
+		r.PubTime = response.Header.Get(gateway.HttpHeaderPubTime)
+		r.Retries = response.Header.Get(gateway.HttpHeaderRetries)
+		if opt.Batch > 1 && response.StatusCode == http.StatusOK {
+			records, err := gateway.DecodeRecords(response.Header.Get("Content-Type"), b)
+			if err != nil {
+				return err
+			}
+			r.Records = records
+		}
 		if err := h(response.StatusCode, b, r); err != nil {
 			return err
 		}
@@ -181,3 +217,123 @@ func (this *Client) SubX(opt SubOption, h SubXHandler) error {
 	}
 
 }
+
+// Bury moves a message consumed by SubX to the retry or dead shadow queue.
+// The retry attempt of the message is carried so that the retry policy can take effect.
+func (this *Client) Bury(opt SubOption, bury string, r *SubXResult, msg []byte) (err error) {
+	if bury != ShadowRetry && bury != ShadowDead {
+		return ErrInvalidBury
+	}
+
+	var req *http.Request
+	var u url.URL
+	u.Scheme = this.cf.Sub.Scheme
+	u.Host = this.cf.Sub.Endpoint
+	u.Path = fmt.Sprintf("/v1/msgs/%s/%s/%s", opt.AppId, opt.Topic, opt.Ver)
+	q := u.Query()
+	q.Set("group", opt.Group)
+	if opt.Shadow != "" && sla.ValidateShadowName(opt.Shadow) {
+		q.Set("q", opt.Shadow)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err = http.NewRequest("PUT", u.String(), bytes.NewReader(msg))
+	if err != nil {
+		return
+	}
+
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderSubkey, this.cf.Secret)
+	req.Header.Set(gateway.HttpHeaderMsgBury, bury)
+	req.Header.Set(gateway.HttpHeaderPartition, r.Partition)
+	req.Header.Set(gateway.HttpHeaderOffset, r.Offset)
+	if r.Retries != "" {
+		req.Header.Set(gateway.HttpHeaderRetries, r.Retries)
+	}
+
+	var response *http.Response
+	response, err = this.subConn.Do(req)
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	// reuse the connection
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(string(b))
+	}
+
+	return nil
+}
+
+// InflightOffset identifies a message delivered by Sub with visibility timeout.
+type InflightOffset struct {
+	Partition int   `json:"partition"`
+	Offset    int64 `json:"offset"`
+}
+
+// Ack acknowledges messages delivered by Sub with visibility timeout so that they will
+// not be redelivered.
+func (this *Client) Ack(opt SubOption, offsets ...InflightOffset) error {
+	return this.landInflight("ack", opt, offsets)
+}
+
+// Nack makes messages delivered by Sub with visibility timeout redelivered immediately.
+func (this *Client) Nack(opt SubOption, offsets ...InflightOffset) error {
+	return this.landInflight("nack", opt, offsets)
+}
+
+func (this *Client) landInflight(op string, opt SubOption, offsets []InflightOffset) (err error) {
+	body, err := json.Marshal(offsets)
+	if err != nil {
+		return
+	}
+
+	var req *http.Request
+	var u url.URL
+	u.Scheme = this.cf.Sub.Scheme
+	u.Host = this.cf.Sub.Endpoint
+	u.Path = fmt.Sprintf("/v1/%s/%s/%s/%s", op, opt.AppId, opt.Topic, opt.Ver)
+	q := u.Query()
+	q.Set("group", opt.Group)
+	if opt.Shadow != "" && sla.ValidateShadowName(opt.Shadow) {
+		q.Set("q", opt.Shadow)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err = http.NewRequest("PUT", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderSubkey, this.cf.Secret)
+
+	var response *http.Response
+	response, err = this.subConn.Do(req)
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	// reuse the connection
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(string(b))
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/funkygao/gafka/cmd/kateway/gateway"
+	"github.com/funkygao/gafka/mpool"
+)
+
+// XaPrepare persists a half message which will not be delivered until XaCommit.
+// The transaction state is resolved with the checkback url registered by RegisterXa
+// if neither XaCommit nor XaRollback arrives in time.
+func (this *Client) XaPrepare(payload []byte, opt PubOption) (xaId string, err error) {
+	buf := mpool.BytesBufferGet()
+	defer mpool.BytesBufferPut(buf)
+
+	buf.Reset()
+	buf.Write(payload)
+
+	var req *http.Request
+	var u url.URL
+	u.Scheme = this.cf.Pub.Scheme
+	u.Host = this.cf.Pub.Endpoint
+	u.Path = fmt.Sprintf("/v1/xa/prepare/%s/%s", opt.Topic, opt.Ver)
+
+	req, err = http.NewRequest("POST", u.String(), buf)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderPubkey, this.cf.Secret)
+
+	var response *http.Response
+	response, err = this.pubConn.Do(req)
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	// reuse the connection
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated {
+		return "", errors.New(string(b))
+	}
+
+	xaId = response.Header.Get(gateway.HttpHeaderXaId)
+
+	if this.cf.Debug {
+		log.Printf("--> [%s]", response.Status)
+		log.Printf("XaId:%s", xaId)
+	}
+
+	return
+}
+
+// RegisterXa sets the checkback url of a topic, which kateway will GET with param id
+// to resolve the transaction state and must respond with 'commit' or 'rollback'.
+// It requires the admin credential.
+func (this *Client) RegisterXa(appid, topic, ver, checkback string) (err error) {
+	var u url.URL
+	u.Scheme = this.cf.Admin.Scheme
+	u.Host = this.cf.Admin.Endpoint
+	u.Path = fmt.Sprintf("/v1/xa/%s/%s/%s", appid, topic, ver)
+
+	body, _ := json.Marshal(map[string]string{"checkback": checkback})
+	req, err := http.NewRequest("PUT", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderPubkey, this.cf.Secret)
+
+	var response *http.Response
+	response, err = this.adminConn.Do(req)
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	// reuse the connection
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(string(b))
+	}
+
+	return nil
+}
+
+// XaCommit makes the prepared message deliverable.
+func (this *Client) XaCommit(xaId string, opt PubOption) error {
+	return this.xaFinalize("commit", xaId, opt)
+}
+
+// XaRollback discards the prepared message.
+func (this *Client) XaRollback(xaId string, opt PubOption) error {
+	return this.xaFinalize("rollback", xaId, opt)
+}
+
+func (this *Client) xaFinalize(op string, xaId string, opt PubOption) (err error) {
+	var req *http.Request
+	var u url.URL
+	u.Scheme = this.cf.Pub.Scheme
+	u.Host = this.cf.Pub.Endpoint
+	u.Path = fmt.Sprintf("/v1/xa/%s/%s/%s", op, opt.Topic, opt.Ver)
+	q := u.Query()
+	q.Set("id", xaId)
+	u.RawQuery = q.Encode()
+
+	req, err = http.NewRequest("PUT", u.String(), nil)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set(gateway.HttpHeaderAppid, this.cf.AppId)
+	req.Header.Set(gateway.HttpHeaderPubkey, this.cf.Secret)
+
+	var response *http.Response
+	response, err = this.pubConn.Do(req)
+	if err != nil {
+		return
+	}
+
+	var b []byte
+	b, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	// reuse the connection
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(string(b))
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Provider authenticates the app calling kateway.
+type Provider interface {
+	// Name of the provider implementation.
+	Name() string
+
+	// Authenticate returns the appid identified by the credential of a request.
+	// It returns ErrNoCredential if the request carries none of its kind.
+	Authenticate(r *http.Request) (appid string, err error)
+}
+
+type chain []Provider
+
+// Chain combines the providers of a listener: the first one that finds its credential
+// in the request decides.
+func Chain(providers ...Provider) Provider {
+	if len(providers) == 1 {
+		return providers[0]
+	}
+
+	return chain(providers)
+}
+
+func (this chain) Name() string {
+	names := make([]string, 0, len(this))
+	for _, p := range this {
+		names = append(names, p.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (this chain) Authenticate(r *http.Request) (appid string, err error) {
+	for _, p := range this {
+		if appid, err = p.Authenticate(r); err != ErrNoCredential {
+			return
+		}
+	}
+
+	return "", ErrNoCredential
+}
+
+// KeyOf returns the appid and the first present key of keyHeaders of a request.
+func KeyOf(r *http.Request, appidHeader string, keyHeaders []string) (appid, key string) {
+	appid = r.Header.Get(appidHeader)
+	for _, h := range keyHeaders {
+		if key = r.Header.Get(h); key != "" {
+			return
+		}
+	}
+
+	return
+}
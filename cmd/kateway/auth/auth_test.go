@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+type fakeProvider struct {
+	name  string
+	appid string
+	err   error
+}
+
+func (this fakeProvider) Name() string {
+	return this.name
+}
+
+func (this fakeProvider) Authenticate(r *http.Request) (string, error) {
+	return this.appid, this.err
+}
+
+func TestChain(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/", nil)
+
+	none := fakeProvider{name: "none", err: ErrNoCredential}
+	bad := fakeProvider{name: "bad", err: ErrInvalidCredential}
+	good := fakeProvider{name: "good", appid: "app1"}
+
+	assert.Equal(t, "good", Chain(good).Name())
+	assert.Equal(t, "none,bad,good", Chain(none, bad, good).Name())
+
+	appid, err := Chain(none, good).Authenticate(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "app1", appid)
+
+	// the first provider finding its credential decides
+	_, err = Chain(none, bad, good).Authenticate(r)
+	assert.Equal(t, ErrInvalidCredential, err)
+
+	_, err = Chain(none, none).Authenticate(r)
+	assert.Equal(t, ErrNoCredential, err)
+}
+
+func TestKeyOf(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Appid", "app1")
+	r.Header.Set("Subkey", "sk")
+
+	appid, key := KeyOf(r, "Appid", []string{"Pubkey", "Subkey"})
+	assert.Equal(t, "app1", appid)
+	assert.Equal(t, "sk", key)
+
+	_, key = KeyOf(r, "Appid", []string{"Pubkey"})
+	assert.Equal(t, "", key)
+}
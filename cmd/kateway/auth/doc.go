@@ -0,0 +1,7 @@
+// Package auth authenticates the apps calling kateway.
+//
+// A Provider identifies the appid of a request by its credential: the manager keys,
+// a static key file, a JWT verified against a JWKS file or a TLS client certificate.
+// Each listener chains its own providers, and what the app is allowed to do is left
+// to the manager.
+package auth
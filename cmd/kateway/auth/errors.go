@@ -0,0 +1,14 @@
+package auth
+
+import (
+	"errors"
+)
+
+var (
+	// ErrNoCredential means the request carries no credential of the provider,
+	// so the next provider in the chain is tried.
+	ErrNoCredential = errors.New("no credential")
+
+	ErrInvalidCredential = errors.New("invalid credential")
+	ErrUnknownProvider   = errors.New("unknown auth provider")
+)
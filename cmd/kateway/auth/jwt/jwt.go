@@ -0,0 +1,301 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/funkygao/gafka/cmd/kateway/auth"
+	log "github.com/funkygao/log4go"
+)
+
+const bearer = "Bearer "
+
+// jwtProvider authenticates an app by a bearer JWT signed by one of the keys of a JWKS file.
+// The appid is the appid claim, falling back to the sub claim.
+// The token must expire, and must be of the issuer and audience if configured.
+type jwtProvider struct {
+	jwksFile string
+	issuer   string
+	audience string
+	reload   time.Duration // how often the JWKS file is checked for changes, 0 means never
+
+	mu      sync.RWMutex
+	keys    map[string]interface{} // kid:public key
+	modTime time.Time              // of the loaded JWKS file
+	checked time.Time
+}
+
+// New loads the public keys of a JWKS file, RSA and EC keys are supported.
+// The file is reloaded if changed every reload interval so that keys can be rotated without restart.
+// Empty issuer or audience skips the check of the iss or aud claim.
+func New(jwksFile, issuer, audience string, reload time.Duration) (auth.Provider, error) {
+	this := &jwtProvider{
+		jwksFile: jwksFile,
+		issuer:   issuer,
+		audience: audience,
+		reload:   reload,
+	}
+	if err := this.load(); err != nil {
+		return nil, err
+	}
+
+	this.checked = time.Now()
+	return this, nil
+}
+
+// load reads the JWKS file if it has changed since last load.
+func (this *jwtProvider) load() error {
+	fi, err := os.Stat(this.jwksFile)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(this.modTime) {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(this.jwksFile)
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJwks(b)
+	if err != nil {
+		return err
+	}
+
+	if this.keys != nil {
+		log.Info("jwks %s reloaded: %d keys", this.jwksFile, len(keys))
+	}
+	this.keys, this.modTime = keys, fi.ModTime()
+	return nil
+}
+
+// publicKeys returns the keys of the JWKS file, reloading it if due.
+func (this *jwtProvider) publicKeys() map[string]interface{} {
+	this.mu.RLock()
+	keys, due := this.keys, this.reload > 0 && time.Since(this.checked) >= this.reload
+	this.mu.RUnlock()
+	if !due {
+		return keys
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if time.Since(this.checked) >= this.reload {
+		this.checked = time.Now()
+		if err := this.load(); err != nil {
+			// keep the loaded keys
+			log.Error("jwks %s: %v", this.jwksFile, err)
+		}
+	}
+	return this.keys
+}
+
+func (this *jwtProvider) Name() string {
+	return "jwt"
+}
+
+func (this *jwtProvider) Authenticate(r *http.Request) (string, error) {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, bearer) {
+		return "", auth.ErrNoCredential
+	}
+
+	token, err := jwt.Parse(strings.TrimPrefix(authorization, bearer), this.keyFunc)
+	if err != nil || !token.Valid {
+		log.Debug("jwt %s: %v", r.RemoteAddr, err)
+		return "", auth.ErrInvalidCredential
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", auth.ErrInvalidCredential
+	}
+
+	if err = this.verifyClaims(claims); err != nil {
+		log.Debug("jwt %s: %v", r.RemoteAddr, err)
+		return "", auth.ErrInvalidCredential
+	}
+
+	appid, _ := claims["appid"].(string)
+	if appid == "" {
+		appid, _ = claims["sub"].(string)
+	}
+	if appid == "" {
+		return "", auth.ErrInvalidCredential
+	}
+
+	return appid, nil
+}
+
+// verifyClaims checks the claims that jwt.Parse leaves optional.
+// The exp is already verified by jwt.Parse if present.
+func (this *jwtProvider) verifyClaims(claims jwt.MapClaims) error {
+	if _, present := claims["exp"]; !present {
+		return fmt.Errorf("no exp")
+	}
+
+	if this.issuer != "" && !claims.VerifyIssuer(this.issuer, true) {
+		return fmt.Errorf("unexpected iss: %v", claims["iss"])
+	}
+
+	if this.audience != "" {
+		// aud is either a string or an array of strings
+		switch aud := claims["aud"].(type) {
+		case string:
+			if aud == this.audience {
+				return nil
+			}
+
+		case []interface{}:
+			for _, a := range aud {
+				if a == this.audience {
+					return nil
+				}
+			}
+		}
+
+		return fmt.Errorf("unexpected aud: %v", claims["aud"])
+	}
+
+	return nil
+}
+
+func (this *jwtProvider) keyFunc(token *jwt.Token) (interface{}, error) {
+	// only the asymmetric algorithms, never verify a HMAC with a public key
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+	default:
+		return nil, fmt.Errorf("unexpected alg: %s", token.Method.Alg())
+	}
+
+	keys := this.publicKeys()
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+
+	key, present := keys[kid]
+	if !present {
+		return nil, fmt.Errorf("unknown kid: %s", kid)
+	}
+
+	return key, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func parseJwks(b []byte) (map[string]interface{}, error) {
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{}, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwk[%s]: %v", k.Kid, err)
+		}
+
+		keys[k.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing key found")
+	}
+
+	return keys, nil
+}
+
+func (this jwk) publicKey() (interface{}, error) {
+	switch this.Kty {
+	case "RSA":
+		n, err := decodeBigInt(this.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(this.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch this.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported crv: %s", this.Crv)
+		}
+
+		x, err := decodeBigInt(this.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(this.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point not on curve %s", this.Crv)
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported kty: %s", this.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,147 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/auth"
+)
+
+func b64(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func request(token string) *http.Request {
+	r, _ := http.NewRequest("GET", "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	s, err := token.SignedString(key)
+	assert.Equal(t, nil, err)
+	return s
+}
+
+func TestJwtProvider(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	jwks := fmt.Sprintf(`{"keys":[
+{"kty":"RSA","kid":"r1","use":"sig","n":"%s","e":"%s"},
+{"kty":"EC","kid":"e1","crv":"P-256","x":"%s","y":"%s"},
+{"kty":"RSA","kid":"enc","use":"enc","n":"%s","e":"AQAB"}]}`,
+		b64(rsaKey.N), b64(big.NewInt(int64(rsaKey.E))), b64(ecKey.X), b64(ecKey.Y), b64(otherKey.N))
+	keys, err := parseJwks([]byte(jwks))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(keys))
+	p := &jwtProvider{keys: keys, issuer: "iss1", audience: "kateway"}
+
+	exp := time.Now().Add(time.Hour).Unix()
+
+	appid, err := p.Authenticate(request(sign(t, jwt.SigningMethodRS256, "r1", rsaKey, jwt.MapClaims{"appid": "app1", "exp": exp, "iss": "iss1", "aud": "kateway"})))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "app1", appid)
+
+	appid, err = p.Authenticate(request(sign(t, jwt.SigningMethodES256, "e1", ecKey, jwt.MapClaims{"sub": "app2", "exp": exp, "iss": "iss1", "aud": []string{"other", "kateway"}})))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "app2", appid)
+
+	_, err = p.Authenticate(request(""))
+	assert.Equal(t, auth.ErrNoCredential, err)
+
+	// expired
+	_, err = p.Authenticate(request(sign(t, jwt.SigningMethodRS256, "r1", rsaKey, jwt.MapClaims{"appid": "app1", "exp": time.Now().Add(-time.Minute).Unix()})))
+	assert.Equal(t, auth.ErrInvalidCredential, err)
+
+	// no exp
+	_, err = p.Authenticate(request(sign(t, jwt.SigningMethodRS256, "r1", rsaKey, jwt.MapClaims{"appid": "app1", "iss": "iss1", "aud": "kateway"})))
+	assert.Equal(t, auth.ErrInvalidCredential, err)
+
+	// wrong or missing iss/aud
+	_, err = p.Authenticate(request(sign(t, jwt.SigningMethodRS256, "r1", rsaKey, jwt.MapClaims{"appid": "app1", "exp": exp, "iss": "iss2", "aud": "kateway"})))
+	assert.Equal(t, auth.ErrInvalidCredential, err)
+	_, err = p.Authenticate(request(sign(t, jwt.SigningMethodRS256, "r1", rsaKey, jwt.MapClaims{"appid": "app1", "exp": exp, "iss": "iss1", "aud": "other"})))
+	assert.Equal(t, auth.ErrInvalidCredential, err)
+	_, err = p.Authenticate(request(sign(t, jwt.SigningMethodRS256, "r1", rsaKey, jwt.MapClaims{"appid": "app1", "exp": exp})))
+	assert.Equal(t, auth.ErrInvalidCredential, err)
+
+	// signed by a key not in the jwks
+	_, err = p.Authenticate(request(sign(t, jwt.SigningMethodRS256, "r1", otherKey, jwt.MapClaims{"appid": "app1"})))
+	assert.Equal(t, auth.ErrInvalidCredential, err)
+	_, err = p.Authenticate(request(sign(t, jwt.SigningMethodRS256, "enc", otherKey, jwt.MapClaims{"appid": "app1"})))
+	assert.Equal(t, auth.ErrInvalidCredential, err)
+
+	// hmac is never accepted
+	_, err = p.Authenticate(request(sign(t, jwt.SigningMethodHS256, "r1", []byte("secret"), jwt.MapClaims{"appid": "app1"})))
+	assert.Equal(t, auth.ErrInvalidCredential, err)
+
+	// no appid
+	_, err = p.Authenticate(request(sign(t, jwt.SigningMethodRS256, "r1", rsaKey, jwt.MapClaims{"exp": exp, "iss": "iss1", "aud": "kateway"})))
+	assert.Equal(t, auth.ErrInvalidCredential, err)
+}
+
+func TestParseJwksInvalid(t *testing.T) {
+	_, err := parseJwks([]byte(`{"keys":[]}`))
+	assert.NotEqual(t, nil, err)
+
+	_, err = parseJwks([]byte(`{"keys":[{"kty":"oct","kid":"k","k":"c2VjcmV0"}]}`))
+	assert.NotEqual(t, nil, err)
+
+	_, err = parseJwks([]byte(`{"keys":[{"kty":"EC","kid":"k","crv":"P-256","x":"AQ","y":"AQ"}]}`))
+	assert.NotEqual(t, nil, err)
+}
+
+func TestJwksReload(t *testing.T) {
+	key1, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key2, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwks := func(key *rsa.PrivateKey) []byte {
+		return []byte(fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"r1","n":"%s","e":"AQAB"}]}`, b64(key.N)))
+	}
+
+	f, err := ioutil.TempFile("", "jwks")
+	assert.Equal(t, nil, err)
+	defer os.Remove(f.Name())
+	f.Close()
+	assert.Equal(t, nil, ioutil.WriteFile(f.Name(), jwks(key1), 0644))
+
+	p, err := New(f.Name(), "", "", time.Millisecond)
+	assert.Equal(t, nil, err)
+
+	claims := jwt.MapClaims{"appid": "app1", "exp": time.Now().Add(time.Hour).Unix()}
+	_, err = p.Authenticate(request(sign(t, jwt.SigningMethodRS256, "r1", key1, claims)))
+	assert.Equal(t, nil, err)
+
+	// rotated
+	assert.Equal(t, nil, ioutil.WriteFile(f.Name(), jwks(key2), 0644))
+	assert.Equal(t, nil, os.Chtimes(f.Name(), time.Now(), time.Now().Add(time.Minute)))
+	time.Sleep(time.Millisecond * 2)
+	_, err = p.Authenticate(request(sign(t, jwt.SigningMethodRS256, "r1", key1, claims)))
+	assert.Equal(t, auth.ErrInvalidCredential, err)
+	_, err = p.Authenticate(request(sign(t, jwt.SigningMethodRS256, "r1", key2, claims)))
+	assert.Equal(t, nil, err)
+
+	// broken file keeps the loaded keys
+	assert.Equal(t, nil, ioutil.WriteFile(f.Name(), []byte("{"), 0644))
+	assert.Equal(t, nil, os.Chtimes(f.Name(), time.Now(), time.Now().Add(time.Minute*2)))
+	time.Sleep(time.Millisecond * 2)
+	_, err = p.Authenticate(request(sign(t, jwt.SigningMethodRS256, "r1", key2, claims)))
+	assert.Equal(t, nil, err)
+}
@@ -0,0 +1,36 @@
+package key
+
+import (
+	"net/http"
+
+	"github.com/funkygao/gafka/cmd/kateway/auth"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+)
+
+// keyProvider authenticates an app by its secret key kept in the manager.
+type keyProvider struct {
+	appidHeader string
+	keyHeaders  []string
+}
+
+// New creates a provider reading the appid and the first present key of keyHeaders from a request.
+func New(appidHeader string, keyHeaders ...string) auth.Provider {
+	return &keyProvider{appidHeader: appidHeader, keyHeaders: keyHeaders}
+}
+
+func (this *keyProvider) Name() string {
+	return "key"
+}
+
+func (this *keyProvider) Authenticate(r *http.Request) (string, error) {
+	appid, key := auth.KeyOf(r, this.appidHeader, this.keyHeaders)
+	if key == "" {
+		return "", auth.ErrNoCredential
+	}
+
+	if err := manager.Default.Auth(appid, key); err != nil {
+		return "", err
+	}
+
+	return appid, nil
+}
@@ -0,0 +1,67 @@
+package mtls
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/funkygao/gafka/cmd/kateway/auth"
+)
+
+// mtlsProvider authenticates an app by its client certificate verified by the https listener.
+type mtlsProvider struct {
+	appids map[string]string // certificate identity:appid
+}
+
+// New loads the mapping file of certificate identity to appid, e,g.
+// {"billing.example.com":"app1"}
+// The identity is the subject common name or any of the DNS names and email addresses.
+// Without mapping file, the subject common name is the appid.
+func New(mapFile string) (auth.Provider, error) {
+	this := &mtlsProvider{}
+	if mapFile == "" {
+		return this, nil
+	}
+
+	b, err := ioutil.ReadFile(mapFile)
+	if err != nil {
+		return nil, err
+	}
+
+	this.appids = make(map[string]string)
+	if err = json.Unmarshal(b, &this.appids); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+func (this *mtlsProvider) Name() string {
+	return "mtls"
+}
+
+func (this *mtlsProvider) Authenticate(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		// plain http, or no client certificate verified against the client CA
+		return "", auth.ErrNoCredential
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	if this.appids == nil {
+		if cert.Subject.CommonName == "" {
+			return "", auth.ErrInvalidCredential
+		}
+
+		return cert.Subject.CommonName, nil
+	}
+
+	identities := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	for _, id := range identities {
+		if appid, present := this.appids[id]; present && id != "" {
+			return appid, nil
+		}
+	}
+
+	return "", auth.ErrInvalidCredential
+}
@@ -0,0 +1,49 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"testing"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/auth"
+)
+
+func request(cert *x509.Certificate) *http.Request {
+	r, _ := http.NewRequest("GET", "/", nil)
+	if cert != nil {
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	return r
+}
+
+func TestMtlsProvider(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "billing"},
+		DNSNames: []string{"billing.example.com"},
+	}
+
+	p := &mtlsProvider{}
+	appid, err := p.Authenticate(request(cert))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "billing", appid)
+
+	_, err = p.Authenticate(request(nil))
+	assert.Equal(t, auth.ErrNoCredential, err)
+
+	// tls without verified client certificate
+	r := request(nil)
+	r.TLS = &tls.ConnectionState{}
+	_, err = p.Authenticate(r)
+	assert.Equal(t, auth.ErrNoCredential, err)
+
+	p = &mtlsProvider{appids: map[string]string{"billing.example.com": "app1"}}
+	appid, err = p.Authenticate(request(cert))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "app1", appid)
+
+	_, err = p.Authenticate(request(&x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}}))
+	assert.Equal(t, auth.ErrInvalidCredential, err)
+}
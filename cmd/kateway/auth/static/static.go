@@ -0,0 +1,56 @@
+package static
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/funkygao/gafka/cmd/kateway/auth"
+)
+
+// staticProvider authenticates an app by its key in a static file.
+type staticProvider struct {
+	appidHeader string
+	keyHeaders  []string
+
+	keys map[string]string // appid:key
+}
+
+// New loads the keys file, which is a json object of appid to key, e,g.
+// {"app1":"c8f3a4f2b1e74d0c"}
+func New(fn string, appidHeader string, keyHeaders ...string) (auth.Provider, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	this := &staticProvider{
+		appidHeader: appidHeader,
+		keyHeaders:  keyHeaders,
+		keys:        make(map[string]string),
+	}
+	if err = json.Unmarshal(b, &this.keys); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+func (this *staticProvider) Name() string {
+	return "static"
+}
+
+func (this *staticProvider) Authenticate(r *http.Request) (string, error) {
+	appid, key := auth.KeyOf(r, this.appidHeader, this.keyHeaders)
+	if key == "" {
+		return "", auth.ErrNoCredential
+	}
+
+	expected, present := this.keys[appid]
+	if !present || subtle.ConstantTimeCompare([]byte(expected), []byte(key)) != 1 {
+		return "", auth.ErrInvalidCredential
+	}
+
+	return appid, nil
+}
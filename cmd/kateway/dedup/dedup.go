@@ -0,0 +1,34 @@
+package dedup
+
+import (
+	"time"
+)
+
+// PendingTimeout is how long a claimed but not committed msg id blocks others, after which
+// it is taken over, e.g. the claimer crashed in the middle of Pub.
+var PendingTimeout = time.Second * 30
+
+type Deduper interface {
+
+	// Claim reserves the msgId of topic before Pub.
+	// If the msgId was already published within the window, dup is true and
+	// the original partition/offset is returned.
+	// If another Pub of the same msgId is in progress, ErrInProgress is returned
+	// until PendingTimeout.
+	Claim(topic, msgId string) (partition int32, offset int64, dup bool, err error)
+
+	// Commit records the Pub result of msgId.
+	// offset -1 means the message is accepted but not delivered yet, e.g. in hinted handoff.
+	Commit(topic, msgId string, partition int32, offset int64)
+
+	// Release gives up a claimed msgId so that it can be published again.
+	Release(topic, msgId string)
+
+	// Lookup returns the committed Pub result of msgId within the window.
+	Lookup(topic, msgId string) (partition int32, offset int64, found bool)
+
+	Init() error
+	Stop() error
+}
+
+var Default Deduper
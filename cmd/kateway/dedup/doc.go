@@ -0,0 +1,18 @@
+// Package dedup remembers the Pub result of client supplied message ids
+// within a time window so that retried Pub will not duplicate messages.
+//
+//  client               kateway               store
+//    |                    |                    |
+//    | Pub(X-Msg-Id:1)    |                    |
+//    |------------------->| Claim(1)           |
+//    |                    |------------------->|
+//    |    timeout         |                    |
+//    |<- - - - - - - - - -|      {P:0 O:10}    |
+//    |                    |<-------------------|
+//    |                    | Commit(1, 0, 10)   |
+//    | Pub(X-Msg-Id:1)    |                    |
+//    |------------------->| Claim(1) dup       |
+//    |         {P:0 O:10} |                    |
+//    |<-------------------|                    |
+//    |                    |                    |
+package dedup
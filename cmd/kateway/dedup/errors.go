@@ -0,0 +1,9 @@
+package dedup
+
+import (
+	"errors"
+)
+
+var (
+	ErrInProgress = errors.New("message id in progress")
+)
@@ -0,0 +1,443 @@
+package mem
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+	log "github.com/funkygao/log4go"
+)
+
+// purgeInterval is how often the expired msg ids are purged.
+const purgeInterval = time.Minute
+
+type msgKey struct {
+	topic, msgId string
+}
+
+type entry struct {
+	Partition int32
+	Offset    int64
+	Ctime     time.Time
+
+	pending bool // claimed but not committed yet
+}
+
+type dumpRecord struct {
+	Topic string
+	MsgId string
+	Val   entry
+}
+
+type memDeduper struct {
+	mu      sync.Mutex
+	entries map[msgKey]*entry
+
+	windows      dedup.Windows
+	snapshotFile string
+	journalFile  string
+
+	// jmu serializes the journal writes and checkpoints outside of mu,
+	// so that Claim and Lookup never wait for a disk fsync
+	jmu     sync.Mutex
+	journal *os.File
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func New(windows dedup.Windows, fn string) *memDeduper {
+	return &memDeduper{
+		entries:      make(map[msgKey]*entry),
+		windows:      windows,
+		snapshotFile: fn,
+		quit:         make(chan struct{}),
+	}
+}
+
+// WithJournal makes each Commit durable by appending it to the journal file with fsync
+// before Commit returns, so that the committed msg ids survive a crash instead of
+// only a clean Stop.
+// The journal is replayed on Init and truncated whenever the snapshot is taken,
+// so it requires the snapshot file.
+func (this *memDeduper) WithJournal(fn string) *memDeduper {
+	this.journalFile = fn
+	return this
+}
+
+func (this *memDeduper) expired(topic string, e *entry, now time.Time) bool {
+	return now.Sub(e.Ctime) >= this.windows.Of(topic)
+}
+
+// stale checks if a pending claim is abandoned and can be taken over.
+func (this *memDeduper) stale(e *entry, now time.Time) bool {
+	return e.pending && now.Sub(e.Ctime) >= dedup.PendingTimeout
+}
+
+func (this *memDeduper) Claim(topic, msgId string) (partition int32, offset int64, dup bool, err error) {
+	if this.windows.Of(topic) == 0 {
+		// dedup disabled for the topic
+		return
+	}
+
+	k := msgKey{topic: topic, msgId: msgId}
+	now := time.Now()
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if e, present := this.entries[k]; present && !this.expired(topic, e, now) && !this.stale(e, now) {
+		if e.pending {
+			err = dedup.ErrInProgress
+			return
+		}
+
+		return e.Partition, e.Offset, true, nil
+	}
+
+	this.entries[k] = &entry{Ctime: now, Offset: -1, pending: true}
+	return
+}
+
+func (this *memDeduper) Commit(topic, msgId string, partition int32, offset int64) {
+	if this.windows.Of(topic) == 0 {
+		return
+	}
+
+	k := msgKey{topic: topic, msgId: msgId}
+	now := time.Now()
+
+	this.mu.Lock()
+	e, present := this.entries[k]
+	if !present || this.expired(topic, e, now) {
+		// e.g. hh delivery after restart
+		e = &entry{Ctime: now}
+		this.entries[k] = e
+	}
+	e.Partition, e.Offset, e.pending = partition, offset, false
+	record := dumpRecord{Topic: topic, MsgId: msgId, Val: *e}
+	this.mu.Unlock()
+
+	if this.journalFile != "" {
+		if err := this.appendJournal(record); err != nil {
+			log.Error("dedup journal %s %s: %v", topic, msgId, err)
+		}
+	}
+}
+
+func (this *memDeduper) appendJournal(record dumpRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	this.jmu.Lock()
+	defer this.jmu.Unlock()
+
+	if this.journal == nil {
+		// not inited or stopped
+		return nil
+	}
+
+	if _, err = this.journal.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	return this.journal.Sync()
+}
+
+func (this *memDeduper) Release(topic, msgId string) {
+	k := msgKey{topic: topic, msgId: msgId}
+
+	this.mu.Lock()
+	if e, present := this.entries[k]; present && e.pending {
+		delete(this.entries, k)
+	}
+	this.mu.Unlock()
+}
+
+func (this *memDeduper) Lookup(topic, msgId string) (partition int32, offset int64, found bool) {
+	k := msgKey{topic: topic, msgId: msgId}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	e, present := this.entries[k]
+	if !present || e.pending || this.expired(topic, e, time.Now()) {
+		return
+	}
+
+	return e.Partition, e.Offset, true
+}
+
+func (this *memDeduper) purge() (n int) {
+	now := time.Now()
+
+	this.mu.Lock()
+	for k, e := range this.entries {
+		if this.expired(k.topic, e, now) {
+			delete(this.entries, k)
+			n++
+		}
+	}
+	this.mu.Unlock()
+	return
+}
+
+func (this *memDeduper) purgeExpired() {
+	defer this.wg.Done()
+
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if n := this.purge(); n > 0 {
+				log.Debug("dedup purged %d msg ids", n)
+			}
+
+			if this.journalFile != "" {
+				// keep the journal from growing forever
+				if err := this.checkpoint(); err != nil {
+					log.Error("dedup checkpoint: %v", err)
+				}
+			}
+
+		case <-this.quit:
+			return
+		}
+	}
+}
+
+func (this *memDeduper) Init() error {
+	if err := this.load(); err != nil {
+		return err
+	}
+
+	if this.journalFile != "" {
+		if this.snapshotFile == "" {
+			return errors.New("dedup journal requires the snapshot file to be compacted into")
+		}
+
+		offset, err := this.replay()
+		if err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(this.journalFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+
+		// drop the torn record, or the commits appended after it are lost to the next replay
+		if err = f.Truncate(offset); err != nil {
+			f.Close()
+			return err
+		}
+
+		this.jmu.Lock()
+		this.journal = f
+		this.jmu.Unlock()
+	}
+
+	this.wg.Add(1)
+	go this.purgeExpired()
+	return nil
+}
+
+func (this *memDeduper) load() error {
+	if this.snapshotFile == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(this.snapshotFile)
+	if err != nil {
+		if _, ok := err.(*os.PathError); ok {
+			return nil
+		}
+		return err
+	}
+
+	dumps := make([]dumpRecord, 0)
+	if err = json.Unmarshal(data, &dumps); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	this.mu.Lock()
+	for _, record := range dumps {
+		e := record.Val
+		if !this.expired(record.Topic, &e, now) {
+			this.entries[msgKey{topic: record.Topic, msgId: record.MsgId}] = &e
+		}
+	}
+	this.mu.Unlock()
+	return nil
+}
+
+// replay applies the commits journaled after the last snapshot.
+// Returns the offset where the last good record ends.
+func (this *memDeduper) replay() (offset int64, err error) {
+	f, err := os.Open(this.journalFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	now := time.Now()
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// torn write of the last record when crashed
+				log.Warn("dedup journal %s: torn record at %d", this.journalFile, offset)
+			}
+
+			return offset, nil
+		} else if err != nil {
+			return offset, err
+		}
+
+		var record dumpRecord
+		if err = json.Unmarshal(line, &record); err != nil {
+			log.Warn("dedup journal %s: %v at %d, replay stopped", this.journalFile, err, offset)
+			return offset, nil
+		}
+
+		offset += int64(len(line))
+		e := record.Val
+		if !this.expired(record.Topic, &e, now) {
+			this.entries[msgKey{topic: record.Topic, msgId: record.MsgId}] = &e
+		}
+	}
+}
+
+// checkpoint takes the snapshot and truncates the journal that it covers.
+func (this *memDeduper) checkpoint() error {
+	// commits journaled after the dump are kept in the journal: they wait for jmu
+	this.jmu.Lock()
+	defer this.jmu.Unlock()
+
+	if this.journal == nil {
+		return nil
+	}
+
+	this.mu.Lock()
+	dumps := this.dump()
+	this.mu.Unlock()
+
+	if err := this.snapshot(dumps); err != nil {
+		return err
+	}
+
+	if err := this.journal.Truncate(0); err != nil {
+		return err
+	}
+
+	return this.journal.Sync()
+}
+
+func (this *memDeduper) Stop() error {
+	close(this.quit)
+	this.wg.Wait()
+
+	this.jmu.Lock()
+	defer this.jmu.Unlock()
+
+	if this.journal != nil {
+		this.journal.Close()
+		this.journal = nil
+	}
+
+	if this.snapshotFile == "" {
+		return nil
+	}
+
+	this.mu.Lock()
+	dumps := this.dump()
+	this.mu.Unlock()
+
+	if err := this.snapshot(dumps); err != nil {
+		return err
+	}
+
+	if this.journalFile != "" {
+		// covered by the snapshot
+		if err := os.Remove(this.journalFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dump returns the committed msg ids within the window, caller must hold the lock.
+func (this *memDeduper) dump() []dumpRecord {
+	now := time.Now()
+	dumps := make([]dumpRecord, 0, len(this.entries))
+	for k, e := range this.entries {
+		if e.pending || this.expired(k.topic, e, now) {
+			continue
+		}
+
+		dumps = append(dumps, dumpRecord{
+			Topic: k.topic,
+			MsgId: k.msgId,
+			Val:   *e,
+		})
+	}
+
+	return dumps
+}
+
+// snapshot durably replaces the snapshot file with the dumps.
+func (this *memDeduper) snapshot(dumps []dumpRecord) error {
+	data, err := json.Marshal(dumps)
+	if err != nil {
+		return err
+	}
+
+	// a torn snapshot would fail the next Init, so replace it atomically
+	tmp := this.snapshotFile + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, this.snapshotFile); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// make the rename durable before the journal is truncated
+	dir, err := os.Open(filepath.Dir(this.snapshotFile))
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	dir.Close()
+	return err
+}
@@ -0,0 +1,176 @@
+package mem
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+)
+
+var _ dedup.Deduper = &memDeduper{}
+
+func TestClaimAndCommit(t *testing.T) {
+	m := New(dedup.FixedWindow(time.Minute), "")
+	_, _, dup, err := m.Claim("topic", "id1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, dup)
+
+	_, _, _, err = m.Claim("topic", "id1")
+	assert.Equal(t, dedup.ErrInProgress, err)
+	_, _, found := m.Lookup("topic", "id1")
+	assert.Equal(t, false, found)
+
+	m.Commit("topic", "id1", 2, 100)
+	partition, offset, dup, err := m.Claim("topic", "id1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, dup)
+	assert.Equal(t, int32(2), partition)
+	assert.Equal(t, int64(100), offset)
+
+	// msg id is per topic
+	_, _, dup, err = m.Claim("topic1", "id1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, dup)
+}
+
+func TestRelease(t *testing.T) {
+	m := New(dedup.FixedWindow(time.Minute), "")
+	m.Claim("topic", "id1")
+	m.Release("topic", "id1")
+	_, _, dup, err := m.Claim("topic", "id1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, dup)
+
+	// committed msg id will not be released
+	m.Commit("topic", "id1", 0, 1)
+	m.Release("topic", "id1")
+	_, offset, found := m.Lookup("topic", "id1")
+	assert.Equal(t, true, found)
+	assert.Equal(t, int64(1), offset)
+}
+
+func TestPendingTimeout(t *testing.T) {
+	defer func(d time.Duration) {
+		dedup.PendingTimeout = d
+	}(dedup.PendingTimeout)
+	dedup.PendingTimeout = time.Millisecond * 10
+
+	m := New(dedup.FixedWindow(time.Minute), "")
+	m.Claim("topic", "id1")
+	_, _, _, err := m.Claim("topic", "id1")
+	assert.Equal(t, dedup.ErrInProgress, err)
+
+	// the abandoned claim is taken over
+	time.Sleep(time.Millisecond * 20)
+	_, _, dup, err := m.Claim("topic", "id1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, dup)
+	_, _, _, err = m.Claim("topic", "id1")
+	assert.Equal(t, dedup.ErrInProgress, err)
+
+	// committed msg id never times out within the window
+	m.Commit("topic", "id1", 0, 1)
+	time.Sleep(time.Millisecond * 20)
+	_, _, dup, _ = m.Claim("topic", "id1")
+	assert.Equal(t, true, dup)
+}
+
+func TestWindow(t *testing.T) {
+	m := New(dedup.FixedWindow(time.Millisecond*10), "")
+	m.Commit("topic", "id1", 0, 1)
+	time.Sleep(time.Millisecond * 20)
+	_, _, found := m.Lookup("topic", "id1")
+	assert.Equal(t, false, found)
+	_, _, dup, _ := m.Claim("topic", "id1")
+	assert.Equal(t, false, dup)
+	assert.Equal(t, 0, m.purge())
+}
+
+func TestInitAndStop(t *testing.T) {
+	fn := "dedup.dmp"
+	defer os.Remove(fn)
+
+	m := New(dedup.FixedWindow(time.Minute), fn)
+	assert.Equal(t, nil, m.Init())
+	m.Commit("topic", "id1", 0, 1)
+	m.Commit("topic", "id2", 1, -1)
+	m.Claim("topic", "id3")
+	assert.Equal(t, nil, m.Stop())
+
+	m = New(dedup.FixedWindow(time.Minute), fn)
+	assert.Equal(t, nil, m.Init())
+	assert.Equal(t, 2, len(m.entries))
+	partition, offset, found := m.Lookup("topic", "id2")
+	assert.Equal(t, true, found)
+	assert.Equal(t, int32(1), partition)
+	assert.Equal(t, int64(-1), offset)
+	m.Stop()
+}
+
+func TestTopicWindows(t *testing.T) {
+	w, _ := dedup.ParseWindows(time.Minute, "app1.nodedup.*=0s")
+	m := New(w, "")
+	m.Commit("app1.nodedup.v1", "id1", 0, 1)
+	_, _, found := m.Lookup("app1.nodedup.v1", "id1")
+	assert.Equal(t, false, found)
+	_, _, dup, err := m.Claim("app1.nodedup.v1", "id1")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, dup)
+	assert.Equal(t, 0, len(m.entries))
+
+	m.Commit("app1.foo.v1", "id1", 0, 1)
+	_, _, found = m.Lookup("app1.foo.v1", "id1")
+	assert.Equal(t, true, found)
+}
+
+func TestJournal(t *testing.T) {
+	fn, jnl := "dedup.dmp", "dedup.jnl"
+	defer os.Remove(fn)
+	defer os.Remove(jnl)
+
+	m := New(dedup.FixedWindow(time.Minute), fn).WithJournal(jnl)
+	assert.Equal(t, nil, m.Init())
+	m.Commit("topic", "id1", 0, 1)
+	assert.Equal(t, nil, m.checkpoint())
+	m.Commit("topic", "id2", 1, 2)
+	m.Claim("topic", "id3")
+
+	// crash without Stop: id1 in snapshot, id2 in journal
+	r := New(dedup.FixedWindow(time.Minute), fn).WithJournal(jnl)
+	assert.Equal(t, nil, r.Init())
+	assert.Equal(t, 2, len(r.entries))
+	_, offset, found := r.Lookup("topic", "id1")
+	assert.Equal(t, true, found)
+	assert.Equal(t, int64(1), offset)
+	partition, offset, found := r.Lookup("topic", "id2")
+	assert.Equal(t, true, found)
+	assert.Equal(t, int32(1), partition)
+	assert.Equal(t, int64(2), offset)
+
+	// torn last record is ignored
+	f, _ := os.OpenFile(jnl, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"Topic":"topic","MsgId":"id4","Val":{"Parti`)
+	f.Close()
+	r = New(dedup.FixedWindow(time.Minute), fn).WithJournal(jnl)
+	assert.Equal(t, nil, r.Init())
+	assert.Equal(t, 2, len(r.entries))
+
+	// and truncated so that the commits after it survive the next crash
+	r.Commit("topic", "id5", 0, 5)
+	r1 := New(dedup.FixedWindow(time.Minute), fn).WithJournal(jnl)
+	assert.Equal(t, nil, r1.Init())
+	_, offset, found = r1.Lookup("topic", "id5")
+	assert.Equal(t, true, found)
+	assert.Equal(t, int64(5), offset)
+	r1.Stop()
+	assert.Equal(t, nil, r.Stop())
+
+	_, err := os.Stat(jnl)
+	assert.Equal(t, true, os.IsNotExist(err))
+	m.Stop()
+
+	// the journal is never compacted without snapshot
+	assert.NotEqual(t, nil, New(dedup.FixedWindow(time.Minute), "").WithJournal(jnl).Init())
+}
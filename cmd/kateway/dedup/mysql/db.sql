@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS Dedup (
+    topic_id bigint unsigned NOT NULL DEFAULT 0,
+    msg_id varbinary(255) NOT NULL DEFAULT "",
+    part int NOT NULL DEFAULT 0,
+    msg_offset bigint NOT NULL DEFAULT -1,
+    pending tinyint NOT NULL DEFAULT 0,
+    ctime int NOT NULL DEFAULT 0,
+    topic varchar(255) NOT NULL DEFAULT "",
+    PRIMARY KEY (topic_id, msg_id),
+    KEY(ctime)
+) ENGINE = INNODB DEFAULT CHARSET=utf8;
@@ -0,0 +1,3 @@
+// Package mysql implements a Deduper with mysql as backend, so that
+// the msg ids survive kateway crash and are shared by all kateways.
+package mysql
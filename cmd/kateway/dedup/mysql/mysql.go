@@ -0,0 +1,187 @@
+package mysql
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/funkygao/fae/config"
+	"github.com/funkygao/fae/servant/mysql"
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+	log "github.com/funkygao/log4go"
+)
+
+const (
+	pool  = "ShardLookup" // msg ids are not sharded
+	table = "Dedup"
+
+	purgeBatch = 1000
+
+	// purgeInterval is how often the expired msg ids are purged.
+	purgeInterval = time.Minute
+
+	sqlClaim   = "INSERT IGNORE INTO Dedup(topic_id,msg_id,part,msg_offset,pending,ctime,topic) VALUES(?,?,0,-1,1,?,?)"
+	sqlReclaim = "UPDATE Dedup SET part=0,msg_offset=-1,pending=1,ctime=? WHERE topic_id=? AND msg_id=? AND (ctime<? OR (pending=1 AND ctime<?))"
+	sqlCommit  = "INSERT INTO Dedup(topic_id,msg_id,part,msg_offset,pending,ctime,topic) VALUES(?,?,?,?,0,?,?) ON DUPLICATE KEY UPDATE part=VALUES(part),msg_offset=VALUES(msg_offset),pending=0"
+	sqlRelease = "DELETE FROM Dedup WHERE topic_id=? AND msg_id=? AND pending=1"
+	sqlLookup  = "SELECT part,msg_offset,pending FROM Dedup WHERE topic_id=? AND msg_id=? AND ctime>=?"
+	sqlPurge   = "DELETE FROM Dedup WHERE ctime<? LIMIT %d"
+)
+
+type mysqlDeduper struct {
+	mc      *mysql.MysqlCluster
+	windows dedup.Windows
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func New(cf *config.ConfigMysql, windows dedup.Windows) (dedup.Deduper, error) {
+	if cf == nil {
+		return nil, fmt.Errorf("dedup: empty mysql config")
+	}
+
+	return &mysqlDeduper{
+		mc:      mysql.New(cf),
+		windows: windows,
+		quit:    make(chan struct{}),
+	}, nil
+}
+
+func (this *mysqlDeduper) topicId(topic string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(topic))
+	return h.Sum64()
+}
+
+func (this *mysqlDeduper) Claim(topic, msgId string) (partition int32, offset int64, dup bool, err error) {
+	window := this.windows.Of(topic)
+	if window == 0 {
+		// dedup disabled for the topic
+		return
+	}
+
+	topicId := this.topicId(topic)
+	now := time.Now()
+
+	var affectedRows int64
+	affectedRows, _, err = this.mc.Exec(pool, table, 0, sqlClaim, topicId, msgId, now.Unix(), topic)
+	if err != nil || affectedRows == 1 {
+		return
+	}
+
+	// take over the expired msg id or the abandoned claim
+	affectedRows, _, err = this.mc.Exec(pool, table, 0, sqlReclaim,
+		now.Unix(), topicId, msgId, now.Add(-window).Unix(), now.Add(-dedup.PendingTimeout).Unix())
+	if err != nil || affectedRows == 1 {
+		return
+	}
+
+	var pending bool
+	partition, offset, pending, dup, err = this.lookup(topicId, msgId, now.Add(-window))
+	if err == nil && pending {
+		dup = false
+		err = dedup.ErrInProgress
+	}
+	return
+}
+
+// lookup finds the msg id claimed since.
+func (this *mysqlDeduper) lookup(topicId uint64, msgId string, since time.Time) (partition int32, offset int64,
+	pending bool, found bool, err error) {
+	rows, err := this.mc.Query(pool, table, 0, sqlLookup, topicId, msgId, since.Unix())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err = rows.Scan(&partition, &offset, &pending); err != nil {
+			return
+		}
+
+		found = true
+	}
+	err = rows.Err()
+	return
+}
+
+func (this *mysqlDeduper) Commit(topic, msgId string, partition int32, offset int64) {
+	if this.windows.Of(topic) == 0 {
+		return
+	}
+
+	if _, _, err := this.mc.Exec(pool, table, 0, sqlCommit,
+		this.topicId(topic), msgId, partition, offset, time.Now().Unix(), topic); err != nil {
+		log.Error("dedup commit %s %s: %v", topic, msgId, err)
+	}
+}
+
+func (this *mysqlDeduper) Release(topic, msgId string) {
+	if _, _, err := this.mc.Exec(pool, table, 0, sqlRelease, this.topicId(topic), msgId); err != nil {
+		log.Error("dedup release %s %s: %v", topic, msgId, err)
+	}
+}
+
+func (this *mysqlDeduper) Lookup(topic, msgId string) (partition int32, offset int64, found bool) {
+	window := this.windows.Of(topic)
+	if window == 0 {
+		return
+	}
+
+	partition, offset, pending, found, err := this.lookup(this.topicId(topic), msgId, time.Now().Add(-window))
+	if err != nil {
+		log.Error("dedup lookup %s %s: %v", topic, msgId, err)
+		return 0, 0, false
+	}
+
+	return partition, offset, found && !pending
+}
+
+func (this *mysqlDeduper) purgeExpired() {
+	defer this.wg.Done()
+
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	sql := fmt.Sprintf(sqlPurge, purgeBatch)
+	for {
+		select {
+		case <-ticker.C:
+			for {
+				// a msg id is kept for the longest window, and expires by the window of its topic
+				affectedRows, _, err := this.mc.Exec(pool, table, 0, sql, time.Now().Add(-this.windows.Max()).Unix())
+				if err != nil {
+					log.Error("dedup purge: %v", err)
+					break
+				}
+
+				if affectedRows > 0 {
+					log.Debug("dedup purged %d msg ids", affectedRows)
+				}
+				if affectedRows < purgeBatch {
+					break
+				}
+			}
+
+		case <-this.quit:
+			return
+		}
+	}
+}
+
+func (this *mysqlDeduper) Init() error {
+	this.mc.Warmup()
+
+	this.wg.Add(1)
+	go this.purgeExpired()
+	return nil
+}
+
+func (this *mysqlDeduper) Stop() error {
+	close(this.quit)
+	this.wg.Wait()
+	this.mc.Close()
+	return nil
+}
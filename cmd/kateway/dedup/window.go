@@ -0,0 +1,85 @@
+package dedup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type windowRule struct {
+	pattern string
+	window  time.Duration
+}
+
+// Windows is the dedup windows of topics, falling back to a default window.
+// Window 0 disables dedup of a topic.
+type Windows struct {
+	def   time.Duration
+	rules []windowRule
+}
+
+// ParseWindows parses the comma separated topic=window pairs, where topic is
+// the kafka topic appid.topic.ver or a prefix of it ending with *, e,g.
+// app1.*=10m,app1.orders.v1=1h
+func ParseWindows(def time.Duration, s string) (Windows, error) {
+	w := Windows{def: def}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return w, fmt.Errorf("dedup window: invalid %s", pair)
+		}
+
+		window, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return w, fmt.Errorf("dedup window: %s %v", pair, err)
+		}
+
+		w.rules = append(w.rules, windowRule{pattern: kv[0], window: window})
+	}
+
+	return w, nil
+}
+
+// FixedWindow is the same window for all topics.
+func FixedWindow(window time.Duration) Windows {
+	return Windows{def: window}
+}
+
+// Of returns the window of a topic: the exact one, or else the one of the longest matching prefix.
+func (this Windows) Of(topic string) time.Duration {
+	window, longest := this.def, -1
+	for _, r := range this.rules {
+		if r.pattern == topic {
+			return r.window
+		}
+
+		if prefix := strings.TrimSuffix(r.pattern, "*"); prefix != r.pattern &&
+			strings.HasPrefix(topic, prefix) && len(prefix) > longest {
+			window, longest = r.window, len(prefix)
+		}
+	}
+
+	return window
+}
+
+// Max returns the longest window of all the topics.
+func (this Windows) Max() time.Duration {
+	max := this.def
+	for _, r := range this.rules {
+		if r.window > max {
+			max = r.window
+		}
+	}
+
+	return max
+}
+
+// Enabled checks if dedup is enabled for any topic.
+func (this Windows) Enabled() bool {
+	return this.Max() > 0
+}
@@ -0,0 +1,30 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func TestWindows(t *testing.T) {
+	w, err := ParseWindows(time.Minute*5, "app1.*=10m, app1.orders.*=1h,app1.orders.v2=0s,app2.foo.v1=2m")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, time.Minute*5, w.Of("app3.foo.v1"))
+	assert.Equal(t, time.Minute*10, w.Of("app1.foo.v1"))
+	assert.Equal(t, time.Hour, w.Of("app1.orders.v1"))
+	assert.Equal(t, time.Duration(0), w.Of("app1.orders.v2"))
+	assert.Equal(t, time.Minute*2, w.Of("app2.foo.v1"))
+	assert.Equal(t, time.Hour, w.Max())
+	assert.Equal(t, true, w.Enabled())
+
+	w, err = ParseWindows(0, "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, w.Enabled())
+	assert.Equal(t, time.Minute, FixedWindow(time.Minute).Of("app1.foo.v1"))
+
+	_, err = ParseWindows(0, "app1.*")
+	assert.NotEqual(t, nil, err)
+	_, err = ParseWindows(0, "app1.*=10")
+	assert.NotEqual(t, nil, err)
+}
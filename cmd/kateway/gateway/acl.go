@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/funkygao/gafka/cmd/kateway/acl"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+type aclContextKey int
+
+const (
+	ctxKeyPrincipal aclContextKey = iota // the authenticated appid
+	ctxKeyAclRule                        // id of the rule granting the operation
+)
+
+func newAclAuditor() log.Logger {
+	auditor := log.NewDefaultLogger(log.TRACE)
+	auditor.DeleteFilter("stdout")
+
+	_ = os.Mkdir("audit", os.ModePerm)
+	rotateEnabled, discardWhenDiskFull := true, false
+	filer := log.NewFileLogWriter("audit/acl_audit.log", rotateEnabled, discardWhenDiskFull, 0644)
+	if filer == nil {
+		panic("failed to open acl audit log")
+	}
+	filer.SetFormat("[%d %T] [%L] (%S) %M")
+	if Options.LogRotateSize > 0 {
+		filer.SetRotateSize(Options.LogRotateSize)
+	}
+	filer.SetRotateLines(0)
+	filer.SetRotateDaily(true)
+	auditor.AddFilter("file", logLevel, filer)
+
+	return auditor
+}
+
+// guard authorizes op of the request by the acl rules before delegating to h.
+//
+// A denied request is audited and rejected, an allowed one is granted to skip the
+// ownership checks of the handler, and the others are left to the handler as is.
+func (this *webServer) guard(op string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		if acl.Default == nil {
+			h(w, r, params)
+			return
+		}
+
+		principal, authenticated := this.aclPrincipal(r, op)
+		if principal == "" {
+			// unauthenticated, the handler will reject it
+			h(w, r, params)
+			return
+		}
+
+		resource := aclResource(principal, params)
+		group := params.ByName(UrlParamGroup)
+		if group == "" {
+			group = r.URL.Query().Get("group")
+		}
+
+		ctx := r.Context()
+		switch d, rule := acl.Default.Check(principal, op, resource, group); d {
+		case acl.Denied:
+			log.Warn("%s[%s] %s(%s) {op:%s resource:%s group:%s} denied by rule#%s",
+				this.name, principal, r.RemoteAddr, getHttpRemoteIp(r), op, resource, group, rule.Id)
+			if this.gw != nil && this.gw.aclAuditor != nil {
+				this.gw.aclAuditor.Warn("deny[%s] %s(%s) {op:%s resource:%s group:%s %s %s UA:%s} rule#%s",
+					principal, r.RemoteAddr, getHttpRemoteIp(r), op, resource, group,
+					r.Method, r.URL.Path, r.Header.Get("User-Agent"), rule.Id)
+			}
+
+			writeForbidden(w, acl.ErrDenied)
+			return
+
+		case acl.Allowed:
+			ctx = context.WithValue(ctx, ctxKeyAclRule, rule.Id)
+		}
+
+		if authenticated {
+			// spare the handler another authentication
+			ctx = context.WithValue(ctx, ctxKeyPrincipal, principal)
+		}
+		h(w, r.WithContext(ctx), params)
+	}
+}
+
+// aclPrincipal identifies the app the rules apply to.
+// The admin app is identified by its key as the handlers do.
+func (this *webServer) aclPrincipal(r *http.Request, op string) (principal string, authenticated bool) {
+	if op == acl.OpAdmin {
+		appid := r.Header.Get(HttpHeaderAppid)
+		if manager.Default.AuthAdmin(appid, r.Header.Get(HttpHeaderPubkey)) {
+			return appid, false
+		}
+	}
+
+	appid, err := this.authenticate(r)
+	if err != nil {
+		return "", false
+	}
+
+	return appid, true
+}
+
+// aclResource is the appid.topic.ver of the topic a request operates on, which is
+// owned by the principal if the path has no appid, or the raw topic name.
+func aclResource(principal string, params httprouter.Params) string {
+	topic := params.ByName(UrlParamTopic)
+	if topic == "" {
+		return ""
+	}
+
+	if params.ByName(UrlParamCluster) != "" {
+		// raw kafka topic
+		return topic
+	}
+
+	appid := params.ByName(UrlParamAppid)
+	if appid == "" {
+		appid = principal
+	}
+	return manager.Default.KafkaTopic(appid, topic, params.ByName(UrlParamVersion))
+}
+
+// aclGranted checks if the acl rules allow the request without the ownership checks.
+func aclGranted(r *http.Request) bool {
+	_, present := r.Context().Value(ctxKeyAclRule).(string)
+	return present
+}
+
+// authAdmin checks if the request is from the admin app or granted admin by the acl rules.
+func (this *webServer) authAdmin(r *http.Request) bool {
+	return manager.Default.AuthAdmin(r.Header.Get(HttpHeaderAppid), r.Header.Get(HttpHeaderPubkey)) ||
+		aclGranted(r)
+}
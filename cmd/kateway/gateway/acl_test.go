@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/acl"
+	"github.com/funkygao/gafka/cmd/kateway/auth"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	mandummy "github.com/funkygao/gafka/cmd/kateway/manager/dummy"
+	"github.com/funkygao/httprouter"
+)
+
+type headerAuth struct{}
+
+func (headerAuth) Name() string {
+	return "header"
+}
+
+func (headerAuth) Authenticate(r *http.Request) (string, error) {
+	if appid := r.Header.Get(HttpHeaderAppid); appid != "" {
+		return appid, nil
+	}
+	return "", auth.ErrNoCredential
+}
+
+type rulesAuthorizer struct {
+	rules []acl.Rule
+}
+
+func (this *rulesAuthorizer) Name() string                     { return "rules" }
+func (this *rulesAuthorizer) Start() error                     { return nil }
+func (this *rulesAuthorizer) Stop()                            {}
+func (this *rulesAuthorizer) Rules() ([]acl.Rule, error)       { return this.rules, nil }
+func (this *rulesAuthorizer) Add(r acl.Rule) (acl.Rule, error) { return r, nil }
+func (this *rulesAuthorizer) Remove(id string) error           { return nil }
+func (this *rulesAuthorizer) Check(principal, op, resource, group string) (acl.Decision, *acl.Rule) {
+	return acl.Evaluate(this.rules, principal, op, resource, group)
+}
+
+func TestAclResource(t *testing.T) {
+	manager.Default = mandummy.New("")
+
+	params := httprouter.Params{
+		httprouter.Param{Key: UrlParamTopic, Value: "orders"},
+		httprouter.Param{Key: UrlParamVersion, Value: "v1"},
+	}
+	assert.Equal(t, "app1.orders.v1", aclResource("app1", params))
+
+	params = append(params, httprouter.Param{Key: UrlParamAppid, Value: "app2"})
+	assert.Equal(t, "app2.orders.v1", aclResource("app1", params))
+
+	params = httprouter.Params{
+		httprouter.Param{Key: UrlParamCluster, Value: "me"},
+		httprouter.Param{Key: UrlParamTopic, Value: "raw.topic"},
+	}
+	assert.Equal(t, "raw.topic", aclResource("app1", params))
+
+	assert.Equal(t, "", aclResource("app1", nil))
+}
+
+func TestGuard(t *testing.T) {
+	manager.Default = mandummy.New("")
+	acl.Default = &rulesAuthorizer{rules: []acl.Rule{
+		{Id: "1", Principal: "app2", Resource: "app1.orders.*", Operation: acl.OpSub, Effect: acl.Allow},
+		{Id: "2", Principal: "*", Resource: "app1.orders.v1", Group: "test", Operation: acl.OpSub, Effect: acl.Deny},
+	}}
+	defer func() {
+		acl.Default = nil
+	}()
+
+	ws := &webServer{name: "sub_server", httpAuth: headerAuth{}}
+	var (
+		called, granted bool
+		principal       string
+	)
+	h := ws.guard(acl.OpSub, func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		called, granted = true, aclGranted(r)
+		principal, _ = ws.authenticate(r)
+	})
+	serve := func(appid, group string) int {
+		called, granted, principal = false, false, ""
+		r, _ := http.NewRequest("GET", "/v1/msgs/app1/orders/v1?group="+group, nil)
+		r.Header.Set(HttpHeaderAppid, appid)
+		w := httptest.NewRecorder()
+		h(w, r, httprouter.Params{
+			httprouter.Param{Key: UrlParamAppid, Value: "app1"},
+			httprouter.Param{Key: UrlParamTopic, Value: "orders"},
+			httprouter.Param{Key: UrlParamVersion, Value: "v1"},
+		})
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("app2", "billing"))
+	assert.Equal(t, true, called)
+	assert.Equal(t, true, granted)
+	assert.Equal(t, "app2", principal)
+
+	// deny wins
+	assert.Equal(t, http.StatusForbidden, serve("app2", "test"))
+	assert.Equal(t, false, called)
+
+	// no rule matches, left to the ownership checks
+	assert.Equal(t, http.StatusOK, serve("app3", "billing"))
+	assert.Equal(t, true, called)
+	assert.Equal(t, false, granted)
+
+	// unauthenticated, left to the handler
+	assert.Equal(t, http.StatusOK, serve("", "test"))
+	assert.Equal(t, true, called)
+	assert.Equal(t, false, granted)
+}
@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/funkygao/gafka/cmd/kateway/auth"
+	authjwt "github.com/funkygao/gafka/cmd/kateway/auth/jwt"
+	authkey "github.com/funkygao/gafka/cmd/kateway/auth/key"
+	authmtls "github.com/funkygao/gafka/cmd/kateway/auth/mtls"
+	authstatic "github.com/funkygao/gafka/cmd/kateway/auth/static"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	log "github.com/funkygao/log4go"
+)
+
+// setupAuth builds the auth provider chains of the http and https listeners, e,g. jwt,key
+// The key based providers read the key from the first present of keyHeaders.
+func (this *webServer) setupAuth(httpProviders, httpsProviders string, keyHeaders ...string) {
+	if this.httpServer != nil {
+		this.httpAuth = newAuthProvider(httpProviders, false, keyHeaders)
+		log.Debug("%s http auth: %s", this.name, this.httpAuth.Name())
+	}
+
+	if this.httpsServer != nil {
+		this.httpsAuth = newAuthProvider(httpsProviders, true, keyHeaders)
+		log.Debug("%s https auth: %s", this.name, this.httpsAuth.Name())
+	}
+}
+
+func newAuthProvider(names string, https bool, keyHeaders []string) auth.Provider {
+	if names == "" {
+		names = "key"
+	}
+
+	var providers []auth.Provider
+	for _, name := range strings.Split(names, ",") {
+		var (
+			p   auth.Provider
+			err error
+		)
+		switch strings.TrimSpace(name) {
+		case "key":
+			p = authkey.New(HttpHeaderAppid, keyHeaders...)
+
+		case "static":
+			p, err = authstatic.New(Options.AuthKeysFile, HttpHeaderAppid, keyHeaders...)
+
+		case "jwt":
+			p, err = authjwt.New(Options.JwksFile, Options.JwtIssuer, Options.JwtAudience, Options.JwksReload)
+
+		case "mtls":
+			if !https || Options.ClientCAFile == "" {
+				panic("mtls auth provider requires https listener with client CA")
+			}
+			p, err = authmtls.New(Options.CertMapFile)
+
+		default:
+			panic("invalid auth provider:" + name)
+		}
+
+		if err != nil {
+			panic(err)
+		}
+		providers = append(providers, p)
+	}
+
+	return auth.Chain(providers...)
+}
+
+// authenticate identifies the app calling through the listener a request arrived at.
+// If fails, appid is the claimed one for logging.
+func (this *webServer) authenticate(r *http.Request) (appid string, err error) {
+	if principal, present := r.Context().Value(ctxKeyPrincipal).(string); present {
+		// authenticated by the acl guard
+		return principal, nil
+	}
+
+	p := this.httpAuth
+	if r.TLS != nil {
+		p = this.httpsAuth
+	}
+
+	if appid, err = p.Authenticate(r); err != nil {
+		appid = r.Header.Get(HttpHeaderAppid)
+		if err == auth.ErrNoCredential {
+			err = manager.ErrEmptyIdentity
+		}
+	}
+	return
+}
+
+// ownTopic authenticates the caller of a request and checks if it owns the topic
+// unless granted by the acl rules.
+func (this *webServer) ownTopic(r *http.Request, topic string) (appid string, err error) {
+	if appid, err = this.authenticate(r); err != nil || aclGranted(r) {
+		return
+	}
+
+	err = manager.Default.OwnTopic(appid, topic)
+	return
+}
+
+// authSub authenticates the caller of a request and checks if it is able to consume
+// message from hisAppid.hisTopic unless granted by the acl rules.
+func (this *webServer) authSub(r *http.Request, hisAppid, hisTopic, group string) (myAppid string, err error) {
+	if myAppid, err = this.authenticate(r); err != nil || aclGranted(r) {
+		return
+	}
+
+	err = manager.Default.AuthSub(myAppid, hisAppid, hisTopic, group)
+	return
+}
@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+)
+
+// buryId identifies a source message so that a replayed bury of it is idempotent.
+func buryId(rawTopic string, partition int32, offset int64) string {
+	return fmt.Sprintf("%s/%d/%d", rawTopic, partition, offset)
+}
+
+// buryOnce publishes the message to shadow topic at most once for the same bury id
+// within the dedup window, then skips it in the source topic.
+// A bury replayed after crash or failure between the 2 steps will not publish again, and
+// dedup.ErrInProgress is returned if the same bury is still publishing.
+// d being nil falls back to the non-atomic behavior.
+func buryOnce(d dedup.Deduper, id, shadowTopic string,
+	pub func() (partition int32, offset int64, err error), skip func() error) error {
+	if d != nil {
+		_, _, dup, err := d.Claim(shadowTopic, id)
+		if err != nil {
+			return err
+		}
+
+		if dup {
+			// published by a previous bury, only the skip step is left
+			return skip()
+		}
+	}
+
+	partition, offset, err := pub()
+	if err != nil {
+		if d != nil {
+			d.Release(shadowTopic, id)
+		}
+		return err
+	}
+
+	if d != nil {
+		d.Commit(shadowTopic, id, partition, offset)
+	}
+
+	return skip()
+}
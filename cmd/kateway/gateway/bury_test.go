@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+	dedupmem "github.com/funkygao/gafka/cmd/kateway/dedup/mem"
+)
+
+type buryRecorder struct {
+	pubN, skipN int
+	pubErr      error
+	skipErr     error
+}
+
+func (this *buryRecorder) pub() (int32, int64, error) {
+	if this.pubErr != nil {
+		return 0, 0, this.pubErr
+	}
+
+	this.pubN++
+	return 0, int64(this.pubN), nil
+}
+
+func (this *buryRecorder) skip() error {
+	if this.skipErr != nil {
+		return this.skipErr
+	}
+
+	this.skipN++
+	return nil
+}
+
+func TestBuryId(t *testing.T) {
+	assert.Equal(t, "app1.foobar.v1/2/10", buryId("app1.foobar.v1", 2, 10))
+}
+
+func TestBuryOnceSkipFailure(t *testing.T) {
+	d := dedupmem.New(dedup.FixedWindow(time.Minute), "")
+	r := &buryRecorder{skipErr: errors.New("commit offset fails")}
+	id := buryId("app1.foobar.v1", 0, 1)
+
+	// crash between pub and skip
+	assert.NotEqual(t, nil, buryOnce(d, id, "shadow", r.pub, r.skip))
+	assert.Equal(t, 1, r.pubN)
+	assert.Equal(t, 0, r.skipN)
+
+	// replayed bury only skips
+	r.skipErr = nil
+	assert.Equal(t, nil, buryOnce(d, id, "shadow", r.pub, r.skip))
+	assert.Equal(t, 1, r.pubN)
+	assert.Equal(t, 1, r.skipN)
+
+	// and again
+	assert.Equal(t, nil, buryOnce(d, id, "shadow", r.pub, r.skip))
+	assert.Equal(t, 1, r.pubN)
+	assert.Equal(t, 2, r.skipN)
+
+	// another message
+	assert.Equal(t, nil, buryOnce(d, buryId("app1.foobar.v1", 0, 2), "shadow", r.pub, r.skip))
+	assert.Equal(t, 2, r.pubN)
+}
+
+func TestBuryOncePubFailure(t *testing.T) {
+	d := dedupmem.New(dedup.FixedWindow(time.Minute), "")
+	r := &buryRecorder{pubErr: errors.New("kafka down")}
+	id := buryId("app1.foobar.v1", 0, 1)
+
+	assert.NotEqual(t, nil, buryOnce(d, id, "shadow", r.pub, r.skip))
+	assert.Equal(t, 0, r.pubN)
+	assert.Equal(t, 0, r.skipN)
+
+	// the claim is released, so the replay publishes
+	r.pubErr = nil
+	assert.Equal(t, nil, buryOnce(d, id, "shadow", r.pub, r.skip))
+	assert.Equal(t, 1, r.pubN)
+	assert.Equal(t, 1, r.skipN)
+}
+
+func TestBuryOnceInProgress(t *testing.T) {
+	d := dedupmem.New(dedup.FixedWindow(time.Minute), "")
+	r := &buryRecorder{}
+	id := buryId("app1.foobar.v1", 0, 1)
+
+	// crash after claim before pub returns
+	d.Claim("shadow", id)
+	assert.Equal(t, dedup.ErrInProgress, buryOnce(d, id, "shadow", r.pub, r.skip))
+	assert.Equal(t, 0, r.pubN)
+	assert.Equal(t, 0, r.skipN)
+}
+
+func TestBuryOnceWithoutDedup(t *testing.T) {
+	r := &buryRecorder{}
+	id := buryId("app1.foobar.v1", 0, 1)
+	assert.Equal(t, nil, buryOnce(nil, id, "shadow", r.pub, r.skip))
+	assert.Equal(t, nil, buryOnce(nil, id, "shadow", r.pub, r.skip))
+	assert.Equal(t, 2, r.pubN)
+	assert.Equal(t, 2, r.skipN)
+}
+
+func TestBuryOnceReplayAfterCrash(t *testing.T) {
+	fn, jnl := "burydedup.dmp", "burydedup.jnl"
+	defer os.Remove(fn)
+	defer os.Remove(jnl)
+
+	d := dedupmem.New(dedup.FixedWindow(time.Minute), fn).WithJournal(jnl)
+	assert.Equal(t, nil, d.Init())
+	r := &buryRecorder{skipErr: errors.New("crashed")}
+	id := buryId("app1.foobar.v1", 0, 1)
+
+	// kateway crashes between pub and skip without a clean Stop
+	assert.NotEqual(t, nil, buryOnce(d, id, "shadow", r.pub, r.skip))
+	assert.Equal(t, 1, r.pubN)
+
+	// the restarted kateway recovers the bury from the journal and only skips
+	restarted := dedupmem.New(dedup.FixedWindow(time.Minute), fn).WithJournal(jnl)
+	assert.Equal(t, nil, restarted.Init())
+	r.skipErr = nil
+	assert.Equal(t, nil, buryOnce(restarted, id, "shadow", r.pub, r.skip))
+	assert.Equal(t, 1, r.pubN)
+	assert.Equal(t, 1, r.skipN)
+	restarted.Stop()
+	d.Stop()
+}
@@ -2,6 +2,7 @@ package gateway
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"io"
 )
 
@@ -58,3 +59,131 @@ func DecodeMessageSet(messageSet []byte) []Message {
 	}
 
 }
+
+// PubResult is the Pub result of a single message.
+type PubResult struct {
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+	Errmsg    string `json:"errmsg,omitempty"`
+}
+
+// BatchMessage is a keyed and tagged message of a batch Pub.
+type BatchMessage struct {
+	Key   []byte
+	Tag   string
+	Value []byte
+}
+
+// EncodeBatchMessages writes a batch of messages in the framed format.
+// BatchMessageSet => [KeyLen(int16) Key TagLen(int16) Tag ValueLen(int32) Value] BigEndian
+func EncodeBatchMessages(writer io.Writer, msgs []BatchMessage) error {
+	buf := make([]byte, 4)
+	for _, m := range msgs {
+		if err := writeI16(writer, buf, int16(len(m.Key))); err != nil {
+			return err
+		}
+		if _, err := writer.Write(m.Key); err != nil {
+			return err
+		}
+		if err := writeI16(writer, buf, int16(len(m.Tag))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(writer, m.Tag); err != nil {
+			return err
+		}
+		if err := writeI32(writer, buf, int32(len(m.Value))); err != nil {
+			return err
+		}
+		if _, err := writer.Write(m.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DecodeBatchMessages is the reverse of EncodeBatchMessages.
+// The decoded messages share the underlying bytes of messageSet.
+func DecodeBatchMessages(messageSet []byte) ([]BatchMessage, error) {
+	var (
+		r   []BatchMessage
+		idx int
+	)
+
+	for idx < len(messageSet) {
+		m := BatchMessage{}
+
+		if idx+2 > len(messageSet) {
+			return nil, ErrIllegalBatchMessage
+		}
+		keyLen := int(binary.BigEndian.Uint16(messageSet[idx : idx+2]))
+		idx += 2
+		if idx+keyLen > len(messageSet) {
+			return nil, ErrIllegalBatchMessage
+		}
+		m.Key = messageSet[idx : idx+keyLen]
+		idx += keyLen
+
+		if idx+2 > len(messageSet) {
+			return nil, ErrIllegalBatchMessage
+		}
+		tagLen := int(binary.BigEndian.Uint16(messageSet[idx : idx+2]))
+		idx += 2
+		if idx+tagLen > len(messageSet) {
+			return nil, ErrIllegalBatchMessage
+		}
+		m.Tag = string(messageSet[idx : idx+tagLen])
+		idx += tagLen
+
+		if idx+4 > len(messageSet) {
+			return nil, ErrIllegalBatchMessage
+		}
+		valueLen := int(binary.BigEndian.Uint32(messageSet[idx : idx+4]))
+		idx += 4
+		if valueLen < 0 || idx+valueLen > len(messageSet) {
+			return nil, ErrIllegalBatchMessage
+		}
+		m.Value = messageSet[idx : idx+valueLen]
+		idx += valueLen
+
+		r = append(r, m)
+	}
+
+	return r, nil
+}
+
+// decodeJsonBatchMessages decodes json array of {"key":"k","tag":"a;b","value":xxx}.
+// If value is a json string, the unquoted string is the message, otherwise
+// the raw json value itself is the message.
+func decodeJsonBatchMessages(b []byte) ([]BatchMessage, error) {
+	var items []struct {
+		Key   string          `json:"key"`
+		Tag   string          `json:"tag"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, err
+	}
+
+	r := make([]BatchMessage, 0, len(items))
+	for _, item := range items {
+		m := BatchMessage{
+			Key: []byte(item.Key),
+			Tag: item.Tag,
+		}
+
+		if len(item.Value) > 0 && item.Value[0] == '"' {
+			var s string
+			if err := json.Unmarshal(item.Value, &s); err != nil {
+				return nil, err
+			}
+			m.Value = []byte(s)
+		} else {
+			m.Value = item.Value
+		}
+
+		r = append(r, m)
+	}
+
+	return r, nil
+}
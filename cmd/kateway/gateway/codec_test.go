@@ -54,3 +54,40 @@ func TestDecodeMessageSetMultiMessage(t *testing.T) {
 	assert.Equal(t, offset2, msgSet[1].Offset)
 	assert.Equal(t, msg2, msgSet[1].Value)
 }
+
+func TestEncodeAndDecodeBatchMessages(t *testing.T) {
+	msgs := []BatchMessage{
+		{Key: []byte("k1"), Tag: "a=b;c=d", Value: []byte("hello world")},
+		{Key: nil, Tag: "", Value: []byte("good morning")},
+	}
+
+	w := bytes.NewBuffer(make([]byte, 0))
+	assert.Equal(t, nil, EncodeBatchMessages(w, msgs))
+
+	decoded, err := DecodeBatchMessages(w.Bytes())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(decoded))
+	assert.Equal(t, "k1", string(decoded[0].Key))
+	assert.Equal(t, "a=b;c=d", decoded[0].Tag)
+	assert.Equal(t, "hello world", string(decoded[0].Value))
+	assert.Equal(t, 0, len(decoded[1].Key))
+	assert.Equal(t, "", decoded[1].Tag)
+	assert.Equal(t, "good morning", string(decoded[1].Value))
+
+	// truncated message set
+	_, err = DecodeBatchMessages(w.Bytes()[:w.Len()-1])
+	assert.Equal(t, ErrIllegalBatchMessage, err)
+}
+
+func TestDecodeJsonBatchMessages(t *testing.T) {
+	msgs, err := decodeJsonBatchMessages([]byte(`[{"key":"k1","tag":"a","value":"hello"},{"value":{"uid":1}}]`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, "k1", string(msgs[0].Key))
+	assert.Equal(t, "a", msgs[0].Tag)
+	assert.Equal(t, "hello", string(msgs[0].Value))
+	assert.Equal(t, `{"uid":1}`, string(msgs[1].Value))
+
+	_, err = decodeJsonBatchMessages([]byte(`{}`))
+	assert.NotEqual(t, nil, err)
+}
@@ -1,5 +1,9 @@
 package gateway
 
+import (
+	"time"
+)
+
 const (
 	HttpHeaderXForwardedFor   = "X-Forwarded-For"
 	HttpHeaderPartition       = "X-Partition"
@@ -7,17 +11,34 @@ const (
 	HttpHeaderMsgBury         = "X-Bury"
 	HttpHeaderMsgKey          = "X-Key"
 	HttpHeaderMsgTag          = "X-Tag"
+	HttpHeaderMsgId           = "X-Msg-Id"
+	HttpHeaderContentType     = "X-Content-Type" // content type of the message, not the http body
+	HttpHeaderEnvelope        = "X-Envelope"     // 1 to publish the message in envelope even without tag or msg id
+	HttpHeaderPubTime         = "X-Pub-Time"
+	HttpHeaderDeliveries      = "X-Deliveries"
+	HttpHeaderRetries         = "X-Retries"
 	HttpHeaderJobId           = "X-Job-Id"
+	HttpHeaderXaId            = "X-Xa-Id"
 	HttpHeaderAcceptEncoding  = "Accept-Encoding"
 	HttpHeaderContentEncoding = "Content-Encoding"
+	HttpHeaderSignature       = "X-Signature" // hmac of webhook delivery
+	HttpHeaderTimestamp       = "X-Timestamp"
+	HttpHeaderSchemaVersion   = "X-Schema-Version"
+	HttpHeaderSchemaFormat    = "X-Schema-Format"
+	HttpHeaderRetryAfter      = "Retry-After"
+	HttpHeaderEndpoint        = "X-Endpoint" // webhook endpoint of a dead letter
 	HttpEncodingGzip          = "gzip"
 
 	UrlParamTopic   = "topic"
 	UrlParamVersion = "ver"
 	UrlParamAppid   = "appid"
 	UrlParamGroup   = "group"
+	UrlParamCluster = "cluster"
 
 	MaxPartitionKeyLen = 256
+	MaxMsgIdLen        = 256
+
+	MaxVisibilityTimeout = 12 * time.Hour
 )
 
 var (
@@ -0,0 +1,203 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/funkygao/gafka/mpool"
+)
+
+const (
+	EnvelopeVersion = byte(1)
+
+	// well known envelope header keys
+	HeaderTag         = "tag"
+	HeaderMsgId       = "id"
+	HeaderContentType = "ct"
+	HeaderPubTime     = "ts"      // unix timestamp in ms
+	HeaderRetries     = "retries" // retry attempt of a message buried to retry shadow topic
+	HeaderRetryReady  = "ready"   // the retry attempt is due and ready for the subscriber
+	HeaderEndpoint    = "ep"      // the webhook endpoint that failed to receive a dead letter
+
+	MaxContentTypeLen = 128
+)
+
+// envelopeMagic starts with 0x00 which is neither a valid ProtocolBuffer field
+// key nor a printable char, so it will not collide with the payload.
+var envelopeMagic = []byte{0x00, 0xFE, 'K', 'W'}
+
+// MessageHeaders is the header map carried by a message envelope.
+type MessageHeaders map[string]string
+
+// NewMessageHeaders builds the envelope headers of a message to be published.
+// Returns nil unless there is a tag or msg id to carry or envelope is explicitly asked,
+// and the message will be published as is. Content type alone does not envelope a message,
+// so that untagged messages stay raw for the consumers reading kafka directly.
+func NewMessageHeaders(tag, msgId, contentType string, envelope bool) MessageHeaders {
+	if tag == "" && msgId == "" && !envelope {
+		return nil
+	}
+
+	h := MessageHeaders{
+		HeaderPubTime: strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10),
+	}
+	if tag != "" {
+		h[HeaderTag] = tag
+	}
+	if msgId != "" {
+		h[HeaderMsgId] = msgId
+	}
+	if contentType != "" {
+		h[HeaderContentType] = contentType
+	}
+	return h
+}
+
+// Tags returns the message tags, nil if untagged.
+func (this MessageHeaders) Tags() []string {
+	if tag := this[HeaderTag]; tag != "" {
+		return parseMessageTag(tag)
+	}
+
+	return nil
+}
+
+// PubTime returns the publish timestamp in ms, 0 if unknown.
+func (this MessageHeaders) PubTime() int64 {
+	ts, _ := strconv.ParseInt(this[HeaderPubTime], 10, 64)
+	return ts
+}
+
+// Retries returns the retry attempt of the message, 0 if never retried.
+func (this MessageHeaders) Retries() int {
+	n, _ := strconv.Atoi(this[HeaderRetries])
+	return n
+}
+
+// RetryScheduled checks if the message is buried to retry shadow topic with a retry
+// policy and still awaiting its retry delay.
+func (this MessageHeaders) RetryScheduled() bool {
+	return this[HeaderRetries] != "" && this[HeaderRetryReady] == ""
+}
+
+// WriteHttpHeader copies the well known headers to http headers for subscribers.
+func (this MessageHeaders) WriteHttpHeader(h http.Header) {
+	for k, v := range this {
+		switch k {
+		case HeaderTag:
+			h.Set(HttpHeaderMsgTag, v)
+		case HeaderMsgId:
+			h.Set(HttpHeaderMsgId, v)
+		case HeaderContentType:
+			h.Set(HttpHeaderContentType, v)
+		case HeaderPubTime:
+			h.Set(HttpHeaderPubTime, v)
+		case HeaderRetries:
+			h.Set(HttpHeaderRetries, v)
+		case HeaderEndpoint:
+			h.Set(HttpHeaderEndpoint, v)
+		}
+	}
+}
+
+// envelopeLen returns how many bytes the envelope takes before the message body.
+func envelopeLen(h MessageHeaders) int {
+	if len(h) == 0 {
+		return 0
+	}
+
+	n := len(envelopeMagic) + 2 // magic version count
+	for k, v := range h {
+		n += 1 + len(k) + 2 + len(v)
+	}
+	return n
+}
+
+// ┌─────┬───────┬─────┬──────────────────────────────────────────┐ ┌────────┐
+// │Magic│Version│Count│[KeyLen(int8) Key ValueLen(int16) Value]  │ │Message │
+// └─────┴───────┴─────┴──────────────────────────────────────────┘ └────────┘
+// AddEnvelopeToMessage shifts the message body and writes the envelope in front of it.
+// m.Body must have envelopeLen(h) bytes reserved at the tail.
+// Header keys must be shorter than 256 bytes and values shorter than 64KB.
+func AddEnvelopeToMessage(m *mpool.Message, h MessageHeaders) {
+	shift := envelopeLen(h)
+	if shift == 0 {
+		return
+	}
+
+	for i := len(m.Body) - 1; i >= shift; i-- {
+		m.Body[i] = m.Body[i-shift]
+	}
+
+	i := copy(m.Body, envelopeMagic)
+	m.Body[i] = EnvelopeVersion
+	i++
+	m.Body[i] = byte(len(h))
+	i++
+	for k, v := range h {
+		m.Body[i] = byte(len(k))
+		i++
+		i += copy(m.Body[i:], k)
+		binary.BigEndian.PutUint16(m.Body[i:], uint16(len(v)))
+		i += 2
+		i += copy(m.Body[i:], v)
+	}
+}
+
+// EncodeMessage returns the message with envelope carrying the headers.
+func EncodeMessage(h MessageHeaders, body []byte) []byte {
+	n := envelopeLen(h)
+	m := &mpool.Message{Body: make([]byte, n+len(body))}
+	copy(m.Body, body)
+	AddEnvelopeToMessage(m, h)
+	return m.Body
+}
+
+// DecodeMessage decodes the envelope of a message stored in kafka.
+// bodyIdx is where the message body starts.
+// Legacy tagged messages have their tags decoded as HeaderTag, untagged messages
+// get nil headers.
+func DecodeMessage(msg []byte) (h MessageHeaders, bodyIdx int, err error) {
+	if !bytes.HasPrefix(msg, envelopeMagic) {
+		if tag, idx := decodeLegacyTag(msg); idx > 0 {
+			return MessageHeaders{HeaderTag: tag}, idx, nil
+		}
+
+		return nil, 0, nil
+	}
+
+	i := len(envelopeMagic)
+	if len(msg) < i+2 || msg[i] != EnvelopeVersion {
+		return nil, 0, ErrIllegalEnvelope
+	}
+	i++
+
+	count := int(msg[i])
+	i++
+	h = make(MessageHeaders, count)
+	for j := 0; j < count; j++ {
+		if i+1 > len(msg) {
+			return nil, 0, ErrIllegalEnvelope
+		}
+		keyLen := int(msg[i])
+		i++
+		if i+keyLen+2 > len(msg) {
+			return nil, 0, ErrIllegalEnvelope
+		}
+		k := string(msg[i : i+keyLen])
+		i += keyLen
+
+		valueLen := int(binary.BigEndian.Uint16(msg[i:]))
+		i += 2
+		if i+valueLen > len(msg) {
+			return nil, 0, ErrIllegalEnvelope
+		}
+		h[k] = string(msg[i : i+valueLen])
+		i += valueLen
+	}
+
+	return h, i, nil
+}
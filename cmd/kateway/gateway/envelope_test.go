@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/mpool"
+)
+
+func newEnvelopedMessage(body string, h MessageHeaders) *mpool.Message {
+	msgSz := envelopeLen(h) + len(body)
+	m := mpool.NewMessage(msgSz)
+	m.Body = m.Body[:msgSz]
+	copy(m.Body, body)
+	AddEnvelopeToMessage(m, h)
+	return m
+}
+
+func TestNewMessageHeaders(t *testing.T) {
+	assert.Equal(t, 0, len(NewMessageHeaders("", "", "", false)))
+	assert.Equal(t, 0, envelopeLen(nil))
+
+	// content type alone keeps the message raw
+	assert.Equal(t, 0, len(NewMessageHeaders("", "", "application/x-www-form-urlencoded", false)))
+
+	h := NewMessageHeaders("a=b", "", "application/json", false)
+	assert.Equal(t, 3, len(h))
+	assert.Equal(t, "a=b", h[HeaderTag])
+	assert.Equal(t, "application/json", h[HeaderContentType])
+	assert.NotEqual(t, "", h[HeaderPubTime])
+
+	h = NewMessageHeaders("", "", "application/json", true)
+	assert.Equal(t, 2, len(h))
+	assert.Equal(t, "application/json", h[HeaderContentType])
+}
+
+func TestAddAndDecodeEnvelope(t *testing.T) {
+	body := "hello world"
+	h := MessageHeaders{
+		HeaderTag:         "a=b;c=d",
+		HeaderMsgId:       "123",
+		HeaderContentType: "text/plain",
+		HeaderPubTime:     "1476781200000",
+	}
+	m := newEnvelopedMessage(body, h)
+	defer m.Free()
+
+	h1, i, err := DecodeMessage(m.Body)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, body, string(m.Body[i:]))
+	assert.Equal(t, h, h1)
+	assert.Equal(t, []string{"a=b", "c=d"}, h1.Tags())
+
+	// truncated envelope
+	for n := len(envelopeMagic); n < i; n++ {
+		_, _, err = DecodeMessage(m.Body[:n])
+		assert.Equal(t, ErrIllegalEnvelope, err)
+	}
+
+	// unknown version
+	m.Body[len(envelopeMagic)] = EnvelopeVersion + 1
+	_, _, err = DecodeMessage(m.Body)
+	assert.Equal(t, ErrIllegalEnvelope, err)
+}
+
+func TestDecodeMessageLegacy(t *testing.T) {
+	msg := []byte("\x01a;b\x02hello world")
+	h, i, err := DecodeMessage(msg)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"a", "b"}, h.Tags())
+	assert.Equal(t, "hello world", string(msg[i:]))
+
+	// untagged
+	for _, body := range []string{"hello world", "\x01\x08\x96\x01", ""} {
+		h, i, err = DecodeMessage([]byte(body))
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 0, len(h))
+		assert.Equal(t, 0, i)
+	}
+}
+
+func TestMessageHeadersWriteHttpHeader(t *testing.T) {
+	h := http.Header{}
+	MessageHeaders{HeaderTag: "a", HeaderPubTime: "1", "unknown": "x"}.WriteHttpHeader(h)
+	assert.Equal(t, 2, len(h))
+	assert.Equal(t, "a", h.Get(HttpHeaderMsgTag))
+	assert.Equal(t, "1", h.Get(HttpHeaderPubTime))
+}
+
+func BenchmarkAddEnvelopeToMessage(b *testing.B) {
+	b.ReportAllocs()
+	h := NewMessageHeaders("a;c", "", "", false)
+	body := strings.Repeat("X", 900)
+	m := mpool.NewMessage(envelopeLen(h) + 900)
+	for i := 0; i < b.N; i++ {
+		m.Body = m.Body[:envelopeLen(h)+900]
+		copy(m.Body, body)
+		AddEnvelopeToMessage(m, h)
+	}
+	b.SetBytes(int64(len(m.Body)))
+}
+
+func BenchmarkDecodeMessage(b *testing.B) {
+	b.ReportAllocs()
+	m := newEnvelopedMessage(strings.Repeat("X", 900), NewMessageHeaders("a;c", "", "", false))
+	for i := 0; i < b.N; i++ {
+		DecodeMessage(m.Body)
+	}
+	b.SetBytes(int64(len(m.Body)))
+}
+
+func TestEncodeMessageWithRetries(t *testing.T) {
+	h := MessageHeaders{HeaderRetries: "2"}
+	msg := EncodeMessage(h, []byte("hello"))
+	h1, bodyIdx, err := DecodeMessage(msg)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hello", string(msg[bodyIdx:]))
+	assert.Equal(t, 2, h1.Retries())
+	assert.Equal(t, true, h1.RetryScheduled())
+
+	h1[HeaderRetryReady] = "1"
+	assert.Equal(t, false, h1.RetryScheduled())
+
+	var untagged MessageHeaders
+	assert.Equal(t, 0, untagged.Retries())
+	assert.Equal(t, false, untagged.RetryScheduled())
+}
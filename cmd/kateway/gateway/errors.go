@@ -5,12 +5,14 @@ import (
 )
 
 var (
-	ErrClientGone           = errors.New("remote client gone")
-	ErrTooBigMessage        = errors.New("too big message")
-	ErrTooSmallMessage      = errors.New("too small message")
-	ErrIllegalTaggedMessage = errors.New("illegal tagged message")
-	ErrClientKilled         = errors.New("client killed")
-	ErrBadResponseWriter    = errors.New("ResponseWriter Close not supported")
-	ErrPartitionOutOfRange  = errors.New("partition out of range")
-	ErrOffsetOutOfRange     = errors.New("offset out of range")
+	ErrClientGone          = errors.New("remote client gone")
+	ErrTooBigMessage       = errors.New("too big message")
+	ErrTooSmallMessage     = errors.New("too small message")
+	ErrIllegalEnvelope     = errors.New("illegal message envelope")
+	ErrIllegalBatchMessage = errors.New("illegal batch message")
+	ErrIllegalTagFilter    = errors.New("illegal tag filter")
+	ErrClientKilled        = errors.New("client killed")
+	ErrBadResponseWriter   = errors.New("ResponseWriter Close not supported")
+	ErrPartitionOutOfRange = errors.New("partition out of range")
+	ErrOffsetOutOfRange    = errors.New("offset out of range")
 )
@@ -15,10 +15,19 @@ import (
 
 	"github.com/funkygao/fae/config"
 	"github.com/funkygao/gafka"
+	"github.com/funkygao/gafka/cmd/kateway/acl"
+	"github.com/funkygao/gafka/cmd/kateway/acl/zkacl"
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
+	dedupmem "github.com/funkygao/gafka/cmd/kateway/dedup/mem"
+	dedupmysql "github.com/funkygao/gafka/cmd/kateway/dedup/mysql"
 	"github.com/funkygao/gafka/cmd/kateway/hh"
 	hhdisk "github.com/funkygao/gafka/cmd/kateway/hh/disk"
 	hhdummy "github.com/funkygao/gafka/cmd/kateway/hh/dummy"
+	"github.com/funkygao/gafka/cmd/kateway/inflight"
+	inflightmem "github.com/funkygao/gafka/cmd/kateway/inflight/mem"
+	inflightmysql "github.com/funkygao/gafka/cmd/kateway/inflight/mysql"
 	"github.com/funkygao/gafka/cmd/kateway/job"
+	jobdisk "github.com/funkygao/gafka/cmd/kateway/job/disk"
 	jobdummy "github.com/funkygao/gafka/cmd/kateway/job/dummy"
 	jobmysql "github.com/funkygao/gafka/cmd/kateway/job/mysql"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
@@ -27,6 +36,11 @@ import (
 	manopen "github.com/funkygao/gafka/cmd/kateway/manager/open"
 	"github.com/funkygao/gafka/cmd/kateway/meta"
 	"github.com/funkygao/gafka/cmd/kateway/meta/zkmeta"
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+	quotacluster "github.com/funkygao/gafka/cmd/kateway/quota/cluster"
+	quotalocal "github.com/funkygao/gafka/cmd/kateway/quota/local"
+	"github.com/funkygao/gafka/cmd/kateway/schema"
+	"github.com/funkygao/gafka/cmd/kateway/schema/zkschema"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	storedummy "github.com/funkygao/gafka/cmd/kateway/store/dummy"
 	storekfk "github.com/funkygao/gafka/cmd/kateway/store/kafka"
@@ -49,14 +63,17 @@ type Gateway struct {
 
 	zkzone       *gzk.ZkZone // load/resume/flush counter metrics to zk
 	svrMetrics   *serverMetrics
+	quotaMetrics *quotaMetrics
 	accessLogger *AccessLogger
+	aclAuditor   log.Logger // denials of the acl rules
 
 	shutdownOnce        sync.Once
 	shutdownCh, quiting chan struct{}
 	wg                  sync.WaitGroup
 
-	certFile string
-	keyFile  string
+	certFile     string
+	keyFile      string
+	clientCAFile string
 
 	pubServer *pubServer
 	subServer *subServer
@@ -66,11 +83,12 @@ type Gateway struct {
 
 func New(id string) *Gateway {
 	this := &Gateway{
-		id:         id,
-		shutdownCh: make(chan struct{}),
-		quiting:    make(chan struct{}),
-		certFile:   Options.CertFile,
-		keyFile:    Options.KeyFile,
+		id:           id,
+		shutdownCh:   make(chan struct{}),
+		quiting:      make(chan struct{}),
+		certFile:     Options.CertFile,
+		keyFile:      Options.KeyFile,
+		clientCAFile: Options.ClientCAFile,
 	}
 
 	this.zkzone = gzk.NewZkZone(gzk.DefaultConfig(Options.Zone, ctx.ZoneZkAddrs(Options.Zone)))
@@ -84,8 +102,29 @@ func New(id string) *Gateway {
 	metaConf := zkmeta.DefaultConfig()
 	metaConf.Refresh = Options.MetaRefresh
 	meta.Default = zkmeta.New(metaConf, this.zkzone)
+	schema.Default = zkschema.New(this.zkzone, Options.SchemaRefresh)
+	if Options.EnableAcl {
+		acl.Default = zkacl.New(this.zkzone, Options.AclRefresh)
+		this.aclAuditor = newAclAuditor()
+	}
+	switch Options.QuotaStore {
+	case "local":
+		quota.Default = quotalocal.New()
+
+	case "cluster":
+		if Options.ManHttpAddr == "" {
+			panic("cluster quota limiter gossips through the man server")
+		}
+		quota.Default = quotacluster.New(this.id, this.zkzone, Options.QuotaGossip)
+
+	case "none":
+
+	default:
+		panic("invalid quota store")
+	}
 	this.accessLogger = NewAccessLogger("access_log", 100)
 	this.svrMetrics = NewServerMetrics(Options.ReporterInterval, this)
+	this.quotaMetrics = newQuotaMetrics()
 	rc, err := influxdb.NewConfig(Options.InfluxServer, Options.InfluxDbName, "", "", Options.ReporterInterval)
 	if err != nil {
 		log.Error("telemetry: %v", err)
@@ -160,6 +199,14 @@ func New(id string) *Gateway {
 
 			job.Default = jm
 
+		case "disk":
+			jd, err := jobdisk.New(id, Options.JobStoreDir)
+			if err != nil {
+				panic(fmt.Errorf("disk job: %v", err))
+			}
+
+			job.Default = jd
+
 		case "dummy":
 			job.Default = jobdummy.New()
 
@@ -191,6 +238,36 @@ func New(id string) *Gateway {
 			panic("unknown hinted handoff type")
 		}
 
+		dedupWindows, err := dedup.ParseWindows(Options.PubDedupWindow, Options.PubDedupWindows)
+		if err != nil {
+			panic(err)
+		}
+		if dedupWindows.Enabled() {
+			switch Options.PubDedupStore {
+			case "mysql":
+				// shared by all kateways so that a retry routed to another kateway is deduped
+				var mcc = &config.ConfigMysql{}
+				b, err := this.zkzone.KatewayJobClusterConfig()
+				if err != nil {
+					panic(err)
+				}
+				if err = mcc.From(b); err != nil {
+					panic(err)
+				}
+				if dedup.Default, err = dedupmysql.New(mcc, dedupWindows); err != nil {
+					panic(fmt.Errorf("mysql pub dedup: %v", err))
+				}
+
+			case "mem":
+				dedup.Default = dedupmem.New(dedupWindows, Options.DedupSnapshot)
+
+			case "none":
+
+			default:
+				panic("invalid pub dedup store")
+			}
+		}
+
 		if Options.FlushHintedOffOnly {
 			meta.Default.Start()
 			log.Trace("meta store[%s] started", meta.Default.Name())
@@ -200,7 +277,17 @@ func New(id string) *Gateway {
 			}
 			log.Trace("pub store[%s] started", store.DefaultPubStore.Name())
 
+			if dedup.Default != nil {
+				if err = dedup.Default.Init(); err != nil {
+					panic(err)
+				}
+			}
+
 			hh.Default.FlushInflights()
+
+			if dedup.Default != nil {
+				dedup.Default.Stop()
+			}
 			os.Exit(0)
 		}
 	}
@@ -219,6 +306,53 @@ func New(id string) *Gateway {
 			panic("invalid store")
 
 		}
+
+		switch Options.InflightStore {
+		case "mysql":
+			var mcc = &config.ConfigMysql{}
+			b, err := this.zkzone.KatewayJobClusterConfig()
+			if err != nil {
+				panic(err)
+			}
+			if err = mcc.From(b); err != nil {
+				panic(err)
+			}
+			if inflight.Default, err = inflightmysql.New(mcc); err != nil {
+				panic(fmt.Errorf("mysql inflight: %v", err))
+			}
+
+		case "mem":
+			inflight.Default = inflightmem.New(Options.InflightSnapshot)
+
+		case "none":
+
+		default:
+			panic("invalid inflight store")
+		}
+
+		switch Options.BuryDedupStore {
+		case "mysql":
+			var mcc = &config.ConfigMysql{}
+			b, err := this.zkzone.KatewayJobClusterConfig()
+			if err != nil {
+				panic(err)
+			}
+			if err = mcc.From(b); err != nil {
+				panic(err)
+			}
+			if this.subServer.buryDedup, err = dedupmysql.New(mcc, dedup.FixedWindow(Options.BuryDedupWindow)); err != nil {
+				panic(fmt.Errorf("mysql bury dedup: %v", err))
+			}
+
+		case "mem":
+			this.subServer.buryDedup = dedupmem.New(dedup.FixedWindow(Options.BuryDedupWindow),
+				Options.BuryDedupSnapshot).WithJournal(Options.BuryDedupJournal)
+
+		case "none":
+
+		default:
+			panic("invalid bury dedup store")
+		}
 	}
 
 	return this
@@ -270,6 +404,25 @@ func (this *Gateway) Start() (err error) {
 	}
 	log.Trace("manager store[%s] started", manager.Default.Name())
 
+	if err = schema.Default.Start(); err != nil {
+		return
+	}
+	log.Trace("schema registry[%s] started", schema.Default.Name())
+
+	if acl.Default != nil {
+		if err = acl.Default.Start(); err != nil {
+			return
+		}
+		log.Trace("acl authorizer[%s] started", acl.Default.Name())
+	}
+
+	if quota.Default != nil {
+		if err = quota.Default.Start(); err != nil {
+			return
+		}
+		log.Trace("quota limiter[%s] started", quota.Default.Name())
+	}
+
 	if telemetry.Default != nil {
 		go func() {
 			log.Trace("telemetry[%s] started", telemetry.Default.Name())
@@ -299,6 +452,14 @@ func (this *Gateway) Start() (err error) {
 		}
 		log.Trace("pub store[%s] started", store.DefaultPubStore.Name())
 
+		// hh delivery depends on dedup
+		if dedup.Default != nil {
+			if err = dedup.Default.Init(); err != nil {
+				panic(err)
+			}
+			log.Trace("pub dedup started")
+		}
+
 		if err = hh.Default.Start(); err != nil {
 			return
 		}
@@ -317,6 +478,20 @@ func (this *Gateway) Start() (err error) {
 		}
 		log.Trace("sub store[%s] started", store.DefaultSubStore.Name())
 
+		if inflight.Default != nil {
+			if err = inflight.Default.Init(); err != nil {
+				panic(err)
+			}
+			log.Trace("inflight store started")
+		}
+
+		if this.subServer.buryDedup != nil {
+			if err = this.subServer.buryDedup.Init(); err != nil {
+				panic(err)
+			}
+			log.Trace("bury dedup started")
+		}
+
 		this.subServer.Start()
 	}
 
@@ -368,6 +543,14 @@ func (this *Gateway) ServeForever() {
 			hh.Default.Stop()
 		}
 
+		if dedup.Default != nil {
+			if err := dedup.Default.Stop(); err != nil {
+				log.Error("pub dedup: %v", err)
+			} else {
+				log.Trace("pub dedup stopped")
+			}
+		}
+
 		if Options.EnableAccessLog {
 			log.Trace("stopping access logger")
 			this.accessLogger.Stop()
@@ -383,6 +566,20 @@ func (this *Gateway) ServeForever() {
 			log.Trace("sub store[%s] stop...", store.DefaultSubStore.Name())
 			store.DefaultSubStore.Stop()
 		}
+		if inflight.Default != nil {
+			if err := inflight.Default.Stop(); err != nil {
+				log.Error("inflight store: %v", err)
+			} else {
+				log.Trace("inflight store stopped")
+			}
+		}
+		if this.subServer != nil && this.subServer.buryDedup != nil {
+			if err := this.subServer.buryDedup.Stop(); err != nil {
+				log.Error("bury dedup: %v", err)
+			} else {
+				log.Trace("bury dedup stopped")
+			}
+		}
 		if job.Default != nil {
 			job.Default.Stop()
 			log.Trace("job store[%s] stopped", job.Default.Name())
@@ -400,6 +597,19 @@ func (this *Gateway) ServeForever() {
 			log.Trace("telemetry[%s] stopped", telemetry.Default.Name())
 		}
 
+		schema.Default.Stop()
+		log.Trace("schema registry[%s] stopped", schema.Default.Name())
+
+		if acl.Default != nil {
+			acl.Default.Stop()
+			log.Trace("acl authorizer[%s] stopped", acl.Default.Name())
+		}
+
+		if quota.Default != nil {
+			quota.Default.Stop()
+			log.Trace("quota limiter[%s] stopped", quota.Default.Name())
+		}
+
 		meta.Default.Stop()
 		log.Trace("meta store[%s] stopped", meta.Default.Name())
 
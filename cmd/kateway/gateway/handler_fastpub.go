@@ -23,7 +23,7 @@ func (this *Gateway) pubHandler(ctx *fasthttp.RequestCtx, params fasthttprouter.
 	header := ctx.Request.Header
 	appid := string(header.Peek(HttpHeaderAppid))
 	pubkey := string(header.Peek(HttpHeaderPubkey))
-	if err := manager.Default.OwnTopic(appid, pubkey, topic); err != nil {
+	if err := fastOwnTopic(appid, pubkey, topic); err != nil {
 		log.Error("app[%s] %s %+v: %v", appid, ctx.RemoteAddr(), params, err)
 
 		ctx.SetConnectionClose()
@@ -118,7 +118,7 @@ func (this *Gateway) pubRawHandler(ctx *fasthttp.RequestCtx, params fasthttprout
 	appid = string(header.Peek(HttpHeaderAppid))
 	pubkey = string(header.Peek(HttpHeaderPubkey))
 
-	if err := manager.Default.OwnTopic(appid, pubkey, topic); err != nil {
+	if err := fastOwnTopic(appid, pubkey, topic); err != nil {
 		log.Error("app[%s] %s %+v: %v", appid, ctx.RemoteAddr(), params, err)
 
 		ctx.SetConnectionClose()
@@ -153,3 +153,12 @@ func (this *Gateway) pubWsHandler(ctx *fasthttp.RequestCtx, params fasthttproute
 func (this *Gateway) pubCheckHandler(ctx *fasthttp.RequestCtx, params fasthttprouter.Params) {
 	ctx.Write(ResponseOk)
 }
+
+// fastOwnTopic authenticates by the manager key only, the auth providers work on net/http.
+func fastOwnTopic(appid, pubkey, topic string) error {
+	if err := manager.Default.Auth(appid, pubkey); err != nil {
+		return err
+	}
+
+	return manager.Default.OwnTopic(appid, topic)
+}
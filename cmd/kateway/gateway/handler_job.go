@@ -1,6 +1,7 @@
 package gateway
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"strconv"
@@ -14,8 +15,9 @@ import (
 )
 
 //go:generate goannotation $GOFILE
-// @rest POST /v1/jobs/:topic/:ver?delay=100|due=1471565204
-// TODO tag, partitionKey
+// @rest POST /v1/jobs/:topic/:ver?delay=100|due=1471565204|cron=*/5 * * * *&key=xxx
+// cron is a recurring job spec, e,g. '@every 1m', '0 9 * * 1-5', '@daily', see job.ParseSchedule
+// jobs of the same partition key fire in due time order, tag is passed by X-Tag header
 // TODO use dedicated metrics
 func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	if !Options.DisableMetrics {
@@ -28,8 +30,16 @@ func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, par
 
 	var due int64
 	q := r.URL.Query()
+	cron := q.Get("cron")    // recurring job has higher priority than due and delay
 	dueParam := q.Get("due") // due has higher priority than delay
-	if dueParam != "" {
+	if cron != "" {
+		if _, err := job.ParseSchedule(cron); err != nil {
+			log.Error("+job[%s] %s(%s) cron:%s %s", appid, r.RemoteAddr, realIp, cron, err)
+
+			writeBadRequest(w, "invalid cron param")
+			return
+		}
+	} else if dueParam != "" {
 		d, err := strconv.ParseInt(dueParam, 10, 64)
 		if err != nil {
 			log.Error("+job[%s] %s(%s) due:%s %s", appid, r.RemoteAddr, realIp, dueParam, err)
@@ -52,13 +62,29 @@ func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, par
 		due = t1.Unix() + delay
 	}
 
-	if due <= t1.Unix() {
+	if cron == "" && due <= t1.Unix() {
 		log.Error("+job[%s] %s(%s) due=%d before now?", appid, r.RemoteAddr, realIp, due)
 
 		writeBadRequest(w, "invalid param")
 		return
 	}
 
+	key := q.Get("key")
+	if len(key) > MaxPartitionKeyLen {
+		log.Warn("+job[%s] %s(%s) too big key: %s", appid, r.RemoteAddr, realIp, key)
+
+		writeBadRequest(w, "too big key")
+		return
+	}
+
+	tag := r.Header.Get(HttpHeaderMsgTag)
+	if len(tag) > Options.MaxMsgTagLen {
+		log.Warn("+job[%s] %s(%s) too big tag: %s", appid, r.RemoteAddr, realIp, tag)
+
+		writeBadRequest(w, "too big tag")
+		return
+	}
+
 	if Options.Ratelimit && !this.throttlePub.Pour(realIp, 1) {
 		log.Warn("+job[%s] %s(%s) rate limit reached", appid, r.RemoteAddr, realIp)
 
@@ -68,7 +94,8 @@ func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, par
 
 	topic := params.ByName(UrlParamTopic)
 	ver := params.ByName(UrlParamVersion)
-	if err := manager.Default.OwnTopic(appid, r.Header.Get(HttpHeaderPubkey), topic); err != nil {
+	var err error
+	if appid, err = this.ownTopic(r, topic); err != nil {
 		log.Warn("+job[%s] %s(%s) {topic:%s, ver:%s} %s", appid, r.RemoteAddr, realIp, topic, ver, err)
 
 		writeAuthFailure(w, err)
@@ -110,8 +137,8 @@ func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, par
 		return
 	}
 
-	log.Debug("+job[%s] %s(%s) {topic:%s, ver:%s} due:%d/%ds",
-		appid, r.RemoteAddr, realIp, topic, ver, due, due-t1.Unix())
+	log.Debug("+job[%s] %s(%s) {topic:%s, ver:%s key:%s tag:%s} due:%d/%ds cron:%s",
+		appid, r.RemoteAddr, realIp, topic, ver, key, tag, due, due-t1.Unix(), cron)
 
 	if !Options.DisableMetrics {
 		this.pubMetrics.JobQps.Mark(1)
@@ -129,7 +156,12 @@ func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, par
 		return
 	}
 
-	jobId, err := job.Default.Add(appid, manager.Default.KafkaTopic(appid, topic, ver), msg.Body, due)
+	var jobId string
+	if cron != "" {
+		jobId, err = job.Default.AddCron(appid, manager.Default.KafkaTopic(appid, topic, ver), msg.Body, key, tag, cron)
+	} else {
+		jobId, err = job.Default.Add(appid, manager.Default.KafkaTopic(appid, topic, ver), msg.Body, key, tag, due)
+	}
 	msg.Free()
 	if err != nil {
 		if !Options.DisableMetrics {
@@ -143,8 +175,8 @@ func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, par
 	}
 
 	if Options.AuditPub {
-		this.auditor.Trace("+job[%s] %s(%s) {topic:%s ver:%s UA:%s} due:%d id:%s",
-			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), due, jobId)
+		this.auditor.Trace("+job[%s] %s(%s) {topic:%s ver:%s UA:%s key:%s tag:%s} due:%d cron:%s id:%s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), key, tag, due, cron, jobId)
 	}
 
 	w.Header().Set(HttpHeaderJobId, jobId)
@@ -161,13 +193,15 @@ func (this *pubServer) addJobHandler(w http.ResponseWriter, r *http.Request, par
 	}
 }
 
-// DELETE /v1/jobs/:topic/:ver?id=22323
+// @rest DELETE /v1/jobs/:topic/:ver?id=22323
+// a recurring job is cancelled
 func (this *pubServer) deleteJobHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	appid := r.Header.Get(HttpHeaderAppid)
 	topic := params.ByName(UrlParamTopic)
 	ver := params.ByName(UrlParamVersion)
 	realIp := getHttpRemoteIp(r)
-	if err := manager.Default.OwnTopic(appid, r.Header.Get(HttpHeaderPubkey), topic); err != nil {
+	var err error
+	if appid, err = this.ownTopic(r, topic); err != nil {
 		log.Error("-job[%s] %s(%s) {topic:%s, ver:%s} %s",
 			appid, r.RemoteAddr, realIp, topic, ver, err)
 
@@ -215,3 +249,112 @@ func (this *pubServer) deleteJobHandler(w http.ResponseWriter, r *http.Request,
 
 	w.Write(ResponseOk)
 }
+
+// @rest GET /v1/crons/:topic/:ver
+// response: [{"id":"341647700585877504","spec":"@every 1m","paused":false,"ctime":1471565204,"next":1471565264,"payload":"hello"}]
+func (this *pubServer) listCronsHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	appid := r.Header.Get(HttpHeaderAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	realIp := getHttpRemoteIp(r)
+	var err error
+	if appid, err = this.ownTopic(r, topic); err != nil {
+		log.Error("crons[%s] %s(%s) {topic:%s, ver:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	crons, err := job.Default.Crons(appid, manager.Default.KafkaTopic(appid, topic, ver))
+	if err != nil {
+		log.Error("crons[%s] %s(%s) {topic:%s, ver:%s} %v",
+			appid, r.RemoteAddr, realIp, topic, ver, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	type cronOutput struct {
+		JobId    string `json:"id"`
+		Spec     string `json:"spec"`
+		Paused   bool   `json:"paused"`
+		Ctime    int64  `json:"ctime"`
+		NextTime int64  `json:"next"`
+		Key      string `json:"key,omitempty"`
+		Tag      string `json:"tag,omitempty"`
+		Payload  string `json:"payload"`
+	}
+	out := make([]cronOutput, 0, len(crons))
+	for _, c := range crons {
+		out = append(out, cronOutput{
+			JobId:    strconv.FormatInt(c.JobId, 10),
+			Spec:     c.Spec,
+			Paused:   c.Paused,
+			Ctime:    c.Ctime,
+			NextTime: c.NextTime,
+			Key:      c.Key,
+			Tag:      c.Tag,
+			Payload:  string(c.Payload),
+		})
+	}
+
+	b, _ := json.Marshal(out)
+	w.Write(b)
+}
+
+// @rest PUT /v1/crons/:topic/:ver?id=22323&op=<pause|resume>
+func (this *pubServer) updateCronHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	appid := r.Header.Get(HttpHeaderAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	realIp := getHttpRemoteIp(r)
+	var err error
+	if appid, err = this.ownTopic(r, topic); err != nil {
+		log.Error("cron[%s] %s(%s) {topic:%s, ver:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	q := r.URL.Query()
+	jobId := q.Get("id")
+	if len(jobId) < 18 { // jobId e,g. 341647700585877504
+		writeBadRequest(w, "invalid job id")
+		return
+	}
+
+	rawTopic := manager.Default.KafkaTopic(appid, topic, ver)
+	op := q.Get("op")
+	switch op {
+	case "pause":
+		err = job.Default.PauseCron(appid, rawTopic, jobId)
+
+	case "resume":
+		err = job.Default.ResumeCron(appid, rawTopic, jobId)
+
+	default:
+		writeBadRequest(w, "invalid op")
+		return
+	}
+
+	if err != nil {
+		log.Error("cron[%s] %s(%s) {topic:%s, ver:%s jid:%s} %s %v",
+			appid, r.RemoteAddr, realIp, topic, ver, jobId, op, err)
+
+		if err == job.ErrNoSuchCron {
+			writeBadRequest(w, err.Error())
+		} else {
+			writeServerError(w, err.Error())
+		}
+		return
+	}
+
+	if Options.AuditPub {
+		this.auditor.Trace("cron[%s] %s(%s) {topic:%s ver:%s UA:%s jid:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), jobId, op)
+	}
+
+	w.Write(ResponseOk)
+}
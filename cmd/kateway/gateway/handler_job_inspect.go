@@ -0,0 +1,274 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/job"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+const maxJobListLimit = 1000
+
+type jobOutput struct {
+	JobId   string `json:"id"`
+	State   string `json:"state"`
+	Ctime   int64  `json:"ctime"`
+	Due     int64  `json:"due"`
+	Etime   int64  `json:"etime,omitempty"`
+	ActorId string `json:"actor,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+	Payload string `json:"payload"`
+}
+
+func newJobOutput(item job.JobItem) jobOutput {
+	return jobOutput{
+		JobId:   strconv.FormatInt(item.JobId, 10),
+		State:   item.State,
+		Ctime:   item.Ctime,
+		Due:     item.DueTime,
+		Etime:   item.Etime,
+		ActorId: item.ActorId,
+		Key:     item.Key,
+		Tag:     item.Tag,
+		Payload: string(item.Payload),
+	}
+}
+
+// @rest GET /v1/jobs/:topic/:ver?id=22323
+// @rest GET /v1/jobs/:topic/:ver?state=<pending|fired>&from=1471565204&to=1471565304&cursor=xx&limit=100
+// response: {"id":"341647700585877504","state":"fired","ctime":1471565104,"due":1471565204,"etime":1471565205,"actor":"1","payload":"hello"}
+// response: {"jobs":[...], "cursor":"1471565204-341647700585877504"}
+func (this *pubServer) getJobsHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	appid := r.Header.Get(HttpHeaderAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	realIp := getHttpRemoteIp(r)
+	var err error
+	if appid, err = this.ownTopic(r, topic); err != nil {
+		log.Error("?job[%s] %s(%s) {topic:%s, ver:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	queryJobs(w, r, appid, topic, ver)
+}
+
+// @rest PUT /v1/jobs/:topic/:ver?id=22323&due=1471565204|delay=100
+// the optional body replaces the job payload.
+func (this *pubServer) updateJobHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	appid := r.Header.Get(HttpHeaderAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	realIp := getHttpRemoteIp(r)
+	var err error
+	if appid, err = this.ownTopic(r, topic); err != nil {
+		log.Error("~job[%s] %s(%s) {topic:%s, ver:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	if updateJob(w, r, appid, topic, ver) && Options.AuditPub {
+		this.auditor.Trace("~job[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), r.URL.RawQuery)
+	}
+}
+
+// @rest GET /v1/jobs/:appid/:topic/:ver?id=22323
+// same as Pub GET /v1/jobs/:topic/:ver for any app.
+func (this *manServer) getJobsHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	appid := r.Header.Get(HttpHeaderAppid)
+	if !this.authAdmin(r) {
+		log.Warn("suspicous ?job %s(%s) {appid:%s}", r.RemoteAddr, getHttpRemoteIp(r), appid)
+
+		writeAuthFailure(w, manager.ErrAuthenticationFail)
+		return
+	}
+
+	queryJobs(w, r, params.ByName(UrlParamAppid), params.ByName(UrlParamTopic), params.ByName(UrlParamVersion))
+}
+
+// @rest PUT /v1/jobs/:appid/:topic/:ver?id=22323&due=1471565204|delay=100
+// same as Pub PUT /v1/jobs/:topic/:ver for any app.
+func (this *manServer) updateJobHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	appid := r.Header.Get(HttpHeaderAppid)
+	realIp := getHttpRemoteIp(r)
+	if !this.authAdmin(r) {
+		log.Warn("suspicous ~job %s(%s) {appid:%s}", r.RemoteAddr, realIp, appid)
+
+		writeAuthFailure(w, manager.ErrAuthenticationFail)
+		return
+	}
+
+	hisAppid := params.ByName(UrlParamAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	if updateJob(w, r, hisAppid, topic, ver) {
+		log.Info("~job[%s] %s(%s) {appid:%s topic:%s ver:%s} %s",
+			appid, r.RemoteAddr, realIp, hisAppid, topic, ver, r.URL.RawQuery)
+	}
+}
+
+func queryJobs(w http.ResponseWriter, r *http.Request, appid, topic, ver string) {
+	if _, found := manager.Default.LookupCluster(appid); !found {
+		writeBadRequest(w, "invalid appid")
+		return
+	}
+
+	rawTopic := manager.Default.KafkaTopic(appid, topic, ver)
+	q := r.URL.Query()
+	if jobId := q.Get("id"); jobId != "" {
+		item, err := job.Default.Get(appid, rawTopic, jobId)
+		switch err {
+		case nil:
+			b, _ := json.Marshal(newJobOutput(item))
+			w.Write(b)
+
+		case job.ErrNoSuchJob:
+			_writeErrorResponse(w, err.Error(), http.StatusNotFound)
+
+		default:
+			log.Error("?job[%s] {topic:%s ver:%s jid:%s} %v", appid, topic, ver, jobId, err)
+
+			writeServerError(w, err.Error())
+		}
+		return
+	}
+
+	var (
+		opt job.ListOption
+		err error
+	)
+	opt.Cursor = q.Get("cursor")
+	opt.State = q.Get("state")
+	switch opt.State {
+	case "", job.JobPending, job.JobDue:
+		opt.State = job.JobPending
+	case job.JobFired:
+	default:
+		writeBadRequest(w, "invalid state")
+		return
+	}
+	if opt.DueFrom, err = getHttpQueryInt64(&q, "from", 0); err != nil {
+		writeBadRequest(w, "invalid from")
+		return
+	}
+	if opt.DueTo, err = getHttpQueryInt64(&q, "to", 0); err != nil {
+		writeBadRequest(w, "invalid to")
+		return
+	}
+	if opt.Limit, err = getHttpQueryInt(&q, "limit", 100); err != nil || opt.Limit > maxJobListLimit {
+		writeBadRequest(w, "invalid limit")
+		return
+	}
+
+	items, cursor, err := job.Default.List(appid, rawTopic, opt)
+	if err != nil {
+		if err == job.ErrInvalidCursor {
+			writeBadRequest(w, err.Error())
+			return
+		}
+
+		log.Error("?job[%s] {topic:%s ver:%s} %+v %v", appid, topic, ver, opt, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	var out = struct {
+		Jobs   []jobOutput `json:"jobs"`
+		Cursor string      `json:"cursor"`
+	}{
+		Jobs:   make([]jobOutput, 0, len(items)),
+		Cursor: cursor,
+	}
+	for _, item := range items {
+		out.Jobs = append(out.Jobs, newJobOutput(item))
+	}
+
+	b, _ := json.Marshal(out)
+	w.Write(b)
+}
+
+// updateJob reschedules and/or replaces the payload of a pending job, returns true on success.
+func updateJob(w http.ResponseWriter, r *http.Request, appid, topic, ver string) bool {
+	if _, found := manager.Default.LookupCluster(appid); !found {
+		writeBadRequest(w, "invalid appid")
+		return false
+	}
+
+	q := r.URL.Query()
+	jobId := q.Get("id")
+	if len(jobId) < 18 { // jobId e,g. 341647700585877504
+		writeBadRequest(w, "invalid job id")
+		return false
+	}
+
+	var due int64
+	now := time.Now().Unix()
+	if dueParam := q.Get("due"); dueParam != "" {
+		d, err := strconv.ParseInt(dueParam, 10, 64)
+		if err != nil || d <= now {
+			writeBadRequest(w, "invalid due param")
+			return false
+		}
+
+		due = d
+	} else if delayParam := q.Get("delay"); delayParam != "" {
+		delay, err := strconv.ParseInt(delayParam, 10, 64)
+		if err != nil || delay <= 0 {
+			writeBadRequest(w, "invalid delay param")
+			return false
+		}
+
+		due = now + delay
+	}
+
+	var payload []byte
+	if r.ContentLength != 0 {
+		if r.ContentLength > Options.MaxJobSize || r.ContentLength < int64(Options.MinPubSize) {
+			writeBadRequest(w, "invalid content length")
+			return false
+		}
+
+		var err error
+		payload = make([]byte, r.ContentLength)
+		if _, err = io.ReadFull(io.LimitReader(r.Body, Options.MaxJobSize), payload); err != nil {
+			writeBadRequest(w, err.Error())
+			return false
+		}
+	}
+
+	if due == 0 && payload == nil {
+		writeBadRequest(w, "nothing to update")
+		return false
+	}
+
+	rawTopic := manager.Default.KafkaTopic(appid, topic, ver)
+	switch err := job.Default.Update(appid, rawTopic, jobId, due, payload); err {
+	case nil:
+		w.Write(ResponseOk)
+		return true
+
+	case job.ErrNoSuchJob:
+		// fired or deleted
+		_writeErrorResponse(w, err.Error(), http.StatusNotFound)
+
+	default:
+		log.Error("~job[%s] {topic:%s ver:%s jid:%s} %v", appid, topic, ver, jobId, err)
+
+		writeServerError(w, err.Error())
+	}
+
+	return false
+}
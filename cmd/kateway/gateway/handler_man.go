@@ -22,38 +22,10 @@ import (
 	"github.com/funkygao/golib/gofmt"
 	"github.com/funkygao/httprouter"
 	log "github.com/funkygao/log4go"
+	zklib "github.com/samuel/go-zookeeper/zk"
 )
 
 //go:generate goannotation $GOFILE
-// @rest GET /v1/schema/:appid/:topic/:ver
-func (this *manServer) schemaHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-	hisAppid := params.ByName(UrlParamAppid)
-	myAppid := r.Header.Get(HttpHeaderAppid)
-	topic := params.ByName(UrlParamTopic)
-	ver := params.ByName(UrlParamVersion)
-	realIp := getHttpRemoteIp(r)
-
-	log.Info("schema[%s] %s(%s) {app:%s topic:%s ver:%s UA:%s}",
-		myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"))
-
-	// TODO authorization
-
-	_, found := manager.Default.LookupCluster(hisAppid)
-	if !found {
-		writeBadRequest(w, "invalid appid")
-		return
-	}
-
-	// TODO lookup from manager and send response
-	schema, err := manager.Default.TopicSchema(hisAppid, topic, ver)
-	if err != nil {
-		writeBadRequest(w, err.Error())
-		return
-	}
-
-	w.Write([]byte(strings.TrimSpace(schema)))
-}
-
 // @rest GET /v1/status
 func (this *manServer) statusHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	log.Info("status %s(%s)", r.RemoteAddr, getHttpRemoteIp(r))
@@ -260,7 +232,7 @@ func (this *manServer) partitionsHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	if !manager.Default.AuthAdmin(appid, pubkey) {
+	if !this.authAdmin(r) {
 		log.Warn("suspicous partitions call from %s(%s) {cluster:%s app:%s key:%s topic:%s ver:%s}",
 			r.RemoteAddr, realIp, cluster, appid, pubkey, topic, ver)
 
@@ -335,7 +307,12 @@ func (this *manServer) partitionsHandler(w http.ResponseWriter, r *http.Request,
 	w.Write(info)
 }
 
-// @rest PUT /v1/webhook/:appid/:topic/:ver?group=xx
+// @rest PUT /v1/webhooks/:appid/:topic/:ver?group=xx
+// body: {"endpoints":["http://a.com/hook"],"filter":"vip","retries":3,"backoff":"1s","template":"es","index":"orders","headers":{"Authorization":"Basic YTpi"}}
+// failed deliveries are retried with exponential backoff("retries":0 turns it off) and then buried to dead shadow topic of the group,
+// which must have been added
+// template: raw(default)|json|es, deliveries are signed by X-Signature: hex(hmac-sha256(secret, X-Timestamp + "." + body))
+// mode: ordered(default)|parallel|fanout with "workers" concurrent deliveries
 func (this *manServer) createWebhookHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	topic := params.ByName(UrlParamTopic)
 	if !manager.Default.ValidateTopicName(topic) {
@@ -352,8 +329,8 @@ func (this *manServer) createWebhookHandler(w http.ResponseWriter, r *http.Reque
 	myAppid := r.Header.Get(HttpHeaderAppid)
 	ver := params.ByName(UrlParamVersion)
 
-	if err := manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
-		hisAppid, topic, group); err != nil {
+	var err error
+	if myAppid, err = this.authSub(r, hisAppid, topic, group); err != nil {
 		log.Error("+webhook[%s/%s] -(%s): {%s.%s.%s UA:%s} %v",
 			myAppid, group, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), err)
 
@@ -397,7 +374,62 @@ func (this *manServer) createWebhookHandler(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
+	if _, err := CompileTagFilter(hook.Filter); err != nil {
+		log.Error("+webhook[%s/%s] %s(%s): {%s.%s.%s UA:%s} filter:%s %v",
+			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), hook.Filter, err)
+
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	if _, _, err := hook.RetryPolicy(); err != nil {
+		log.Error("+webhook[%s/%s] %s(%s): {%s.%s.%s UA:%s} backoff:%s %v",
+			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), hook.Backoff, err)
+
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	if _, err := hook.BodyTemplate(); err != nil {
+		log.Error("+webhook[%s/%s] %s(%s): {%s.%s.%s UA:%s} template:%s %v",
+			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), hook.Template, err)
+
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	if _, _, err := hook.DeliveryMode(); err != nil {
+		log.Error("+webhook[%s/%s] %s(%s): {%s.%s.%s UA:%s} mode:%s workers:%d %v",
+			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), hook.Mode, hook.Workers, err)
+
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	if err := validateWebhookHeaders(hook.Headers); err != nil {
+		log.Error("+webhook[%s/%s] %s(%s): {%s.%s.%s UA:%s} headers:%+v %v",
+			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), hook.Headers, err)
+
+		writeBadRequest(w, err.Error())
+		return
+	}
+
 	hook.Cluster = cluster // cluster is decided by server
+	hook.Appid = myAppid   // deliveries are signed with the subscriber secret
+	hook.Dead = ""
+	if group != "" {
+		// deliveries that exhaust retries are buried to the dead shadow topic of the group
+		hook.Dead = manager.Default.ShadowTopic(sla.SlaKeyDeadLetterTopic, myAppid, hisAppid, topic, ver, group)
+
+		// otherwise actor could never bury the failed deliveries
+		if zkcluster := meta.Default.ZkCluster(cluster); zkcluster == nil || len(zkcluster.Partitions(hook.Dead)) == 0 {
+			log.Error("+webhook[%s/%s] %s(%s): {%s.%s.%s UA:%s} dead:%s not found",
+				myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), hook.Dead)
+
+			writeBadRequest(w, "dead letter shadow topic not found, add shadow queue first")
+			return
+		}
+	}
 	if err := this.gw.zkzone.CreateOrUpdateWebhook(rawTopic, hook); err != nil {
 		log.Error("+webhook[%s/%s] %s(%s): {%s.%s.%s UA:%s} %v",
 			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), err)
@@ -409,7 +441,8 @@ func (this *manServer) createWebhookHandler(w http.ResponseWriter, r *http.Reque
 	w.Write(ResponseOk)
 }
 
-// @rest DELETE /v1/jobs/:appid/:topic/:ver?group=xx
+// @rest DELETE /v1/webhooks/:appid/:topic/:ver?group=xx
+// the actor that owns the webhook stops pushing at once
 func (this *manServer) deleteWebhookHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	topic := params.ByName(UrlParamTopic)
 	if !manager.Default.ValidateTopicName(topic) {
@@ -426,31 +459,33 @@ func (this *manServer) deleteWebhookHandler(w http.ResponseWriter, r *http.Reque
 	myAppid := r.Header.Get(HttpHeaderAppid)
 	ver := params.ByName(UrlParamVersion)
 
-	if err := manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
-		hisAppid, topic, group); err != nil {
-		log.Error("+webhook[%s/%s] -(%s): {%s.%s.%s UA:%s} %v",
+	var err error
+	if myAppid, err = this.authSub(r, hisAppid, topic, group); err != nil {
+		log.Error("-webhook[%s/%s] -(%s): {%s.%s.%s UA:%s} %v",
 			myAppid, group, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), err)
 
 		writeAuthFailure(w, err)
 		return
 	}
 
-	/*
-		cluster, found := manager.Default.LookupCluster(hisAppid)
-		if !found {
-			log.Error("+webhook[%s/%s] -(%s): {%s.%s.%s UA:%s} undefined cluster",
-				myAppid, group, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"))
+	log.Info("-webhook[%s/%s] %s(%s): {%s.%s.%s UA:%s}",
+		myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"))
+
+	rawTopic := manager.Default.KafkaTopic(hisAppid, topic, ver)
+	if err := this.gw.zkzone.DeleteWebhook(rawTopic); err != nil {
+		log.Error("-webhook[%s/%s] %s(%s): {%s.%s.%s UA:%s} %v",
+			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), err)
 
-			writeBadRequest(w, "invalid appid")
+		if err == zklib.ErrNoNode {
+			writeNotFound(w)
 			return
 		}
 
-		log.Info("+webhook[%s/%s] %s(%s): {%s.%s.%s UA:%s}",
-			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"))
-
-		rawTopic := manager.Default.KafkaTopic(hisAppid, topic, ver)
-	*/
+		writeServerError(w, err.Error())
+		return
+	}
 
+	w.Write(ResponseOk)
 }
 
 // @rest POST /v1/jobs/:appid/:topic/:ver
@@ -474,7 +509,7 @@ func (this *manServer) createJobHandler(w http.ResponseWriter, r *http.Request,
 	appid := r.Header.Get(HttpHeaderAppid)
 	pubkey := r.Header.Get(HttpHeaderPubkey)
 	ver := params.ByName(UrlParamVersion)
-	if !manager.Default.AuthAdmin(appid, pubkey) {
+	if !this.authAdmin(r) {
 		log.Warn("suspicous create job %s(%s) {appid:%s pubkey:%s topic:%s ver:%s}",
 			r.RemoteAddr, realIp, appid, pubkey, topic, ver)
 
@@ -546,7 +581,7 @@ func (this *manServer) createTopicHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if !manager.Default.AuthAdmin(appid, pubkey) {
+	if !this.authAdmin(r) {
 		log.Warn("suspicous create topic %s(%s) {appid:%s pubkey:%s cluster:%s topic:%s ver:%s}",
 			r.RemoteAddr, realIp, appid, pubkey, cluster, topic, ver)
 
@@ -659,7 +694,7 @@ func (this *manServer) alterTopicHandler(w http.ResponseWriter, r *http.Request,
 	appid := r.Header.Get(HttpHeaderAppid)
 	pubkey := r.Header.Get(HttpHeaderPubkey)
 	ver := params.ByName(UrlParamVersion)
-	if !manager.Default.AuthAdmin(appid, pubkey) {
+	if !this.authAdmin(r) {
 		log.Warn("suspicous alter topic from %s(%s) {appid:%s pubkey:%s topic:%s ver:%s}",
 			r.RemoteAddr, realIp, appid, pubkey, topic, ver)
 
@@ -746,7 +781,7 @@ func (this *manServer) refreshManagerHandler(w http.ResponseWriter, r *http.Requ
 	pubkey := r.Header.Get(HttpHeaderPubkey)
 	realIp := getHttpRemoteIp(r)
 
-	if !manager.Default.AuthAdmin(appid, pubkey) {
+	if !this.authAdmin(r) {
 		log.Warn("suspicous refresh call from %s(%s) {app:%s key:%s}",
 			r.RemoteAddr, realIp, appid, pubkey)
 
@@ -0,0 +1,113 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/funkygao/gafka/cmd/kateway/acl"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+//go:generate goannotation $GOFILE
+// @rest GET /v1/acls
+func (this *manServer) aclsHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if !this.authAcl(w, r) {
+		return
+	}
+
+	rules, err := acl.Default.Rules()
+	if err != nil {
+		log.Error("acls %s(%s) %v", r.RemoteAddr, getHttpRemoteIp(r), err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	if rules == nil {
+		rules = []acl.Rule{}
+	}
+	b, _ := json.Marshal(rules)
+	w.Write(b)
+}
+
+// @rest POST /v1/acls
+// body is acl.Rule without id, e,g. {"principal":"app2","resource":"app1.orders.*","op":"sub","effect":"allow"}
+func (this *manServer) addAclHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if !this.authAcl(w, r) {
+		return
+	}
+
+	var rule acl.Rule
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&rule); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+	r.Body.Close()
+
+	rule, err := acl.Default.Add(rule)
+	switch err {
+	case nil:
+
+	case acl.ErrInvalidOperation, acl.ErrInvalidEffect, acl.ErrEmptyPattern:
+		writeBadRequest(w, err.Error())
+		return
+
+	default:
+		log.Error("+acl[%s] %s(%s) %+v: %v", r.Header.Get(HttpHeaderAppid), r.RemoteAddr, getHttpRemoteIp(r), rule, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	log.Info("+acl[%s] %s(%s) %+v", r.Header.Get(HttpHeaderAppid), r.RemoteAddr, getHttpRemoteIp(r), rule)
+
+	b, _ := json.Marshal(rule)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+// @rest DELETE /v1/acls/:id
+func (this *manServer) deleteAclHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	if !this.authAcl(w, r) {
+		return
+	}
+
+	id := params.ByName("id")
+	switch err := acl.Default.Remove(id); err {
+	case nil:
+
+	case acl.ErrRuleNotFound:
+		writeNotFound(w)
+		return
+
+	default:
+		log.Error("-acl[%s] %s(%s) {id:%s} %v", r.Header.Get(HttpHeaderAppid), r.RemoteAddr, getHttpRemoteIp(r), id, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	log.Info("-acl[%s] %s(%s) {id:%s}", r.Header.Get(HttpHeaderAppid), r.RemoteAddr, getHttpRemoteIp(r), id)
+
+	w.Write(ResponseOk)
+}
+
+// authAcl allows only the admin to manage the acl rules.
+func (this *manServer) authAcl(w http.ResponseWriter, r *http.Request) bool {
+	if !this.authAdmin(r) {
+		log.Warn("suspicous acl call from %s(%s) {app:%s}", r.RemoteAddr, getHttpRemoteIp(r), r.Header.Get(HttpHeaderAppid))
+
+		writeAuthFailure(w, manager.ErrAuthenticationFail)
+		return false
+	}
+
+	if acl.Default == nil {
+		writeBadRequest(w, "acl not enabled")
+		return false
+	}
+
+	return true
+}
@@ -21,7 +21,8 @@ func (this *manServer) pubRawHandler(w http.ResponseWriter, r *http.Request, par
 	appid := r.Header.Get(HttpHeaderAppid)
 	realIp := getHttpRemoteIp(r)
 
-	if err := manager.Default.OwnTopic(appid, r.Header.Get(HttpHeaderPubkey), topic); err != nil {
+	var err error
+	if appid, err = this.ownTopic(r, topic); err != nil {
 		log.Error("pub raw[%s] %s(%s) {topic:%s, ver:%s}: %s",
 			appid, r.RemoteAddr, realIp, topic, ver, err)
 
@@ -0,0 +1,42 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+//go:generate goannotation $GOFILE
+// @rest POST /v1/quota/gossip
+// Called by the peer kateways to share their quota usage, body is quota.Report.
+// A report is accepted only from the address where its kateway is registered.
+func (this *manServer) quotaGossipHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	gossiper, ok := quota.Default.(quota.Gossiper)
+	if !ok {
+		writeBadRequest(w, "quota limiter is not clustered")
+		return
+	}
+
+	var report quota.Report
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&report); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+	r.Body.Close()
+
+	// peers gossip directly, X-Forwarded-For is not trusted
+	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if err := gossiper.Gossip(ip, report); err != nil {
+		log.Warn("quota gossip from %s(%s) {id:%s} %v", r.RemoteAddr, getHttpRemoteIp(r), report.Id, err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	w.Write(ResponseOk)
+}
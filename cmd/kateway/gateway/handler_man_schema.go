@@ -0,0 +1,226 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/schema"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+//go:generate goannotation $GOFILE
+// @rest GET /v1/schemas/:appid/:topic/:ver?version=2
+// Without version, the latest registered schema is returned, falling back to the manager's.
+func (this *manServer) schemaHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	hisAppid := params.ByName(UrlParamAppid)
+	myAppid := r.Header.Get(HttpHeaderAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	realIp := getHttpRemoteIp(r)
+
+	log.Info("schema[%s] %s(%s) {app:%s topic:%s ver:%s UA:%s}",
+		myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"))
+
+	// TODO authorization
+
+	_, found := manager.Default.LookupCluster(hisAppid)
+	if !found {
+		writeBadRequest(w, "invalid appid")
+		return
+	}
+
+	sub, err := schema.Default.Subject(hisAppid, topic, ver)
+	switch err {
+	case nil:
+		v := sub.Latest()
+		if version := r.URL.Query().Get("version"); version != "" {
+			n, _ := strconv.Atoi(version)
+			v, err = sub.Version(n)
+		}
+		if err != nil || v == nil {
+			writeNotFound(w)
+			return
+		}
+
+		w.Header().Set(HttpHeaderSchemaVersion, strconv.Itoa(v.Version))
+		w.Header().Set(HttpHeaderSchemaFormat, sub.Format)
+		w.Write([]byte(strings.TrimSpace(v.Schema)))
+		return
+
+	case schema.ErrSubjectNotFound:
+		// registered in the manager before the schema registry exists
+
+	default:
+		log.Error("schema[%s] %s(%s) {app:%s topic:%s ver:%s} %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	s, err := manager.Default.TopicSchema(hisAppid, topic, ver)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	w.Write([]byte(strings.TrimSpace(s)))
+}
+
+// @rest GET /v1/schemas/:appid/:topic/:ver/versions
+func (this *manServer) schemaVersionsHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	hisAppid := params.ByName(UrlParamAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+
+	log.Info("schema versions %s(%s) {app:%s topic:%s ver:%s UA:%s}",
+		r.RemoteAddr, getHttpRemoteIp(r), hisAppid, topic, ver, r.Header.Get("User-Agent"))
+
+	sub, err := schema.Default.Subject(hisAppid, topic, ver)
+	switch err {
+	case nil:
+		w.Write(sub.Bytes())
+
+	case schema.ErrSubjectNotFound:
+		writeNotFound(w)
+
+	default:
+		writeServerError(w, err.Error())
+	}
+}
+
+// @rest POST /v1/schemas/:appid/:topic/:ver
+// Body: {"format": "avro|json", "compatibility": "none|backward|forward|full", "schema": {...}}
+// compatibility applies only to the 1st version, default backward.
+func (this *manServer) registerSchemaHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	hisAppid := params.ByName(UrlParamAppid)
+	myAppid := r.Header.Get(HttpHeaderAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	realIp := getHttpRemoteIp(r)
+
+	if err := this.authSchemaOwner(r, hisAppid, topic); err != nil {
+		log.Warn("+schema[%s] %s(%s) {app:%s topic:%s ver:%s UA:%s} %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	var req struct {
+		Format        string          `json:"format"`
+		Compatibility string          `json:"compatibility"`
+		Schema        json.RawMessage `json:"schema"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+	r.Body.Close()
+
+	// the schema is either a json string or the schema document itself
+	s := string(req.Schema)
+	if err := json.Unmarshal(req.Schema, &s); err != nil {
+		s = string(req.Schema)
+	}
+	if _, err := schema.Compile(req.Format, s); err != nil {
+		log.Warn("+schema[%s] %s(%s) {app:%s topic:%s ver:%s format:%s} %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, req.Format, err)
+
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	version, err := schema.Default.Register(hisAppid, topic, ver, req.Format, req.Compatibility, s)
+	if err != nil {
+		log.Warn("+schema[%s] %s(%s) {app:%s topic:%s ver:%s format:%s} %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, req.Format, err)
+
+		writeSchemaError(w, err)
+		return
+	}
+
+	log.Info("+schema[%s] %s(%s) {app:%s topic:%s ver:%s format:%s} v%d",
+		myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, req.Format, version)
+
+	w.WriteHeader(http.StatusCreated)
+	b, _ := json.Marshal(map[string]int{"version": version})
+	w.Write(b)
+}
+
+// @rest PUT /v1/schemas/:appid/:topic/:ver?enforce=1&compatibility=full
+// Pub of non-conforming messages is rejected only when enforce=1.
+func (this *manServer) configSchemaHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	hisAppid := params.ByName(UrlParamAppid)
+	myAppid := r.Header.Get(HttpHeaderAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	realIp := getHttpRemoteIp(r)
+
+	if err := this.authSchemaOwner(r, hisAppid, topic); err != nil {
+		log.Warn("schema config[%s] %s(%s) {app:%s topic:%s ver:%s UA:%s} %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+	enforce := query.Get("enforce") == "1"
+	compatibility := query.Get("compatibility")
+	if err := schema.Default.Configure(hisAppid, topic, ver, compatibility, enforce); err != nil {
+		log.Warn("schema config[%s] %s(%s) {app:%s topic:%s ver:%s enforce:%v compatibility:%s} %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, enforce, compatibility, err)
+
+		writeSchemaError(w, err)
+		return
+	}
+
+	log.Info("schema config[%s] %s(%s) {app:%s topic:%s ver:%s enforce:%v compatibility:%s}",
+		myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, enforce, compatibility)
+
+	w.Write(ResponseOk)
+}
+
+// authSchemaOwner allows only the topic owner and the admin to change its schema.
+func (this *manServer) authSchemaOwner(r *http.Request, hisAppid, topic string) error {
+	if this.authAdmin(r) {
+		return nil
+	}
+
+	myAppid, err := this.authenticate(r)
+	if err != nil {
+		return err
+	}
+	if myAppid != hisAppid {
+		return manager.ErrAuthorizationFail
+	}
+	return manager.Default.OwnTopic(myAppid, topic)
+}
+
+func writeSchemaError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case *schema.CompatibilityError:
+		_writeErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	switch err {
+	case schema.ErrInvalidFormat, schema.ErrInvalidCompatibility, schema.ErrFormatMismatch:
+		writeBadRequest(w, err.Error())
+
+	case schema.ErrConcurrentUpdate:
+		_writeErrorResponse(w, err.Error(), http.StatusConflict)
+
+	case schema.ErrSubjectNotFound:
+		writeNotFound(w)
+
+	default:
+		writeServerError(w, err.Error())
+	}
+}
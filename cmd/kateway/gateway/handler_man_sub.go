@@ -2,6 +2,7 @@ package gateway
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -12,6 +13,7 @@ import (
 	"github.com/funkygao/gafka/cmd/kateway/meta"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	"github.com/funkygao/gafka/sla"
+	gzk "github.com/funkygao/gafka/zk"
 	"github.com/funkygao/httprouter"
 	log "github.com/funkygao/log4go"
 	"github.com/samuel/go-zookeeper/zk"
@@ -41,8 +43,8 @@ func (this *manServer) subRawHandler(w http.ResponseWriter, r *http.Request, par
 		return
 	}
 
-	if err := manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
-		hisAppid, topic, group); err != nil {
+	var err error
+	if myAppid, err = this.authSub(r, hisAppid, topic, group); err != nil {
 		log.Error("sub raw[%s] %s {topic:%s, ver:%s, hisapp:%s}: %s",
 			myAppid, r.RemoteAddr, topic, ver, hisAppid, err)
 
@@ -312,7 +314,12 @@ LOOP:
 			return
 
 		case msg := <-msgChan:
-			msgs = append(msgs, msg.Value)
+			// peek the message body without envelope
+			if _, bodyIdx, err := DecodeMessage(msg.Value); err == nil {
+				msgs = append(msgs, msg.Value[bodyIdx:])
+			} else {
+				msgs = append(msgs, msg.Value)
+			}
 
 			n++
 			if n >= lastN {
@@ -371,8 +378,7 @@ func (this *manServer) resetSubOffsetHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	if err = manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
-		hisAppid, topic, group); err != nil {
+	if myAppid, err = this.authSub(r, hisAppid, topic, group); err != nil {
 		log.Error("sub reset offset[%s] %s(%s) {app:%s topic:%s ver:%s partition:%s group:%s offset:%s} %v",
 			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, partition, group, offset, err)
 
@@ -427,8 +433,7 @@ func (this *manServer) delSubGroupHandler(w http.ResponseWriter, r *http.Request
 	myAppid = r.Header.Get(HttpHeaderAppid)
 	realIp := getHttpRemoteIp(r)
 
-	if err = manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
-		hisAppid, topic, group); err != nil {
+	if myAppid, err = this.authSub(r, hisAppid, topic, group); err != nil {
 		log.Error("unsub[%s] %s(%s) {app:%s, topic:%s, ver:%s, group:%s} %v",
 			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group, err)
 
@@ -476,7 +481,8 @@ func (this *manServer) delSubGroupHandler(w http.ResponseWriter, r *http.Request
 	w.Write(ResponseOk)
 }
 
-// @rest POST /v1/shadow/:appid/:topic/:ver/:group?replicas=2
+// @rest POST /v1/shadow/:appid/:topic/:ver/:group?replicas=2&retry.backoff=10s,1m,10m
+// retry.backoff enables redelivery of the messages buried to retry shadow topic after each delay.
 func (this *manServer) addTopicShadowHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	var (
 		topic    string
@@ -501,8 +507,7 @@ func (this *manServer) addTopicShadowHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	if err = manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
-		hisAppid, topic, group); err != nil {
+	if myAppid, err = this.authSub(r, hisAppid, topic, group); err != nil {
 		log.Error("shadow+ [%s/%s] %s(%s) %s.%s.%s %v", myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, err)
 
 		writeAuthFailure(w, err)
@@ -526,6 +531,12 @@ func (this *manServer) addTopicShadowHandler(w http.ResponseWriter, r *http.Requ
 		writeBadRequest(w, err.Error())
 		return
 	}
+	if backoff := query.Get(sla.SlaKeyRetryBackoff); backoff != "" {
+		if err = ts.ParseRetryBackoff(backoff); err != nil {
+			writeBadRequest(w, fmt.Sprintf("%s: %v", sla.SlaKeyRetryBackoff, err))
+			return
+		}
+	}
 	zkcluster := meta.Default.ZkCluster(cluster)
 	shadowTopics := []string{
 		manager.Default.ShadowTopic(sla.SlaKeyRetryTopic, myAppid, hisAppid, topic, ver, group),
@@ -558,6 +569,21 @@ func (this *manServer) addTopicShadowHandler(w http.ResponseWriter, r *http.Requ
 		}
 	}
 
+	if len(ts.RetryBackoff) > 0 {
+		retry := gzk.RetryMeta{
+			Cluster: cluster,
+			Backoff: ts.DumpRetryBackoff(),
+			Dead:    shadowTopics[1],
+		}
+		if err = this.gw.zkzone.CreateOrUpdateRetry(shadowTopics[0], retry); err != nil {
+			log.Error("shadow+ [%s/%s] %s(%s) %s.%s.%s %s: %v", myAppid, group, r.RemoteAddr, realIp,
+				hisAppid, topic, ver, shadowTopics[0], err)
+
+			writeServerError(w, err.Error())
+			return
+		}
+	}
+
 	w.WriteHeader(http.StatusCreated)
 	w.Write(ResponseOk)
 }
@@ -588,8 +614,7 @@ func (this *manServer) subStatusHandler(w http.ResponseWriter, r *http.Request,
 	hisAppid = params.ByName(UrlParamAppid)
 	myAppid = r.Header.Get(HttpHeaderAppid)
 
-	if err = manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
-		hisAppid, topic, group); err != nil {
+	if myAppid, err = this.authSub(r, hisAppid, topic, group); err != nil {
 		log.Error("sub status[%s] %s(%s) {app:%s, topic:%s, ver:%s, group:%s} %v",
 			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, group, err)
 
@@ -639,7 +664,7 @@ func (this *manServer) subdStatusHandler(w http.ResponseWriter, r *http.Request,
 	topic = params.ByName(UrlParamTopic)
 	myAppid = r.Header.Get(HttpHeaderAppid)
 
-	if err = manager.Default.OwnTopic(myAppid, r.Header.Get(HttpHeaderSubkey), topic); err != nil {
+	if myAppid, err = this.ownTopic(r, topic); err != nil {
 		log.Error("subd status[%s] %s(%s) {topic:%s, ver:%s} %v",
 			myAppid, r.RemoteAddr, realIp, topic, ver, err)
 
@@ -674,8 +699,8 @@ func (this *manServer) subdStatusHandler(w http.ResponseWriter, r *http.Request,
 func (this *manServer) appSubStatusHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	var (
 		myAppid = r.Header.Get(HttpHeaderAppid)
-		subkey  = r.Header.Get(HttpHeaderSubkey)
 		realIp  = getHttpRemoteIp(r)
+		err     error
 	)
 
 	if !this.throttleSubStatus.Pour(realIp, 1) {
@@ -683,7 +708,7 @@ func (this *manServer) appSubStatusHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if err := manager.Default.Auth(myAppid, subkey); err != nil {
+	if myAppid, err = this.authenticate(r); err != nil {
 		writeAuthFailure(w, err)
 		return
 	}
@@ -0,0 +1,77 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/zk"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+//go:generate goannotation $GOFILE
+// @rest PUT /v1/xa/:appid/:topic/:ver
+// Body: {"checkback": "https://producer/xa/checkback"}
+// Only the admin registers the checkback url, which actord GETs to resolve the prepared messages.
+func (this *manServer) registerXaHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	topic := params.ByName(UrlParamTopic)
+	if !manager.Default.ValidateTopicName(topic) {
+		log.Warn("illegal topic: %s", topic)
+
+		writeBadRequest(w, "illegal topic")
+		return
+	}
+
+	hisAppid := params.ByName(UrlParamAppid)
+	myAppid := r.Header.Get(HttpHeaderAppid)
+	ver := params.ByName(UrlParamVersion)
+	realIp := getHttpRemoteIp(r)
+
+	if !this.authAdmin(r) {
+		log.Warn("suspicous +xa[%s] %s(%s) {app:%s topic:%s ver:%s UA:%s}",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"))
+
+		writeAuthFailure(w, manager.ErrAuthenticationFail)
+		return
+	}
+
+	if _, found := manager.Default.LookupCluster(hisAppid); !found {
+		log.Error("+xa[%s] %s(%s) {app:%s topic:%s ver:%s} invalid appid",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver)
+
+		writeBadRequest(w, "invalid appid")
+		return
+	}
+
+	var xa zk.XaMeta
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&xa); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+	r.Body.Close()
+
+	if u, err := url.Parse(xa.Checkback); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Warn("+xa[%s] %s(%s) {app:%s topic:%s ver:%s} invalid checkback: %s",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, xa.Checkback)
+
+		writeBadRequest(w, "invalid checkback")
+		return
+	}
+
+	rawTopic := manager.Default.KafkaTopic(hisAppid, topic, ver)
+	if err := this.gw.zkzone.CreateOrUpdateXa(rawTopic, xa); err != nil {
+		log.Error("+xa[%s] %s(%s) {app:%s topic:%s ver:%s} %v",
+			myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	log.Info("+xa[%s] %s(%s) {app:%s topic:%s ver:%s checkback:%s}",
+		myAppid, r.RemoteAddr, realIp, hisAppid, topic, ver, xa.Checkback)
+
+	w.Write(ResponseOk)
+}
@@ -8,8 +8,10 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
 	"github.com/funkygao/gafka/cmd/kateway/hh"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/schema"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	"github.com/funkygao/gafka/mpool"
 	"github.com/funkygao/httprouter"
@@ -25,6 +27,8 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 		ver          string
 		tag          string
 		partitionKey string
+		msgId        string
+		contentType  string
 		async        bool
 		hhDisabled   bool // hh enabled by default
 		t1           = time.Now()
@@ -47,7 +51,8 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 	topic = params.ByName(UrlParamTopic)
 	ver = params.ByName(UrlParamVersion)
 
-	if err := manager.Default.OwnTopic(appid, r.Header.Get(HttpHeaderPubkey), topic); err != nil {
+	var err error
+	if appid, err = this.ownTopic(r, topic); err != nil {
 		log.Warn("pub[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
 			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
 
@@ -88,22 +93,34 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 		return
 	}
 
-	var msg *mpool.Message
+	msgId = r.Header.Get(HttpHeaderMsgId)
+	if len(msgId) > MaxMsgIdLen {
+		log.Warn("pub[%s] %s(%s) {topic:%s ver:%s UA:%s} too big msg id: %s",
+			appid, r.RemoteAddr, realIp, topic, ver,
+			r.Header.Get("User-Agent"), msgId)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, "too big msg id", http.StatusBadRequest)
+		return
+	}
+
 	tag = r.Header.Get(HttpHeaderMsgTag)
-	if tag != "" {
-		if len(tag) > Options.MaxMsgTagLen {
-			this.respond4XX(appid, w, "too big tag", http.StatusBadRequest)
-			return
-		}
+	if len(tag) > Options.MaxMsgTagLen {
+		this.respond4XX(appid, w, "too big tag", http.StatusBadRequest)
+		return
+	}
 
-		msgSz := tagLen(tag) + msgLen
-		msg = mpool.NewMessage(msgSz)
-		msg.Body = msg.Body[0:msgSz]
-	} else {
-		msg = mpool.NewMessage(msgLen)
-		msg.Body = msg.Body[0:msgLen]
+	contentType = r.Header.Get(HttpHeaderContentType)
+	if len(contentType) > MaxContentTypeLen {
+		this.respond4XX(appid, w, "too big content type", http.StatusBadRequest)
+		return
 	}
 
+	headers := NewMessageHeaders(tag, msgId, contentType, r.Header.Get(HttpHeaderEnvelope) == "1")
+	msgSz := envelopeLen(headers) + msgLen
+	msg := mpool.NewMessage(msgSz)
+	msg.Body = msg.Body[0:msgSz]
+
 	// get the raw POST message, if body more than content-length ignore the extra payload
 	lbr := io.LimitReader(r.Body, Options.MaxPubSize+1)
 	if _, err := io.ReadAtLeast(lbr, msg.Body, msgLen); err != nil {
@@ -117,10 +134,33 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 		return
 	}
 
-	if tag != "" {
-		AddTagToMessage(msg, tag)
+	if schema.Default != nil {
+		if err := schema.Default.Validate(appid, topic, ver, msg.Body[:msgLen]); err != nil {
+			msg.Free()
+
+			log.Warn("pub[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+				appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
+
+			this.pubMetrics.ClientError.Inc(1)
+			this.respond4XX(appid, w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
+	// taken only by a valid message so that a malformed request never burns the quota
+	if ok, retryAfter := this.gw.takePubQuota(appid, topic, ver, 1, int64(msgLen)); !ok {
+		msg.Free()
+
+		log.Warn("pub[%s] %s(%s) {topic:%s ver:%s UA:%s} quota exceeded, retry after %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), retryAfter)
+
+		this.pubMetrics.ClientError.Inc(1)
+		writeRetryAfter(w, retryAfter)
+		return
+	}
+
+	AddEnvelopeToMessage(msg, headers)
+
 	if !Options.DisableMetrics {
 		this.pubMetrics.PubQps.Mark(1)
 		this.pubMetrics.PubMsgSize.Update(int64(len(msg.Body)))
@@ -138,61 +178,37 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 
 	var (
 		partition int32
-		offset    int64 = -1
-		err       error
+		offset    int64
+		dup       bool
+		dedupOn   = msgId != "" && dedup.Default != nil
 		rawTopic  = manager.Default.KafkaTopic(appid, topic, ver)
 	)
 
-	pubMethod := store.DefaultPubStore.SyncAllPub
 	async = query.Get("async") == "1"
-	if async {
-		pubMethod = store.DefaultPubStore.AsyncPub
-	}
-
-	ackAll := query.Get("ack") == "local"
-	if ackAll {
-		pubMethod = store.DefaultPubStore.SyncPub
-	}
-
+	ackLocal := query.Get("ack") == "local"
 	hhDisabled = query.Get("hh") == "n" // yes | no
 
-	msgKey := []byte(partitionKey)
-	if ackAll {
-		// hh not applied
-		partition, offset, err = pubMethod(cluster, rawTopic, msgKey, msg.Body)
-	} else if Options.AllwaysHintedHandoff {
-		err = hh.Default.Append(cluster, rawTopic, msgKey, msg.Body)
-	} else if !hhDisabled && Options.EnableHintedHandoff && !hh.Default.Empty(cluster, rawTopic) {
-		err = hh.Default.Append(cluster, rawTopic, msgKey, msg.Body)
-	} else if async {
-		if !hhDisabled && Options.EnableHintedHandoff {
-			// async uses hinted handoff mechanism to save memory overhead
-			err = hh.Default.Append(cluster, rawTopic, msgKey, msg.Body)
-		} else {
-			// message pool can't be applied on async pub because
-			// we don't know when to recycle the memory
-			body := make([]byte, 0, len(msg.Body))
-			copy(body, msg.Body)
-			partition, offset, err = pubMethod(cluster, rawTopic, msgKey, body)
-		}
-	} else {
-		// hack byte string conv TODO
-		partition, offset, err = pubMethod(cluster, rawTopic, msgKey, msg.Body)
-		if err != nil {
-			// sarama didn't reset this, so I have to handle it
-			offset = -1
-		}
-		if err != nil && store.DefaultPubStore.IsSystemError(err) && !hhDisabled && Options.EnableHintedHandoff {
-			log.Warn("pub[%s] %s(%s) {%s.%s.%s UA:%s} resort hh for: %v", appid, r.RemoteAddr, realIp,
-				appid, topic, ver, r.Header.Get("User-Agent"), err)
-
-			err = hh.Default.Append(cluster, rawTopic, msgKey, msg.Body)
-			// async = true
+	if dedupOn {
+		partition, offset, dup, err = dedup.Default.Claim(rawTopic, msgId)
+	}
+	if err == nil && !dup {
+		partition, offset, err = this.pubMessage(appid, topic, ver, cluster, rawTopic, msgId,
+			[]byte(partitionKey), msg.Body, async, ackLocal, hhDisabled)
+
+		if dedupOn {
+			if err != nil {
+				// the store might have accepted it, but we have no idea of the offset
+				dedup.Default.Release(rawTopic, msgId)
+			} else {
+				dedup.Default.Commit(rawTopic, msgId, partition, offset)
+			}
 		}
 	}
 
 	if err != nil {
 		log.Error("pub[%s] %s(%s) {topic:%s.%s err:%s} '%s'", appid, r.RemoteAddr, realIp, topic, ver, err, string(msg.Body))
+	} else if dup {
+		log.Debug("pub[%s] %s(%s) {topic:%s.%s id:%s} duplicated {P:%d O:%d}", appid, r.RemoteAddr, realIp, topic, ver, msgId, partition, offset)
 	} else if Options.AuditPub && offset > -1 {
 		this.auditor.Trace("pub[%s] %s(%s) {%s.%s.%s UA:%s} {P:%d O:%d} a=%v",
 			appid, r.RemoteAddr, realIp, appid, topic, ver, r.Header.Get("User-Agent"), partition, offset, async)
@@ -205,7 +221,9 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 			this.pubMetrics.PubFail(appid, topic, ver)
 		}
 
-		if store.DefaultPubStore.IsSystemError(err) {
+		if err == dedup.ErrInProgress {
+			this.respond4XX(appid, w, err.Error(), http.StatusConflict)
+		} else if store.DefaultPubStore.IsSystemError(err) {
 			this.pubMetrics.InternalErr.Inc(1)
 			writeServerError(w, err.Error())
 		} else {
@@ -233,3 +251,54 @@ func (this *pubServer) pubHandler(w http.ResponseWriter, r *http.Request, params
 	}
 
 }
+
+// pubMessage publishes a keyed message to the underlying store, resorting to
+// hinted handoff when the store is unavailable.
+// msgId is optional and is kept in hinted handoff to avoid duplicated delivery.
+// On error, offset is always -1.
+func (this *pubServer) pubMessage(appid, topic, ver, cluster, rawTopic, msgId string, key, body []byte,
+	async, ackLocal, hhDisabled bool) (partition int32, offset int64, err error) {
+	offset = -1
+
+	pubMethod := store.DefaultPubStore.SyncAllPub
+	if async {
+		pubMethod = store.DefaultPubStore.AsyncPub
+	}
+	if ackLocal {
+		pubMethod = store.DefaultPubStore.SyncPub
+	}
+
+	if ackLocal {
+		// hh not applied
+		partition, offset, err = pubMethod(cluster, rawTopic, key, body)
+	} else if Options.AllwaysHintedHandoff {
+		err = hh.Default.AppendWithId(cluster, rawTopic, msgId, key, body)
+	} else if !hhDisabled && Options.EnableHintedHandoff && !hh.Default.Empty(cluster, rawTopic) {
+		err = hh.Default.AppendWithId(cluster, rawTopic, msgId, key, body)
+	} else if async {
+		if !hhDisabled && Options.EnableHintedHandoff {
+			// async uses hinted handoff mechanism to save memory overhead
+			err = hh.Default.AppendWithId(cluster, rawTopic, msgId, key, body)
+		} else {
+			// message pool can't be applied on async pub because
+			// we don't know when to recycle the memory
+			b := make([]byte, len(body))
+			copy(b, body)
+			partition, offset, err = pubMethod(cluster, rawTopic, key, b)
+		}
+	} else {
+		partition, offset, err = pubMethod(cluster, rawTopic, key, body)
+		if err != nil && store.DefaultPubStore.IsSystemError(err) && !hhDisabled && Options.EnableHintedHandoff {
+			log.Warn("pub[%s] {%s.%s.%s} resort hh for: %v", appid, appid, topic, ver, err)
+
+			err = hh.Default.AppendWithId(cluster, rawTopic, msgId, key, body)
+		}
+	}
+
+	if err != nil {
+		// sarama didn't reset this, so I have to handle it
+		offset = -1
+	}
+
+	return
+}
@@ -0,0 +1,304 @@
+// +build !fasthttp
+
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/hh"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/schema"
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	"github.com/funkygao/gafka/mpool"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+//go:generate goannotation $GOFILE
+// @rest POST /v1/msgs/:topic/:ver/batch?ack=local&hh=n
+// Body is either json array(Content-Type: application/json) or BatchMessageSet.
+// Response is json array of PubResult in the same order as the messages.
+func (this *pubServer) pubBatchHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var (
+		appid      string
+		topic      string
+		ver        string
+		ackLocal   bool
+		hhDisabled bool // hh enabled by default
+		msgs       []BatchMessage
+		err        error
+		t1         = time.Now()
+	)
+
+	if !Options.DisableMetrics {
+		this.pubMetrics.PubTryQps.Mark(1)
+	}
+
+	realIp := getHttpRemoteIp(r)
+	appid = r.Header.Get(HttpHeaderAppid)
+	topic = params.ByName(UrlParamTopic)
+	ver = params.ByName(UrlParamVersion)
+
+	if appid, err = this.ownTopic(r, topic); err != nil {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if r.ContentLength > Options.MaxPubBatchBytes {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} too big content length: %d",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), r.ContentLength)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, ErrTooBigMessage.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, 4<<10))
+	if _, err = buf.ReadFrom(io.LimitReader(r.Body, Options.MaxPubBatchBytes+1)); err != nil {
+		log.Error("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(buf.Len()) > Options.MaxPubBatchBytes {
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, ErrTooBigMessage.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		msgs, err = decodeJsonBatchMessages(buf.Bytes())
+	} else {
+		msgs, err = DecodeBatchMessages(buf.Bytes())
+	}
+	if err != nil {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case len(msgs) == 0:
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, "empty batch", http.StatusBadRequest)
+		return
+
+	case len(msgs) > Options.MaxPubBatchSize:
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} too big batch: %d",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), len(msgs))
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, "too big batch", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if Options.Ratelimit && !this.throttlePub.Pour(realIp, int64(len(msgs))) {
+		log.Warn("pub batch[%s] %s(%s) rate limit reached: %d/s", appid, r.RemoteAddr, realIp, Options.PubQpsLimit)
+
+		this.pubMetrics.ClientError.Inc(1)
+		writeQuotaExceeded(w)
+		return
+	}
+
+	for i, m := range msgs {
+		var reason string
+		switch {
+		case len(m.Key) > MaxPartitionKeyLen:
+			reason = "too big key"
+		case len(m.Tag) > Options.MaxMsgTagLen:
+			reason = "too big tag"
+		case int64(len(m.Value)) > Options.MaxPubSize:
+			reason = ErrTooBigMessage.Error()
+		case len(m.Value) < Options.MinPubSize:
+			reason = ErrTooSmallMessage.Error()
+		case schema.Default != nil:
+			if err = schema.Default.Validate(appid, topic, ver, m.Value); err != nil {
+				reason = err.Error()
+			}
+		}
+
+		if reason != "" {
+			log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} #%d %s",
+				appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), i, reason)
+
+			this.pubMetrics.ClientError.Inc(1)
+			this.respond4XX(appid, w, fmt.Sprintf("#%d %s", i, reason), http.StatusBadRequest)
+			return
+		}
+	}
+
+	contentType := r.Header.Get(HttpHeaderContentType) // applies to all messages
+	envelope := r.Header.Get(HttpHeaderEnvelope) == "1"
+	if len(contentType) > MaxContentTypeLen {
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, "too big content type", http.StatusBadRequest)
+		return
+	}
+
+	// taken only by a valid batch so that a malformed request never burns the quota
+	if ok, retryAfter := this.gw.takePubQuota(appid, topic, ver, int64(len(msgs)), int64(buf.Len())); !ok {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} quota exceeded, retry after %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), retryAfter)
+
+		this.pubMetrics.ClientError.Inc(1)
+		writeRetryAfter(w, retryAfter)
+		return
+	}
+
+	cluster, found := manager.Default.LookupCluster(appid)
+	if !found {
+		log.Warn("pub batch[%s] %s(%s) {topic:%s ver:%s UA:%s} cluster not found",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"))
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, "invalid appid", http.StatusBadRequest)
+		return
+	}
+
+	// enveloped messages need their own buffer
+	var (
+		pubMsgs  = make([]*store.PubMessage, len(msgs))
+		pooled   = make([]*mpool.Message, 0, len(msgs))
+		rawTopic = manager.Default.KafkaTopic(appid, topic, ver)
+	)
+	for i, m := range msgs {
+		pubMsgs[i] = &store.PubMessage{Key: m.Key, Value: m.Value}
+		if headers := NewMessageHeaders(m.Tag, "", contentType, envelope); headers != nil {
+			msgSz := envelopeLen(headers) + len(m.Value)
+			msg := mpool.NewMessage(msgSz)
+			msg.Body = msg.Body[0:msgSz]
+			copy(msg.Body, m.Value)
+			AddEnvelopeToMessage(msg, headers)
+
+			pubMsgs[i].Value = msg.Body
+			pooled = append(pooled, msg)
+		}
+
+		if !Options.DisableMetrics {
+			this.pubMetrics.PubQps.Mark(1)
+			this.pubMetrics.PubMsgSize.Update(int64(len(pubMsgs[i].Value)))
+		}
+	}
+
+	query := r.URL.Query()
+	ackLocal = query.Get("ack") == "local"
+	hhDisabled = query.Get("hh") == "n"
+
+	err = this.pubMessages(appid, topic, ver, cluster, rawTopic, pubMsgs, ackLocal, hhDisabled)
+
+	for _, msg := range pooled {
+		msg.Free()
+	}
+
+	if err != nil {
+		log.Error("pub batch[%s] %s(%s) {topic:%s.%s batch:%d err:%s}", appid, r.RemoteAddr, realIp, topic, ver, len(msgs), err)
+
+		if !Options.DisableMetrics {
+			this.pubMetrics.PubFail(appid, topic, ver)
+		}
+
+		if store.DefaultPubStore.IsSystemError(err) {
+			this.pubMetrics.InternalErr.Inc(1)
+			writeServerError(w, err.Error())
+		} else {
+			this.respond4XX(appid, w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	results := make([]PubResult, len(pubMsgs))
+	for i, m := range pubMsgs {
+		results[i].Partition, results[i].Offset = m.Partition, m.Offset
+		if m.Err != nil {
+			results[i].Errmsg = m.Err.Error()
+
+			if !Options.DisableMetrics {
+				this.pubMetrics.PubFail(appid, topic, ver)
+			}
+			continue
+		}
+
+		if Options.AuditPub && m.Offset > -1 {
+			this.auditor.Trace("pub batch[%s] %s(%s) {%s.%s.%s UA:%s} {P:%d O:%d}",
+				appid, r.RemoteAddr, realIp, appid, topic, ver, r.Header.Get("User-Agent"), m.Partition, m.Offset)
+		}
+
+		if !Options.DisableMetrics {
+			this.pubMetrics.PubOk(appid, topic, ver)
+		}
+	}
+
+	b, _ := json.Marshal(results)
+	w.WriteHeader(http.StatusCreated)
+	if _, err = w.Write(b); err != nil {
+		log.Error("%s: %v", r.RemoteAddr, err)
+		this.pubMetrics.ClientError.Inc(1)
+	}
+
+	if !Options.DisableMetrics {
+		this.pubMetrics.PubLatency.Update(time.Since(t1).Nanoseconds() / 1e6) // in ms
+	}
+}
+
+// pubMessages is the batch version of pubMessage.
+// The returned error applies to the whole batch, otherwise each message carries its own result.
+func (this *pubServer) pubMessages(appid, topic, ver, cluster, rawTopic string, msgs []*store.PubMessage,
+	ackLocal, hhDisabled bool) (err error) {
+	hhEnabled := !hhDisabled && Options.EnableHintedHandoff
+
+	if !ackLocal && (Options.AllwaysHintedHandoff || (hhEnabled && !hh.Default.Empty(cluster, rawTopic))) {
+		// keep the order with the messages already in hh
+		for _, m := range msgs {
+			m.Partition, m.Offset = -1, -1
+			m.Err = hh.Default.Append(cluster, rawTopic, m.Key, m.Value)
+		}
+		return
+	}
+
+	pubMethod := store.DefaultPubStore.SyncAllPubBatch
+	if ackLocal {
+		pubMethod = store.DefaultPubStore.SyncPubBatch
+	}
+
+	if err = pubMethod(cluster, rawTopic, msgs); err != nil {
+		if ackLocal || !hhEnabled || !store.DefaultPubStore.IsSystemError(err) {
+			return
+		}
+
+		log.Warn("pub batch[%s] {%s.%s.%s} resort hh for: %v", appid, appid, topic, ver, err)
+
+		for _, m := range msgs {
+			m.Partition, m.Offset = -1, -1
+			m.Err = hh.Default.Append(cluster, rawTopic, m.Key, m.Value)
+		}
+		return nil
+	}
+
+	if ackLocal || !hhEnabled {
+		return
+	}
+
+	// partial failure
+	for _, m := range msgs {
+		if m.Err != nil && store.DefaultPubStore.IsSystemError(m.Err) {
+			m.Err = hh.Default.Append(cluster, rawTopic, m.Key, m.Value)
+		}
+	}
+
+	return
+}
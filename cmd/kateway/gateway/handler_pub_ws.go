@@ -3,21 +3,275 @@
 package gateway
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/schema"
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	"github.com/funkygao/gafka/mpool"
 	"github.com/funkygao/httprouter"
 	log "github.com/funkygao/log4go"
+	"github.com/gorilla/websocket"
 )
 
 //go:generate goannotation $GOFILE
-// @rest POST /v1/ws/msgs/:topic/:ver
-// TODO not implemented yet
+// @rest GET /v1/ws/msgs/:topic/:ver?key=mykey&async=1&ack=local&hh=n
+// Each data frame the client sends is a message and is acked in order with a json PubResult frame.
+// key, tag and content type apply to all messages of the connection.
 func (this *pubServer) pubWsHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	var (
+		appid        string
+		topic        string
+		ver          string
+		tag          string
+		contentType  string
+		partitionKey string
+		envelope     bool // envelope even without tag
+		async        bool
+		ackLocal     bool
+		hhDisabled   bool // hh enabled by default
+	)
+
+	realIp := getHttpRemoteIp(r)
+	appid = r.Header.Get(HttpHeaderAppid)
+	topic = params.ByName(UrlParamTopic)
+	ver = params.ByName(UrlParamVersion)
+
+	// auth before upgrade so that client can get a meaningful http status
+	var err error
+	if appid, err = this.ownTopic(r, topic); err != nil {
+		log.Warn("pub ws[%s] %s(%s) {topic:%s ver:%s UA:%s} %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), err)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	partitionKey = query.Get("key")
+	if len(partitionKey) > MaxPartitionKeyLen {
+		log.Warn("pub ws[%s] %s(%s) {topic:%s ver:%s UA:%s} too big key: %s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), partitionKey)
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, "too big key", http.StatusBadRequest)
+		return
+	}
+
+	tag = r.Header.Get(HttpHeaderMsgTag)
+	if len(tag) > Options.MaxMsgTagLen {
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, "too big tag", http.StatusBadRequest)
+		return
+	}
+
+	contentType = r.Header.Get(HttpHeaderContentType)
+	if len(contentType) > MaxContentTypeLen {
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, "too big content type", http.StatusBadRequest)
+		return
+	}
+	envelope = r.Header.Get(HttpHeaderEnvelope) == "1"
+
+	cluster, found := manager.Default.LookupCluster(appid)
+	if !found {
+		log.Warn("pub ws[%s] %s(%s) {topic:%s ver:%s UA:%s} cluster not found",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"))
+
+		this.pubMetrics.ClientError.Inc(1)
+		this.respond4XX(appid, w, "invalid appid", http.StatusBadRequest)
+		return
+	}
+
+	async = query.Get("async") == "1"
+	ackLocal = query.Get("ack") == "local"
+	hhDisabled = query.Get("hh") == "n"
+
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Error("%s: %v", r.RemoteAddr, err)
+		log.Error("pub ws[%s] %s(%s): %v", appid, r.RemoteAddr, realIp, err)
 		return
 	}
 
-	defer ws.Close()
+	if !Options.DisableMetrics {
+		this.gw.svrMetrics.ConcurrentPubWs.Inc(1)
+	}
+
+	defer func() {
+		ws.Close()
+
+		if !Options.DisableMetrics {
+			this.gw.svrMetrics.ConcurrentPubWs.Dec(1)
+		}
+	}()
+
+	log.Debug("pub ws[%s] %s(%s) {topic:%s ver:%s key:%s tag:%s UA:%s} connected",
+		appid, r.RemoteAddr, realIp, topic, ver, partitionKey, tag, r.Header.Get("User-Agent"))
+
+	// kateway             pub client
+	//   |                    |
+	//   |                msg |
+	//   |<-------------------|
+	//   | ack                |
+	//   |------------------->|
+	//   |                    |
+	//   | ping               |
+	//   |------------------->|
+	//   |               pong |
+	//   |<-------------------|
+	//   |                    |
+
+	clientGone := make(chan struct{})
+	go this.wsPingPump(clientGone, ws)
+
+	var (
+		rawTopic = manager.Default.KafkaTopic(appid, topic, ver)
+		msgKey   = []byte(partitionKey)
+		ack      PubResult
+		body     []byte
+	)
+
+	ws.SetReadLimit(Options.MaxPubSize)
+	ws.SetReadDeadline(time.Now().Add(this.wsPongWait))
+	ws.SetPongHandler(func(string) error {
+		ws.SetReadDeadline(time.Now().Add(this.wsPongWait))
+		return nil
+	})
+
+	defer close(clientGone)
+
+	for {
+		_, body, err = ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Warn("pub ws[%s] %s(%s) {topic:%s ver:%s}: %v", appid, r.RemoteAddr, realIp, topic, ver, err)
+			} else {
+				log.Debug("pub ws[%s] %s(%s) {topic:%s ver:%s}: %v", appid, r.RemoteAddr, realIp, topic, ver, err)
+			}
+
+			return
+		}
+
+		ws.SetReadDeadline(time.Now().Add(this.wsPongWait))
+
+		t1 := time.Now()
+		if !Options.DisableMetrics {
+			this.pubMetrics.PubTryQps.Mark(1)
+		}
+
+		ack.Partition, ack.Offset = -1, -1
+
+		if ack.Errmsg = this.checkWsFrame(r, appid, topic, ver, realIp, body); ack.Errmsg == "" {
+			headers := NewMessageHeaders(tag, "", contentType, envelope)
+			msgSz := envelopeLen(headers) + len(body)
+			msg := mpool.NewMessage(msgSz)
+			msg.Body = msg.Body[0:msgSz]
+			copy(msg.Body, body)
+			AddEnvelopeToMessage(msg, headers)
+
+			if !Options.DisableMetrics {
+				this.pubMetrics.PubQps.Mark(1)
+				this.pubMetrics.PubMsgSize.Update(int64(len(msg.Body)))
+			}
+
+			ack.Partition, ack.Offset, err = this.pubMessage(appid, topic, ver, cluster, rawTopic, "",
+				msgKey, msg.Body, async, ackLocal, hhDisabled)
+			if err != nil {
+				log.Error("pub ws[%s] %s(%s) {topic:%s.%s err:%s} '%s'", appid, r.RemoteAddr, realIp, topic, ver, err, string(msg.Body))
+
+				if !Options.DisableMetrics {
+					this.pubMetrics.PubFail(appid, topic, ver)
+				}
+				if store.DefaultPubStore.IsSystemError(err) {
+					this.pubMetrics.InternalErr.Inc(1)
+				}
+
+				ack.Errmsg = err.Error()
+			} else {
+				if Options.AuditPub && ack.Offset > -1 {
+					this.auditor.Trace("pub ws[%s] %s(%s) {%s.%s.%s UA:%s} {P:%d O:%d} a=%v",
+						appid, r.RemoteAddr, realIp, appid, topic, ver, r.Header.Get("User-Agent"), ack.Partition, ack.Offset, async)
+				}
+
+				if !Options.DisableMetrics {
+					this.pubMetrics.PubOk(appid, topic, ver)
+					this.pubMetrics.PubLatency.Update(time.Since(t1).Nanoseconds() / 1e6) // in ms
+				}
+			}
+
+			msg.Free()
+		}
+
+		ws.SetWriteDeadline(time.Now().Add(time.Second * 10))
+		if err = ws.WriteJSON(&ack); err != nil {
+			log.Error("pub ws[%s] %s(%s): %v", appid, r.RemoteAddr, realIp, err)
+			return
+		}
+	}
+}
+
+// checkWsFrame checks a published ws frame in the same order as Pub, the quota is taken
+// last so that a rejected frame never burns it.
+// Returns the error message to ack, empty if the frame can be published.
+func (this *pubServer) checkWsFrame(r *http.Request, appid, topic, ver, realIp string, body []byte) string {
+	if Options.Ratelimit && !this.throttlePub.Pour(realIp, 1) {
+		log.Warn("pub ws[%s] %s(%s) rate limit reached: %d/s", appid, r.RemoteAddr, realIp, Options.PubQpsLimit)
+
+		this.pubMetrics.ClientError.Inc(1)
+		return "quota exceeded"
+	}
+
+	if len(body) < Options.MinPubSize {
+		this.pubMetrics.ClientError.Inc(1)
+		return ErrTooSmallMessage.Error()
+	}
+
+	if schema.Default != nil {
+		if err := schema.Default.Validate(appid, topic, ver, body); err != nil {
+			log.Warn("pub ws[%s] %s(%s) {topic:%s ver:%s} %s", appid, r.RemoteAddr, realIp, topic, ver, err)
+
+			this.pubMetrics.ClientError.Inc(1)
+			return err.Error()
+		}
+	}
+
+	if ok, retryAfter := this.gw.takePubQuota(appid, topic, ver, 1, int64(len(body))); !ok {
+		log.Warn("pub ws[%s] %s(%s) {topic:%s ver:%s} quota exceeded, retry after %s",
+			appid, r.RemoteAddr, realIp, topic, ver, retryAfter)
+
+		this.pubMetrics.ClientError.Inc(1)
+		return fmt.Sprintf("quota exceeded, retry after %s", retryAfter)
+	}
+
+	return ""
+}
+
+// wsPingPump keeps the websocket publisher alive and closes the conn on shutdown.
+func (this *pubServer) wsPingPump(clientGone chan struct{}, ws *websocket.Conn) {
+	ticker := time.NewTicker(this.wsPongWait / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// WriteControl can be called concurrently with the ack writer
+			if err := ws.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(time.Second*10)); err != nil {
+				log.Debug("%s: %v", ws.RemoteAddr(), err)
+				return
+			}
+
+		case <-this.gw.shutdownCh:
+			ws.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown"),
+				time.Now().Add(time.Second))
+			ws.Close()
+			return
+
+		case <-clientGone:
+			return
+		}
+	}
 }
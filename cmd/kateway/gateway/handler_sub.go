@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/inflight"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/quota"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	"github.com/funkygao/gafka/sla"
 	"github.com/funkygao/httprouter"
@@ -15,7 +17,9 @@ import (
 )
 
 //go:generate goannotation $GOFILE
-// @rest GET /v1/msgs/:appid/:topic/:ver?group=xx&batch=10&mux=1&reset=<newest|oldest>&ack=1&q=<dead|retry>
+// @rest GET /v1/msgs/:appid/:topic/:ver?group=xx&batch=10&mux=1&reset=<newest|oldest>&ack=1&q=<dead|retry>&visibility=30
+// Batch response format is negotiated by Accept header, see Record.
+// visibility in seconds enables per message ack/nack, see ackInflightHandler.
 func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	var (
 		topic      string
@@ -33,6 +37,8 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 		offsetN    int64 = -1
 		limit      int   // max messages to include in the message set
 		delayedAck bool  // last acked partition/offset piggybacked on this request
+		visibility time.Duration
+		tagFilter  *TagFilter
 		err        error
 	)
 
@@ -84,8 +90,7 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 	hisAppid = params.ByName(UrlParamAppid)
 
 	// auth
-	if err = manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
-		hisAppid, topic, group); err != nil {
+	if myAppid, err = this.authSub(r, hisAppid, topic, group); err != nil {
 		log.Error("sub[%s/%s] -(%s): {%s.%s.%s UA:%s} %v",
 			myAppid, group, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), err)
 
@@ -93,6 +98,16 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 		writeAuthFailure(w, err)
 		return
 	}
+	realGroup = myAppid + "." + group // the authenticated app might differ from the header
+
+	if ok, retryAfter := this.gw.takeQuota(myAppid, topic, ver, quota.SubBytes, 0); !ok {
+		log.Warn("sub[%s/%s] %s(%s) {%s.%s.%s UA:%s} quota exceeded, retry after %s",
+			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), retryAfter)
+
+		this.subMetrics.ClientError.Mark(1)
+		writeRetryAfter(w, retryAfter)
+		return
+	}
 
 	// fetch the client ack partition and offset
 	delayedAck = query.Get("ack") == "1"
@@ -136,6 +151,34 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 		}
 	}
 
+	if v, _ := getHttpQueryInt(&query, "visibility", 0); v > 0 {
+		visibility = time.Duration(v) * time.Second
+		switch {
+		case inflight.Default == nil:
+			this.subMetrics.ClientError.Mark(1)
+			writeBadRequest(w, "visibility timeout not supported")
+			return
+
+		case delayedAck:
+			this.subMetrics.ClientError.Mark(1)
+			writeBadRequest(w, "visibility timeout conflicts with ack=1")
+			return
+
+		case visibility > MaxVisibilityTimeout:
+			visibility = MaxVisibilityTimeout
+		}
+	}
+
+	// compile the tag filter once for all messages
+	if tagFilter, err = CompileTagFilter(r.Header.Get(HttpHeaderMsgTag)); err != nil {
+		log.Error("sub[%s/%s] %s(%s) {%s.%s.%s UA:%s} tag:%s %v",
+			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), r.Header.Get(HttpHeaderMsgTag), err)
+
+		this.subMetrics.ClientError.Mark(1)
+		writeBadRequest(w, err.Error())
+		return
+	}
+
 	shadow = query.Get("q")
 
 	log.Debug("sub[%s/%s] %s(%s) {%s.%s.%s q:%s batch:%d ack:%s P:%s O:%s UA:%s}",
@@ -181,6 +224,25 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 		return
 	}
 
+	if visibility > 0 {
+		// the visible inflight messages go first
+		var n int
+		n, err = this.redeliverMessages(w, r, realIp, cluster, rawTopic, limit, myAppid, hisAppid, topic, ver, group, visibility)
+		if err != nil {
+			log.Error("sub[%s/%s] %s(%s) {%s UA:%s} redeliver: %v",
+				myAppid, group, r.RemoteAddr, realIp, rawTopic, r.Header.Get("User-Agent"), err)
+
+			if n == 0 {
+				this.subMetrics.ServerError.Mark(1)
+				writeServerError(w, err.Error())
+			}
+			return
+		}
+		if n > 0 {
+			return
+		}
+	}
+
 	fetcher, err := store.DefaultSubStore.Fetch(cluster, rawTopic,
 		realGroup, r.RemoteAddr, realIp, reset, Options.PermitStandbySub, query.Get("mux") == "1")
 	if err != nil {
@@ -226,7 +288,8 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 
 	var gz *gzip.Writer
 	w, gz = gzipWriter(w, r)
-	err = this.pumpMessages(w, r, realIp, fetcher, limit, myAppid, hisAppid, topic, ver, group, delayedAck)
+	err = this.pumpMessages(w, r, realIp, fetcher, limit, myAppid, hisAppid, topic, ver, group, delayedAck,
+		tagFilter, cluster, visibility)
 	if err != nil {
 		// e,g. broken pipe, io timeout, client gone
 		// e,g. kafka: error while consuming app1.foobar.v1/0: EOF (kafka was shutdown)
@@ -278,33 +341,24 @@ func (this *subServer) subHandler(w http.ResponseWriter, r *http.Request, params
 }
 
 func (this *subServer) pumpMessages(w http.ResponseWriter, r *http.Request, realIp string,
-	fetcher store.Fetcher, limit int, myAppid, hisAppid, topic, ver, group string, delayedAck bool) error {
+	fetcher store.Fetcher, limit int, myAppid, hisAppid, topic, ver, group string, delayedAck bool,
+	tagFilter *TagFilter, cluster string, visibility time.Duration) error {
 	cn, ok := w.(http.CloseNotifier)
 	if !ok {
 		return ErrBadResponseWriter
 	}
 
 	var (
-		metaBuf       []byte = nil
-		n                    = 0
-		idleTimeout          = Options.SubTimeout
-		chunkedEver          = false
-		tagConditions        = make(map[string]struct{})
-		clientGoneCh         = cn.CloseNotify()
-		startedAt            = time.Now()
+		bw           *batchWriter
+		n            = 0
+		idleTimeout  = Options.SubTimeout
+		chunkedEver  = false
+		clientGoneCh = cn.CloseNotify()
+		startedAt    = time.Now()
 	)
 
-	// parse http tag header as filter condition
-	if tagFilter := r.Header.Get(HttpHeaderMsgTag); tagFilter != "" {
-		for _, t := range parseMessageTag(tagFilter) {
-			if t != "" {
-				tagConditions[t] = struct{}{}
-			}
-		}
-	}
-
 	for {
-		if len(tagConditions) > 0 && time.Since(startedAt) > idleTimeout {
+		if tagFilter != nil && time.Since(startedAt) > idleTimeout {
 			// e,g. tag filter got 1000 msgs, but no tag hit after timeout, we'll return 204
 			if chunkedEver {
 				return nil
@@ -370,70 +424,57 @@ func (this *subServer) pumpMessages(w http.ResponseWriter, r *http.Request, real
 				w.Header().Set(HttpHeaderOffset, strconv.FormatInt(msg.Offset, 10))
 			}
 
-			var (
-				tags    []string
-				bodyIdx int
-				err     error
-			)
-			if IsTaggedMessage(msg.Value) {
-				tags, bodyIdx, err = ExtractMessageTag(msg.Value)
-				if err != nil {
-					// always move offset cursor ahead, otherwise will be blocked forever
-					fetcher.CommitUpto(msg)
+			headers, bodyIdx, err := DecodeMessage(msg.Value)
+			if err != nil {
+				// always move offset cursor ahead, otherwise will be blocked forever
+				fetcher.CommitUpto(msg)
 
-					return err
-				}
+				return err
 			}
 
-			// assert tag conditions are satisfied. if empty, feed all messages
-			if len(tagConditions) > 0 {
-				tagSatisfied := false
-				for _, t := range tags {
-					if _, present := tagConditions[t]; present {
-						tagSatisfied = true
-						break
-					}
+			if headers.RetryScheduled() {
+				// actord will redeliver it when the retry backoff is due
+				if !delayedAck {
+					fetcher.CommitUpto(msg)
 				}
 
-				if !tagSatisfied {
-					if !delayedAck {
-						log.Debug("sub auto commit offset with tag unmatched %s(%s) {G:%s, T:%s/%d, O:%d} %+v/%+v",
-							r.RemoteAddr, realIp, group, msg.Topic, msg.Partition, msg.Offset, tagConditions, tags)
+				continue
+			}
 
-						fetcher.CommitUpto(msg)
-					}
+			// assert tag filter is satisfied. if empty, feed all messages
+			if !tagFilter.Match(headers.Tags()) {
+				if !delayedAck {
+					log.Debug("sub auto commit offset with tag unmatched %s(%s) {G:%s, T:%s/%d, O:%d} %s",
+						r.RemoteAddr, realIp, group, msg.Topic, msg.Partition, msg.Offset, tagFilter)
 
-					continue
+					fetcher.CommitUpto(msg)
+				}
+
+				continue
+			}
+
+			if visibility > 0 {
+				// the message is acked by the inflight store thereafter
+				if err = inflight.Default.TakeOff(cluster, msg.Topic, myAppid+"."+group, msg.Partition, msg.Offset,
+					msg.Key, msg.Value, visibility); err != nil {
+					return err
 				}
 			}
 
 			if limit == 1 {
+				headers.WriteHttpHeader(w.Header())
+
 				// non-batch mode, just the message itself without meta
 				if _, err = w.Write(msg.Value[bodyIdx:]); err != nil {
 					// when remote close silently, the write still ok
 					return err
 				}
 			} else {
-				// batch mode, write MessageSet
-				// MessageSet => [Partition(int32) Offset(int64) MessageSize(int32) Message] BigEndian
-				if metaBuf == nil {
-					// initialize the reuseable buffer
-					metaBuf = make([]byte, 8)
-
-					// override the middleware added header
-					w.Header().Set("Content-Type", "application/octet-stream")
-				}
-
-				if err = writeI32(w, metaBuf, msg.Partition); err != nil {
-					return err
-				}
-				if err = writeI64(w, metaBuf, msg.Offset); err != nil {
-					return err
-				}
-				if err = writeI32(w, metaBuf, int32(len(msg.Value[bodyIdx:]))); err != nil {
-					return err
+				// batch mode
+				if bw == nil {
+					bw = newBatchWriter(w, r.Header.Get("Accept"))
 				}
-				if _, err = w.Write(msg.Value[bodyIdx:]); err != nil {
+				if err = bw.write(msg, headers, bodyIdx); err != nil {
 					return err
 				}
 			}
@@ -462,6 +503,12 @@ func (this *subServer) pumpMessages(w http.ResponseWriter, r *http.Request, real
 			this.subMetrics.ConsumeOk(myAppid, topic, ver)
 			this.subMetrics.ConsumedOk(hisAppid, topic, ver)
 
+			// the size is known only after delivery: once the quota is exhausted, end the batch
+			// instead of fetching more messages, and the next sub waits for the Retry-After
+			if ok, _ := this.gw.takeQuota(myAppid, topic, ver, quota.SubBytes, int64(len(msg.Value)-bodyIdx)); !ok {
+				return nil
+			}
+
 			n++
 			if n >= limit {
 				return nil
@@ -41,8 +41,7 @@ func (this *subServer) ackHandler(w http.ResponseWriter, r *http.Request, params
 	hisAppid = params.ByName(UrlParamAppid)
 	myAppid = r.Header.Get(HttpHeaderAppid)
 
-	if err = manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
-		hisAppid, topic, group); err != nil {
+	if myAppid, err = this.authSub(r, hisAppid, topic, group); err != nil {
 		writeAuthFailure(w, err)
 		return
 	}
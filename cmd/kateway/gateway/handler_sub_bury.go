@@ -4,18 +4,23 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
 	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	"github.com/funkygao/gafka/sla"
 	"github.com/funkygao/httprouter"
 	log "github.com/funkygao/log4go"
+	"github.com/samuel/go-zookeeper/zk"
 )
 
 //go:generate goannotation $GOFILE
 // @rest PUT /v1/msgs/:appid/:topic/:ver?group=xx&mux=1&q=<dead|retry>
 // q=retry&X-Bury=dead means bury from retry queue to dead queue
+// Bury is idempotent for the same message within the bury dedup window, 409 if it is in progress.
+// If the retry queue has retry policy, X-Bury=retry should carry X-Retries header of the message.
 func (this *subServer) buryHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	var (
 		topic      string
@@ -56,8 +61,7 @@ func (this *subServer) buryHandler(w http.ResponseWriter, r *http.Request, param
 	}
 
 	// auth
-	if err = manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
-		hisAppid, topic, group); err != nil {
+	if myAppid, err = this.authSub(r, hisAppid, topic, group); err != nil {
 		log.Error("bury[%s/%s] %s(%s) {%s.%s.%s UA:%s} %v",
 			myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), err)
 
@@ -149,27 +153,87 @@ func (this *subServer) buryHandler(w http.ResponseWriter, r *http.Request, param
 		return
 	}
 
-	// step1: pub
 	shadowTopic := manager.Default.ShadowTopic(bury, myAppid, hisAppid, topic, ver, group)
-	_, _, err = store.DefaultPubStore.SyncPub(cluster, shadowTopic, nil, msg)
-	if err != nil {
-		log.Error("bury[%s/%s] %s(%s) %s %v", myAppid, group, r.RemoteAddr, realIp, shadowTopic, err)
+	if bury == sla.SlaKeyRetryTopic {
+		if msg, err = this.scheduleRetry(r, shadowTopic, msg); err != nil {
+			log.Error("bury[%s/%s] %s(%s) %s %v", myAppid, group, r.RemoteAddr, realIp, shadowTopic, err)
 
-		writeServerError(w, err.Error())
-		return
+			writeServerError(w, err.Error())
+			return
+		}
 	}
 
-	// step2: skip this message in the master topic TODO atomic with step1
-	if err = fetcher.CommitUpto(&sarama.ConsumerMessage{
-		Topic:     rawTopic,
-		Partition: int32(partitionN),
-		Offset:    offsetN,
-	}); err != nil {
-		log.Error("bury[%s/%s] %s(%s) %s %v", myAppid, group, r.RemoteAddr, realIp, rawTopic, err)
+	// step1: pub to shadow topic
+	// step2: skip this message in the master topic
+	// a replayed bury of the same message will not pub again
+	err = buryOnce(this.buryDedup, buryId(rawTopic, int32(partitionN), offsetN), shadowTopic,
+		func() (int32, int64, error) {
+			return store.DefaultPubStore.SyncPub(cluster, shadowTopic, nil, msg)
+		},
+		func() error {
+			return fetcher.CommitUpto(&sarama.ConsumerMessage{
+				Topic:     rawTopic,
+				Partition: int32(partitionN),
+				Offset:    offsetN,
+			})
+		})
+	if err != nil {
+		log.Error("bury[%s/%s] %s(%s) {%s P:%d O:%d} -> %s %v", myAppid, group, r.RemoteAddr, realIp,
+			rawTopic, partitionN, offsetN, shadowTopic, err)
 
-		writeServerError(w, err.Error())
+		if err == dedup.ErrInProgress {
+			_writeErrorResponse(w, err.Error(), http.StatusConflict)
+		} else {
+			writeServerError(w, err.Error())
+		}
 		return
 	}
 
 	w.Write(ResponseOk)
 }
+
+// scheduleRetry stamps the retry attempt on the message if the retry queue has retry policy,
+// then actord will redeliver it after the retry backoff.
+func (this *subServer) scheduleRetry(r *http.Request, retryTopic string, msg []byte) ([]byte, error) {
+	found, err := this.hasRetryPolicy(retryTopic)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		// no retry policy, subscriber consumes the retry queue at once
+		return msg, nil
+	}
+
+	retries, _ := strconv.Atoi(r.Header.Get(HttpHeaderRetries))
+	if retries < 0 {
+		retries = 0
+	}
+	headers := MessageHeaders{
+		HeaderRetries: strconv.Itoa(retries + 1),
+		HeaderPubTime: strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10),
+	}
+	return EncodeMessage(headers, msg), nil
+}
+
+// hasRetryPolicy checks if the retry queue has retry policy, cached for retryPolicyTTL
+// so that bury will not hit zk each time.
+func (this *subServer) hasRetryPolicy(retryTopic string) (bool, error) {
+	now := time.Now()
+	this.retryPoliciesLock.RLock()
+	e, present := this.retryPolicies[retryTopic]
+	this.retryPoliciesLock.RUnlock()
+	if present && now.Before(e.expire) {
+		return e.found, nil
+	}
+
+	_, err := this.gw.zkzone.RetryInfo(retryTopic)
+	if err != nil && err != zk.ErrNoNode {
+		return false, err
+	}
+
+	e = retryPolicyEntry{found: err == nil, expire: now.Add(retryPolicyTTL)}
+	this.retryPoliciesLock.Lock()
+	this.retryPolicies[retryTopic] = e
+	this.retryPoliciesLock.Unlock()
+	return e.found, nil
+}
@@ -0,0 +1,219 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/funkygao/gafka/cmd/kateway/inflight"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/store"
+	"github.com/funkygao/gafka/sla"
+	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
+)
+
+// redeliverMessages writes at most limit visible inflight messages of a Sub with visibility timeout.
+// Messages redelivered more than Options.MaxRedeliveries are buried to the dead letter shadow topic.
+func (this *subServer) redeliverMessages(w http.ResponseWriter, r *http.Request, realIp string,
+	cluster, rawTopic string, limit int, myAppid, hisAppid, topic, ver, group string,
+	visibility time.Duration) (n int, err error) {
+	realGroup := myAppid + "." + group
+	msgs, err := inflight.Default.Redeliver(cluster, rawTopic, realGroup, limit, visibility)
+	if err != nil {
+		if len(msgs) == 0 {
+			return
+		}
+
+		// partially redelivered, serve what we got
+		log.Warn("sub redeliver[%s/%s] %s(%s) {T:%s} %v", myAppid, group, r.RemoteAddr, realIp, rawTopic, err)
+		err = nil
+	}
+
+	var bw *batchWriter
+	for _, m := range msgs {
+		if m.Deliveries > Options.MaxRedeliveries+1 {
+			this.buryDeadMessage(r, realIp, cluster, rawTopic, myAppid, hisAppid, topic, ver, group, m)
+			continue
+		}
+
+		if Options.AuditSub {
+			this.auditor.Trace("sub redeliver[%s/%s] %s(%s) {T:%s/%d O:%d} #%d",
+				myAppid, group, r.RemoteAddr, realIp, rawTopic, m.Partition, m.Offset, m.Deliveries)
+		}
+
+		headers, bodyIdx, e := DecodeMessage(m.Value)
+		if e != nil {
+			// should never happen: the message passed DecodeMessage when taken off
+			log.Error("sub redeliver[%s/%s] %s(%s) {T:%s/%d O:%d} %v",
+				myAppid, group, r.RemoteAddr, realIp, rawTopic, m.Partition, m.Offset, e)
+			continue
+		}
+
+		msg := &sarama.ConsumerMessage{
+			Topic:     rawTopic,
+			Partition: m.Partition,
+			Offset:    m.Offset,
+			Key:       m.Key,
+			Value:     m.Value,
+		}
+		if limit == 1 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf8") // override middleware header
+			w.Header().Set(HttpHeaderMsgKey, string(msg.Key))
+			w.Header().Set(HttpHeaderPartition, strconv.FormatInt(int64(msg.Partition), 10))
+			w.Header().Set(HttpHeaderOffset, strconv.FormatInt(msg.Offset, 10))
+			w.Header().Set(HttpHeaderDeliveries, strconv.Itoa(m.Deliveries))
+			headers.WriteHttpHeader(w.Header())
+			_, err = w.Write(msg.Value[bodyIdx:])
+		} else {
+			if bw == nil {
+				bw = newBatchWriter(w, r.Header.Get("Accept"))
+			}
+			err = bw.write(msg, headers, bodyIdx)
+		}
+
+		if err != nil {
+			// the message will be redelivered after visibility timeout
+			return
+		}
+
+		n++
+		this.subMetrics.ConsumeOk(myAppid, topic, ver)
+		this.subMetrics.ConsumedOk(hisAppid, topic, ver)
+	}
+
+	return
+}
+
+func (this *subServer) buryDeadMessage(r *http.Request, realIp string, cluster, rawTopic string,
+	myAppid, hisAppid, topic, ver, group string, m inflight.Message) {
+	deadTopic := manager.Default.ShadowTopic(sla.SlaKeyDeadLetterTopic, myAppid, hisAppid, topic, ver, group)
+	if _, _, err := store.DefaultPubStore.SyncPub(cluster, deadTopic, m.Key, m.Value); err != nil {
+		// will retry on next redelivery
+		log.Error("sub bury[%s/%s] %s(%s) {T:%s/%d O:%d} -> %s %v",
+			myAppid, group, r.RemoteAddr, realIp, rawTopic, m.Partition, m.Offset, deadTopic, err)
+		return
+	}
+
+	if err := inflight.Default.Land(cluster, rawTopic, myAppid+"."+group, m.Partition, m.Offset); err != nil {
+		log.Error("sub bury[%s/%s] %s(%s) {T:%s/%d O:%d} %v",
+			myAppid, group, r.RemoteAddr, realIp, rawTopic, m.Partition, m.Offset, err)
+	}
+
+	log.Warn("sub bury[%s/%s] %s(%s) {T:%s/%d O:%d} -> %s after %d deliveries",
+		myAppid, group, r.RemoteAddr, realIp, rawTopic, m.Partition, m.Offset, deadTopic, m.Deliveries-1)
+}
+
+// @rest PUT /v1/ack/:appid/:topic/:ver?group=xx&q=<dead|retry> with json body [{"partition":0,"offset":1}]
+// Ack the messages delivered by Sub with visibility timeout.
+//
+//go:generate goannotation $GOFILE
+func (this *subServer) ackInflightHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	this.landInflight(w, r, params, false)
+}
+
+// @rest PUT /v1/nack/:appid/:topic/:ver?group=xx&q=<dead|retry> with json body [{"partition":0,"offset":1}]
+// Nack the messages delivered by Sub with visibility timeout, they will be redelivered immediately.
+func (this *subServer) nackInflightHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	this.landInflight(w, r, params, true)
+}
+
+func (this *subServer) landInflight(w http.ResponseWriter, r *http.Request, params httprouter.Params, nack bool) {
+	var (
+		topic    string
+		ver      string
+		myAppid  string
+		hisAppid string
+		group    string
+		shadow   string
+		rawTopic string
+		op       = "ack"
+		err      error
+	)
+
+	if nack {
+		op = "nack"
+	}
+
+	query := r.URL.Query()
+	group = query.Get("group")
+	ver = params.ByName(UrlParamVersion)
+	topic = params.ByName(UrlParamTopic)
+	hisAppid = params.ByName(UrlParamAppid)
+	myAppid = r.Header.Get(HttpHeaderAppid)
+	realIp := getHttpRemoteIp(r)
+
+	if inflight.Default == nil {
+		writeBadRequest(w, "visibility timeout not supported")
+		return
+	}
+
+	if !manager.Default.ValidateGroupName(r.Header, group) {
+		writeBadRequest(w, "illegal group")
+		return
+	}
+
+	if myAppid, err = this.authSub(r, hisAppid, topic, group); err != nil {
+		log.Error("%s[%s/%s] %s(%s) {%s.%s.%s UA:%s} %v",
+			op, myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	cluster, found := manager.Default.LookupCluster(hisAppid)
+	if !found {
+		writeBadRequest(w, "invalid appid")
+		return
+	}
+
+	var acks ackOffsets
+	if err = json.NewDecoder(io.LimitReader(r.Body, Options.MaxPubSize)).Decode(&acks); err != nil || len(acks) == 0 {
+		writeBadRequest(w, "invalid ack json body")
+		return
+	}
+
+	shadow = query.Get("q")
+	if shadow != "" {
+		if !sla.ValidateShadowName(shadow) {
+			writeBadRequest(w, "invalid shadow name")
+			return
+		}
+
+		rawTopic = manager.Default.ShadowTopic(shadow, myAppid, hisAppid, topic, ver, group)
+	} else {
+		rawTopic = manager.Default.KafkaTopic(hisAppid, topic, ver)
+	}
+
+	log.Debug("%s[%s/%s] %s(%s) {%s.%s.%s UA:%s} %+v",
+		op, myAppid, group, r.RemoteAddr, realIp, hisAppid, topic, ver, r.Header.Get("User-Agent"), acks)
+
+	realGroup := myAppid + "." + group
+	for _, ack := range acks {
+		if nack {
+			err = inflight.Default.Nack(cluster, rawTopic, realGroup, int32(ack.Partition), ack.Offset)
+		} else {
+			err = inflight.Default.Land(cluster, rawTopic, realGroup, int32(ack.Partition), ack.Offset)
+		}
+
+		switch err {
+		case nil:
+		case inflight.ErrNotInflight:
+			// e,g. acked twice or already buried
+			log.Warn("%s[%s/%s] %s(%s) {%s P:%d O:%d} %v",
+				op, myAppid, group, r.RemoteAddr, realIp, rawTopic, ack.Partition, ack.Offset, err)
+
+		default:
+			log.Error("%s[%s/%s] %s(%s) {%s P:%d O:%d} %v",
+				op, myAppid, group, r.RemoteAddr, realIp, rawTopic, ack.Partition, ack.Offset, err)
+
+			writeServerError(w, err.Error())
+			return
+		}
+	}
+
+	w.Write(ResponseOk)
+}
@@ -4,6 +4,7 @@ import (
 	"compress/gzip"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/gafka/cmd/kateway/store"
@@ -13,15 +14,17 @@ import (
 
 //go:generate goannotation $GOFILE
 // @rest GET /v1/raw/msgs/:cluster/:topic?group=xx&batch=10&mux=1&reset=<newest|oldest>
+// Messages are raw, tags are not stripped even when filtered by X-Tag.
 func (this *subServer) subRawHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	var (
-		cluster string
-		topic   string
-		myAppid string
-		reset   string
-		group   string
-		limit   int // max messages to include in the message set
-		err     error
+		cluster   string
+		topic     string
+		myAppid   string
+		reset     string
+		group     string
+		limit     int // max messages to include in the message set
+		tagFilter *TagFilter
+		err       error
 	)
 
 	if !Options.DisableMetrics {
@@ -55,6 +58,14 @@ func (this *subServer) subRawHandler(w http.ResponseWriter, r *http.Request, par
 	cluster = params.ByName("cluster")
 	myAppid = r.Header.Get(HttpHeaderAppid)
 
+	if tagFilter, err = CompileTagFilter(r.Header.Get(HttpHeaderMsgTag)); err != nil {
+		log.Error("sub raw[%s/%s] %s(%s) {%s/%s UA:%s} tag:%s %v",
+			myAppid, group, r.RemoteAddr, realIp, cluster, topic, r.Header.Get("User-Agent"), r.Header.Get(HttpHeaderMsgTag), err)
+		this.subMetrics.ClientError.Mark(1)
+		writeBadRequest(w, err.Error())
+		return
+	}
+
 	log.Debug("sub raw[%s/%s] %s(%s) {%s/%s batch:%d UA:%s}",
 		myAppid, group, r.RemoteAddr, realIp, cluster, topic, limit, r.Header.Get("User-Agent"))
 
@@ -81,7 +92,7 @@ func (this *subServer) subRawHandler(w http.ResponseWriter, r *http.Request, par
 
 	var gz *gzip.Writer
 	w, gz = gzipWriter(w, r)
-	err = this.pumpRawMessages(w, r, realIp, fetcher, limit, myAppid, topic, group)
+	err = this.pumpRawMessages(w, r, realIp, fetcher, limit, myAppid, topic, group, tagFilter)
 	if err != nil {
 		// e,g. broken pipe, io timeout, client gone
 		// e,g. kafka: error while consuming app1.foobar.v1/0: EOF (kafka was shutdown)
@@ -109,7 +120,7 @@ func (this *subServer) subRawHandler(w http.ResponseWriter, r *http.Request, par
 }
 
 func (this *subServer) pumpRawMessages(w http.ResponseWriter, r *http.Request, realIp string,
-	fetcher store.Fetcher, limit int, myAppid, topic, group string) error {
+	fetcher store.Fetcher, limit int, myAppid, topic, group string, tagFilter *TagFilter) error {
 	cn, ok := w.(http.CloseNotifier)
 	if !ok {
 		return ErrBadResponseWriter
@@ -120,10 +131,22 @@ func (this *subServer) pumpRawMessages(w http.ResponseWriter, r *http.Request, r
 		idleTimeout         = Options.SubTimeout
 		chunkedEver         = false
 		clientGoneCh        = cn.CloseNotify()
+		startedAt           = time.Now()
 		metaBuf      []byte = nil
 	)
 
 	for {
+		if tagFilter != nil && time.Since(startedAt) > idleTimeout {
+			// all fetched messages might be filtered out
+			if chunkedEver {
+				return nil
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+			w.Write([]byte{})
+			return nil
+		}
+
 		select {
 		case <-clientGoneCh:
 			// FIXME access log will not be able to record this behavior
@@ -161,6 +184,16 @@ func (this *subServer) pumpRawMessages(w http.ResponseWriter, r *http.Request, r
 				return ErrClientKilled
 			}
 
+			if tagSatisfied, _, err := tagFilter.MatchMessage(msg.Value); err != nil || !tagSatisfied {
+				// always move offset cursor ahead, otherwise will be blocked forever
+				fetcher.CommitUpto(msg)
+
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
 			if limit == 1 {
 				partition := strconv.FormatInt(int64(msg.Partition), 10)
 
@@ -12,7 +12,8 @@ import (
 )
 
 //go:generate goannotation $GOFILE
-// @rest GET /v1/ws/msgs/:appid/:topic/:ver?group=xx&mux=1
+// @rest GET /v1/ws/msgs/:appid/:topic/:ver?group=xx&mux=1&tag=expr
+// tag filter expression is from X-Tag header, or tag param for browsers that can't set ws header.
 func (this *subServer) subWsHandler(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -55,8 +56,7 @@ func (this *subServer) subWsHandler(w http.ResponseWriter, r *http.Request, para
 	hisAppid = params.ByName(UrlParamAppid)
 	myAppid = r.Header.Get(HttpHeaderAppid)
 	realIp := getHttpRemoteIp(r)
-	if err = manager.Default.AuthSub(myAppid, r.Header.Get(HttpHeaderSubkey),
-		hisAppid, topic, group); err != nil {
+	if myAppid, err = this.authSub(r, hisAppid, topic, group); err != nil {
 		log.Error("consumer[%s] %s {hisapp:%s, topic:%s, ver:%s, group:%s, limit:%d}: %s",
 			myAppid, r.RemoteAddr, hisAppid, topic, ver, group, limit, err)
 
@@ -64,6 +64,19 @@ func (this *subServer) subWsHandler(w http.ResponseWriter, r *http.Request, para
 		return
 	}
 
+	tagExpr := r.Header.Get(HttpHeaderMsgTag)
+	if tagExpr == "" {
+		tagExpr = query.Get("tag")
+	}
+	tagFilter, err := CompileTagFilter(tagExpr)
+	if err != nil {
+		log.Error("consumer[%s] %s {hisapp:%s, topic:%s, ver:%s, group:%s} tag:%s %s",
+			myAppid, r.RemoteAddr, hisAppid, topic, ver, group, tagExpr, err)
+
+		writeWsError(ws, err.Error())
+		return
+	}
+
 	log.Debug("sub[%s] %s: %+v", myAppid, r.RemoteAddr, params)
 
 	rawTopic := manager.Default.KafkaTopic(hisAppid, topic, ver)
@@ -95,7 +108,7 @@ func (this *subServer) subWsHandler(w http.ResponseWriter, r *http.Request, para
 	//
 
 	clientGone := make(chan struct{})
-	go this.wsWritePump(clientGone, ws, fetcher)
+	go this.wsWritePump(clientGone, ws, fetcher, tagFilter)
 	this.wsReadPump(clientGone, ws)
 
 	return
@@ -128,17 +141,33 @@ func (this *subServer) wsReadPump(clientGone chan struct{}, ws *websocket.Conn)
 	}
 }
 
-func (this *subServer) wsWritePump(clientGone chan struct{}, ws *websocket.Conn, fetcher store.Fetcher) {
+func (this *subServer) wsWritePump(clientGone chan struct{}, ws *websocket.Conn, fetcher store.Fetcher,
+	tagFilter *TagFilter) {
 	defer fetcher.Close()
 
-	var err error
+	var (
+		err          error
+		tagSatisfied bool
+		bodyIdx      int
+	)
 	for {
 		select {
 		case msg := <-fetcher.Messages():
+			tagSatisfied, bodyIdx, err = tagFilter.MatchMessage(msg.Value)
+			if err != nil || !tagSatisfied {
+				if err != nil {
+					log.Error("%s: %v", ws.RemoteAddr(), err)
+				}
+
+				// move ahead
+				fetcher.CommitUpto(msg)
+				continue
+			}
+
 			ws.SetWriteDeadline(time.Now().Add(time.Second * 10))
 			// FIXME because of buffer, client recv 10, but kateway written 100, then
 			// client quit...
-			if err = ws.WriteMessage(websocket.BinaryMessage, msg.Value); err != nil {
+			if err = ws.WriteMessage(websocket.BinaryMessage, msg.Value[bodyIdx:]); err != nil {
 				log.Error("%s: %v", ws.RemoteAddr(), err)
 				return
 			}
@@ -5,8 +5,9 @@ package gateway
 import (
 	"net/http"
 
-	//"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
 )
 
 //go:generate goannotation $GOFILE
@@ -17,11 +18,16 @@ func (this *pubServer) authHandler(w http.ResponseWriter, r *http.Request, param
 		secret = r.Header.Get("X-App-Secret")
 	)
 
-	// TODO manager auth first
+	if err := manager.Default.Auth(appid, secret); err != nil {
+		log.Warn("token[%s] %s(%s) %v", appid, r.RemoteAddr, getHttpRemoteIp(r), err)
+
+		writeAuthFailure(w, err)
+		return
+	}
 
 	tokenString, err := jwtToken(appid, secret)
 	if err != nil {
-		// TODO
+		writeServerError(w, err.Error())
 		return
 	}
 
@@ -51,28 +51,211 @@ what's the problem?
     该消息对应的事务到底是commit了还是rollback了。
     因此，producer要保存事务状态表
 
+XA protocol
+===========
+
+    PUT  /v1/xa/:appid/:topic/:ver  {"checkback": url}  (man server, admin only)
+    POST /v1/xa/prepare/:topic/:ver  => X-Xa-Id
+    PUT  /v1/xa/commit/:topic/:ver?id=xx
+    PUT  /v1/xa/rollback/:topic/:ver?id=xx
+
+Prepared messages persist in job store and are invisible to consumers.
+Committed messages are delivered by actord.
+If a prepared message is neither committed nor rolled back in time, actord
+will checkback the producer at the url registered for the topic: GET url?id=xx with
+response body 'commit' or 'rollback', otherwise the state is unknown and actord will
+checkback later. The checkback url is never taken from Pub clients, so that they can't
+make actord request arbitrary hosts.
+After so many unknown checkbacks, the message is kept unresolved until the producer
+commits or rollbacks it.
+
+The topic must have job queue created.
+
 */
 
 package gateway
 
 import (
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/job"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/mpool"
 	"github.com/funkygao/httprouter"
+	log "github.com/funkygao/log4go"
 )
 
 //go:generate goannotation $GOFILE
 // @rest POST /v1/xa/prepare/:topic/:ver
 func (this *pubServer) xa_prepare(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	t1 := time.Now()
+	realIp := getHttpRemoteIp(r)
+	appid := r.Header.Get(HttpHeaderAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+
+	if Options.Ratelimit && !this.throttlePub.Pour(realIp, 1) {
+		log.Warn("xa prepare[%s] %s(%s) rate limit reached", appid, r.RemoteAddr, realIp)
+
+		writeQuotaExceeded(w)
+		return
+	}
+
+	var err error
+	if appid, err = this.ownTopic(r, topic); err != nil {
+		log.Warn("xa prepare[%s] %s(%s) {topic:%s, ver:%s} %s", appid, r.RemoteAddr, realIp, topic, ver, err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	if checkback := r.URL.Query().Get("checkback"); checkback != "" {
+		log.Warn("xa prepare[%s] %s(%s) {topic:%s, ver:%s} unregistered checkback: %s",
+			appid, r.RemoteAddr, realIp, topic, ver, checkback)
+
+		writeBadRequest(w, "checkback must be registered through the man server")
+		return
+	}
+
+	msgLen := int(r.ContentLength)
+	switch {
+	case msgLen == -1:
+		log.Warn("xa prepare[%s] %s(%s) {topic:%s, ver:%s} invalid content length: %d",
+			appid, r.RemoteAddr, realIp, topic, ver, msgLen)
+
+		writeBadRequest(w, "invalid content length")
+		return
+
+	case int64(msgLen) > Options.MaxJobSize:
+		log.Warn("xa prepare[%s] %s(%s) {topic:%s, ver:%s} too big content length: %d",
+			appid, r.RemoteAddr, realIp, topic, ver, msgLen)
+
+		writeBadRequest(w, ErrTooBigMessage.Error())
+		return
+
+	case msgLen < Options.MinPubSize:
+		log.Warn("xa prepare[%s] %s(%s) {topic:%s, ver:%s} too small content length: %d",
+			appid, r.RemoteAddr, realIp, topic, ver, msgLen)
+
+		writeBadRequest(w, ErrTooSmallMessage.Error())
+		return
+	}
+
+	lbr := io.LimitReader(r.Body, Options.MaxJobSize+1)
+	msg := mpool.NewMessage(msgLen)
+	msg.Body = msg.Body[0:msgLen]
+	if _, err := io.ReadAtLeast(lbr, msg.Body, msgLen); err != nil {
+		msg.Free()
+
+		log.Error("xa prepare[%s] %s(%s) {topic:%s, ver:%s} %s", appid, r.RemoteAddr, realIp, topic, ver, err)
+
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	if _, found := manager.Default.LookupCluster(appid); !found {
+		msg.Free()
+
+		log.Error("xa prepare[%s] %s(%s) {topic:%s, ver:%s} cluster not found", appid, r.RemoteAddr, realIp, topic, ver)
 
+		writeBadRequest(w, "invalid appid")
+		return
+	}
+
+	xaId, err := job.Default.Prepare(appid, manager.Default.KafkaTopic(appid, topic, ver), msg.Body)
+	msg.Free()
+	if err != nil {
+		if !Options.DisableMetrics {
+			this.pubMetrics.PubFail(appid, topic, ver)
+		}
+
+		log.Error("xa prepare[%s] %s(%s) {topic:%s, ver:%s} %s", appid, r.RemoteAddr, realIp, topic, ver, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	if Options.AuditPub {
+		this.auditor.Trace("xa prepare[%s] %s(%s) {topic:%s ver:%s UA:%s} id:%s",
+			appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), xaId)
+	}
+
+	w.Header().Set(HttpHeaderXaId, xaId)
+	w.WriteHeader(http.StatusCreated)
+
+	if _, err = w.Write(ResponseOk); err != nil {
+		log.Error("%s: %v", r.RemoteAddr, err)
+		this.pubMetrics.ClientError.Inc(1)
+	}
+
+	if !Options.DisableMetrics {
+		this.pubMetrics.PubLatency.Update(time.Since(t1).Nanoseconds() / 1e6) // in ms
+	}
 }
 
-// @rest POST /v1/xa/commit?id=xx
+// @rest PUT /v1/xa/commit/:topic/:ver?id=xx
 func (this *pubServer) xa_commit(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
-
+	this.xaFinalize(w, r, params, true)
 }
 
-// @rest PUT /v1/xa/rollback?id=xx
+// @rest PUT /v1/xa/rollback/:topic/:ver?id=xx
 func (this *pubServer) xa_rollback(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	this.xaFinalize(w, r, params, false)
+}
+
+func (this *pubServer) xaFinalize(w http.ResponseWriter, r *http.Request, params httprouter.Params, commit bool) {
+	appid := r.Header.Get(HttpHeaderAppid)
+	topic := params.ByName(UrlParamTopic)
+	ver := params.ByName(UrlParamVersion)
+	realIp := getHttpRemoteIp(r)
+
+	op, finalize := "rollback", job.Default.Rollback
+	if commit {
+		op, finalize = "commit", job.Default.Commit
+	}
+
+	var err error
+	if appid, err = this.ownTopic(r, topic); err != nil {
+		log.Warn("xa %s[%s] %s(%s) {topic:%s, ver:%s} %s", op, appid, r.RemoteAddr, realIp, topic, ver, err)
+
+		writeAuthFailure(w, err)
+		return
+	}
+
+	if _, found := manager.Default.LookupCluster(appid); !found {
+		log.Error("xa %s[%s] %s(%s) {topic:%s, ver:%s} cluster not found", op, appid, r.RemoteAddr, realIp, topic, ver)
+
+		writeBadRequest(w, "invalid appid")
+		return
+	}
+
+	xaId := r.URL.Query().Get("id")
+	if len(xaId) < 18 { // same as job id
+		writeBadRequest(w, "invalid xa id")
+		return
+	}
+
+	if err := finalize(appid, manager.Default.KafkaTopic(appid, topic, ver), xaId); err != nil {
+		if err == job.ErrNotPrepared {
+			// already finalized by checkback or unknown xa id
+			log.Warn("xa %s[%s] %s(%s) {topic:%s, ver:%s id:%s} %v", op, appid, r.RemoteAddr, realIp, topic, ver, xaId, err)
+
+			this.respond4XX(appid, w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		log.Error("xa %s[%s] %s(%s) {topic:%s, ver:%s id:%s} %v", op, appid, r.RemoteAddr, realIp, topic, ver, xaId, err)
+
+		writeServerError(w, err.Error())
+		return
+	}
+
+	if Options.AuditPub {
+		this.auditor.Trace("xa %s[%s] %s(%s) {topic:%s ver:%s UA:%s id:%s}",
+			op, appid, r.RemoteAddr, realIp, topic, ver, r.Header.Get("User-Agent"), xaId)
+	}
 
+	w.Write(ResponseOk)
 }
@@ -0,0 +1,42 @@
+package gateway
+
+import (
+	"sync"
+
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+	"github.com/funkygao/gafka/telemetry"
+	"github.com/funkygao/go-metrics"
+)
+
+type tenantCounters struct {
+	mu sync.RWMutex
+	m  map[string]metrics.Counter
+}
+
+// quotaMetrics is the per app usage and rejections of each quota resource.
+type quotaMetrics struct {
+	used     map[string]*tenantCounters // key is resource
+	exceeded map[string]*tenantCounters
+}
+
+func newQuotaMetrics() *quotaMetrics {
+	this := &quotaMetrics{
+		used:     make(map[string]*tenantCounters),
+		exceeded: make(map[string]*tenantCounters),
+	}
+	for _, resource := range []string{quota.PubMsgs, quota.PubBytes, quota.SubBytes} {
+		this.used[resource] = &tenantCounters{m: make(map[string]metrics.Counter)}
+		this.exceeded[resource] = &tenantCounters{m: make(map[string]metrics.Counter)}
+	}
+	return this
+}
+
+func (this *quotaMetrics) Used(appid, topic, ver, resource string, n int64) {
+	c := this.used[resource]
+	telemetry.UpdateCounter(appid, topic, ver, "quota."+resource, n, &c.mu, c.m)
+}
+
+func (this *quotaMetrics) Exceeded(appid, topic, ver, resource string) {
+	c := this.exceeded[resource]
+	telemetry.UpdateCounter(appid, topic, ver, "quota."+resource+".exceeded", 1, &c.mu, c.m)
+}
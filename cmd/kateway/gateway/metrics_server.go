@@ -30,6 +30,7 @@ type serverMetrics struct {
 	ConcurrentPub   metrics.Counter
 	ConcurrentSub   metrics.Counter
 	ConcurrentSubWs metrics.Counter
+	ConcurrentPubWs metrics.Counter
 }
 
 func NewServerMetrics(interval time.Duration, gw *Gateway) *serverMetrics {
@@ -40,6 +41,7 @@ func NewServerMetrics(interval time.Duration, gw *Gateway) *serverMetrics {
 		ConcurrentPub:   metrics.NewRegisteredCounter("server.conns.pub", metrics.DefaultRegistry),
 		ConcurrentSub:   metrics.NewRegisteredCounter("server.conns.sub", metrics.DefaultRegistry),
 		ConcurrentSubWs: metrics.NewRegisteredCounter("server.conns.subws", metrics.DefaultRegistry),
+		ConcurrentPubWs: metrics.NewRegisteredCounter("server.conns.pubws", metrics.DefaultRegistry),
 	}
 
 	if Options.DebugHttpAddr != "" {
@@ -22,8 +22,21 @@ var (
 		ManHttpAddr                string
 		ManHttpsAddr               string
 		DebugHttpAddr              string
+		PubHttpAuth                string
+		PubHttpsAuth               string
+		SubHttpAuth                string
+		SubHttpsAuth               string
+		ManHttpAuth                string
+		ManHttpsAuth               string
+		AuthKeysFile               string
+		JwksFile                   string
+		JwtIssuer                  string
+		JwtAudience                string
+		CertMapFile                string
+		ClientCAFile               string
 		Store                      string
 		JobStore                   string
+		JobStoreDir                string
 		ManagerStore               string
 		PidFile                    string
 		CertFile                   string
@@ -37,6 +50,15 @@ var (
 		KillFile                   string
 		HintedHandoffType          string
 		HintedHandoffDir           string
+		DedupSnapshot              string
+		PubDedupStore              string
+		PubDedupWindows            string
+		InflightStore              string
+		InflightSnapshot           string
+		BuryDedupStore             string
+		BuryDedupSnapshot          string
+		BuryDedupJournal           string
+		QuotaStore                 string
 		AllwaysHintedHandoff       bool
 		ShowVersion                bool
 		Ratelimit                  bool
@@ -60,19 +82,26 @@ var (
 		UseCompress                bool
 		Debug                      bool
 		EnableRegistry             bool
+		EnableAcl                  bool
 		HttpHeaderMaxBytes         int
 		MaxPubSize                 int64
+		MaxPubBatchBytes           int64
 		MaxJobSize                 int64
 		LogRotateSize              int
 		MaxMsgTagLen               int
 		MinPubSize                 int
 		PubQpsLimit                int64
 		MaxSubBatchSize            int
+		MaxRedeliveries            int
+		MaxPubBatchSize            int
 		MaxClients                 int
 		MaxRequestPerConn          int // to make load balancer distribute request even for persistent conn
 		PubPoolCapcity             int
 		AssignJobShardId           int // how to assign shard id for new app
 		PubPoolIdleTimeout         time.Duration
+		PubDedupWindow             time.Duration
+		JwksReload                 time.Duration
+		BuryDedupWindow            time.Duration
 		SubTimeout                 time.Duration
 		OffsetCommitInterval       time.Duration
 		BadClientPunishDuration    time.Duration
@@ -80,6 +109,9 @@ var (
 		ReporterInterval           time.Duration
 		MetaRefresh                time.Duration
 		ManagerRefresh             time.Duration
+		SchemaRefresh              time.Duration
+		AclRefresh                 time.Duration
+		QuotaGossip                time.Duration
 		HttpReadTimeout            time.Duration
 		HttpWriteTimeout           time.Duration
 		MaxWaitBeforeForceClose    time.Duration
@@ -122,12 +154,35 @@ func ParseFlags() {
 	flag.StringVar(&Options.CertFile, "certfile", "", "cert file path")
 	flag.StringVar(&Options.PidFile, "pid", "", "pid file")
 	flag.StringVar(&Options.KeyFile, "keyfile", "", "key file path")
+	flag.StringVar(&Options.ClientCAFile, "clientca", "", "CA file to verify the https client certificates")
+	flag.StringVar(&Options.PubHttpAuth, "pubhttpauth", "key", "pub http auth providers <key|static|jwt>, comma separated")
+	flag.StringVar(&Options.PubHttpsAuth, "pubhttpsauth", "key", "pub https auth providers <key|static|jwt|mtls>, comma separated")
+	flag.StringVar(&Options.SubHttpAuth, "subhttpauth", "key", "sub http auth providers <key|static|jwt>, comma separated")
+	flag.StringVar(&Options.SubHttpsAuth, "subhttpsauth", "key", "sub https auth providers <key|static|jwt|mtls>, comma separated")
+	flag.StringVar(&Options.ManHttpAuth, "manhttpauth", "key", "management http auth providers <key|static|jwt>, comma separated")
+	flag.StringVar(&Options.ManHttpsAuth, "manhttpsauth", "key", "management https auth providers <key|static|jwt|mtls>, comma separated")
+	flag.StringVar(&Options.AuthKeysFile, "authkeys", "", "static auth provider keys file")
+	flag.StringVar(&Options.JwksFile, "jwks", "", "jwt auth provider JWKS file")
+	flag.DurationVar(&Options.JwksReload, "jwksreload", time.Minute, "jwt auth provider JWKS file reload interval, 0 to never reload")
+	flag.StringVar(&Options.JwtIssuer, "jwtiss", "", "jwt auth provider required iss claim, empty to skip the check")
+	flag.StringVar(&Options.JwtAudience, "jwtaud", "", "jwt auth provider required aud claim, empty to skip the check")
+	flag.StringVar(&Options.CertMapFile, "certmap", "", "mtls auth provider certificate to appid mapping file")
 	flag.StringVar(&Options.DebugHttpAddr, "debughttp", "", "debug http bind addr")
 	flag.StringVar(&Options.Store, "store", "kafka", "message underlying store")
 	flag.StringVar(&Options.HintedHandoffType, "hhtype", "disk", "underlying hinted handoff")
 	flag.StringVar(&Options.HintedHandoffDir, "hhdirs", "hhdata", "hinted handoff dirs separated by comma")
 	flag.BoolVar(&Options.FlushHintedOffOnly, "hhflush", false, "flush hinted handoff and exit")
-	flag.StringVar(&Options.JobStore, "jstore", "mysql", "job underlying store")
+	flag.StringVar(&Options.DedupSnapshot, "dedupdmp", "dedup.dmp", "Pub msg id dedup snapshot file")
+	flag.StringVar(&Options.PubDedupStore, "dedup", "mem", "Pub msg id dedup store <mem|mysql|none>, mysql is shared by all kateways")
+	flag.StringVar(&Options.PubDedupWindows, "dedupwins", "", "Pub msg id dedup window of topics overriding -dedupwin, e,g. app1.*=10m,app1.orders.v1=0s")
+	flag.StringVar(&Options.JobStore, "jstore", "mysql", "job underlying store <mysql|disk|dummy>")
+	flag.StringVar(&Options.JobStoreDir, "jdir", "jobdata", "disk job store dir shared with actord")
+	flag.StringVar(&Options.InflightStore, "istore", "mem", "Sub visibility timeout inflight store <mem|mysql|none>, mysql needs the Inflight table")
+	flag.StringVar(&Options.InflightSnapshot, "inflightdmp", "inflight.dmp", "mem inflight store snapshot file")
+	flag.StringVar(&Options.BuryDedupStore, "burydedup", "mysql", "Sub bury dedup store <mysql|mem|none>, mysql is shared by all kateways")
+	flag.StringVar(&Options.BuryDedupSnapshot, "burydedupdmp", "burydedup.dmp", "mem bury dedup store snapshot file")
+	flag.StringVar(&Options.BuryDedupJournal, "burydedupjnl", "burydedup.jnl", "mem bury dedup store journal file fsynced on each bury")
+	flag.StringVar(&Options.QuotaStore, "quota", "local", "per app quota limiter <local|cluster|none>")
 	flag.StringVar(&Options.DummyCluster, "dummycluster", "me", "dummy store's cluster name")
 	flag.StringVar(&Options.ManagerStore, "mstore", "mysql", "store integration with manager")
 	flag.StringVar(&Options.ConfigFile, "conf", "", "config file, defaults $HOME/.gafka.cf")
@@ -144,6 +199,7 @@ func ParseFlags() {
 	flag.BoolVar(&Options.UseCompress, "snappy", false, "backend store will snappy compress messages")
 	flag.BoolVar(&Options.EnableAccessLog, "accesslog", false, "en(dis)able access log")
 	flag.BoolVar(&Options.EnableRegistry, "withreg", true, "self register in zk, otherwise isolated from cluster")
+	flag.BoolVar(&Options.EnableAcl, "acl", true, "authorize operations by the acl rules in zk")
 	flag.BoolVar(&Options.DryRun, "dryrun", false, "dry run mode")
 	flag.BoolVar(&Options.HintedHandoffBufio, "hhbuf", false, "enable hinted handoff bufio")
 	flag.BoolVar(&Options.EnableHintedHandoff, "hh", true, "enable hinted handoff for full pub availability")
@@ -158,6 +214,8 @@ func ParseFlags() {
 	flag.BoolVar(&Options.DisableMetrics, "metricsoff", false, "disable metrics reporter")
 	flag.IntVar(&Options.HttpHeaderMaxBytes, "maxheader", 4<<10, "http header max size in bytes")
 	flag.Int64Var(&Options.MaxPubSize, "maxpub", 512<<10, "max Pub message size")
+	flag.Int64Var(&Options.MaxPubBatchBytes, "maxpubbatchsz", 4<<20, "max batch Pub request body size")
+	flag.IntVar(&Options.MaxPubBatchSize, "maxpubbatch", 1000, "max messages of a batch Pub")
 	flag.Int64Var(&Options.MaxJobSize, "maxjob", 16<<10, "max Pub job size")
 	flag.IntVar(&Options.MinPubSize, "minpub", 1, "min Pub message size")
 	flag.IntVar(&Options.MaxRequestPerConn, "maxreq", -1, "max request per connection")
@@ -165,6 +223,7 @@ func ParseFlags() {
 	flag.IntVar(&Options.MaxMsgTagLen, "tagsz", 1024, "max message tag length permitted")
 	// kafka Fetch maxFetchSize=1MB, so if our msg agv size is 250B, batch size can be 4000
 	flag.IntVar(&Options.MaxSubBatchSize, "maxbatch", 4000, "max sub batch size")
+	flag.IntVar(&Options.MaxRedeliveries, "maxredeliver", 5, "max redeliveries before buried to dead letter topic")
 	flag.IntVar(&Options.LogRotateSize, "logsize", 10<<30, "max unrotated log file size")
 	flag.Int64Var(&Options.PubQpsLimit, "publimit", 60*10000, "pub qps limit per minute per ip")
 	flag.IntVar(&Options.PubPoolCapcity, "pubpool", 100, "pub connection pool capacity")
@@ -177,7 +236,12 @@ func ParseFlags() {
 	flag.DurationVar(&Options.BadClientPunishDuration, "punish", time.Second*3, "punish bad client by sleep")
 	flag.DurationVar(&Options.MetaRefresh, "metarefresh", time.Minute*5, "meta data refresh interval")
 	flag.DurationVar(&Options.ManagerRefresh, "manrefresh", time.Minute*5, "manager integration refresh interval")
+	flag.DurationVar(&Options.SchemaRefresh, "schemarefresh", time.Second*30, "schema registry refresh interval")
+	flag.DurationVar(&Options.AclRefresh, "aclrefresh", time.Second*30, "acl rules refresh interval")
+	flag.DurationVar(&Options.QuotaGossip, "quotagossip", time.Second, "cluster quota limiter usage gossip interval")
 	flag.DurationVar(&Options.PubPoolIdleTimeout, "pubpoolidle", 0, "pub pool connect idle timeout")
+	flag.DurationVar(&Options.PubDedupWindow, "dedupwin", time.Minute*5, "Pub msg id dedup window, 0 to disable")
+	flag.DurationVar(&Options.BuryDedupWindow, "burydedupwin", time.Minute*10, "Sub bury dedup window, should cover the offset commit interval")
 	flag.DurationVar(&Options.InternalServerErrorBackoff, "500backoff", time.Second, "internal server error backoff duration")
 	flag.DurationVar(&Options.MaxWaitBeforeForceClose, "maxwait", time.Second*20, "how long to wait for current active http connections close before forced close")
 
@@ -0,0 +1,60 @@
+package gateway
+
+import (
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/manager"
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+)
+
+// takeQuota consumes n units of an app's quota of a resource on topic, 0 only checks it.
+// If the quota is exceeded, retryAfter tells the app how long to wait.
+func (this *Gateway) takeQuota(appid, topic, ver, resource string, n int64) (ok bool, retryAfter time.Duration) {
+	if quota.Default == nil {
+		return true, 0
+	}
+
+	q, found := manager.Default.Quota(appid, topic)
+	if !found {
+		return true, 0
+	}
+
+	var rate int64
+	switch resource {
+	case quota.PubMsgs:
+		rate = q.PubMsgs
+	case quota.PubBytes:
+		rate = q.PubBytes
+	case quota.SubBytes:
+		rate = q.SubBytes
+	}
+	if rate <= 0 {
+		// unlimited
+		return true, 0
+	}
+
+	// a topic level quota has its own bucket
+	if ok, retryAfter = quota.Default.Take(appid+"/"+q.Topic+"/"+resource, rate, n); ok {
+		if n > 0 {
+			this.quotaMetrics.Used(appid, topic, ver, resource, n)
+		}
+	} else {
+		this.quotaMetrics.Exceeded(appid, topic, ver, resource)
+	}
+	return
+}
+
+// takePubQuota consumes the pub msgs and bytes quota of an app, telling how long to wait if exceeded.
+// A refused take consumes nothing, so neither quota is consumed unless both pass.
+func (this *Gateway) takePubQuota(appid, topic, ver string, msgs, bytes int64) (ok bool, retryAfter time.Duration) {
+	// check the bytes quota before the msgs quota is taken
+	if ok, retryAfter = this.takeQuota(appid, topic, ver, quota.PubBytes, 0); !ok {
+		return
+	}
+
+	if ok, retryAfter = this.takeQuota(appid, topic, ver, quota.PubMsgs, msgs); !ok {
+		return
+	}
+
+	return this.takeQuota(appid, topic, ver, quota.PubBytes, bytes)
+}
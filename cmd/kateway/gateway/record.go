@@ -0,0 +1,589 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// Batch Sub response formats negotiated by the Accept header.
+const (
+	ContentTypeMessageSet = "application/octet-stream" // default, see pumpMessages
+	ContentTypeJsonLines  = "application/x-ndjson"
+	ContentTypeMsgpack    = "application/x-msgpack"
+	ContentTypeProtobuf   = "application/x-protobuf"
+)
+
+// Record is a consumed message with its metadata in the self-describing batch Sub formats.
+//
+// JSON lines: one json object per line, key and value are base64 encoded.
+// msgpack: a stream of maps keyed by the json field names, key and value are bin.
+// protobuf: a stream of varint length delimited messages:
+//
+//	message Record {
+//	    int32 partition = 1;
+//	    int64 offset = 2;
+//	    bytes key = 3;
+//	    repeated string tags = 4;
+//	    int64 timestamp = 5;
+//	    bytes value = 6;
+//	}
+type Record struct {
+	Partition int32    `json:"partition"`
+	Offset    int64    `json:"offset"`
+	Key       []byte   `json:"key"`
+	Tags      []string `json:"tags,omitempty"`
+	Timestamp int64    `json:"timestamp"` // publish time in ms, 0 if unknown
+	Value     []byte   `json:"value"`
+}
+
+// negotiateBatchFormat picks the batch Sub response format from the Accept header.
+// The first supported media type wins, q values are ignored.
+func negotiateBatchFormat(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case ContentTypeJsonLines, "application/jsonlines":
+			return ContentTypeJsonLines
+		case ContentTypeMsgpack, "application/msgpack":
+			return ContentTypeMsgpack
+		case ContentTypeProtobuf, "application/protobuf":
+			return ContentTypeProtobuf
+		}
+	}
+
+	return ContentTypeMessageSet
+}
+
+// batchWriter writes the messages of a batch Sub in the negotiated format.
+type batchWriter struct {
+	w         http.ResponseWriter
+	format    string
+	metaBuf   []byte
+	recordBuf *bytes.Buffer
+}
+
+func newBatchWriter(w http.ResponseWriter, accept string) *batchWriter {
+	return &batchWriter{
+		w:      w,
+		format: negotiateBatchFormat(accept),
+	}
+}
+
+func (this *batchWriter) write(msg *sarama.ConsumerMessage, headers MessageHeaders, bodyIdx int) (err error) {
+	if this.format == ContentTypeMessageSet {
+		// MessageSet => [Partition(int32) Offset(int64) MessageSize(int32) Message] BigEndian
+		if this.metaBuf == nil {
+			// initialize the reuseable buffer
+			this.metaBuf = make([]byte, 8)
+
+			// override the middleware added header
+			this.w.Header().Set("Content-Type", ContentTypeMessageSet)
+		}
+
+		if err = writeI32(this.w, this.metaBuf, msg.Partition); err != nil {
+			return
+		}
+		if err = writeI64(this.w, this.metaBuf, msg.Offset); err != nil {
+			return
+		}
+		if err = writeI32(this.w, this.metaBuf, int32(len(msg.Value[bodyIdx:]))); err != nil {
+			return
+		}
+		_, err = this.w.Write(msg.Value[bodyIdx:])
+		return
+	}
+
+	// self-describing records
+	if this.recordBuf == nil {
+		this.recordBuf = bytes.NewBuffer(make([]byte, 0, 4<<10))
+		this.w.Header().Set("Content-Type", this.format)
+	}
+
+	record := Record{
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Tags:      headers.Tags(),
+		Timestamp: headers.PubTime(),
+		Value:     msg.Value[bodyIdx:],
+	}
+	return writeRecord(this.w, this.format, this.recordBuf, &record)
+}
+
+// writeRecord encodes a record in the negotiated format using buf as scratch buffer.
+func writeRecord(w io.Writer, format string, buf *bytes.Buffer, r *Record) error {
+	buf.Reset()
+	switch format {
+	case ContentTypeJsonLines:
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+
+	case ContentTypeMsgpack:
+		encodeMsgpackRecord(buf, r)
+
+	case ContentTypeProtobuf:
+		encodeProtobufRecord(buf, r)
+
+	default:
+		return ErrIllegalBatchMessage
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// DecodeRecords decodes a batch Sub response body by its Content-Type.
+// The decoded keys and values share the underlying bytes of b except JSON lines.
+func DecodeRecords(contentType string, b []byte) ([]Record, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case ContentTypeJsonLines:
+		var r []Record
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		for {
+			var rec Record
+			if err := decoder.Decode(&rec); err == io.EOF {
+				return r, nil
+			} else if err != nil {
+				return nil, err
+			}
+			r = append(r, rec)
+		}
+
+	case ContentTypeMsgpack:
+		return decodeMsgpackRecords(b)
+
+	case ContentTypeProtobuf:
+		return decodeProtobufRecords(b)
+
+	default:
+		// the legacy MessageSet carries no key and tags
+		if len(b) == 0 {
+			return nil, nil
+		}
+		if !validMessageSet(b) {
+			return nil, ErrIllegalBatchMessage
+		}
+
+		msgs := DecodeMessageSet(b)
+		r := make([]Record, len(msgs))
+		for i, m := range msgs {
+			r[i] = Record{Partition: m.Partition, Offset: m.Offset, Value: m.Value}
+		}
+		return r, nil
+	}
+}
+
+func validMessageSet(b []byte) bool {
+	for idx := 0; idx < len(b); {
+		if idx+16 > len(b) {
+			return false
+		}
+		idx += 16 + int(binary.BigEndian.Uint32(b[idx+12:idx+16]))
+		if idx > len(b) {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeMsgpackRecord(buf *bytes.Buffer, r *Record) {
+	buf.WriteByte(0x80 | 6) // fixmap
+	msgpackWriteString(buf, "partition")
+	msgpackWriteInt(buf, int64(r.Partition))
+	msgpackWriteString(buf, "offset")
+	msgpackWriteInt(buf, r.Offset)
+	msgpackWriteString(buf, "key")
+	msgpackWriteBin(buf, r.Key)
+	msgpackWriteString(buf, "tags")
+	msgpackWriteArrayLen(buf, len(r.Tags))
+	for _, tag := range r.Tags {
+		msgpackWriteString(buf, tag)
+	}
+	msgpackWriteString(buf, "timestamp")
+	msgpackWriteInt(buf, r.Timestamp)
+	msgpackWriteString(buf, "value")
+	msgpackWriteBin(buf, r.Value)
+}
+
+func msgpackWriteInt(buf *bytes.Buffer, v int64) {
+	if v >= 0 && v < 128 {
+		buf.WriteByte(byte(v)) // positive fixint
+		return
+	}
+
+	var b [9]byte
+	b[0] = 0xd3 // int64
+	binary.BigEndian.PutUint64(b[1:], uint64(v))
+	buf.Write(b[:])
+}
+
+func msgpackWriteString(buf *bytes.Buffer, s string) {
+	var b [5]byte
+	switch n := len(s); {
+	case n < 32:
+		buf.WriteByte(0xa0 | byte(n)) // fixstr
+	case n <= math.MaxUint8:
+		buf.Write([]byte{0xd9, byte(n)})
+	case n <= math.MaxUint16:
+		b[0] = 0xda
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		buf.Write(b[:3])
+	default:
+		b[0] = 0xdb
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		buf.Write(b[:5])
+	}
+	buf.WriteString(s)
+}
+
+func msgpackWriteBin(buf *bytes.Buffer, v []byte) {
+	var b [5]byte
+	switch n := len(v); {
+	case n <= math.MaxUint8:
+		buf.Write([]byte{0xc4, byte(n)})
+	case n <= math.MaxUint16:
+		b[0] = 0xc5
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		buf.Write(b[:3])
+	default:
+		b[0] = 0xc6
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		buf.Write(b[:5])
+	}
+	buf.Write(v)
+}
+
+func msgpackWriteArrayLen(buf *bytes.Buffer, n int) {
+	if n < 16 {
+		buf.WriteByte(0x90 | byte(n)) // fixarray
+		return
+	}
+
+	var b [5]byte
+	b[0] = 0xdd
+	binary.BigEndian.PutUint32(b[1:], uint32(n))
+	buf.Write(b[:5])
+}
+
+// msgpackReader decodes the subset of msgpack used by encodeMsgpackRecord.
+type msgpackReader struct {
+	b   []byte
+	idx int
+	err error
+}
+
+func (this *msgpackReader) next(n int) []byte {
+	if this.err != nil {
+		return nil
+	}
+	if n < 0 || this.idx+n > len(this.b) {
+		this.err = ErrIllegalBatchMessage
+		return nil
+	}
+
+	v := this.b[this.idx : this.idx+n]
+	this.idx += n
+	return v
+}
+
+func (this *msgpackReader) uint(n int) int {
+	v := this.next(n)
+	switch n {
+	case 1:
+		if v != nil {
+			return int(v[0])
+		}
+	case 2:
+		if v != nil {
+			return int(binary.BigEndian.Uint16(v))
+		}
+	case 4:
+		if v != nil {
+			return int(binary.BigEndian.Uint32(v))
+		}
+	}
+	return 0
+}
+
+func (this *msgpackReader) readInt() int64 {
+	b := this.next(1)
+	if b == nil {
+		return 0
+	}
+
+	switch c := b[0]; {
+	case c < 0x80:
+		return int64(c)
+	case c >= 0xe0:
+		return int64(int8(c)) // negative fixint
+	case c == 0xcc:
+		return int64(this.uint(1))
+	case c == 0xcd:
+		return int64(this.uint(2))
+	case c == 0xce:
+		return int64(this.uint(4))
+	case c == 0xcf, c == 0xd3:
+		if v := this.next(8); v != nil {
+			return int64(binary.BigEndian.Uint64(v))
+		}
+	case c == 0xd0:
+		if v := this.next(1); v != nil {
+			return int64(int8(v[0]))
+		}
+	case c == 0xd1:
+		if v := this.next(2); v != nil {
+			return int64(int16(binary.BigEndian.Uint16(v)))
+		}
+	case c == 0xd2:
+		if v := this.next(4); v != nil {
+			return int64(int32(binary.BigEndian.Uint32(v)))
+		}
+	default:
+		this.err = ErrIllegalBatchMessage
+	}
+	return 0
+}
+
+// readBytes reads str or bin.
+func (this *msgpackReader) readBytes() []byte {
+	b := this.next(1)
+	if b == nil {
+		return nil
+	}
+
+	switch c := b[0]; {
+	case c&0xe0 == 0xa0:
+		return this.next(int(c & 0x1f))
+	case c == 0xc0:
+		return nil // nil
+	case c == 0xc4, c == 0xd9:
+		return this.next(this.uint(1))
+	case c == 0xc5, c == 0xda:
+		return this.next(this.uint(2))
+	case c == 0xc6, c == 0xdb:
+		return this.next(this.uint(4))
+	}
+
+	this.err = ErrIllegalBatchMessage
+	return nil
+}
+
+func (this *msgpackReader) readContainerLen(fix, fixMask, c16, c32 byte) int {
+	b := this.next(1)
+	if b == nil {
+		return 0
+	}
+
+	switch c := b[0]; {
+	case c&fixMask == fix:
+		return int(c &^ fixMask)
+	case c == c16:
+		return this.uint(2)
+	case c == c32:
+		return this.uint(4)
+	}
+
+	this.err = ErrIllegalBatchMessage
+	return 0
+}
+
+func decodeMsgpackRecords(b []byte) ([]Record, error) {
+	var (
+		r  []Record
+		mr = &msgpackReader{b: b}
+	)
+	for mr.idx < len(b) {
+		var rec Record
+		n := mr.readContainerLen(0x80, 0xf0, 0xde, 0xdf) // map
+		for i := 0; i < n && mr.err == nil; i++ {
+			switch string(mr.readBytes()) {
+			case "partition":
+				rec.Partition = int32(mr.readInt())
+			case "offset":
+				rec.Offset = mr.readInt()
+			case "key":
+				rec.Key = mr.readBytes()
+			case "tags":
+				tagsN := mr.readContainerLen(0x90, 0xf0, 0xdc, 0xdd) // array
+				for j := 0; j < tagsN && mr.err == nil; j++ {
+					rec.Tags = append(rec.Tags, string(mr.readBytes()))
+				}
+			case "timestamp":
+				rec.Timestamp = mr.readInt()
+			case "value":
+				rec.Value = mr.readBytes()
+			default:
+				mr.err = ErrIllegalBatchMessage
+			}
+		}
+
+		if mr.err != nil {
+			return nil, mr.err
+		}
+		r = append(r, rec)
+	}
+
+	return r, nil
+}
+
+const (
+	pbWireVarint = 0
+	pbWireBytes  = 2
+)
+
+func encodeProtobufRecord(buf *bytes.Buffer, r *Record) {
+	var (
+		tmp [binary.MaxVarintLen64]byte
+		sz  int
+	)
+
+	// calculate the size first for the length delimiter
+	if r.Partition != 0 {
+		sz += 1 + uvarintLen(uint64(int64(r.Partition)))
+	}
+	if r.Offset != 0 {
+		sz += 1 + uvarintLen(uint64(r.Offset))
+	}
+	if len(r.Key) > 0 {
+		sz += 1 + uvarintLen(uint64(len(r.Key))) + len(r.Key)
+	}
+	for _, tag := range r.Tags {
+		sz += 1 + uvarintLen(uint64(len(tag))) + len(tag)
+	}
+	if r.Timestamp != 0 {
+		sz += 1 + uvarintLen(uint64(r.Timestamp))
+	}
+	if len(r.Value) > 0 {
+		sz += 1 + uvarintLen(uint64(len(r.Value))) + len(r.Value)
+	}
+
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], uint64(sz))])
+
+	writeVarint := func(field int, v uint64) {
+		buf.WriteByte(byte(field<<3 | pbWireVarint))
+		buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+	}
+	writeBytes := func(field int, v []byte) {
+		buf.WriteByte(byte(field<<3 | pbWireBytes))
+		buf.Write(tmp[:binary.PutUvarint(tmp[:], uint64(len(v)))])
+		buf.Write(v)
+	}
+
+	if r.Partition != 0 {
+		writeVarint(1, uint64(int64(r.Partition)))
+	}
+	if r.Offset != 0 {
+		writeVarint(2, uint64(r.Offset))
+	}
+	if len(r.Key) > 0 {
+		writeBytes(3, r.Key)
+	}
+	for _, tag := range r.Tags {
+		writeBytes(4, []byte(tag))
+	}
+	if r.Timestamp != 0 {
+		writeVarint(5, uint64(r.Timestamp))
+	}
+	if len(r.Value) > 0 {
+		writeBytes(6, r.Value)
+	}
+}
+
+func uvarintLen(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+func decodeProtobufRecords(b []byte) ([]Record, error) {
+	var r []Record
+	for idx := 0; idx < len(b); {
+		sz, n := binary.Uvarint(b[idx:])
+		if n <= 0 || uint64(len(b)-idx-n) < sz {
+			return nil, ErrIllegalBatchMessage
+		}
+		idx += n
+
+		rec, err := decodeProtobufRecord(b[idx : idx+int(sz)])
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, rec)
+		idx += int(sz)
+	}
+
+	return r, nil
+}
+
+func decodeProtobufRecord(b []byte) (rec Record, err error) {
+	for idx := 0; idx < len(b); {
+		key, n := binary.Uvarint(b[idx:])
+		if n <= 0 {
+			return rec, ErrIllegalBatchMessage
+		}
+		idx += n
+
+		field, wireType := int(key>>3), int(key&0x7)
+		switch wireType {
+		case pbWireVarint:
+			v, n := binary.Uvarint(b[idx:])
+			if n <= 0 {
+				return rec, ErrIllegalBatchMessage
+			}
+			idx += n
+
+			switch field {
+			case 1:
+				rec.Partition = int32(v)
+			case 2:
+				rec.Offset = int64(v)
+			case 5:
+				rec.Timestamp = int64(v)
+			}
+
+		case pbWireBytes:
+			l, n := binary.Uvarint(b[idx:])
+			if n <= 0 || uint64(len(b)-idx-n) < l {
+				return rec, ErrIllegalBatchMessage
+			}
+			idx += n
+			v := b[idx : idx+int(l)]
+			idx += int(l)
+
+			switch field {
+			case 3:
+				rec.Key = v
+			case 4:
+				rec.Tags = append(rec.Tags, string(v))
+			case 6:
+				rec.Value = v
+			}
+
+		default:
+			// fixed32/fixed64 are never used by Record
+			return rec, ErrIllegalBatchMessage
+		}
+	}
+
+	return
+}
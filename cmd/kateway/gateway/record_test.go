@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestNegotiateBatchFormat(t *testing.T) {
+	assert.Equal(t, ContentTypeMessageSet, negotiateBatchFormat(""))
+	assert.Equal(t, ContentTypeMessageSet, negotiateBatchFormat("*/*"))
+	assert.Equal(t, ContentTypeJsonLines, negotiateBatchFormat("application/x-ndjson"))
+	assert.Equal(t, ContentTypeMsgpack, negotiateBatchFormat("text/html, application/msgpack;q=0.9"))
+	assert.Equal(t, ContentTypeProtobuf, negotiateBatchFormat("application/x-protobuf, application/x-ndjson"))
+}
+
+func TestWriteAndDecodeRecords(t *testing.T) {
+	records := []Record{
+		{Partition: 1, Offset: 100, Key: []byte("k"), Tags: []string{"a=b", "c"}, Timestamp: 1476781200000, Value: []byte("hello")},
+		{Partition: 0, Offset: 0, Value: bytes.Repeat([]byte("X"), 70000)},
+		{Partition: 3, Offset: 1 << 40, Key: []byte{0, 1, 2}, Value: []byte("world")},
+	}
+
+	for _, format := range []string{ContentTypeJsonLines, ContentTypeMsgpack, ContentTypeProtobuf} {
+		var (
+			w   bytes.Buffer
+			buf bytes.Buffer
+		)
+		for i := range records {
+			assert.Equal(t, nil, writeRecord(&w, format, &buf, &records[i]))
+		}
+
+		decoded, err := DecodeRecords(format+"; charset=utf8", w.Bytes())
+		assert.Equal(t, nil, err)
+		assert.Equal(t, len(records), len(decoded))
+		for i, r := range decoded {
+			assert.Equal(t, records[i].Partition, r.Partition)
+			assert.Equal(t, records[i].Offset, r.Offset)
+			assert.Equal(t, string(records[i].Key), string(r.Key))
+			assert.Equal(t, len(records[i].Tags), len(r.Tags))
+			assert.Equal(t, records[i].Timestamp, r.Timestamp)
+			assert.Equal(t, string(records[i].Value), string(r.Value))
+		}
+
+		// truncated
+		if format != ContentTypeJsonLines {
+			_, err = DecodeRecords(format, w.Bytes()[:w.Len()-1])
+			assert.Equal(t, ErrIllegalBatchMessage, err)
+		}
+	}
+}
+
+func TestDecodeRecordsMessageSet(t *testing.T) {
+	var w bytes.Buffer
+	buf := make([]byte, 8)
+	writeI32(&w, buf, 2)
+	writeI64(&w, buf, 10)
+	writeI32(&w, buf, 5)
+	w.WriteString("hello")
+
+	records, err := DecodeRecords(ContentTypeMessageSet, w.Bytes())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, int32(2), records[0].Partition)
+	assert.Equal(t, int64(10), records[0].Offset)
+	assert.Equal(t, "hello", string(records[0].Value))
+
+	_, err = DecodeRecords("", w.Bytes()[:w.Len()-1])
+	assert.Equal(t, ErrIllegalBatchMessage, err)
+}
+
+func BenchmarkWriteRecordProtobuf(b *testing.B) {
+	b.ReportAllocs()
+	var w, buf bytes.Buffer
+	r := &Record{Partition: 1, Offset: 100, Key: []byte("k"), Tags: []string{"a"}, Value: bytes.Repeat([]byte("X"), 900)}
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		writeRecord(&w, ContentTypeProtobuf, &buf, r)
+	}
+}
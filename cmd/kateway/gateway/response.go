@@ -3,6 +3,7 @@ package gateway
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -42,6 +43,13 @@ func writeAuthFailure(w http.ResponseWriter, err error) {
 	_writeErrorResponse(w, err.Error(), http.StatusUnauthorized)
 }
 
+func writeForbidden(w http.ResponseWriter, err error) {
+	punishClient()
+
+	w.Header().Set("Connection", "close")
+	_writeErrorResponse(w, err.Error(), http.StatusForbidden)
+}
+
 func writeWsError(ws *websocket.Conn, err string) {
 	ws.WriteMessage(websocket.CloseMessage, []byte(err))
 }
@@ -54,6 +62,17 @@ func writeQuotaExceeded(w http.ResponseWriter) {
 	_writeErrorResponse(w, "quota exceeded", http.StatusTooManyRequests)
 }
 
+// writeRetryAfter tells the client that its quota is exceeded and when to retry.
+func writeRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set(HttpHeaderRetryAfter, strconv.Itoa(seconds))
+	_writeErrorResponse(w, "quota exceeded", http.StatusTooManyRequests)
+}
+
 func writeServerError(w http.ResponseWriter, err string) {
 	// internal server error, if client brutely retry without backoff, it will
 	// hurt both server and client and its dependencies
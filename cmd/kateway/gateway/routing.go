@@ -5,6 +5,7 @@ import (
 	"net/http/pprof"
 
 	"github.com/NYTimes/gziphandler"
+	"github.com/funkygao/gafka/cmd/kateway/acl"
 	"github.com/funkygao/httprouter"
 	log "github.com/funkygao/log4go"
 )
@@ -26,43 +27,63 @@ func (this *Gateway) buildRouting() {
 
 		// api for pubsub manager
 		this.manServer.Router().GET("/v1/partitions/:appid/:topic/:ver",
-			m(this.manServer.partitionsHandler))
+			m(this.manServer.guard(acl.OpAdmin, this.manServer.partitionsHandler)))
 		this.manServer.Router().POST("/v1/topics/:appid/:topic/:ver",
-			m(this.manServer.createTopicHandler))
+			m(this.manServer.guard(acl.OpAdmin, this.manServer.createTopicHandler)))
 		this.manServer.Router().PUT("/v1/topics/:appid/:topic/:ver",
-			m(this.manServer.alterTopicHandler))
+			m(this.manServer.guard(acl.OpAdmin, this.manServer.alterTopicHandler)))
 		this.manServer.Router().POST("/v1/jobs/:appid/:topic/:ver",
-			this.manServer.createJobHandler)
+			this.manServer.guard(acl.OpAdmin, this.manServer.createJobHandler))
+		this.manServer.Router().GET("/v1/jobs/:appid/:topic/:ver",
+			m(this.manServer.guard(acl.OpAdmin, this.manServer.getJobsHandler)))
+		this.manServer.Router().PUT("/v1/jobs/:appid/:topic/:ver",
+			m(this.manServer.guard(acl.OpAdmin, this.manServer.updateJobHandler)))
+		this.manServer.Router().PUT("/v1/xa/:appid/:topic/:ver",
+			m(this.manServer.guard(acl.OpAdmin, this.manServer.registerXaHandler)))
 		this.manServer.Router().PUT("/v1/webhooks/:appid/:topic/:ver",
-			this.manServer.createWebhookHandler)
+			this.manServer.guard(acl.OpSub, this.manServer.createWebhookHandler))
 		this.manServer.Router().DELETE("/v1/webhooks/:appid/:topic/:ver",
-			this.manServer.deleteWebhookHandler)
+			this.manServer.guard(acl.OpSub, this.manServer.deleteWebhookHandler))
 		this.manServer.Router().GET("/v1/schemas/:appid/:topic/:ver",
 			m(this.manServer.schemaHandler))
+		this.manServer.Router().GET("/v1/schemas/:appid/:topic/:ver/versions",
+			m(this.manServer.schemaVersionsHandler))
+		this.manServer.Router().POST("/v1/schemas/:appid/:topic/:ver",
+			m(this.manServer.guard(acl.OpAdmin, this.manServer.registerSchemaHandler)))
+		this.manServer.Router().PUT("/v1/schemas/:appid/:topic/:ver",
+			m(this.manServer.guard(acl.OpAdmin, this.manServer.configSchemaHandler)))
+		this.manServer.Router().POST("/v1/quota/gossip",
+			m(this.manServer.quotaGossipHandler))
+		this.manServer.Router().GET("/v1/acls",
+			m(this.manServer.guard(acl.OpAdmin, this.manServer.aclsHandler)))
+		this.manServer.Router().POST("/v1/acls",
+			m(this.manServer.guard(acl.OpAdmin, this.manServer.addAclHandler)))
+		this.manServer.Router().DELETE("/v1/acls/:id",
+			m(this.manServer.guard(acl.OpAdmin, this.manServer.deleteAclHandler)))
 		this.manServer.Router().DELETE("/v1/manager/cache",
-			m(this.manServer.refreshManagerHandler))
+			m(this.manServer.guard(acl.OpAdmin, this.manServer.refreshManagerHandler)))
 
 		// Pub related api for pubsub manager
 		this.manServer.Router().GET("/v1/raw/pub/:topic/:ver",
-			m(this.manServer.pubRawHandler))
+			m(this.manServer.guard(acl.OpPub, this.manServer.pubRawHandler)))
 
 		// Sub related api for pubsub manager
 		this.manServer.Router().GET("/v1/raw/sub/:appid/:topic/:ver",
-			m(this.manServer.subRawHandler))
+			m(this.manServer.guard(acl.OpSub, this.manServer.subRawHandler)))
 		this.manServer.Router().GET("/v1/peek/:appid/:topic/:ver",
-			m(this.manServer.peekHandler))
+			m(this.manServer.guard(acl.OpSub, this.manServer.peekHandler)))
 		this.manServer.Router().POST("/v1/shadow/:appid/:topic/:ver/:group",
-			m(this.manServer.addTopicShadowHandler))
+			m(this.manServer.guard(acl.OpSub, this.manServer.addTopicShadowHandler)))
 		this.manServer.Router().GET("/v1/subd/:topic/:ver",
-			m(this.manServer.subdStatusHandler))
+			m(this.manServer.guard(acl.OpPub, this.manServer.subdStatusHandler)))
 		this.manServer.Router().GET("/v1/status/:appid/:topic/:ver",
-			m(this.manServer.subStatusHandler))
+			m(this.manServer.guard(acl.OpSub, this.manServer.subStatusHandler)))
 		this.manServer.Router().GET("/v1/sub/status",
-			m(this.manServer.appSubStatusHandler))
+			m(this.manServer.guard(acl.OpSub, this.manServer.appSubStatusHandler)))
 		this.manServer.Router().DELETE("/v1/groups/:appid/:topic/:ver/:group",
-			m(this.manServer.delSubGroupHandler))
+			m(this.manServer.guard(acl.OpSub, this.manServer.delSubGroupHandler)))
 		this.manServer.Router().PUT("/v1/offset/:appid/:topic/:ver/:group/:partition",
-			m(this.manServer.resetSubOffsetHandler))
+			m(this.manServer.guard(acl.OpResetOffset, this.manServer.resetSubOffsetHandler)))
 	}
 
 	if this.pubServer != nil {
@@ -72,19 +93,24 @@ func (this *Gateway) buildRouting() {
 		// health check
 		this.pubServer.Router().GET("/alive", m(this.checkAliveHandler))
 
-		this.pubServer.Router().POST("/v1/raw/msgs/:cluster/:topic", m(this.pubServer.pubRawHandler))
-		this.pubServer.Router().POST("/v1/msgs/:topic/:ver", m(this.pubServer.pubHandler))
-		this.pubServer.Router().POST("/v1/ws/msgs/:topic/:ver", m(this.pubServer.pubWsHandler))
-		this.pubServer.Router().POST("/v1/jobs/:topic/:ver", m(this.pubServer.addJobHandler))
-		this.pubServer.Router().DELETE("/v1/jobs/:topic/:ver", m(this.pubServer.deleteJobHandler))
+		this.pubServer.Router().POST("/v1/raw/msgs/:cluster/:topic", m(this.pubServer.guard(acl.OpPub, this.pubServer.pubRawHandler)))
+		this.pubServer.Router().POST("/v1/msgs/:topic/:ver", m(this.pubServer.guard(acl.OpPub, this.pubServer.pubHandler)))
+		this.pubServer.Router().POST("/v1/msgs/:topic/:ver/batch", m(this.pubServer.guard(acl.OpPub, this.pubServer.pubBatchHandler)))
+		this.pubServer.Router().GET("/v1/ws/msgs/:topic/:ver", m(this.pubServer.guard(acl.OpPub, this.pubServer.pubWsHandler)))
+		this.pubServer.Router().POST("/v1/jobs/:topic/:ver", m(this.pubServer.guard(acl.OpPub, this.pubServer.addJobHandler)))
+		this.pubServer.Router().GET("/v1/jobs/:topic/:ver", m(this.pubServer.guard(acl.OpPub, this.pubServer.getJobsHandler)))
+		this.pubServer.Router().PUT("/v1/jobs/:topic/:ver", m(this.pubServer.guard(acl.OpPub, this.pubServer.updateJobHandler)))
+		this.pubServer.Router().DELETE("/v1/jobs/:topic/:ver", m(this.pubServer.guard(acl.OpPub, this.pubServer.deleteJobHandler)))
+		this.pubServer.Router().GET("/v1/crons/:topic/:ver", m(this.pubServer.guard(acl.OpPub, this.pubServer.listCronsHandler)))
+		this.pubServer.Router().PUT("/v1/crons/:topic/:ver", m(this.pubServer.guard(acl.OpPub, this.pubServer.updateCronHandler)))
 
 		// pubServer acts as a XA compliant RM(resource manager)
-		this.pubServer.Router().POST("/v1/xa/prepare/:topic/:ver", m(this.pubServer.xa_prepare))
-		this.pubServer.Router().PUT("/v1/xa/rollback", m(this.pubServer.xa_commit))
-		this.pubServer.Router().PUT("/v1/xa/abort", m(this.pubServer.xa_rollback))
+		this.pubServer.Router().POST("/v1/xa/prepare/:topic/:ver", m(this.pubServer.guard(acl.OpPub, this.pubServer.xa_prepare)))
+		this.pubServer.Router().PUT("/v1/xa/commit/:topic/:ver", m(this.pubServer.guard(acl.OpPub, this.pubServer.xa_commit)))
+		this.pubServer.Router().PUT("/v1/xa/rollback/:topic/:ver", m(this.pubServer.guard(acl.OpPub, this.pubServer.xa_rollback)))
 
 		// TODO deprecated
-		this.pubServer.Router().POST("/topics/:topic/:ver", m(this.pubServer.pubHandler))
+		this.pubServer.Router().POST("/topics/:topic/:ver", m(this.pubServer.guard(acl.OpPub, this.pubServer.pubHandler)))
 	}
 
 	if this.subServer != nil {
@@ -94,15 +120,17 @@ func (this *Gateway) buildRouting() {
 		// health check
 		this.subServer.Router().GET("/alive", m(this.checkAliveHandler))
 
-		this.subServer.Router().GET("/v1/raw/msgs/:cluster/:topic", m(this.subServer.subRawHandler))
-		this.subServer.Router().GET("/v1/msgs/:appid/:topic/:ver", m(this.subServer.subHandler))
-		this.subServer.Router().PUT("/v1/msgs/:appid/:topic/:ver", m(this.subServer.buryHandler))
-		this.subServer.Router().GET("/v1/ws/msgs/:appid/:topic/:ver", m(this.subServer.subWsHandler))
-		this.subServer.Router().PUT("/v1/offsets/:appid/:topic/:ver/:group", m(this.subServer.ackHandler))
-		this.subServer.Router().PUT("/v1/raw/offsets/:cluster/:topic/:group", m(this.subServer.ackRawHandler))
+		this.subServer.Router().GET("/v1/raw/msgs/:cluster/:topic", m(this.subServer.guard(acl.OpSub, this.subServer.subRawHandler)))
+		this.subServer.Router().GET("/v1/msgs/:appid/:topic/:ver", m(this.subServer.guard(acl.OpSub, this.subServer.subHandler)))
+		this.subServer.Router().PUT("/v1/msgs/:appid/:topic/:ver", m(this.subServer.guard(acl.OpBury, this.subServer.buryHandler)))
+		this.subServer.Router().GET("/v1/ws/msgs/:appid/:topic/:ver", m(this.subServer.guard(acl.OpSub, this.subServer.subWsHandler)))
+		this.subServer.Router().PUT("/v1/offsets/:appid/:topic/:ver/:group", m(this.subServer.guard(acl.OpSub, this.subServer.ackHandler)))
+		this.subServer.Router().PUT("/v1/raw/offsets/:cluster/:topic/:group", m(this.subServer.guard(acl.OpSub, this.subServer.ackRawHandler)))
+		this.subServer.Router().PUT("/v1/ack/:appid/:topic/:ver", m(this.subServer.guard(acl.OpSub, this.subServer.ackInflightHandler)))
+		this.subServer.Router().PUT("/v1/nack/:appid/:topic/:ver", m(this.subServer.guard(acl.OpSub, this.subServer.nackInflightHandler)))
 
 		// TODO deprecated
-		this.subServer.Router().GET("/topics/:appid/:topic/:ver", m(this.subServer.subHandler))
+		this.subServer.Router().GET("/topics/:appid/:topic/:ver", m(this.subServer.guard(acl.OpSub, this.subServer.subHandler)))
 	}
 
 	if this.debugMux != nil {
@@ -2,6 +2,9 @@ package gateway
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 )
@@ -11,7 +14,7 @@ type connStateFunc func(c net.Conn, cs http.ConnState)
 type onConnNewFunc func(net.Conn)
 type onConnCloseFunc func(net.Conn)
 
-func setupHttpsListener(listener net.Listener, certFile, keyFile string) (net.Listener, *tls.Config, error) {
+func setupHttpsListener(listener net.Listener, certFile, keyFile, clientCAFile string) (net.Listener, *tls.Config, error) {
 	cer, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
 		return nil, nil, err
@@ -22,6 +25,20 @@ func setupHttpsListener(listener net.Listener, certFile, keyFile string) (net.Li
 		Certificates: []tls.Certificate{cer},
 	}
 
+	if clientCAFile != "" {
+		// client certificate is optional, the mtls auth provider requires it
+		ca, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(ca) {
+			return nil, nil, fmt.Errorf("no certificate found in %s", clientCAFile)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
 	tlsListener := tls.NewListener(listener, config)
 	return tlsListener, config, nil
 }
@@ -20,6 +20,7 @@ func newManServer(httpAddr, httpsAddr string, maxClients int, gw *Gateway) *manS
 		throttleAddTopic:  ratelimiter.NewLeakyBuckets(60, time.Minute),
 		throttleSubStatus: ratelimiter.NewLeakyBuckets(60, time.Minute),
 	}
+	this.setupAuth(Options.ManHttpAuth, Options.ManHttpsAuth, HttpHeaderPubkey, HttpHeaderSubkey)
 
 	return this
 }
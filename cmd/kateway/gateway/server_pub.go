@@ -20,6 +20,9 @@ type pubServer struct {
 	auditor     log.Logger
 
 	throttleBadAppid *ratelimiter.LeakyBuckets
+
+	// websocket heartbeat configuration
+	wsPongWait time.Duration
 }
 
 func newPubServer(httpAddr, httpsAddr string, maxClients int, gw *Gateway) *pubServer {
@@ -27,8 +30,10 @@ func newPubServer(httpAddr, httpsAddr string, maxClients int, gw *Gateway) *pubS
 		webServer:        newWebServer("pub_server", httpAddr, httpsAddr, maxClients, Options.HttpReadTimeout, gw),
 		throttlePub:      ratelimiter.NewLeakyBuckets(Options.PubQpsLimit, time.Minute),
 		throttleBadAppid: ratelimiter.NewLeakyBuckets(3, time.Minute),
+		wsPongWait:       time.Minute,
 	}
 	this.pubMetrics = NewPubMetrics(this.gw)
+	this.setupAuth(Options.PubHttpAuth, Options.PubHttpsAuth, HttpHeaderPubkey)
 	this.onConnNewFunc = this.onConnNew
 	this.onConnCloseFunc = this.onConnClose
 
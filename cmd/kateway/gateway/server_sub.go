@@ -9,6 +9,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
 	"github.com/funkygao/gafka/cmd/kateway/meta"
 	"github.com/funkygao/golib/ratelimiter"
 	"github.com/funkygao/golib/sync2"
@@ -17,6 +18,14 @@ import (
 	"github.com/samuel/go-zookeeper/zk"
 )
 
+// retryPolicyTTL is how long the retry policy of a retry queue is cached.
+const retryPolicyTTL = time.Minute
+
+type retryPolicyEntry struct {
+	found  bool
+	expire time.Time
+}
+
 type subServer struct {
 	*webServer
 
@@ -40,6 +49,11 @@ type subServer struct {
 
 	subMetrics *subMetrics
 
+	buryDedup dedup.Deduper // makes bury idempotent, nil if disabled
+
+	retryPolicies     map[string]retryPolicyEntry // retry topic: has retry policy or not
+	retryPoliciesLock sync.RWMutex
+
 	badGroupBudget   *ratelimiter.LeakyBuckets
 	goodGroupClients map[string]struct{} // key is remote addr(port inclusive)
 	goodGroupLock    sync.RWMutex
@@ -58,8 +72,10 @@ func newSubServer(httpAddr, httpsAddr string, maxClients int, gw *Gateway) *subS
 		ackShutdown:      0,
 		ackCh:            make(chan ackOffsets, 100),
 		ackedOffsets:     make(map[string]map[string]map[string]map[int]int64),
+		retryPolicies:    make(map[string]retryPolicyEntry),
 	}
 	this.subMetrics = NewSubMetrics(this.gw)
+	this.setupAuth(Options.SubHttpAuth, Options.SubHttpsAuth, HttpHeaderSubkey)
 	this.waitExitFunc = this.waitExit
 	this.connStateFunc = this.connStateHandler
 
@@ -11,6 +11,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/auth"
 	"github.com/funkygao/httprouter"
 	log "github.com/funkygao/log4go"
 )
@@ -29,6 +30,9 @@ type webServer struct {
 	httpsListener net.Listener
 	httpsServer   *http.Server
 
+	httpAuth  auth.Provider
+	httpsAuth auth.Provider
+
 	waitExitFunc    waitExitFunc
 	connStateFunc   connStateFunc
 	onConnNewFunc   onConnNewFunc
@@ -144,7 +148,7 @@ func (this *webServer) startServer(https bool) {
 				}
 
 				var tlsConfig *tls.Config
-				theListener, tlsConfig, err = setupHttpsListener(this.httpsListener, this.gw.certFile, this.gw.keyFile)
+				theListener, tlsConfig, err = setupHttpsListener(this.httpsListener, this.gw.certFile, this.gw.keyFile, this.gw.clientCAFile)
 				if err != nil {
 					panic(err)
 				}
@@ -1,55 +1,40 @@
 package gateway
 
 import (
-	"bytes"
 	"strings"
-
-	"github.com/funkygao/gafka/mpool"
 )
 
+// Legacy tagged messages are published before the message envelope was introduced:
+// ┌────────────────────────────┐ ┌────────┐
+// │TagMarkStart Tag TagMarkEnd │ │Message │
+// └────────────────────────────┘ └────────┘
+// Tags are now carried by the envelope, and the legacy format is only decoded.
 const (
-	TagMarkStart = byte(1) // FIXME conflicts with ProtocolBuffer
+	TagMarkStart = byte(1)
 	TagMarkEnd   = byte(2)
 	TagSeperator = ";" // follow cookie rules a=b;c=d
 )
 
-func IsTaggedMessage(msg []byte) bool {
-	return msg[0] == TagMarkStart
-}
-
-// ┌────────────────────────────┐ ┌────────┐
-// │TagMarkStart Tag TagMarkEnd │ │Message │
-// └────────────────────────────┘ └────────┘
-func AddTagToMessage(m *mpool.Message, tag string) {
-	shift := tagLen(tag)
-	for i := len(m.Body) - 1; i >= shift; i-- {
-		m.Body[i] = m.Body[i-shift]
+// decodeLegacyTag returns the tag and where the body starts of a legacy tagged message.
+// idx is 0 if it is not a legacy tagged message, e,g. ProtocolBuffer payload that
+// happens to start with TagMarkStart.
+func decodeLegacyTag(msg []byte) (tag string, idx int) {
+	if len(msg) == 0 || msg[0] != TagMarkStart {
+		return
 	}
 
-	i := 0
-	m.Body[i] = TagMarkStart
-	i++
-	for _, b := range tag {
-		m.Body[i] = byte(b)
-		i++
-	}
-	m.Body[i] = TagMarkEnd
-}
+	for i := 1; i < len(msg); i++ {
+		switch c := msg[i]; {
+		case c == TagMarkEnd:
+			return string(msg[1:i]), i + 1
 
-func ExtractMessageTag(msg []byte) ([]string, int, error) {
-	tagEnd := bytes.IndexByte(msg, TagMarkEnd)
-	if tagEnd == -1 {
-		// not a tagged message
-		return nil, 0, ErrIllegalTaggedMessage
+		case c < ' ' || c > '~':
+			// tag is always printable
+			return "", 0
+		}
 	}
 
-	tag := string(msg[1:tagEnd]) // discard the tag mark start
-	tags := parseMessageTag(tag)
-	return tags, tagEnd + 1, nil
-}
-
-func tagLen(tag string) int {
-	return 2 + len(tag) // TagMarkStart tag TagMarkEnd
+	return "", 0
 }
 
 func parseMessageTag(tag string) []string {
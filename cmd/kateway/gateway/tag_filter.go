@@ -0,0 +1,251 @@
+package gateway
+
+import (
+	"strings"
+)
+
+// TagFilter is a compiled boolean expression over message tags.
+//
+//	expr  = or
+//	or    = and { ("|" | "||" | "OR" | ";") and }
+//	and   = unary { ("&" | "&&" | "AND") unary }
+//	unary = ("!" | "NOT") unary | "(" expr ")" | term
+//	term  = key | key "=" value | prefix "*"
+//
+// A bare key matches tag 'key' or any 'key=value', 'key=value' matches exactly
+// and a term ending with '*' is prefix match.
+// The legacy 'a;b' form still means any of the tags.
+type TagFilter struct {
+	expr string
+	root tagExpr
+}
+
+// CompileTagFilter parses a tag filter expression.
+// Empty expression returns a nil filter which matches all messages.
+func CompileTagFilter(expr string) (*TagFilter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+
+	p := &tagParser{tokens: lexTagFilter(expr)}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, ErrIllegalTagFilter
+	}
+
+	return &TagFilter{expr: expr, root: root}, nil
+}
+
+// Match evaluates the filter against tags of a message.
+func (this *TagFilter) Match(tags []string) bool {
+	if this == nil {
+		return true
+	}
+
+	return this.root.eval(tags)
+}
+
+// MatchMessage decodes the envelope of a raw message and evaluates the filter.
+// bodyIdx is where the message body starts.
+func (this *TagFilter) MatchMessage(msg []byte) (ok bool, bodyIdx int, err error) {
+	var h MessageHeaders
+	if h, bodyIdx, err = DecodeMessage(msg); err != nil {
+		return
+	}
+
+	return this.Match(h.Tags()), bodyIdx, nil
+}
+
+func (this *TagFilter) String() string {
+	return this.expr
+}
+
+type tagExpr interface {
+	eval(tags []string) bool
+}
+
+type tagTerm struct {
+	text   string
+	prefix bool
+}
+
+func (t tagTerm) eval(tags []string) bool {
+	for _, tag := range tags {
+		switch {
+		case t.prefix:
+			if strings.HasPrefix(tag, t.text) {
+				return true
+			}
+
+		case tag == t.text:
+			return true
+
+		case strings.IndexByte(t.text, '=') == -1 && strings.HasPrefix(tag, t.text+"="):
+			return true
+		}
+	}
+
+	return false
+}
+
+type tagNot struct {
+	x tagExpr
+}
+
+func (t tagNot) eval(tags []string) bool {
+	return !t.x.eval(tags)
+}
+
+type tagAnd struct {
+	l, r tagExpr
+}
+
+func (t tagAnd) eval(tags []string) bool {
+	return t.l.eval(tags) && t.r.eval(tags)
+}
+
+type tagOr struct {
+	l, r tagExpr
+}
+
+func (t tagOr) eval(tags []string) bool {
+	return t.l.eval(tags) || t.r.eval(tags)
+}
+
+const tagFilterOperators = "()!&|;"
+
+func lexTagFilter(expr string) []string {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+
+		case strings.IndexByte(tagFilterOperators, c) != -1:
+			if (c == '&' || c == '|') && i+1 < len(expr) && expr[i+1] == c {
+				i++ // && ||
+			}
+			tokens = append(tokens, string(c))
+			i++
+
+		default:
+			j := i
+			for j < len(expr) && expr[j] != ' ' && expr[j] != '\t' &&
+				strings.IndexByte(tagFilterOperators, expr[j]) == -1 {
+				j++
+			}
+
+			switch word := expr[i:j]; word {
+			case "AND":
+				tokens = append(tokens, "&")
+			case "OR":
+				tokens = append(tokens, "|")
+			case "NOT":
+				tokens = append(tokens, "!")
+			default:
+				tokens = append(tokens, word)
+			}
+			i = j
+		}
+	}
+
+	return tokens
+}
+
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *tagParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *tagParser) parseOr() (tagExpr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch p.peek() {
+		case "|", ";":
+			p.pos++
+			if p.peek() == "" && p.tokens[p.pos-1] == ";" {
+				return l, nil // legacy trailing seperator: a;b;
+			}
+
+			r, err := p.parseAnd()
+			if err != nil {
+				return nil, err
+			}
+			l = tagOr{l: l, r: r}
+
+		default:
+			return l, nil
+		}
+	}
+}
+
+func (p *tagParser) parseAnd() (tagExpr, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "&" {
+		p.pos++
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = tagAnd{l: l, r: r}
+	}
+
+	return l, nil
+}
+
+func (p *tagParser) parseUnary() (tagExpr, error) {
+	switch tok := p.peek(); tok {
+	case "":
+		return nil, ErrIllegalTagFilter
+
+	case "!":
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return tagNot{x: x}, nil
+
+	case "(":
+		p.pos++
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, ErrIllegalTagFilter
+		}
+		p.pos++
+		return x, nil
+
+	default:
+		if strings.IndexByte(tagFilterOperators, tok[0]) != -1 {
+			return nil, ErrIllegalTagFilter
+		}
+
+		p.pos++
+		if strings.HasSuffix(tok, "*") {
+			return tagTerm{text: strings.TrimSuffix(tok, "*"), prefix: true}, nil
+		}
+		return tagTerm{text: tok}, nil
+	}
+}
@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestCompileTagFilterIllegal(t *testing.T) {
+	for _, expr := range []string{
+		"a &", "(a", "a)", "a b", "| a", "!", "a & & b", "()",
+	} {
+		_, err := CompileTagFilter(expr)
+		assert.Equal(t, ErrIllegalTagFilter, err)
+	}
+
+	f, err := CompileTagFilter(" ")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, f == nil)
+	assert.Equal(t, true, f.Match(nil))
+}
+
+func TestTagFilterMatch(t *testing.T) {
+	tags := []string{"a=b", "c=d", "vip"}
+	cases := []struct {
+		expr  string
+		match bool
+	}{
+		{"a=b", true},
+		{"a=c", false},
+		{"a", true},     // bare key
+		{"vi", false},   // not prefix
+		{"vi*", true},   // prefix
+		{"a=*", true},   // prefix on value
+		{"x;vip", true}, // legacy OR
+		{"x;y;", false}, // legacy with trailing seperator
+		{"a=b & c=d", true},
+		{"a=b AND c=x", false},
+		{"a=x || c=d", true},
+		{"a=x OR c=x", false},
+		{"!a", false},
+		{"NOT x", true},
+		{"!(a=x | c=x) & vip", true},
+		{"a=b & (c=x | !vip)", false},
+		{"a=x | a=b & c=d", true}, // AND binds tighter
+	}
+	for _, c := range cases {
+		f, err := CompileTagFilter(c.expr)
+		assert.Equal(t, nil, err)
+		if f.Match(tags) != c.match {
+			t.Fatalf("%s expected %v", c.expr, c.match)
+		}
+	}
+
+	// untagged message
+	f, _ := CompileTagFilter("!vip")
+	assert.Equal(t, true, f.Match(nil))
+}
+
+func TestTagFilterMatchMessage(t *testing.T) {
+	body := "hello world"
+	m := newEnvelopedMessage(body, NewMessageHeaders("a=b;vip", "", "", false))
+
+	f, _ := CompileTagFilter("vip & a=b")
+	ok, bodyIdx, err := f.MatchMessage(m.Body)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, body, string(m.Body[bodyIdx:]))
+
+	f, _ = CompileTagFilter("!vip")
+	ok, _, _ = f.MatchMessage(m.Body)
+	assert.Equal(t, false, ok)
+	ok, bodyIdx, _ = f.MatchMessage([]byte(body))
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 0, bodyIdx)
+
+	// legacy tagged message
+	ok, bodyIdx, _ = f.MatchMessage([]byte("\x01vip\x02" + body))
+	assert.Equal(t, false, ok)
+	assert.Equal(t, body, string([]byte("\x01vip\x02" + body)[bodyIdx:]))
+}
+
+func BenchmarkTagFilterMatch(b *testing.B) {
+	b.ReportAllocs()
+	f, _ := CompileTagFilter("(a=b | c=d) & !vip*")
+	tags := []string{"a=b", "c=d", "x"}
+	for i := 0; i < b.N; i++ {
+		f.Match(tags)
+	}
+}
@@ -33,6 +33,15 @@ func getHttpQueryInt(query *url.Values, key string, defaultVal int) (int, error)
 	return strconv.Atoi(valStr)
 }
 
+func getHttpQueryInt64(query *url.Values, key string, defaultVal int64) (int64, error) {
+	valStr := query.Get(key)
+	if valStr == "" {
+		return defaultVal, nil
+	}
+
+	return strconv.ParseInt(valStr, 10, 64)
+}
+
 // getHttpRemoteIp returns ip only, without remote port.
 func getHttpRemoteIp(r *http.Request) string {
 	forwardFor := r.Header.Get(HttpHeaderXForwardedFor) // client_ip,proxy_ip,proxy_ip,...
@@ -57,6 +66,40 @@ func getHttpRemoteIp(r *http.Request) string {
 	}
 }
 
+// webhookReservedHeaders can't be customized by webhook deliveries.
+var webhookReservedHeaders = map[string]struct{}{
+	"Host":              {},
+	"Content-Length":    {},
+	"Transfer-Encoding": {},
+	"Connection":        {},
+	"User-Agent":        {},
+	"X-App-Signature":   {},
+	HttpHeaderSignature: {},
+	HttpHeaderTimestamp: {},
+	HttpHeaderOffset:    {},
+	HttpHeaderPartition: {},
+}
+
+func validateWebhookHeaders(headers map[string]string) error {
+	for k, v := range headers {
+		if k == "" || strings.IndexFunc(k, func(r rune) bool {
+			return r <= ' ' || r >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, r)
+		}) != -1 {
+			return fmt.Errorf("invalid header: %q", k)
+		}
+
+		if _, present := webhookReservedHeaders[http.CanonicalHeaderKey(k)]; present {
+			return fmt.Errorf("reserved header: %s", k)
+		}
+
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("invalid header value: %s", k)
+		}
+	}
+
+	return nil
+}
+
 func checkUlimit(min int) {
 	ulimitN, err := exec.Command("/bin/sh", "-c", "ulimit -n").Output()
 	if err != nil {
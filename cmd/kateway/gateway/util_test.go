@@ -67,3 +67,12 @@ func BenchmarkGetHttpRemoteIp(b *testing.B) {
 		getHttpRemoteIp(r)
 	}
 }
+
+func TestValidateWebhookHeaders(t *testing.T) {
+	assert.Equal(t, nil, validateWebhookHeaders(nil))
+	assert.Equal(t, nil, validateWebhookHeaders(map[string]string{"Authorization": "Basic YTpi", "X-Foo": "bar"}))
+	assert.NotEqual(t, nil, validateWebhookHeaders(map[string]string{"x-signature": "forged"}))
+	assert.NotEqual(t, nil, validateWebhookHeaders(map[string]string{"X Foo": "bar"}))
+	assert.NotEqual(t, nil, validateWebhookHeaders(map[string]string{"": "bar"}))
+	assert.NotEqual(t, nil, validateWebhookHeaders(map[string]string{"X-Foo": "bar\r\nHost: evil"}))
+}
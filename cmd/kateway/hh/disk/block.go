@@ -7,7 +7,8 @@ import (
 )
 
 type block struct {
-	magic [2]byte // TODO [0]magic [1]attr
+	magic [2]byte // [0]magic [1]attr
+	msgId []byte  // present only if attrMsgId is set
 	key   []byte
 	value []byte
 
@@ -15,9 +16,17 @@ type block struct {
 }
 
 func (b *block) size() int64 {
+	if b.hasMsgId() {
+		return int64(len(b.msgId) + len(b.key) + len(b.value) + 14)
+	}
+
 	return int64(len(b.key) + len(b.value) + 10)
 }
 
+func (b *block) hasMsgId() bool {
+	return b.magic[1]&attrMsgId != 0
+}
+
 func (b *block) keyLen() uint32 {
 	return uint32(len(b.key))
 }
@@ -31,6 +40,16 @@ func (b *block) writeTo(w io.Writer) (err error) {
 		return
 	}
 
+	if b.hasMsgId() {
+		if err = b.writeUint32(w, uint32(len(b.msgId))); err != nil {
+			return
+		}
+
+		if err = writeBytes(w, b.msgId); err != nil {
+			return
+		}
+	}
+
 	if err = b.writeUint32(w, b.keyLen()); err != nil {
 		return
 	}
@@ -55,10 +74,27 @@ func (b *block) readFrom(r io.Reader, buf []byte) error {
 	if err := readBytes(r, b.rbuf[:2]); err != nil {
 		return err
 	}
-	for i := 0; i < len(currentMagic); i++ {
-		if b.rbuf[i] != currentMagic[i] {
+	if b.rbuf[0] != currentMagic[0] || b.rbuf[1]&^attrMask != 0 {
+		return ErrSegmentCorrupt
+	}
+	b.magic[0], b.magic[1] = b.rbuf[0], b.rbuf[1]
+
+	b.msgId = b.msgId[:0]
+	if b.hasMsgId() {
+		idLen, err := b.readUint32(r)
+		if err != nil {
+			return err
+		}
+
+		if idLen > maxMsgIdLen {
 			return ErrSegmentCorrupt
 		}
+
+		if err = readBytes(r, buf[:int(idLen)]); err != nil {
+			return err
+		}
+
+		b.msgId = append(b.msgId, buf[:int(idLen)]...)
 	}
 
 	keyLen, err := b.readUint32(r)
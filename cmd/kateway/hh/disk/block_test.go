@@ -1,6 +1,7 @@
 package disk
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/funkygao/assert"
@@ -20,3 +21,37 @@ func TestBlockBasic(t *testing.T) {
 func TestBlockReadWrite(t *testing.T) {
 	t.SkipNow()
 }
+
+func TestBlockReadWriteWithMsgId(t *testing.T) {
+	var buf bytes.Buffer
+	b := block{
+		magic: currentMagic,
+		key:   []byte("abc"),
+		value: []byte("12345678"),
+	}
+	b.magic[1] |= attrMsgId
+	b.msgId = []byte("id1")
+	assert.Equal(t, true, b.hasMsgId())
+	assert.Equal(t, nil, b.writeTo(&buf))
+	assert.Equal(t, b.size(), int64(buf.Len()))
+
+	// legacy block without msg id
+	b1 := block{
+		magic: currentMagic,
+		key:   []byte("k"),
+		value: []byte("v"),
+	}
+	assert.Equal(t, nil, b1.writeTo(&buf))
+
+	var b2 block
+	rbuf := make([]byte, maxBlockSize)
+	assert.Equal(t, nil, b2.readFrom(&buf, rbuf))
+	assert.Equal(t, "id1", string(b2.msgId))
+	assert.Equal(t, "abc", string(b2.key))
+	assert.Equal(t, "12345678", string(b2.value))
+
+	assert.Equal(t, nil, b2.readFrom(&buf, rbuf))
+	assert.Equal(t, false, b2.hasMsgId())
+	assert.Equal(t, 0, len(b2.msgId))
+	assert.Equal(t, "v", string(b2.value))
+}
@@ -120,11 +120,19 @@ func (this *Service) ResetCounters() {
 }
 
 func (this *Service) Append(cluster, topic string, key, value []byte) error {
+	return this.AppendWithId(cluster, topic, "", key, value)
+}
+
+func (this *Service) AppendWithId(cluster, topic, msgId string, key, value []byte) error {
 	if this.closed {
 		return ErrNotOpen
 	}
 
 	b := &block{magic: currentMagic, key: key, value: value}
+	if msgId != "" {
+		b.magic[1] |= attrMsgId
+		b.msgId = []byte(msgId)
+	}
 	ct := clusterTopic{cluster: cluster, topic: topic}
 
 	log.Debug("hh[%s] append %s/%s", this.Name(), cluster, topic)
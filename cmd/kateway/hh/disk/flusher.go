@@ -27,6 +27,12 @@ func (q *queue) FlushInflights(errCh chan<- error, wg *sync.WaitGroup) {
 		err = q.Next(&b)
 		switch err {
 		case nil:
+			if q.delivered(&b) {
+				q.cursor.commitPosition()
+				q.inflights.Add(-1)
+				continue
+			}
+
 			for retries := 0; retries < flusherMaxRetries; retries++ {
 				partition, offset, err = store.DefaultPubStore.SyncPub(q.clusterTopic.cluster, q.clusterTopic.topic, b.key, b.value)
 				if err == nil {
@@ -34,6 +40,8 @@ func (q *queue) FlushInflights(errCh chan<- error, wg *sync.WaitGroup) {
 						Auditor.Trace("queue[%s] {P:%d O:%d}", q.ident(), partition, offset)
 					}
 
+					q.markDelivered(&b, partition, offset)
+
 					q.cursor.commitPosition()
 					okN++
 					q.inflights.Add(-1)
@@ -12,6 +12,11 @@ const (
 
 	defaultSegmentSize = 100 << 20 // if each block=1k, can hold up to 100k blocks
 	maxBlockSize       = 1 << 20
+	maxMsgIdLen        = 1 << 10
+
+	// block attributes
+	attrMsgId = 1 << 0
+	attrMask  = attrMsgId
 
 	defaultPurgeInterval = time.Minute * 10
 	defaultMaxAge        = time.Hour * 24 * 7
@@ -3,6 +3,7 @@ package disk
 import (
 	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/dedup"
 	"github.com/funkygao/gafka/cmd/kateway/store"
 	log "github.com/funkygao/log4go"
 )
@@ -37,6 +38,15 @@ func (q *queue) pump() {
 		err = q.Next(&b)
 		switch err {
 		case nil:
+			if q.delivered(&b) {
+				log.Debug("queue[%s] skipped delivered block {id:%s}", q.ident(), string(b.msgId))
+
+				q.cursor.commitPosition()
+				q.inflights.Add(-1)
+				q.deliverN.Add(1)
+				continue
+			}
+
 			for retries = 0; retries < defaultMaxRetries; retries++ {
 				// TODO we might use AsyncPub
 				partition, offset, err = store.DefaultPubStore.SyncPub(q.clusterTopic.cluster, q.clusterTopic.topic, b.key, b.value)
@@ -45,6 +55,8 @@ func (q *queue) pump() {
 						Auditor.Trace("queue[%s] {P:%d O:%d}", q.ident(), partition, offset)
 					}
 
+					q.markDelivered(&b, partition, offset)
+
 					q.cursor.commitPosition()
 					okN++
 					q.inflights.Add(-1)
@@ -111,3 +123,22 @@ func (q *queue) pump() {
 		}
 	}
 }
+
+// delivered returns whether the block with msg id has already been delivered, e.g.
+// the cursor was not dumped before restart and the block is replayed.
+func (q *queue) delivered(b *block) bool {
+	if !b.hasMsgId() || dedup.Default == nil {
+		return false
+	}
+
+	_, offset, found := dedup.Default.Lookup(q.clusterTopic.topic, string(b.msgId))
+	return found && offset > -1
+}
+
+func (q *queue) markDelivered(b *block, partition int32, offset int64) {
+	if !b.hasMsgId() || dedup.Default == nil {
+		return
+	}
+
+	dedup.Default.Commit(q.clusterTopic.topic, string(b.msgId), partition, offset)
+}
@@ -20,6 +20,8 @@ import (
 // | 2 bytes | | 4 bytes | | N bytes | | 4 bytes   | | N bytes | | 2 bytes | | 4 bytes | | N bytes | | 4 bytes   | | N bytes |
 // └─────────┘ └─────────┘ └─────────┘ └───────────┘ └─────────┘ └─────────┘ └─────────┘ └─────────┘ └───────────┘ └─────────┘
 //
+// If the attr byte of magic has attrMsgId set, a 4 bytes msg id len and the msg id follow the magic.
+//
 // Segments store arbitrary byte slices and leave the serialization to the caller.  Segments
 // are created with a max size and will block writes when the segment is full.
 type segment struct {
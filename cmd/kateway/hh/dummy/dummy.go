@@ -27,6 +27,10 @@ func (this *dummyStore) Append(cluster, topic string, key, value []byte) error {
 	return nil
 }
 
+func (this *dummyStore) AppendWithId(cluster, topic, msgId string, key, value []byte) error {
+	return nil
+}
+
 func (this *dummyStore) Empty(cluster, topic string) bool {
 	return true
 }
@@ -18,6 +18,10 @@ type Service interface {
 	// Append add key/value byte slice to end of the buffer.
 	Append(cluster, topic string, key, value []byte) error
 
+	// AppendWithId is Append with a client supplied message id which
+	// prevents the message from being delivered more than once.
+	AppendWithId(cluster, topic, msgId string, key, value []byte) error
+
 	// Empty returns whether the buffer has no inflight entries.
 	Empty(cluster, topic string) bool
 
@@ -1,22 +1,27 @@
-// Deprecated pkg
-// Package inflight provides storage for manipulating inflight
-// message offsets.
+// Package inflight provides storage for delivered but unacked messages,
+// which gives Sub the SQS-like visibility timeout semantics.
 //
-//  server               client
-//    |                    |
-//    |               Sub  |
-//    |<-------------------|
-//    |                    |
-//    | Ok/TakeOff(1)      |
-//    |------------------->|
-//    |                    |
-//    |        Sub/Land(1) |
-//    |<-------------------|
-//    |                    |
-//    | Ok/TakeOff(2)      |
-//    |------------------->|
-//    |                    |
-//    |        Sub/Land(2) |
-//    |<-------------------|
-//    |                    |
+//  server                       client
+//    |                            |
+//    |   Sub?visibility=30        |
+//    |<---------------------------|
+//    |                            |
+//    | Ok/TakeOff(1)              |
+//    |--------------------------->|
+//    |                            |
+//    |          Ack(1)/Land(1)    |
+//    |<---------------------------|
+//    |                            |
+//    | Ok/TakeOff(2)              |
+//    |--------------------------->|
+//    |                            |
+//    |          Nack(2)           |
+//    |<---------------------------|
+//    |                            |
+//    | Ok/Redeliver(2)            |
+//    |--------------------------->|
+//    |                            |
+//
+// A message not landed within the visibility timeout is redelivered, and after
+// max redeliveries it is buried to the dead letter shadow topic.
 package inflight
@@ -5,5 +5,5 @@ import (
 )
 
 var (
-	ErrOutOfOrder = errors.New("out of order inflight offset")
+	ErrNotInflight = errors.New("message not inflight")
 )
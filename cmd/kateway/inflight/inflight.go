@@ -1,11 +1,34 @@
 package inflight
 
+import (
+	"time"
+)
+
+// Message is a delivered message awaiting ack.
+type Message struct {
+	Partition  int32
+	Offset     int64
+	Key        []byte
+	Value      []byte
+	Deliveries int // how many times it has been delivered
+}
+
+// Inflight tracks the delivered but unacked messages of consumer groups.
+// Each inflight message is invisible until its visibility timeout expires.
 type Inflight interface {
-	Land(cluster, topic, group, partition string, offset int64) error
+	// TakeOff records a delivered message, it becomes visible again after timeout unless landed.
+	// Taking off an inflight message again counts as another delivery.
+	TakeOff(cluster, topic, group string, partition int32, offset int64, key, value []byte,
+		timeout time.Duration) error
+
+	// Land acks an inflight message and forgets it.
+	Land(cluster, topic, group string, partition int32, offset int64) error
 
-	LandX(cluster, topic, group, partition string, offset int64) ([]byte, error)
+	// Nack makes an inflight message visible immediately.
+	Nack(cluster, topic, group string, partition int32, offset int64) error
 
-	TakeOff(cluster, topic, group, partition string, offset int64, msg []byte) error
+	// Redeliver takes off again at most n visible messages for another timeout.
+	Redeliver(cluster, topic, group string, n int, timeout time.Duration) ([]Message, error)
 
 	Init() error
 	Stop() error
@@ -2,112 +2,165 @@ package mem
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/funkygao/gafka/cmd/kateway/inflight"
-	"github.com/funkygao/golib/cmap"
-	log "github.com/funkygao/log4go"
 )
 
-type dumpRecord struct {
-	Key string
-	Val message
+type queueKey struct {
+	Cluster, Topic, Group string
+}
+
+type msgKey struct {
+	Partition int32
+	Offset    int64
+}
+
+type msgKeys []msgKey
+
+func (this msgKeys) Len() int      { return len(this) }
+func (this msgKeys) Swap(i, j int) { this[i], this[j] = this[j], this[i] }
+func (this msgKeys) Less(i, j int) bool {
+	if this[i].Partition != this[j].Partition {
+		return this[i].Partition < this[j].Partition
+	}
+	return this[i].Offset < this[j].Offset
 }
 
 type message struct {
-	Offset int64
-	Value  []byte
+	Key        []byte
+	Value      []byte
+	Deliveries int
+	VisibleAt  time.Time
+}
+
+type dumpRecord struct {
+	Queue queueKey
+	Msg   msgKey
+	Val   message
 }
 
+// memInflight is a process local Inflight, the acks must land on the same kateway.
 type memInflight struct {
-	offsets cmap.ConcurrentMap
+	mu     sync.Mutex
+	queues map[queueKey]map[msgKey]*message
 
 	snapshotFile string
-	debug        bool
 }
 
-func New(fn string, debug bool) *memInflight {
+func New(fn string) *memInflight {
 	return &memInflight{
-		offsets:      cmap.New(),
+		queues:       make(map[queueKey]map[msgKey]*message),
 		snapshotFile: fn,
-		debug:        debug,
 	}
 }
 
-// FIXME bad perf
-func (this *memInflight) key(cluster, topic, group, partition string) string {
-	return fmt.Sprintf("%s:%s:%s:%s", cluster, topic, group, partition)
-}
+func (this *memInflight) TakeOff(cluster, topic, group string, partition int32, offset int64, key, value []byte,
+	timeout time.Duration) error {
+	q := queueKey{Cluster: cluster, Topic: topic, Group: group}
+	k := msgKey{Partition: partition, Offset: offset}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
 
-func (this *memInflight) Land(cluster, topic, group, partition string, offset int64) error {
-	key := this.key(cluster, topic, group, partition)
-	if this.debug {
-		log.Debug("Land %s => %d", key, offset)
+	msgs, present := this.queues[q]
+	if !present {
+		msgs = make(map[msgKey]*message)
+		this.queues[q] = msgs
 	}
-	o, found := this.offsets.Get(key)
-	if !found || o.(message).Offset != offset {
-		if this.debug {
-			log.Error("out of order: %s", this)
+
+	msg, present := msgs[k]
+	if !present {
+		// the caller might reuse the buffer
+		msg = &message{
+			Key:   append([]byte(nil), key...),
+			Value: append([]byte(nil), value...),
 		}
-		return inflight.ErrOutOfOrder
+		msgs[k] = msg
 	}
-
-	this.offsets.Remove(key)
+	msg.Deliveries++
+	msg.VisibleAt = time.Now().Add(timeout)
 	return nil
 }
 
-func (this *memInflight) LandX(cluster, topic, group, partition string, offset int64) ([]byte, error) {
-	key := this.key(cluster, topic, group, partition)
-	if this.debug {
-		log.Debug("LandX %s => %d", key, offset)
+func (this *memInflight) Land(cluster, topic, group string, partition int32, offset int64) error {
+	q := queueKey{Cluster: cluster, Topic: topic, Group: group}
+	k := msgKey{Partition: partition, Offset: offset}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	msgs := this.queues[q]
+	if _, present := msgs[k]; !present {
+		return inflight.ErrNotInflight
 	}
-	o, found := this.offsets.Get(key)
-	if !found || o.(message).Offset != offset {
-		if this.debug {
-			log.Error("out of order: %s", this)
-		}
-		return nil, inflight.ErrOutOfOrder
+
+	delete(msgs, k)
+	if len(msgs) == 0 {
+		delete(this.queues, q)
 	}
+	return nil
+}
 
-	msg := o.(message).Value
+func (this *memInflight) Nack(cluster, topic, group string, partition int32, offset int64) error {
+	q := queueKey{Cluster: cluster, Topic: topic, Group: group}
+	k := msgKey{Partition: partition, Offset: offset}
 
-	this.offsets.Remove(key)
-	return msg, nil
-}
+	this.mu.Lock()
+	defer this.mu.Unlock()
 
-// FIXME not atomic, add CAS
-func (this *memInflight) TakeOff(cluster, topic, group, partition string, offset int64, msg []byte) error {
-	key := this.key(cluster, topic, group, partition)
-	if this.debug {
-		log.Debug("TakeOff %s => %d", key, offset)
-	}
-	o, found := this.offsets.Get(key)
-	if found && o.(message).Offset != offset {
-		if this.debug {
-			log.Error("out of order: %s", this)
-		}
-		return inflight.ErrOutOfOrder
+	msg, present := this.queues[q][k]
+	if !present {
+		return inflight.ErrNotInflight
 	}
 
-	this.offsets.Set(key, message{
-		Offset: offset,
-		Value:  msg,
-	})
+	msg.VisibleAt = time.Time{}
 	return nil
 }
 
-func (this *memInflight) String() string {
-	dumps := make([]dumpRecord, 0, this.offsets.Count())
-	for item := range this.offsets.Iter() {
-		dumps = append(dumps, dumpRecord{
-			Key: item.Key,
-			Val: item.Val.(message),
+func (this *memInflight) Redeliver(cluster, topic, group string, n int, timeout time.Duration) ([]inflight.Message, error) {
+	q := queueKey{Cluster: cluster, Topic: topic, Group: group}
+	now := time.Now()
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	var visibles []msgKey
+	for k, msg := range this.queues[q] {
+		if !msg.VisibleAt.After(now) {
+			visibles = append(visibles, k)
+		}
+	}
+	if len(visibles) == 0 {
+		return nil, nil
+	}
+
+	// oldest first
+	sort.Sort(msgKeys(visibles))
+	if len(visibles) > n {
+		visibles = visibles[:n]
+	}
+
+	r := make([]inflight.Message, 0, len(visibles))
+	for _, k := range visibles {
+		msg := this.queues[q][k]
+		msg.Deliveries++
+		msg.VisibleAt = now.Add(timeout)
+
+		r = append(r, inflight.Message{
+			Partition:  k.Partition,
+			Offset:     k.Offset,
+			Key:        msg.Key,
+			Value:      msg.Value,
+			Deliveries: msg.Deliveries,
 		})
 	}
-	data, _ := json.Marshal(dumps)
-	return string(data)
+
+	return r, nil
 }
 
 func (this *memInflight) Init() error {
@@ -127,9 +180,19 @@ func (this *memInflight) Init() error {
 	if err = json.Unmarshal(data, &dumps); err != nil {
 		return err
 	}
+
+	this.mu.Lock()
 	for _, record := range dumps {
-		this.offsets.Set(record.Key, record.Val)
+		msgs, present := this.queues[record.Queue]
+		if !present {
+			msgs = make(map[msgKey]*message)
+			this.queues[record.Queue] = msgs
+		}
+
+		msg := record.Val
+		msgs[record.Msg] = &msg
 	}
+	this.mu.Unlock()
 	return nil
 }
 
@@ -138,13 +201,19 @@ func (this *memInflight) Stop() error {
 		return nil
 	}
 
-	dumps := make([]dumpRecord, 0, this.offsets.Count())
-	for item := range this.offsets.Iter() {
-		dumps = append(dumps, dumpRecord{
-			Key: item.Key,
-			Val: item.Val.(message),
-		})
+	this.mu.Lock()
+	dumps := make([]dumpRecord, 0)
+	for q, msgs := range this.queues {
+		for k, msg := range msgs {
+			dumps = append(dumps, dumpRecord{
+				Queue: q,
+				Msg:   k,
+				Val:   *msg,
+			})
+		}
 	}
+	this.mu.Unlock()
+
 	data, err := json.Marshal(dumps)
 	if err != nil {
 		return err
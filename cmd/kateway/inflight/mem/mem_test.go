@@ -1,8 +1,9 @@
 package mem
 
 import (
-	//"os"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/funkygao/assert"
 	"github.com/funkygao/gafka/cmd/kateway/inflight"
@@ -12,53 +13,78 @@ var _ inflight.Inflight = &memInflight{}
 
 var msg = []byte("hello world")
 
-func TestBasic(t *testing.T) {
-	m := New("", true)
-	err := m.TakeOff("cluster", "topic", "group", "partition", 1, msg)
-	assert.Equal(t, nil, err)
-	err = m.TakeOff("cluster", "topic", "group", "partition", 1, msg) // reentrant is ok
+func TestTakeOffAndLand(t *testing.T) {
+	m := New("")
+	assert.Equal(t, nil, m.TakeOff("cluster", "topic", "group", 0, 1, nil, msg, time.Minute))
+	assert.Equal(t, nil, m.TakeOff("cluster", "topic", "group", 0, 1, nil, msg, time.Minute)) // reentrant is ok
+	assert.Equal(t, inflight.ErrNotInflight, m.Land("cluster", "topic", "group", 0, 2))
+	assert.Equal(t, inflight.ErrNotInflight, m.Land("cluster", "topic", "group1", 0, 1))
+	assert.Equal(t, nil, m.Land("cluster", "topic", "group", 0, 1))
+	assert.Equal(t, inflight.ErrNotInflight, m.Land("cluster", "topic", "group", 0, 1))
+	assert.Equal(t, 0, len(m.queues))
+}
+
+func TestRedeliver(t *testing.T) {
+	m := New("")
+	m.TakeOff("cluster", "topic", "group", 1, 5, []byte("k"), msg, time.Minute)
+	m.TakeOff("cluster", "topic", "group", 0, 9, nil, msg, time.Minute)
+	m.TakeOff("cluster", "topic", "group", 0, 8, nil, msg, time.Millisecond)
+
+	// invisible
+	msgs, err := m.Redeliver("cluster", "topic", "group", 10, time.Minute)
 	assert.Equal(t, nil, err)
-	err = m.TakeOff("cluster", "topic", "group", "partition", 2, msg)
-	assert.Equal(t, inflight.ErrOutOfOrder, err)
-	var m1 []byte
-	m1, err = m.LandX("cluster", "topic", "group", "partition", 1)
+	assert.Equal(t, 0, len(msgs))
+
+	// nack and timeout
+	assert.Equal(t, nil, m.Nack("cluster", "topic", "group", 1, 5))
+	assert.Equal(t, inflight.ErrNotInflight, m.Nack("cluster", "topic", "group", 1, 6))
+	time.Sleep(time.Millisecond * 5)
+	msgs, err = m.Redeliver("cluster", "topic", "group", 10, time.Minute)
 	assert.Equal(t, nil, err)
-	assert.Equal(t, "hello world", string(m1))
-	err = m.Land("cluster", "topic", "group", "partition", 2)
-	assert.Equal(t, inflight.ErrOutOfOrder, err)
+	assert.Equal(t, 2, len(msgs))
+	assert.Equal(t, int64(8), msgs[0].Offset)
+	assert.Equal(t, 2, msgs[0].Deliveries)
+	assert.Equal(t, int32(1), msgs[1].Partition)
+	assert.Equal(t, "k", string(msgs[1].Key))
+	assert.Equal(t, "hello world", string(msgs[1].Value))
+
+	// redelivered messages are invisible again
+	msgs, _ = m.Redeliver("cluster", "topic", "group", 10, time.Minute)
+	assert.Equal(t, 0, len(msgs))
+
+	// limit
+	m.Nack("cluster", "topic", "group", 0, 8)
+	m.Nack("cluster", "topic", "group", 0, 9)
+	msgs, _ = m.Redeliver("cluster", "topic", "group", 1, time.Minute)
+	assert.Equal(t, 1, len(msgs))
+	assert.Equal(t, 3, msgs[0].Deliveries)
 }
 
 func TestInitAndStop(t *testing.T) {
-	m := New("snapshot", true)
-	assert.Equal(t, nil, m.Init())
-	t.Logf("count: %d", m.offsets.Count())
-	for it := range m.offsets.Iter() {
-		t.Logf("%s %d %s", it.Key, it.Val.(message).Offset, string(it.Val.(message).Value))
-	}
-	m.TakeOff("cluster", "topic", "group", "partition0", 1, msg)
-	m.TakeOff("cluster", "topic", "group", "partition1", 2, msg)
-	assert.Equal(t, nil, m.Init())
-	m.Stop()
+	fn := "inflight.dmp"
+	defer os.Remove(fn)
 
+	m := New(fn)
 	assert.Equal(t, nil, m.Init())
-	assert.Equal(t, 2, m.offsets.Count())
-
-	//os.Remove(m.snapshotFile)
-}
+	m.TakeOff("cluster", "topic", "group", 0, 1, nil, msg, time.Minute)
+	m.TakeOff("cluster", "topic", "group", 1, 2, nil, msg, 0)
+	assert.Equal(t, nil, m.Stop())
 
-func BenchmarkKey(b *testing.B) {
-	b.ReportAllocs()
-	m := New("", true)
-	for i := 0; i < b.N; i++ {
-		m.key("cluster", "topic", "group", "partition")
-	}
+	m = New(fn)
+	assert.Equal(t, nil, m.Init())
+	msgs, err := m.Redeliver("cluster", "topic", "group", 10, time.Minute)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(msgs))
+	assert.Equal(t, int64(2), msgs[0].Offset)
+	assert.Equal(t, 2, msgs[0].Deliveries)
+	assert.Equal(t, nil, m.Land("cluster", "topic", "group", 0, 1))
 }
 
 func BenchmarkTakeOffThenLand(b *testing.B) {
 	b.ReportAllocs()
-	m := New("", true)
+	m := New("")
 	for i := 0; i < b.N; i++ {
-		m.TakeOff("cluster", "topic", "group", "partition", 1, msg)
-		m.Land("cluster", "topic", "group", "partition", 1)
+		m.TakeOff("cluster", "topic", "group", 0, 1, nil, msg, time.Minute)
+		m.Land("cluster", "topic", "group", 0, 1)
 	}
 }
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS Inflight (
+    queue_id bigint unsigned NOT NULL DEFAULT 0,
+    part int NOT NULL DEFAULT 0,
+    msg_offset bigint NOT NULL DEFAULT 0,
+    msg_key varbinary(255),
+    payload mediumblob,
+    deliveries int NOT NULL DEFAULT 0,
+    visible_at int NOT NULL DEFAULT 0,
+    ctime int NOT NULL DEFAULT 0,
+    queue varchar(512) NOT NULL DEFAULT "",
+    PRIMARY KEY (queue_id, part, msg_offset),
+    KEY(queue_id, visible_at)
+) ENGINE = INNODB DEFAULT CHARSET=utf8;
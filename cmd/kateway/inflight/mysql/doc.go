@@ -0,0 +1,3 @@
+// Package mysql implements an Inflight with mysql as backend, so that
+// the ack can land on any kateway.
+package mysql
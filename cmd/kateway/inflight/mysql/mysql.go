@@ -0,0 +1,137 @@
+package mysql
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/funkygao/fae/config"
+	"github.com/funkygao/fae/servant/mysql"
+	"github.com/funkygao/gafka/cmd/kateway/inflight"
+)
+
+const (
+	pool  = "ShardLookup" // inflight messages are not sharded
+	table = "Inflight"
+
+	sqlTakeOff = "INSERT INTO Inflight(queue_id,part,msg_offset,msg_key,payload,deliveries,visible_at,ctime,queue) VALUES(?,?,?,?,?,1,?,?,?) ON DUPLICATE KEY UPDATE deliveries=deliveries+1,visible_at=VALUES(visible_at)"
+	sqlLand    = "DELETE FROM Inflight WHERE queue_id=? AND part=? AND msg_offset=?"
+	sqlNack    = "UPDATE Inflight SET visible_at=0 WHERE queue_id=? AND part=? AND msg_offset=?"
+	sqlVisible = "SELECT part,msg_offset,msg_key,payload,deliveries,visible_at FROM Inflight WHERE queue_id=? AND visible_at<=? ORDER BY visible_at LIMIT %d"
+	sqlRetake  = "UPDATE Inflight SET deliveries=deliveries+1,visible_at=? WHERE queue_id=? AND part=? AND msg_offset=? AND visible_at=?"
+)
+
+type mysqlInflight struct {
+	mc *mysql.MysqlCluster
+}
+
+func New(cf *config.ConfigMysql) (inflight.Inflight, error) {
+	if cf == nil {
+		return nil, fmt.Errorf("inflight: empty mysql config")
+	}
+
+	return &mysqlInflight{
+		mc: mysql.New(cf),
+	}, nil
+}
+
+func (this *mysqlInflight) queue(cluster, topic, group string) (queueId uint64, queue string) {
+	queue = cluster + "/" + topic + "/" + group
+	h := fnv.New64a()
+	h.Write([]byte(queue))
+	return h.Sum64(), queue
+}
+
+func (this *mysqlInflight) TakeOff(cluster, topic, group string, partition int32, offset int64, key, value []byte,
+	timeout time.Duration) (err error) {
+	queueId, queue := this.queue(cluster, topic, group)
+	now := time.Now()
+	_, _, err = this.mc.Exec(pool, table, 0, sqlTakeOff,
+		queueId, partition, offset, key, value, now.Add(timeout).Unix(), now.Unix(), queue)
+	return
+}
+
+func (this *mysqlInflight) Land(cluster, topic, group string, partition int32, offset int64) error {
+	queueId, _ := this.queue(cluster, topic, group)
+	affectedRows, _, err := this.mc.Exec(pool, table, 0, sqlLand, queueId, partition, offset)
+	if err != nil {
+		return err
+	}
+	if affectedRows == 0 {
+		return inflight.ErrNotInflight
+	}
+
+	return nil
+}
+
+func (this *mysqlInflight) Nack(cluster, topic, group string, partition int32, offset int64) error {
+	queueId, _ := this.queue(cluster, topic, group)
+	affectedRows, _, err := this.mc.Exec(pool, table, 0, sqlNack, queueId, partition, offset)
+	if err != nil {
+		return err
+	}
+	if affectedRows == 0 {
+		// nack a visible message twice also affects no rows, but it is harmless
+		return inflight.ErrNotInflight
+	}
+
+	return nil
+}
+
+func (this *mysqlInflight) Redeliver(cluster, topic, group string, n int, timeout time.Duration) ([]inflight.Message, error) {
+	queueId, _ := this.queue(cluster, topic, group)
+	now := time.Now()
+	rows, err := this.mc.Query(pool, table, 0, fmt.Sprintf(sqlVisible, n), queueId, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	type visible struct {
+		inflight.Message
+		visibleAt int64
+	}
+	var visibles []visible
+	for rows.Next() {
+		var v visible
+		if err = rows.Scan(&v.Partition, &v.Offset, &v.Key, &v.Value, &v.Deliveries, &v.visibleAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		visibles = append(visibles, v)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	r := make([]inflight.Message, 0, len(visibles))
+	visibleAt := now.Add(timeout).Unix()
+	for _, v := range visibles {
+		// CAS: other kateway might be redelivering the same message
+		affectedRows, _, err := this.mc.Exec(pool, table, 0, sqlRetake,
+			visibleAt, queueId, v.Partition, v.Offset, v.visibleAt)
+		if err != nil {
+			return r, err
+		}
+		if affectedRows == 0 {
+			continue
+		}
+
+		v.Deliveries++
+		r = append(r, v.Message)
+	}
+
+	return r, nil
+}
+
+func (this *mysqlInflight) Init() error {
+	this.mc.Warmup()
+	return nil
+}
+
+func (this *mysqlInflight) Stop() error {
+	this.mc.Close()
+	return nil
+}
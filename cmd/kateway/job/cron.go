@@ -0,0 +1,181 @@
+package job
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when a recurring job fires.
+type Schedule interface {
+	// Next returns the next firing time after t, zero time if never.
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule parses the spec of a recurring job, which is either a fixed interval
+// '@every 1m30s', a standard 5 fields cron expression 'minute hour dom month dow'
+// or one of @yearly, @monthly, @weekly, @daily and @hourly.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil || d < time.Second {
+			return nil, ErrInvalidSchedule
+		}
+
+		return everySchedule(d), nil
+	}
+
+	switch spec {
+	case "@yearly", "@annually":
+		spec = "0 0 1 1 *"
+	case "@monthly":
+		spec = "0 0 1 * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@hourly":
+		spec = "0 * * * *"
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, ErrInvalidSchedule
+	}
+
+	var (
+		s   = &cronSchedule{}
+		err error
+	)
+	if s.minute, _, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hour, _, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.dom, s.domStar, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.month, _, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.dow, s.dowStar, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		// both 0 and 7 are Sunday
+		s.dow |= 1
+	}
+
+	return s, nil
+}
+
+type everySchedule time.Duration
+
+func (this everySchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(this)).Truncate(time.Second)
+}
+
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // bitset of the matched values
+	domStar, dowStar              bool
+}
+
+// cronSearchLimit stops searching for impossible expressions, e,g. Feb 30th.
+const cronSearchLimit = 5
+
+func (this *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + cronSearchLimit
+
+	for t.Year() <= yearLimit {
+		if this.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !this.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if this.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if this.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// dayMatches follows the cron rule: if both dom and dow are restricted,
+// either of them matches.
+func (this *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := this.dom&(1<<uint(t.Day())) != 0
+	dowMatch := this.dow&(1<<uint(t.Weekday())) != 0
+	if this.domStar || this.dowStar {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
+
+// parseCronField parses comma separated '*', 'n', 'a-b' with optional '/step'.
+func parseCronField(field string, min, max int) (bits uint64, star bool, err error) {
+	for _, part := range strings.Split(field, ",") {
+		var (
+			lo, hi = min, max
+			step   = 1
+			rng    = part
+		)
+
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, false, ErrInvalidSchedule
+			}
+			rng = part[:i]
+		}
+
+		switch {
+		case rng == "*":
+			star = star || step == 1
+
+		case strings.IndexByte(rng, '-') > 0:
+			i := strings.IndexByte(rng, '-')
+			if lo, err = strconv.Atoi(rng[:i]); err != nil {
+				return 0, false, ErrInvalidSchedule
+			}
+			if hi, err = strconv.Atoi(rng[i+1:]); err != nil {
+				return 0, false, ErrInvalidSchedule
+			}
+
+		default:
+			if lo, err = strconv.Atoi(rng); err != nil {
+				return 0, false, ErrInvalidSchedule
+			}
+			hi = lo
+			if step > 1 {
+				// 'n/step' means from n to max
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return 0, false, ErrInvalidSchedule
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, star, nil
+}
@@ -0,0 +1,70 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func mustParseSchedule(t *testing.T, spec string) Schedule {
+	s, err := ParseSchedule(spec)
+	if err != nil {
+		t.Fatalf("%s: %v", spec, err)
+	}
+	return s
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"@every 10ms",
+		"@every abc",
+		"@weird",
+	} {
+		_, err := ParseSchedule(spec)
+		assert.Equal(t, ErrInvalidSchedule, err)
+	}
+}
+
+func TestEverySchedule(t *testing.T) {
+	s := mustParseSchedule(t, "@every 1m30s")
+	now := time.Date(2017, 1, 1, 10, 0, 0, 0, time.Local)
+	assert.Equal(t, now.Add(90*time.Second), s.Next(now))
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	now := time.Date(2017, 1, 1, 10, 7, 30, 0, time.Local) // Sunday
+	fixtures := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2017, 1, 1, 10, 8, 0, 0, time.Local)},
+		{"*/15 * * * *", time.Date(2017, 1, 1, 10, 15, 0, 0, time.Local)},
+		{"5 * * * *", time.Date(2017, 1, 1, 11, 5, 0, 0, time.Local)},
+		{"0 9-17 * * 1-5", time.Date(2017, 1, 2, 9, 0, 0, 0, time.Local)},
+		{"30 2 1,15 * *", time.Date(2017, 1, 15, 2, 30, 0, 0, time.Local)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.Local)},
+		{"0 0 * * 7", time.Date(2017, 1, 8, 0, 0, 0, 0, time.Local)},
+		{"@daily", time.Date(2017, 1, 2, 0, 0, 0, 0, time.Local)},
+		{"@hourly", time.Date(2017, 1, 1, 11, 0, 0, 0, time.Local)},
+		{"@monthly", time.Date(2017, 2, 1, 0, 0, 0, 0, time.Local)},
+		{"0 0 13 * 5", time.Date(2017, 1, 6, 0, 0, 0, 0, time.Local)}, // dom or dow
+	}
+	for _, f := range fixtures {
+		assert.Equal(t, f.next, mustParseSchedule(t, f.spec).Next(now))
+	}
+
+	// impossible date
+	assert.Equal(t, true, mustParseSchedule(t, "0 0 30 2 *").Next(now).IsZero())
+}
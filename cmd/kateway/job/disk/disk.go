@@ -0,0 +1,607 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/job"
+	"github.com/funkygao/golib/idgen"
+	log "github.com/funkygao/log4go"
+)
+
+type diskStore struct {
+	dir   string
+	idgen *idgen.IdGenerator
+
+	mu     sync.Mutex
+	queues map[string]*queue // key is topic
+}
+
+// New creates an embedded job store under dir, id is used to generate job ids.
+func New(id string, dir string) (job.JobStore, error) {
+	wid, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ig, err := idgen.NewIdGenerator(wid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &diskStore{
+		dir:    dir,
+		idgen:  ig,
+		queues: make(map[string]*queue),
+	}, nil
+}
+
+func (this *diskStore) queue(topic string) (*queue, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if q, present := this.queues[topic]; present {
+		return q, nil
+	}
+
+	q, err := openQueue(filepath.Join(this.dir, topic))
+	if err != nil {
+		return nil, err
+	}
+
+	this.queues[topic] = q
+	return q, nil
+}
+
+func (this *diskStore) nextId() int64 {
+	for {
+		id, err := this.idgen.Next()
+		if err != nil {
+			if err == idgen.ErrorClockBackwards {
+				log.Warn("%s, sleep 50ms", err)
+
+				time.Sleep(time.Millisecond * 50)
+				continue
+			} else {
+				// should never happen
+				panic(err)
+			}
+		}
+
+		return id
+	}
+}
+
+func (this *diskStore) CreateJobQueue(shardId int, appid, topic string) (err error) {
+	return os.MkdirAll(filepath.Join(this.dir, topic), 0755)
+}
+
+func (this *diskStore) Add(appid, topic string, payload []byte, key, tag string, due int64) (jobId string, err error) {
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	item := job.JobItem{
+		JobId:   this.nextId(),
+		Payload: payload,
+		Ctime:   time.Now().Unix(),
+		DueTime: due,
+		Key:     key,
+		Tag:     tag,
+	}
+	err = q.update(func() ([]record, error) {
+		return []record{putRecord(item)}, nil
+	})
+	jobId = strconv.FormatInt(item.JobId, 10)
+	return
+}
+
+func (this *diskStore) AddCron(appid, topic string, payload []byte, key, tag, spec string) (jobId string, err error) {
+	schedule, err := job.ParseSchedule(spec)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	next := schedule.Next(now)
+	if next.IsZero() {
+		return "", job.ErrInvalidSchedule
+	}
+
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	jid := this.nextId()
+	err = q.update(func() ([]record, error) {
+		return []record{
+			cronRecord(job.CronItem{JobId: jid, Payload: payload, Spec: spec, Ctime: now.Unix(), Key: key, Tag: tag}),
+			putRecord(job.JobItem{JobId: jid, Payload: payload, Ctime: now.Unix(), DueTime: next.Unix(), Key: key, Tag: tag}),
+		}, nil
+	})
+	jobId = strconv.FormatInt(jid, 10)
+	return
+}
+
+func (this *diskStore) Crons(appid, topic string) (crons []job.CronItem, err error) {
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	err = q.view(func() error {
+		for _, c := range q.crons {
+			if item, present := q.jobs[c.JobId]; present {
+				c.NextTime = item.DueTime
+			}
+			crons = append(crons, c)
+		}
+		return nil
+	})
+	sort.Sort(cronItems(crons))
+	return
+}
+
+func (this *diskStore) CronSpecs(appid, topic string, jobIds []int64) (specs map[int64]string, err error) {
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	specs = make(map[int64]string)
+	err = q.view(func() error {
+		for _, jid := range jobIds {
+			if c, present := q.crons[jid]; present && !c.Paused {
+				specs[jid] = c.Spec
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (this *diskStore) PauseCron(appid, topic, jobId string) (err error) {
+	jid, err := strconv.ParseInt(jobId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	return q.update(func() ([]record, error) {
+		c, present := q.crons[jid]
+		if !present {
+			return nil, job.ErrNoSuchCron
+		}
+		if c.Paused {
+			// pause is idempotent
+			return nil, nil
+		}
+
+		// cancel the pending occurrence
+		c.Paused = true
+		return []record{cronRecord(c), delRecord(jid)}, nil
+	})
+}
+
+func (this *diskStore) ResumeCron(appid, topic, jobId string) (err error) {
+	jid, err := strconv.ParseInt(jobId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	return q.update(func() ([]record, error) {
+		c, present := q.crons[jid]
+		if !present {
+			return nil, job.ErrNoSuchCron
+		}
+		if !c.Paused {
+			// resume is idempotent
+			return nil, nil
+		}
+
+		schedule, err := job.ParseSchedule(c.Spec)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		next := schedule.Next(now)
+		if next.IsZero() {
+			return nil, job.ErrInvalidSchedule
+		}
+
+		c.Paused = false
+		return []record{
+			cronRecord(c),
+			putRecord(occurrence(c, now.Unix(), next.Unix())),
+		}, nil
+	})
+}
+
+func (this *diskStore) Get(appid, topic, jobId string) (item job.JobItem, err error) {
+	jid, err := strconv.ParseInt(jobId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	err = q.view(func() error {
+		var present bool
+		if item, present = q.jobs[jid]; present {
+			item.State = job.PendingState(item.DueTime, time.Now().Unix())
+			return nil
+		}
+
+		if item, present = q.archive[jid]; present {
+			item.State = job.JobFired
+			return nil
+		}
+
+		// never added, deleted or a paused recurring job
+		return job.ErrNoSuchJob
+	})
+	return
+}
+
+func (this *diskStore) List(appid, topic string, opt job.ListOption) (jobs []job.JobItem, cursor string, err error) {
+	var (
+		afterDue int64
+		afterJid int64
+	)
+	if opt.Cursor != "" {
+		if afterDue, afterJid, err = job.ParseCursor(opt.Cursor); err != nil {
+			return
+		}
+	}
+	if opt.Limit <= 0 {
+		opt.Limit = defaultListLimit
+	}
+
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	err = q.view(func() error {
+		items := q.jobs
+		if opt.State == job.JobFired {
+			items = q.archive
+		}
+
+		now := time.Now().Unix()
+		for _, item := range items {
+			switch {
+			case opt.DueFrom > 0 && item.DueTime < opt.DueFrom:
+				continue
+			case opt.DueTo > 0 && item.DueTime >= opt.DueTo:
+				continue
+			case opt.Cursor != "" && (item.DueTime < afterDue || (item.DueTime == afterDue && item.JobId <= afterJid)):
+				continue
+			}
+
+			if opt.State == job.JobFired {
+				item.State = job.JobFired
+			} else {
+				item.State = job.PendingState(item.DueTime, now)
+			}
+			jobs = append(jobs, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	sort.Sort(jobItems(jobs))
+	if len(jobs) >= opt.Limit {
+		jobs = jobs[:opt.Limit]
+		last := jobs[len(jobs)-1]
+		cursor = job.FormatCursor(last.DueTime, last.JobId)
+	}
+	return
+}
+
+func (this *diskStore) Reschedule(appid, topic, jobId string, due int64) (err error) {
+	return this.Update(appid, topic, jobId, due, nil)
+}
+
+func (this *diskStore) UpdatePayload(appid, topic, jobId string, payload []byte) (err error) {
+	return this.Update(appid, topic, jobId, 0, payload)
+}
+
+func (this *diskStore) Update(appid, topic, jobId string, due int64, payload []byte) (err error) {
+	jid, err := strconv.ParseInt(jobId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	if due == 0 && payload == nil {
+		return
+	}
+
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	return q.update(func() ([]record, error) {
+		var records []record
+		item, present := q.jobs[jid]
+		if present {
+			if payload != nil {
+				item.Payload = payload
+			}
+			if due > 0 {
+				item.DueTime = due
+			}
+			item.Mtime = nextMtime(item.Mtime)
+			records = append(records, putRecord(item))
+		} else if due > 0 {
+			return nil, job.ErrNoSuchJob
+		}
+
+		// the following occurrences of recurring job
+		if c, present := q.crons[jid]; present && payload != nil {
+			c.Payload = payload
+			records = append(records, cronRecord(c))
+		}
+
+		if len(records) == 0 {
+			return nil, job.ErrNoSuchJob
+		}
+		return records, nil
+	})
+}
+
+func (this *diskStore) Delete(appid, topic, jobId string) (err error) {
+	jid, err := strconv.ParseInt(jobId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	return q.update(func() ([]record, error) {
+		var records []record
+		if _, present := q.jobs[jid]; present {
+			records = append(records, delRecord(jid))
+		}
+
+		// cancel the recurring job if it is
+		if _, present := q.crons[jid]; present {
+			records = append(records, uncronRecord(jid))
+		}
+
+		if len(records) == 0 {
+			return nil, job.ErrNothingDeleted
+		}
+		return records, nil
+	})
+}
+
+func (this *diskStore) Prepare(appid, topic string, payload []byte) (xaId string, err error) {
+	return "", job.ErrNotSupported
+}
+
+func (this *diskStore) Commit(appid, topic, xaId string) (err error) {
+	return job.ErrNotSupported
+}
+
+func (this *diskStore) Rollback(appid, topic, xaId string) (err error) {
+	return job.ErrNotSupported
+}
+
+func (this *diskStore) Open(appid, topic string) (err error) {
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	// schedule the active recurring jobs that lost the pending occurrence,
+	// e.g. actor crashed between Take and Reenqueue
+	return q.update(func() ([]record, error) {
+		var records []record
+		now := time.Now()
+		for _, c := range q.crons {
+			if _, present := q.jobs[c.JobId]; present || c.Paused {
+				continue
+			}
+
+			schedule, err := job.ParseSchedule(c.Spec)
+			if err != nil {
+				log.Error("%s cron %d %s: %v", topic, c.JobId, c.Spec, err)
+				continue
+			}
+
+			if next := schedule.Next(now); !next.IsZero() {
+				log.Warn("%s restored cron %d next %s", topic, c.JobId, next)
+				records = append(records, putRecord(occurrence(c, now.Unix(), next.Unix())))
+			}
+		}
+		return records, nil
+	})
+}
+
+func (this *diskStore) Due(appid, topic string, due int64) (jobs []job.JobItem, err error) {
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	err = q.view(func() error {
+		for _, item := range q.jobs {
+			if item.DueTime <= due {
+				jobs = append(jobs, item)
+			}
+		}
+		return nil
+	})
+	sort.Sort(jobItems(jobs))
+	return
+}
+
+func (this *diskStore) Take(appid, topic string, item job.JobItem) (ok bool, err error) {
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	err = q.update(func() ([]record, error) {
+		if current, present := q.jobs[item.JobId]; !present || current.Mtime != item.Mtime {
+			// deleted or updated since Due
+			return nil, nil
+		}
+
+		ok = true
+		return []record{delRecord(item.JobId)}, nil
+	})
+	return
+}
+
+func (this *diskStore) Putback(appid, topic string, item job.JobItem) (err error) {
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	return q.update(func() ([]record, error) {
+		return []record{putRecord(item)}, nil
+	})
+}
+
+func (this *diskStore) Archive(appid, topic string, item job.JobItem, etime int64, actorId string) (err error) {
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	item.Etime, item.ActorId = etime, actorId
+	return q.update(func() ([]record, error) {
+		return []record{archiveRecord(item)}, nil
+	})
+}
+
+func (this *diskStore) Reenqueue(appid, topic string, jobId int64, due int64) (err error) {
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	return q.update(func() ([]record, error) {
+		c, present := q.crons[jobId]
+		if !present || c.Paused {
+			// paused or cancelled since actor learned it is recurring
+			return nil, nil
+		}
+
+		return []record{
+			putRecord(occurrence(c, time.Now().Unix(), due)),
+		}, nil
+	})
+}
+
+func (this *diskStore) TakeBatch(appid, topic string, items []job.JobItem) (taken []job.JobItem, err error) {
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	err = q.update(func() ([]record, error) {
+		taken = taken[:0]
+		records := make([]record, 0, len(items))
+		took := make(map[int64]struct{}, len(items))
+		for _, item := range items {
+			if _, present := took[item.JobId]; present {
+				continue
+			}
+			if current, present := q.jobs[item.JobId]; !present || current.Mtime != item.Mtime {
+				// deleted or updated since Due
+				continue
+			}
+
+			took[item.JobId] = struct{}{}
+			taken = append(taken, item)
+			records = append(records, delRecord(item.JobId))
+		}
+		return records, nil
+	})
+	return
+}
+
+func (this *diskStore) SettleBatch(appid, topic string, batch job.FiredBatch, etime int64, actorId string) (err error) {
+	q, err := this.queue(topic)
+	if err != nil {
+		return
+	}
+
+	// the whole batch is a single append to the log
+	records := make([]record, 0, len(batch.Fired)+len(batch.Recurring)+len(batch.Failed))
+	for _, item := range batch.Fired {
+		item.Etime, item.ActorId = etime, actorId
+		records = append(records, archiveRecord(item))
+	}
+	for _, item := range batch.Failed {
+		records = append(records, putRecord(item))
+	}
+	if len(records) == 0 && len(batch.Recurring) == 0 {
+		return
+	}
+
+	return q.update(func() ([]record, error) {
+		now := time.Now().Unix()
+		for _, item := range batch.Recurring {
+			c, present := q.crons[item.JobId]
+			if !present || c.Paused || item.DueTime == 0 {
+				// paused or cancelled since actor learned it is recurring
+				continue
+			}
+
+			records = append(records, putRecord(occurrence(c, now, item.DueTime)))
+		}
+		return records, nil
+	})
+}
+
+func (this *diskStore) Name() string {
+	return "disk"
+}
+
+func (this *diskStore) Start() error {
+	return nil
+}
+
+func (this *diskStore) Stop() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for topic, q := range this.queues {
+		q.close()
+		delete(this.queues, topic)
+	}
+}
@@ -0,0 +1,214 @@
+package disk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/job"
+	"github.com/funkygao/gafka/cmd/kateway/job/storetest"
+)
+
+const (
+	testAppid = "app1"
+	testTopic = "app1.foobar.v1"
+)
+
+func newTestStore(t *testing.T, dir string) job.JobStore {
+	store, err := New("1", dir)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, store.CreateJobQueue(1, testAppid, testTopic))
+	return store
+}
+
+func TestStoreSuite(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "jobstore")
+	defer os.RemoveAll(dir)
+
+	store, err := New("1", dir)
+	assert.Equal(t, nil, err)
+	defer store.Stop()
+
+	storetest.Run(t, store, testAppid, testTopic)
+}
+
+func TestNoSuchJobQueue(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "jobstore")
+	defer os.RemoveAll(dir)
+
+	store, _ := New("1", dir)
+	defer store.Stop()
+
+	_, err := store.Add(testAppid, testTopic, []byte("hello"), "", "", time.Now().Unix())
+	assert.Equal(t, job.ErrNoSuchJobQueue, err)
+	assert.Equal(t, job.ErrNoSuchJobQueue, store.Open(testAppid, testTopic))
+}
+
+func TestDurable(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "jobstore")
+	defer os.RemoveAll(dir)
+
+	store := newTestStore(t, dir)
+	due := time.Now().Unix() + 100
+	jobId, err := store.Add(testAppid, testTopic, []byte("hello"), "", "", due)
+	assert.Equal(t, nil, err)
+	store.Stop()
+
+	store = newTestStore(t, dir)
+	defer store.Stop()
+	item, err := store.Get(testAppid, testTopic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "hello", string(item.Payload))
+	assert.Equal(t, due, item.DueTime)
+}
+
+// kateway and actord share the same job queue directory.
+func TestSharedByProcesses(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "jobstore")
+	defer os.RemoveAll(dir)
+
+	kateway := newTestStore(t, dir)
+	defer kateway.Stop()
+	actord := newTestStore(t, dir)
+	defer actord.Stop()
+
+	due := time.Now().Unix()
+	jobId, err := kateway.Add(testAppid, testTopic, []byte("hello"), "", "", due)
+	assert.Equal(t, nil, err)
+
+	items, err := actord.Due(testAppid, testTopic, due)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(items))
+	ok, err := actord.Take(testAppid, testTopic, items[0])
+	assert.Equal(t, true, ok)
+	assert.Equal(t, nil, actord.Archive(testAppid, testTopic, items[0], due, "actor1"))
+
+	item, err := kateway.Get(testAppid, testTopic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, job.JobFired, item.State)
+	assert.Equal(t, job.ErrNothingDeleted, kateway.Delete(testAppid, testTopic, jobId))
+}
+
+func TestTornRecord(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "jobstore")
+	defer os.RemoveAll(dir)
+
+	store := newTestStore(t, dir)
+	jobId, err := store.Add(testAppid, testTopic, []byte("hello"), "", "", time.Now().Unix()+100)
+	assert.Equal(t, nil, err)
+	store.Stop()
+
+	// crashed in the middle of append
+	f, _ := os.OpenFile(filepath.Join(dir, testTopic, logFile), os.O_APPEND|os.O_WRONLY, 0644)
+	f.Write([]byte(`{"op":"put","id":1`))
+	f.Close()
+
+	store = newTestStore(t, dir)
+	defer store.Stop()
+	_, err = store.Get(testAppid, testTopic, jobId)
+	assert.Equal(t, nil, err)
+
+	jobId, err = store.Add(testAppid, testTopic, []byte("world"), "", "", time.Now().Unix()+100)
+	assert.Equal(t, nil, err)
+	item, err := store.Get(testAppid, testTopic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "world", string(item.Payload))
+}
+
+func TestCompaction(t *testing.T) {
+	defer func(size int64) {
+		compactMinSize = size
+	}(compactMinSize)
+	compactMinSize = 1 << 10
+
+	dir, _ := ioutil.TempDir("", "jobstore")
+	defer os.RemoveAll(dir)
+
+	store := newTestStore(t, dir)
+	defer store.Stop()
+	other := newTestStore(t, dir)
+	defer other.Stop()
+
+	due := time.Now().Unix() + 100
+	kept, err := store.Add(testAppid, testTopic, []byte("kept"), "", "", due)
+	assert.Equal(t, nil, err)
+	for i := 0; i < 100; i++ {
+		jobId, err := store.Add(testAppid, testTopic, []byte("hello"), "", "", due)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, store.Delete(testAppid, testTopic, jobId))
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, testTopic, logFile))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, fi.Size() < compactMinSize)
+
+	for _, s := range []job.JobStore{store, other} {
+		items, _, err := s.List(testAppid, testTopic, job.ListOption{})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 1, len(items))
+		assert.Equal(t, kept, strconv.FormatInt(items[0].JobId, 10))
+	}
+}
+
+func TestArchiveRetention(t *testing.T) {
+	defer func(retention, interval time.Duration) {
+		archiveRetention, archivePurgeInterval = retention, interval
+	}(archiveRetention, archivePurgeInterval)
+	archiveRetention, archivePurgeInterval = time.Minute, 0
+
+	dir, _ := ioutil.TempDir("", "jobstore")
+	defer os.RemoveAll(dir)
+
+	store := newTestStore(t, dir)
+	defer store.Stop()
+	assert.Equal(t, nil, store.Open(testAppid, testTopic))
+
+	now := time.Now().Unix()
+	var jobIds []string
+	for _, etime := range []int64{now - 120, now} {
+		jobId, err := store.Add(testAppid, testTopic, []byte("hello"), "", "", now)
+		assert.Equal(t, nil, err)
+		item, err := store.Get(testAppid, testTopic, jobId)
+		assert.Equal(t, nil, err)
+		ok, err := store.Take(testAppid, testTopic, item)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, nil, store.Archive(testAppid, testTopic, item, etime, "actor1"))
+		jobIds = append(jobIds, jobId)
+	}
+
+	other := newTestStore(t, dir)
+	defer other.Stop()
+	for _, s := range []job.JobStore{store, other} {
+		_, err := s.Get(testAppid, testTopic, jobIds[0])
+		assert.Equal(t, job.ErrNoSuchJob, err)
+		item, err := s.Get(testAppid, testTopic, jobIds[1])
+		assert.Equal(t, nil, err)
+		assert.Equal(t, job.JobFired, item.State)
+	}
+}
+
+func benchmarkFire(b *testing.B, batchSize int) {
+	dir, _ := ioutil.TempDir("", "jobstore")
+	defer os.RemoveAll(dir)
+
+	store, _ := New("1", dir)
+	defer store.Stop()
+
+	storetest.Bench(b, store, testAppid, testTopic, batchSize)
+}
+
+func BenchmarkFireOneByOne(b *testing.B) {
+	benchmarkFire(b, 0)
+}
+
+func BenchmarkFireBatch1(b *testing.B) {
+	benchmarkFire(b, 1)
+}
+
+func BenchmarkFireBatch100(b *testing.B) {
+	benchmarkFire(b, 100)
+}
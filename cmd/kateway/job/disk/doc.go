@@ -0,0 +1,13 @@
+// Package disk implements an embedded durable job store on local file system
+// for single node and test deployments without mysql.
+//
+// Each job queue is a directory with an append-only log of mutations,
+// the state is rebuilt in memory by replaying the log.
+// Every operation takes a file lock and catches up the records appended by
+// other processes, so that kateway and actord on the same host can share
+// the job queues by pointing to the same directory.
+// The log is compacted into a snapshot of live jobs when it is mostly garbage.
+// Fired jobs are kept in archive for 7 days.
+//
+// XA transactional messages are not supported.
+package disk
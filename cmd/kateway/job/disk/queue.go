@@ -0,0 +1,336 @@
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/job"
+	log "github.com/funkygao/log4go"
+)
+
+const (
+	logFile  = "jobs.log"
+	lockFile = "LOCK"
+)
+
+var (
+	// the log is compacted when it is larger than this and mostly garbage.
+	compactMinSize int64 = 4 << 20
+
+	// the log is mostly garbage when it holds so many times records of the live ones.
+	compactGarbageRatio = 4
+
+	// fired jobs are kept in archive for so long after they fired.
+	archiveRetention = time.Hour * 24 * 7
+
+	// how often the expired archive is purged.
+	archivePurgeInterval = time.Minute
+)
+
+// queue is the log structured storage of a single job queue.
+type queue struct {
+	dir string
+
+	mu     sync.Mutex
+	lock   *os.File // flock target that survives compaction
+	f      *os.File // the log opened for append
+	offset int64    // replayed up to
+	n      int      // records in the log
+
+	jobs    map[int64]job.JobItem
+	archive map[int64]job.JobItem
+	crons   map[int64]job.CronItem
+
+	purged time.Time // when the archive was purged last time
+}
+
+func openQueue(dir string) (*queue, error) {
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return nil, job.ErrNoSuchJobQueue
+	}
+
+	lock, err := os.OpenFile(filepath.Join(dir, lockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &queue{dir: dir, lock: lock}
+	if err = q.reopen(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (q *queue) logPath() string {
+	return filepath.Join(q.dir, logFile)
+}
+
+// reopen opens the log and resets the state so that it will be replayed from scratch.
+func (q *queue) reopen() error {
+	if q.f != nil {
+		q.f.Close()
+	}
+
+	f, err := os.OpenFile(q.logPath(), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		q.f = nil
+		return err
+	}
+
+	q.f = f
+	q.offset, q.n = 0, 0
+	q.jobs = make(map[int64]job.JobItem)
+	q.archive = make(map[int64]job.JobItem)
+	q.crons = make(map[int64]job.CronItem)
+	return nil
+}
+
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.f != nil {
+		q.f.Close()
+		q.f = nil
+	}
+	q.lock.Close()
+}
+
+// view runs fn with the latest state.
+func (q *queue) view(fn func() error) error {
+	return q.locked(syscall.LOCK_SH, func() error {
+		if err := q.catchup(false); err != nil {
+			return err
+		}
+
+		q.purgeArchive()
+		return fn()
+	})
+}
+
+// update appends the records returned by fn which decides upon the latest state.
+func (q *queue) update(fn func() ([]record, error)) error {
+	return q.locked(syscall.LOCK_EX, func() error {
+		if err := q.catchup(true); err != nil {
+			return err
+		}
+
+		q.purgeArchive()
+		records, err := fn()
+		if err != nil || len(records) == 0 {
+			return err
+		}
+
+		if err = q.append(records); err != nil {
+			return err
+		}
+
+		return q.compactIfNecessary()
+	})
+}
+
+func (q *queue) locked(how int, fn func() error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.f == nil {
+		return job.ErrNoSuchJobQueue
+	}
+
+	if err := syscall.Flock(int(q.lock.Fd()), how); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(q.lock.Fd()), syscall.LOCK_UN)
+
+	return fn()
+}
+
+// catchup replays the records appended by others since last time.
+func (q *queue) catchup(exclusive bool) error {
+	fi, err := os.Stat(q.logPath())
+	if err != nil {
+		return err
+	}
+	if mine, err := q.f.Stat(); err != nil {
+		return err
+	} else if !os.SameFile(fi, mine) {
+		// compacted by others
+		if err = q.reopen(); err != nil {
+			return err
+		}
+	}
+
+	if fi.Size() == q.offset {
+		return nil
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(q.f, q.offset, fi.Size()-q.offset))
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// torn write of a crashed process
+				log.Warn("%s: torn record at %d", q.dir, q.offset)
+
+				if exclusive {
+					return q.f.Truncate(q.offset)
+				}
+			}
+
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var rec record
+		if err = json.Unmarshal(line, &rec); err != nil {
+			log.Error("%s: corrupted record at %d %s", q.dir, q.offset, err)
+		} else {
+			q.apply(rec)
+		}
+		q.offset += int64(len(line))
+		q.n++
+	}
+}
+
+func (q *queue) append(records []record) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf) // each record ends with newline
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	if _, err := q.f.Write(buf.Bytes()); err != nil {
+		// a partial write will be truncated by next catchup
+		return err
+	}
+	if err := q.f.Sync(); err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		q.apply(rec)
+	}
+	q.offset += int64(buf.Len())
+	q.n += len(records)
+	return nil
+}
+
+func (q *queue) apply(rec record) {
+	switch rec.Op {
+	case opPut:
+		q.jobs[rec.JobId] = rec.jobItem()
+
+	case opDel:
+		delete(q.jobs, rec.JobId)
+
+	case opArchive:
+		if !archiveExpired(rec.Etime, time.Now()) {
+			q.archive[rec.JobId] = rec.jobItem()
+		}
+
+	case opCron:
+		q.crons[rec.JobId] = rec.cronItem()
+
+	case opUncron:
+		delete(q.crons, rec.JobId)
+	}
+}
+
+func archiveExpired(etime int64, now time.Time) bool {
+	return etime < now.Add(-archiveRetention).Unix()
+}
+
+// purgeArchive drops the expired archive from memory, their records become garbage
+// of the log and are discarded by compaction.
+func (q *queue) purgeArchive() {
+	now := time.Now()
+	if now.Sub(q.purged) < archivePurgeInterval {
+		return
+	}
+
+	q.purged = now
+	n := 0
+	for jid, item := range q.archive {
+		if archiveExpired(item.Etime, now) {
+			delete(q.archive, jid)
+			n++
+		}
+	}
+
+	if n > 0 {
+		log.Trace("%s: purged %d archived jobs", q.dir, n)
+	}
+}
+
+func (q *queue) live() int {
+	return len(q.jobs) + len(q.archive) + len(q.crons)
+}
+
+// compactIfNecessary rewrites the log with live records only.
+// Other processes will find the log replaced and replay the new one.
+func (q *queue) compactIfNecessary() error {
+	if q.offset < compactMinSize || q.n < compactGarbageRatio*q.live() {
+		return nil
+	}
+
+	records := make([]record, 0, q.live())
+	for _, c := range q.crons {
+		records = append(records, cronRecord(c))
+	}
+	for _, item := range q.jobs {
+		records = append(records, putRecord(item))
+	}
+	for _, item := range q.archive {
+		records = append(records, archiveRecord(item))
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	tmp := q.logPath() + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(buf.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, q.logPath()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	log.Trace("%s: compacted %d/%d records %d -> %d bytes", q.dir, len(records), q.n, q.offset, buf.Len())
+
+	// the state is intact, only switch to the new log
+	jobs, archive, crons := q.jobs, q.archive, q.crons
+	if err = q.reopen(); err != nil {
+		return err
+	}
+	q.jobs, q.archive, q.crons = jobs, archive, crons
+	q.offset, q.n = int64(buf.Len()), len(records)
+	return nil
+}
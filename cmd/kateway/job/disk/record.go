@@ -0,0 +1,104 @@
+package disk
+
+import (
+	"github.com/funkygao/gafka/cmd/kateway/job"
+)
+
+// log record operations.
+const (
+	opPut     = "put"    // add or update a pending job
+	opDel     = "del"    // remove a pending job
+	opArchive = "arc"    // keep a fired job
+	opCron    = "cron"   // add or update a recurring job definition
+	opUncron  = "uncron" // remove a recurring job definition
+)
+
+// record is a line of the log in json.
+type record struct {
+	Op      string `json:"op"`
+	JobId   int64  `json:"id"`
+	Payload []byte `json:"p,omitempty"`
+	Ctime   int64  `json:"c,omitempty"`
+	Mtime   int64  `json:"m,omitempty"`
+	Due     int64  `json:"d,omitempty"`
+	Etime   int64  `json:"e,omitempty"`
+	ActorId string `json:"a,omitempty"`
+	Spec    string `json:"s,omitempty"`
+	Paused  bool   `json:"z,omitempty"`
+	Key     string `json:"k,omitempty"`
+	Tag     string `json:"t,omitempty"`
+}
+
+func putRecord(item job.JobItem) record {
+	return record{
+		Op:      opPut,
+		JobId:   item.JobId,
+		Payload: item.Payload,
+		Ctime:   item.Ctime,
+		Mtime:   item.Mtime,
+		Due:     item.DueTime,
+		Key:     item.Key,
+		Tag:     item.Tag,
+	}
+}
+
+func delRecord(jobId int64) record {
+	return record{Op: opDel, JobId: jobId}
+}
+
+func archiveRecord(item job.JobItem) record {
+	return record{
+		Op:      opArchive,
+		JobId:   item.JobId,
+		Payload: item.Payload,
+		Ctime:   item.Ctime,
+		Due:     item.DueTime,
+		Etime:   item.Etime,
+		ActorId: item.ActorId,
+		Key:     item.Key,
+		Tag:     item.Tag,
+	}
+}
+
+func cronRecord(c job.CronItem) record {
+	return record{
+		Op:      opCron,
+		JobId:   c.JobId,
+		Payload: c.Payload,
+		Ctime:   c.Ctime,
+		Spec:    c.Spec,
+		Paused:  c.Paused,
+		Key:     c.Key,
+		Tag:     c.Tag,
+	}
+}
+
+func uncronRecord(jobId int64) record {
+	return record{Op: opUncron, JobId: jobId}
+}
+
+func (this record) jobItem() job.JobItem {
+	return job.JobItem{
+		JobId:   this.JobId,
+		Payload: this.Payload,
+		Ctime:   this.Ctime,
+		Mtime:   this.Mtime,
+		DueTime: this.Due,
+		Etime:   this.Etime,
+		ActorId: this.ActorId,
+		Key:     this.Key,
+		Tag:     this.Tag,
+	}
+}
+
+func (this record) cronItem() job.CronItem {
+	return job.CronItem{
+		JobId:   this.JobId,
+		Payload: this.Payload,
+		Spec:    this.Spec,
+		Paused:  this.Paused,
+		Ctime:   this.Ctime,
+		Key:     this.Key,
+		Tag:     this.Tag,
+	}
+}
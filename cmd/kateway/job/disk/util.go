@@ -0,0 +1,63 @@
+package disk
+
+import (
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/job"
+)
+
+const defaultListLimit = 100
+
+// nextMtime bumps the mtime of a job on update so that actor never fires a stale job.
+func nextMtime(mtime int64) int64 {
+	if now := time.Now().Unix(); now > mtime {
+		return now
+	}
+
+	return mtime + 1
+}
+
+// jobItems sorts jobs by due time and then job id.
+type jobItems []job.JobItem
+
+func (this jobItems) Len() int {
+	return len(this)
+}
+
+func (this jobItems) Less(i, j int) bool {
+	if this[i].DueTime != this[j].DueTime {
+		return this[i].DueTime < this[j].DueTime
+	}
+
+	return this[i].JobId < this[j].JobId
+}
+
+func (this jobItems) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
+
+type cronItems []job.CronItem
+
+func (this cronItems) Len() int {
+	return len(this)
+}
+
+func (this cronItems) Less(i, j int) bool {
+	return this[i].JobId < this[j].JobId
+}
+
+func (this cronItems) Swap(i, j int) {
+	this[i], this[j] = this[j], this[i]
+}
+
+// occurrence is the next pending job of a recurring job.
+func occurrence(c job.CronItem, ctime, due int64) job.JobItem {
+	return job.JobItem{
+		JobId:   c.JobId,
+		Payload: c.Payload,
+		Ctime:   ctime,
+		DueTime: due,
+		Key:     c.Key,
+		Tag:     c.Tag,
+	}
+}
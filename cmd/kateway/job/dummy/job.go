@@ -10,7 +10,47 @@ func New() job.JobStore {
 	return &dummy{}
 }
 
-func (this *dummy) Add(appid, topic string, payload []byte, due int64) (jobId string, err error) {
+func (this *dummy) Add(appid, topic string, payload []byte, key, tag string, due int64) (jobId string, err error) {
+	return
+}
+
+func (this *dummy) AddCron(appid, topic string, payload []byte, key, tag, spec string) (jobId string, err error) {
+	return
+}
+
+func (this *dummy) Crons(appid, topic string) ([]job.CronItem, error) {
+	return nil, nil
+}
+
+func (this *dummy) CronSpecs(appid, topic string, jobIds []int64) (specs map[int64]string, err error) {
+	return
+}
+
+func (this *dummy) PauseCron(appid, topic, jobId string) (err error) {
+	return
+}
+
+func (this *dummy) ResumeCron(appid, topic, jobId string) (err error) {
+	return
+}
+
+func (this *dummy) Get(appid, topic, jobId string) (item job.JobItem, err error) {
+	return
+}
+
+func (this *dummy) List(appid, topic string, opt job.ListOption) (jobs []job.JobItem, cursor string, err error) {
+	return
+}
+
+func (this *dummy) Reschedule(appid, topic, jobId string, due int64) (err error) {
+	return
+}
+
+func (this *dummy) Update(appid, topic, jobId string, due int64, payload []byte) (err error) {
+	return
+}
+
+func (this *dummy) UpdatePayload(appid, topic, jobId string, payload []byte) (err error) {
 	return
 }
 
@@ -18,6 +58,50 @@ func (this *dummy) Delete(appid, topic, jobId string) (err error) {
 	return
 }
 
+func (this *dummy) Prepare(appid, topic string, payload []byte) (xaId string, err error) {
+	return
+}
+
+func (this *dummy) Commit(appid, topic, xaId string) (err error) {
+	return
+}
+
+func (this *dummy) Rollback(appid, topic, xaId string) (err error) {
+	return
+}
+
+func (this *dummy) Open(appid, topic string) (err error) {
+	return
+}
+
+func (this *dummy) Due(appid, topic string, due int64) ([]job.JobItem, error) {
+	return nil, nil
+}
+
+func (this *dummy) Take(appid, topic string, item job.JobItem) (ok bool, err error) {
+	return
+}
+
+func (this *dummy) Putback(appid, topic string, item job.JobItem) (err error) {
+	return
+}
+
+func (this *dummy) Archive(appid, topic string, item job.JobItem, etime int64, actorId string) (err error) {
+	return
+}
+
+func (this *dummy) Reenqueue(appid, topic string, jobId int64, due int64) (err error) {
+	return
+}
+
+func (this *dummy) TakeBatch(appid, topic string, items []job.JobItem) (taken []job.JobItem, err error) {
+	return
+}
+
+func (this *dummy) SettleBatch(appid, topic string, batch job.FiredBatch, etime int64, actorId string) (err error) {
+	return
+}
+
 func (this *dummy) CreateJobQueue(shardId int, appid, topic string) (err error) {
 	return
 }
@@ -3,5 +3,12 @@ package job
 import "errors"
 
 var (
-	ErrNothingDeleted = errors.New("nothing deleted")
+	ErrNothingDeleted  = errors.New("nothing deleted")
+	ErrNotPrepared     = errors.New("xa message not prepared")
+	ErrNoSuchCron      = errors.New("no such recurring job")
+	ErrNoSuchJob       = errors.New("no such job")
+	ErrInvalidCursor   = errors.New("invalid cursor")
+	ErrNoSuchJobQueue  = errors.New("no such job queue")
+	ErrNotSupported    = errors.New("not supported by the job store")
+	ErrInvalidSchedule = errors.New("invalid schedule spec")
 )
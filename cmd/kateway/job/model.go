@@ -3,16 +3,44 @@ package job
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+)
+
+// XA message states.
+const (
+	XaPrepared   = 0
+	XaCommitted  = 1
+	XaUnresolved = 2 // checkback exhausted, kept until the producer commits or rollbacks
+)
+
+// Job lifecycle states.
+const (
+	JobPending = "pending" // waiting in job queue for its due time
+	JobDue     = "due"     // overdue in job queue, actor is lagging behind or down
+	JobFired   = "fired"   // delivered to kafka and moved to archive table
 )
 
 type JobItem struct {
 	JobId   int64
 	Payload []byte
 	Ctime   int64
+	Mtime   int64 // bumped on each update so that actor never fires a stale job
 	DueTime int64
+	Key     string // partition key, jobs of the same key fire in due time order
+	Tag     string // message tag carried to subscribers
+
+	// available only for Get and List
+	State   string
+	Etime   int64  // when the job fired
+	ActorId string // which actor fired the job
 }
 
 func (this JobItem) String() string {
+	if this.Key != "" {
+		return fmt.Sprintf("{%d:%d key:%s %s}", this.JobId, this.DueTime, this.Key, string(this.Payload))
+	}
+
 	return fmt.Sprintf("{%d:%d %s}", this.JobId, this.DueTime, string(this.Payload))
 }
 
@@ -23,3 +51,67 @@ func (this JobItem) PayloadString(limit int) string {
 
 	return string(this.Payload)
 }
+
+// CronItem is the definition of a recurring job.
+type CronItem struct {
+	JobId    int64
+	Payload  []byte
+	Spec     string // see ParseSchedule
+	Key      string
+	Tag      string
+	Paused   bool
+	Ctime    int64
+	NextTime int64 // 0 if paused
+}
+
+func (this CronItem) String() string {
+	return fmt.Sprintf("{%d:%s paused:%v %s}", this.JobId, this.Spec, this.Paused, string(this.Payload))
+}
+
+// FiredBatch is the outcome of firing the jobs taken by TakeBatch.
+type FiredBatch struct {
+	Fired     []JobItem // one-off jobs to archive
+	Recurring []JobItem // recurring jobs with DueTime of the next occurrence, 0 if never fires again
+	Failed    []JobItem // jobs to put back
+}
+
+// ListOption filters the jobs of a topic.
+type ListOption struct {
+	State   string // JobPending for the job queue, JobFired for the archive
+	DueFrom int64  // inclusive, 0 means unbounded
+	DueTo   int64  // exclusive, 0 means unbounded
+	Cursor  string // returned by the previous List, empty for the first page
+	Limit   int
+}
+
+// PendingState returns the state of a job in job queue.
+func PendingState(due, now int64) string {
+	if due <= now {
+		return JobDue
+	}
+
+	return JobPending
+}
+
+// FormatCursor encodes the position of the last listed job.
+func FormatCursor(due, jobId int64) string {
+	return fmt.Sprintf("%d-%d", due, jobId)
+}
+
+// ParseCursor decodes the cursor returned by FormatCursor.
+func ParseCursor(cursor string) (due, jobId int64, err error) {
+	tuples := strings.SplitN(cursor, "-", 2)
+	if len(tuples) != 2 {
+		err = ErrInvalidCursor
+		return
+	}
+
+	if due, err = strconv.ParseInt(tuples[0], 10, 64); err != nil {
+		err = ErrInvalidCursor
+		return
+	}
+	if jobId, err = strconv.ParseInt(tuples[1], 10, 64); err != nil {
+		err = ErrInvalidCursor
+	}
+	return
+}
@@ -0,0 +1,24 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestCursor(t *testing.T) {
+	due, jid, err := ParseCursor(FormatCursor(1471565204, 341647700585877504))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1471565204), due)
+	assert.Equal(t, int64(341647700585877504), jid)
+
+	for _, cursor := range []string{"", "1471565204", "a-1", "1-b"} {
+		_, _, err = ParseCursor(cursor)
+		assert.Equal(t, ErrInvalidCursor, err)
+	}
+}
+
+func TestPendingState(t *testing.T) {
+	assert.Equal(t, JobPending, PendingState(101, 100))
+	assert.Equal(t, JobDue, PendingState(100, 100))
+}
@@ -0,0 +1,171 @@
+package mysql
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/job"
+	log "github.com/funkygao/log4go"
+)
+
+func (this *mysqlStore) Open(appid, topic string) (err error) {
+	// job queues created before recurring jobs have no cron table
+	aid := App_id(appid)
+	_, _, err = this.mc.Exec(AppPool, CronTable(topic), aid, CronTableSchema(topic))
+	if err != nil {
+		return
+	}
+
+	// job queues created before partition key and tag support have no such columns
+	for _, table := range []string{JobTable(topic), HistoryTable(topic), CronTable(topic)} {
+		if err = this.addKeyTagColumns(table, aid); err != nil {
+			return
+		}
+	}
+
+	if err = this.releaseClaims(JobTable(topic), aid); err != nil {
+		return
+	}
+
+	return this.restoreCrons(topic, aid)
+}
+
+// restoreCrons schedules the next occurrence of the active recurring jobs that have
+// no pending occurrence, e.g. actor crashed between Take and Reenqueue or Reenqueue failed.
+func (this *mysqlStore) restoreCrons(topic string, aid int) (err error) {
+	table, cronTable := JobTable(topic), CronTable(topic)
+	sql := fmt.Sprintf("SELECT c.job_id,c.spec FROM %s c LEFT JOIN %s j ON j.job_id=c.job_id WHERE c.paused=0 AND j.job_id IS NULL",
+		cronTable, table)
+	rows, err := this.mc.Query(AppPool, cronTable, aid, sql)
+	if err != nil {
+		return
+	}
+
+	specs := make(map[int64]string)
+	for rows.Next() {
+		var (
+			jid  int64
+			spec string
+		)
+		if err = rows.Scan(&jid, &spec); err != nil {
+			rows.Close()
+			return
+		}
+
+		specs[jid] = spec
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	for jid, spec := range specs {
+		schedule, err := job.ParseSchedule(spec)
+		if err != nil {
+			log.Error("%s cron %d %s: %v", cronTable, jid, spec, err)
+			continue
+		}
+
+		next := schedule.Next(now)
+		if next.IsZero() {
+			continue
+		}
+
+		// IGNORE: the occurrence might be re-enqueued meanwhile
+		sql = fmt.Sprintf("INSERT IGNORE INTO %s(job_id, payload, ctime, due_time, job_key, tag) SELECT job_id, payload, ?, ?, job_key, tag FROM %s WHERE job_id=? AND paused=0",
+			table, cronTable)
+		if _, _, err = this.mc.Exec(AppPool, table, aid, sql, now.Unix(), next.Unix(), jid); err != nil {
+			return err
+		}
+
+		log.Warn("%s restored cron %d next %s", table, jid, next)
+	}
+
+	return
+}
+
+func (this *mysqlStore) addKeyTagColumns(table string, aid int) (err error) {
+	sql := "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA=DATABASE() AND TABLE_NAME=? AND COLUMN_NAME='job_key'"
+	rows, err := this.mc.Query(AppPool, table, aid, sql, table)
+	if err != nil {
+		return
+	}
+
+	var n int
+	if rows.Next() {
+		err = rows.Scan(&n)
+	}
+	rows.Close()
+	if err != nil || n > 0 {
+		return
+	}
+
+	log.Info("migrating %s: add job_key and tag", table)
+
+	sql = fmt.Sprintf(`ALTER TABLE %s ADD COLUMN job_key varchar(256) NOT NULL DEFAULT "", ADD COLUMN tag varchar(1024) NOT NULL DEFAULT ""`, table)
+	_, _, err = this.mc.Exec(AppPool, table, aid, sql)
+	return
+}
+
+func (this *mysqlStore) Due(appid, topic string, due int64) ([]job.JobItem, error) {
+	table, aid := JobTable(topic), App_id(appid)
+	this.retryReleaseClaims(table)
+
+	sql := fmt.Sprintf("SELECT job_id,payload,ctime,mtime,due_time,job_key,tag FROM %s WHERE due_time<=? AND mtime>=0 ORDER BY due_time,job_id", table)
+	rows, err := this.mc.Query(AppPool, table, aid, sql, due)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []job.JobItem
+	for rows.Next() {
+		var item job.JobItem
+		if err = rows.Scan(&item.JobId, &item.Payload, &item.Ctime, &item.Mtime, &item.DueTime, &item.Key, &item.Tag); err != nil {
+			return items, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func (this *mysqlStore) Take(appid, topic string, item job.JobItem) (ok bool, err error) {
+	// zabbix maintains a in-memory delete queue
+	// delete from history_uint where itemid=? and clock<min_clock
+	// mtime guards against the job being rescheduled or updated after Due
+	var affectedRows int64
+	table, aid := JobTable(topic), App_id(appid)
+	sql := fmt.Sprintf("DELETE FROM %s WHERE job_id=? AND mtime=?", table)
+	affectedRows, _, err = this.mc.Exec(AppPool, table, aid, sql, item.JobId, item.Mtime)
+	ok = affectedRows > 0
+	return
+}
+
+func (this *mysqlStore) Putback(appid, topic string, item job.JobItem) (err error) {
+	table, aid := JobTable(topic), App_id(appid)
+	sql := fmt.Sprintf("INSERT INTO %s(job_id, payload, ctime, mtime, due_time, job_key, tag) VALUES(?,?,?,?,?,?,?)", table)
+	_, _, err = this.mc.Exec(AppPool, table, aid, sql,
+		item.JobId, item.Payload, item.Ctime, item.Mtime, item.DueTime, item.Key, item.Tag)
+	return
+}
+
+func (this *mysqlStore) Archive(appid, topic string, item job.JobItem, etime int64, actorId string) (err error) {
+	table, aid := HistoryTable(topic), App_id(appid)
+	sql := fmt.Sprintf("INSERT INTO %s(job_id,payload,ctime,due_time,etime,actor_id,job_key,tag) VALUES(?,?,?,?,?,?,?,?)", table)
+	_, _, err = this.mc.Exec(AppPool, table, aid, sql,
+		item.JobId, item.Payload, item.Ctime, item.DueTime, etime, actorId, item.Key, item.Tag)
+	return
+}
+
+func (this *mysqlStore) Reenqueue(appid, topic string, jobId int64, due int64) (err error) {
+	// the job might be paused or cancelled since actor learned it is recurring
+	table, cronTable, aid := JobTable(topic), CronTable(topic), App_id(appid)
+	sql := fmt.Sprintf("INSERT INTO %s(job_id, payload, ctime, due_time, job_key, tag) SELECT job_id, payload, ?, ?, job_key, tag FROM %s WHERE job_id=? AND paused=0",
+		table, cronTable)
+	_, _, err = this.mc.Exec(AppPool, table, aid, sql, time.Now().Unix(), due, jobId)
+	return
+}
@@ -0,0 +1,262 @@
+package mysql
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/job"
+	log "github.com/funkygao/log4go"
+)
+
+// A batch of due jobs is claimed by setting their mtime to a negative claim id with
+// a single statement. The claimed jobs are invisible to Due and can not be updated
+// or deleted by clients.
+// The batch is settled with one multi-row statement per kind of result instead of a
+// transaction: MysqlCluster runs each Exec on any pooled connection of the shard and
+// can not hold a transaction across statements.
+// Each statement is atomic by itself and the archive is the commit point of a fired job,
+// so a failure or crash in between never loses or refires a settled job: releasing a
+// claim deletes the archived jobs and puts back only the jobs not yet settled.
+// If a statement after the claim fails, the claim is released so that the jobs are
+// due again, a failed release is retried by the next Due.
+// If actor crashes before settling the batch, Open releases the claims and the jobs
+// not yet archived fire again.
+
+// claimKey identifies a claim to release.
+type claimKey struct {
+	table string
+	aid   int
+	claim int64
+}
+
+func (this *mysqlStore) nextClaim() int64 {
+	// fits in the int mtime column
+	return -int64(atomic.AddInt32(&this.claimSeq, 1)&math.MaxInt32) - 1
+}
+
+func (this *mysqlStore) TakeBatch(appid, topic string, items []job.JobItem) (taken []job.JobItem, err error) {
+	if len(items) == 0 {
+		return
+	}
+
+	claim := this.nextClaim()
+	table, aid := JobTable(topic), App_id(appid)
+	args := make([]interface{}, 0, 1+2*len(items))
+	args = append(args, claim)
+	for _, item := range items {
+		args = append(args, item.JobId, item.Mtime)
+	}
+	sql := fmt.Sprintf("UPDATE %s SET mtime=? WHERE %s", table, orPlaceholders("(job_id=? AND mtime=?)", len(items)))
+	var affectedRows int64
+	affectedRows, _, err = this.mc.Exec(AppPool, table, aid, sql, args...)
+	if err != nil {
+		// the claim might have been applied, e,g. read timeout
+		this.releaseClaim(claimKey{table: table, aid: aid, claim: claim})
+		return
+	}
+	if affectedRows == 0 {
+		return
+	}
+
+	args = args[:0]
+	args = append(args, claim)
+	for _, item := range items {
+		args = append(args, item.JobId)
+	}
+	sql = fmt.Sprintf("SELECT job_id FROM %s WHERE mtime=? AND job_id IN (%s)", table, placeholders("?", len(items)))
+	claimed, err := this.claimedJobs(table, aid, sql, args, int(affectedRows))
+	if err != nil {
+		this.releaseClaim(claimKey{table: table, aid: aid, claim: claim})
+		return
+	}
+
+	for _, item := range items {
+		if _, present := claimed[item.JobId]; present {
+			delete(claimed, item.JobId) // a job might be fetched twice
+			item.Mtime = claim
+			taken = append(taken, item)
+		}
+	}
+
+	return
+}
+
+func (this *mysqlStore) claimedJobs(table string, aid int, sql string, args []interface{}, n int) (map[int64]struct{}, error) {
+	rows, err := this.mc.Query(AppPool, table, aid, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	claimed := make(map[int64]struct{}, n)
+	for rows.Next() {
+		var jid int64
+		if err = rows.Scan(&jid); err != nil {
+			return nil, err
+		}
+
+		claimed[jid] = struct{}{}
+	}
+
+	return claimed, rows.Err()
+}
+
+func (this *mysqlStore) SettleBatch(appid, topic string, batch job.FiredBatch, etime int64, actorId string) (err error) {
+	table, aid := JobTable(topic), App_id(appid)
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		// the jobs not yet settled fire again, the archived ones never
+		claims := make(map[int64]struct{})
+		for _, items := range [][]job.JobItem{batch.Fired, batch.Recurring, batch.Failed} {
+			for _, item := range items {
+				claims[item.Mtime] = struct{}{}
+			}
+		}
+		for claim := range claims {
+			this.releaseClaim(claimKey{table: table, aid: aid, claim: claim})
+		}
+	}()
+
+	if len(batch.Fired) > 0 {
+		// IGNORE: a job fired again after actor crash is archived once
+		historyTable := HistoryTable(topic)
+		args := make([]interface{}, 0, 8*len(batch.Fired))
+		for _, item := range batch.Fired {
+			args = append(args, item.JobId, item.Payload, item.Ctime, item.DueTime, etime, actorId, item.Key, item.Tag)
+		}
+		sql := fmt.Sprintf("INSERT IGNORE INTO %s(job_id,payload,ctime,due_time,etime,actor_id,job_key,tag) VALUES %s",
+			historyTable, placeholders("(?,?,?,?,?,?,?,?)", len(batch.Fired)))
+		if _, _, err = this.mc.Exec(AppPool, historyTable, aid, sql, args...); err != nil {
+			return
+		}
+	}
+
+	if n := len(batch.Recurring); n > 0 {
+		// reschedule the fired recurring jobs in place instead of delete and re-insert,
+		// so that a recurring job never ends up without its pending occurrence.
+		// the job might be paused or cancelled since actor learned it is recurring, and
+		// is left claimed to be deleted with the fired jobs
+		cases := make([]interface{}, 0, 2*n)
+		conds := make([]interface{}, 0, 2*n)
+		for _, item := range batch.Recurring {
+			if item.DueTime > 0 {
+				cases = append(cases, item.JobId, item.DueTime)
+				conds = append(conds, item.JobId, item.Mtime)
+			}
+		}
+		if m := len(conds) / 2; m > 0 {
+			now := time.Now().Unix()
+			args := make([]interface{}, 0, len(cases)+2+len(conds))
+			args = append(args, cases...)
+			args = append(args, now, now)
+			args = append(args, conds...)
+			cronTable := CronTable(topic)
+			sql := fmt.Sprintf("UPDATE %s j JOIN %s c ON c.job_id=j.job_id AND c.paused=0 SET j.due_time=CASE j.job_id %s END,j.payload=c.payload,j.job_key=c.job_key,j.tag=c.tag,j.ctime=?,j.mtime=? WHERE %s",
+				table, cronTable, strings.Repeat("WHEN ? THEN ? ", m), orPlaceholders("(j.job_id=? AND j.mtime=?)", m))
+			if _, _, err = this.mc.Exec(AppPool, table, aid, sql, args...); err != nil {
+				return
+			}
+		}
+	}
+
+	if n := len(batch.Fired) + len(batch.Recurring); n > 0 {
+		// the rescheduled recurring jobs are no longer claimed and kept
+		args := make([]interface{}, 0, 2*n)
+		for _, items := range [][]job.JobItem{batch.Fired, batch.Recurring} {
+			for _, item := range items {
+				args = append(args, item.JobId, item.Mtime)
+			}
+		}
+		sql := fmt.Sprintf("DELETE FROM %s WHERE %s", table, orPlaceholders("(job_id=? AND mtime=?)", n))
+		if _, _, err = this.mc.Exec(AppPool, table, aid, sql, args...); err != nil {
+			return
+		}
+	}
+
+	if len(batch.Failed) > 0 {
+		// release the claims so that the jobs are due again
+		args := make([]interface{}, 0, 1+2*len(batch.Failed))
+		args = append(args, time.Now().Unix())
+		for _, item := range batch.Failed {
+			args = append(args, item.JobId, item.Mtime)
+		}
+		sql := fmt.Sprintf("UPDATE %s SET mtime=? WHERE %s", table, orPlaceholders("(job_id=? AND mtime=?)", len(batch.Failed)))
+		_, _, err = this.mc.Exec(AppPool, table, aid, sql, args...)
+	}
+
+	return
+}
+
+// releaseClaim puts back the jobs of a claim which failed to settle.
+// It is retried by Due on failure.
+func (this *mysqlStore) releaseClaim(key claimKey) {
+	this.claimsLock.Lock()
+	defer this.claimsLock.Unlock()
+
+	if _, present := this.unreleased[key]; present {
+		return
+	}
+
+	affectedRows, err := this.settleArchived(key.table, key.aid, "j.mtime=?", key.claim)
+	if err == nil {
+		sql := fmt.Sprintf("UPDATE %s SET mtime=? WHERE mtime=?", key.table)
+		affectedRows, _, err = this.mc.Exec(AppPool, key.table, key.aid, sql, time.Now().Unix(), key.claim)
+	}
+	if err != nil {
+		log.Error("%s release claim %d: %v", key.table, key.claim, err)
+		this.unreleased[key] = struct{}{}
+		return
+	}
+
+	log.Warn("%s released %d jobs of claim %d", key.table, affectedRows, key.claim)
+}
+
+// retryReleaseClaims releases the claims of a table which failed to release before.
+func (this *mysqlStore) retryReleaseClaims(table string) {
+	this.claimsLock.Lock()
+	var keys []claimKey
+	for key := range this.unreleased {
+		if key.table == table {
+			keys = append(keys, key)
+			delete(this.unreleased, key)
+		}
+	}
+	this.claimsLock.Unlock()
+
+	for _, key := range keys {
+		this.releaseClaim(key)
+	}
+}
+
+// releaseClaims puts back the jobs claimed by a crashed actor.
+func (this *mysqlStore) releaseClaims(table string, aid int) (err error) {
+	var affectedRows int64
+	if _, err = this.settleArchived(table, aid, "j.mtime<0"); err != nil {
+		return
+	}
+
+	sql := fmt.Sprintf("UPDATE %s SET mtime=? WHERE mtime<0", table)
+	affectedRows, _, err = this.mc.Exec(AppPool, table, aid, sql, time.Now().Unix())
+	if err == nil && affectedRows > 0 {
+		log.Warn("%s released %d claimed jobs", table, affectedRows)
+	}
+	return
+}
+
+// settleArchived deletes the claimed jobs that are already archived, whose settle
+// failed after the archive.
+func (this *mysqlStore) settleArchived(table string, aid int, cond string, args ...interface{}) (int64, error) {
+	sql := fmt.Sprintf("DELETE j FROM %s j JOIN %s h ON h.job_id=j.job_id WHERE %s",
+		table, historyTableOf(table), cond)
+	affectedRows, _, err := this.mc.Exec(AppPool, table, aid, sql, args...)
+	if err == nil && affectedRows > 0 {
+		log.Warn("%s settled %d archived jobs", table, affectedRows)
+	}
+	return affectedRows, err
+}
@@ -2,7 +2,10 @@ package mysql
 
 import (
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/funkygao/fae/config"
@@ -16,12 +19,18 @@ const (
 	appLookupTable = "AppLookup"
 	AppPool        = "AppShard"
 
+	defaultListLimit = 100
+
 	sqlInsertAppLookup = "INSERT IGNORE INTO AppLookup(entityId, shardId, name, ctime) VALUES(?,?,?,?)"
 )
 
 type mysqlStore struct {
-	idgen *idgen.IdGenerator
-	mc    *mysql.MysqlCluster
+	idgen    *idgen.IdGenerator
+	mc       *mysql.MysqlCluster
+	claimSeq int32 // see TakeBatch
+
+	claimsLock sync.Mutex
+	unreleased map[claimKey]struct{} // claims failed to release
 }
 
 func New(id string, cf *config.ConfigMysql) (job.JobStore, error) {
@@ -41,8 +50,10 @@ func New(id string, cf *config.ConfigMysql) (job.JobStore, error) {
 
 	cf.DefaultLookupTable = appLookupTable
 	return &mysqlStore{
-		idgen: ig,
-		mc:    mysql.New(cf),
+		idgen:      ig,
+		mc:         mysql.New(cf),
+		claimSeq:   int32(time.Now().UnixNano() & math.MaxInt32), // differs among actors
+		unreleased: make(map[claimKey]struct{}),
 	}, nil
 }
 
@@ -55,7 +66,7 @@ func (this *mysqlStore) CreateJobQueue(shardId int, appid, topic string) (err er
 		return
 	}
 
-	// create the job table and job histrory table
+	// create the job table, xa table and job histrory table
 	// in mysql InnoDB, blob is []byte while text is string, both length limit 1<<16(64KB)
 	sql := fmt.Sprintf(`
 CREATE TABLE %s (
@@ -64,6 +75,8 @@ CREATE TABLE %s (
     ctime int NOT NULL DEFAULT 0,
     mtime int NOT NULL DEFAULT 0,
     due_time int NOT NULL,
+    job_key varchar(256) NOT NULL DEFAULT "",
+    tag varchar(1024) NOT NULL DEFAULT "",
     PRIMARY KEY (job_id),
     KEY(due_time)
 ) ENGINE = INNODB DEFAULT CHARSET utf8
@@ -73,6 +86,13 @@ CREATE TABLE %s (
 		return
 	}
 
+	// XA prepared messages
+	xaTable := XaTable(topic)
+	_, _, err = this.mc.Exec(AppPool, xaTable, aid, XaTableSchema(topic))
+	if err != nil {
+		return
+	}
+
 	historyTable := HistoryTable(topic)
 	sql = fmt.Sprintf(`
 CREATE TABLE %s (
@@ -82,25 +102,135 @@ CREATE TABLE %s (
     due_time int NOT NULL,
     etime int NOT NULL DEFAULT 0,
     actor_id char(64) NOT NULL,
+    job_key varchar(256) NOT NULL DEFAULT "",
+    tag varchar(1024) NOT NULL DEFAULT "",
     PRIMARY KEY (job_id),
     KEY(due_time)
 ) ENGINE = INNODB DEFAULT CHARSET utf8
 		`, historyTable)
 	_, _, err = this.mc.Exec(AppPool, historyTable, aid, sql)
+	if err != nil {
+		return
+	}
+
+	cronTable := CronTable(topic)
+	_, _, err = this.mc.Exec(AppPool, cronTable, aid, CronTableSchema(topic))
 	return
 }
 
-func (this *mysqlStore) Add(appid, topic string, payload []byte, due int64) (jobId string, err error) {
+func (this *mysqlStore) Add(appid, topic string, payload []byte, key, tag string, due int64) (jobId string, err error) {
 	jid := this.nextId()
 	table, aid := JobTable(topic), App_id(appid)
-	sql := fmt.Sprintf("INSERT INTO %s(job_id, payload, ctime, due_time) VALUES(?,?,?,?)", table)
+	if key == "" && tag == "" {
+		// job tables not yet migrated by actord have no key and tag columns
+		sql := fmt.Sprintf("INSERT INTO %s(job_id, payload, ctime, due_time) VALUES(?,?,?,?)", table)
+		_, _, err = this.mc.Exec(AppPool, table, aid, sql,
+			jid, payload, time.Now().Unix(), due)
+	} else {
+		sql := fmt.Sprintf("INSERT INTO %s(job_id, payload, ctime, due_time, job_key, tag) VALUES(?,?,?,?,?,?)", table)
+		_, _, err = this.mc.Exec(AppPool, table, aid, sql,
+			jid, payload, time.Now().Unix(), due, key, tag)
+	}
+	jobId = strconv.FormatInt(jid, 10)
+	return
+}
+
+// AddCron persists the recurring job definition and its first occurrence in job table
+// with the same job id, actor will re-enqueue the next occurrence after each firing.
+func (this *mysqlStore) AddCron(appid, topic string, payload []byte, key, tag, spec string) (jobId string, err error) {
+	schedule, err := job.ParseSchedule(spec)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	next := schedule.Next(now)
+	if next.IsZero() {
+		return "", job.ErrInvalidSchedule
+	}
+
+	jid := this.nextId()
+	cronTable, aid := CronTable(topic), App_id(appid)
+	sql := fmt.Sprintf("INSERT INTO %s(job_id, payload, spec, paused, ctime, mtime, job_key, tag) VALUES(?,?,?,0,?,?,?,?)", cronTable)
+	_, _, err = this.mc.Exec(AppPool, cronTable, aid, sql,
+		jid, payload, spec, now.Unix(), now.Unix(), key, tag)
+	if err != nil {
+		return
+	}
+
+	table := JobTable(topic)
+	sql = fmt.Sprintf("INSERT INTO %s(job_id, payload, ctime, due_time, job_key, tag) VALUES(?,?,?,?,?,?)", table)
 	_, _, err = this.mc.Exec(AppPool, table, aid, sql,
-		jid, payload, time.Now().Unix(), due)
+		jid, payload, now.Unix(), next.Unix(), key, tag)
+	if err != nil {
+		// the definition without occurrence will never fire
+		sql = fmt.Sprintf("DELETE FROM %s WHERE job_id=?", cronTable)
+		this.mc.Exec(AppPool, cronTable, aid, sql, jid)
+		return
+	}
+
 	jobId = strconv.FormatInt(jid, 10)
 	return
 }
 
-func (this *mysqlStore) Delete(appid, topic, jobId string) (err error) {
+func (this *mysqlStore) Crons(appid, topic string) ([]job.CronItem, error) {
+	cronTable, table, aid := CronTable(topic), JobTable(topic), App_id(appid)
+	sql := fmt.Sprintf("SELECT c.job_id,c.payload,c.spec,c.paused,c.ctime,c.job_key,c.tag,IFNULL(j.due_time,0) FROM %s c LEFT JOIN %s j ON c.job_id=j.job_id",
+		cronTable, table)
+	rows, err := this.mc.Query(AppPool, cronTable, aid, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var r []job.CronItem
+	for rows.Next() {
+		var item job.CronItem
+		if err = rows.Scan(&item.JobId, &item.Payload, &item.Spec, &item.Paused, &item.Ctime, &item.Key, &item.Tag, &item.NextTime); err != nil {
+			return nil, err
+		}
+
+		r = append(r, item)
+	}
+
+	return r, rows.Err()
+}
+
+func (this *mysqlStore) CronSpecs(appid, topic string, jobIds []int64) (specs map[int64]string, err error) {
+	specs = make(map[int64]string)
+	if len(jobIds) == 0 {
+		return
+	}
+
+	cronTable, aid := CronTable(topic), App_id(appid)
+	args := make([]interface{}, 0, len(jobIds))
+	for _, jid := range jobIds {
+		args = append(args, jid)
+	}
+	sql := fmt.Sprintf("SELECT job_id,spec FROM %s WHERE paused=0 AND job_id IN (%s)", cronTable, placeholders("?", len(jobIds)))
+	rows, err := this.mc.Query(AppPool, cronTable, aid, sql, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			jid  int64
+			spec string
+		)
+		if err = rows.Scan(&jid, &spec); err != nil {
+			return
+		}
+
+		specs[jid] = spec
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (this *mysqlStore) PauseCron(appid, topic, jobId string) (err error) {
 	var jid int64
 	jid, err = strconv.ParseInt(jobId, 10, 64)
 	if err != nil {
@@ -108,16 +238,351 @@ func (this *mysqlStore) Delete(appid, topic, jobId string) (err error) {
 	}
 
 	var affectedRows int64
+	cronTable, aid := CronTable(topic), App_id(appid)
+	sql := fmt.Sprintf("UPDATE %s SET paused=1, mtime=? WHERE job_id=? AND paused=0", cronTable)
+	affectedRows, _, err = this.mc.Exec(AppPool, cronTable, aid, sql, time.Now().Unix(), jid)
+	if err != nil {
+		return
+	}
+	if affectedRows == 0 {
+		// pause is idempotent
+		_, err = this.cronSpec(cronTable, aid, jid)
+		return
+	}
+
+	// cancel the pending occurrence, actor will not re-enqueue a paused job
+	table := JobTable(topic)
+	sql = fmt.Sprintf("DELETE FROM %s WHERE job_id=? AND mtime>=0", table)
+	_, _, err = this.mc.Exec(AppPool, table, aid, sql, jid)
+	return
+}
+
+func (this *mysqlStore) ResumeCron(appid, topic, jobId string) (err error) {
+	var jid int64
+	jid, err = strconv.ParseInt(jobId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	cronTable, aid := CronTable(topic), App_id(appid)
+	spec, err := this.cronSpec(cronTable, aid, jid)
+	if err != nil {
+		return
+	}
+
+	schedule, err := job.ParseSchedule(spec)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	next := schedule.Next(now)
+	if next.IsZero() {
+		return job.ErrInvalidSchedule
+	}
+
+	var affectedRows int64
+	sql := fmt.Sprintf("UPDATE %s SET paused=0, mtime=? WHERE job_id=? AND paused=1", cronTable)
+	affectedRows, _, err = this.mc.Exec(AppPool, cronTable, aid, sql, now.Unix(), jid)
+	if err != nil || affectedRows == 0 {
+		// resume is idempotent
+		return
+	}
+
+	table := JobTable(topic)
+	sql = fmt.Sprintf("INSERT INTO %s(job_id, payload, ctime, due_time, job_key, tag) SELECT job_id, payload, ?, ?, job_key, tag FROM %s WHERE job_id=?",
+		table, cronTable)
+	_, _, err = this.mc.Exec(AppPool, table, aid, sql, now.Unix(), next.Unix(), jid)
+	return
+}
+
+func (this *mysqlStore) cronSpec(cronTable string, aid int, jid int64) (spec string, err error) {
+	sql := fmt.Sprintf("SELECT spec FROM %s WHERE job_id=?", cronTable)
+	rows, err := this.mc.Query(AppPool, cronTable, aid, sql, jid)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return "", job.ErrNoSuchCron
+	}
+
+	err = rows.Scan(&spec)
+	return
+}
+
+func (this *mysqlStore) Get(appid, topic, jobId string) (item job.JobItem, err error) {
+	var jid int64
+	jid, err = strconv.ParseInt(jobId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	table, aid := JobTable(topic), App_id(appid)
+	sql := fmt.Sprintf("SELECT job_id,payload,ctime,mtime,due_time,job_key,tag FROM %s WHERE job_id=?", table)
+	rows, err := this.mc.Query(AppPool, table, aid, sql, jid)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err = rows.Scan(&item.JobId, &item.Payload, &item.Ctime, &item.Mtime, &item.DueTime, &item.Key, &item.Tag); err != nil {
+			return
+		}
+
+		item.State = job.PendingState(item.DueTime, time.Now().Unix())
+		return
+	}
+
+	historyTable := HistoryTable(topic)
+	sql = fmt.Sprintf("SELECT job_id,payload,ctime,due_time,etime,actor_id,job_key,tag FROM %s WHERE job_id=?", historyTable)
+	archiveRows, err := this.mc.Query(AppPool, historyTable, aid, sql, jid)
+	if err != nil {
+		return
+	}
+	defer archiveRows.Close()
+
+	if !archiveRows.Next() {
+		// never added, deleted or a paused recurring job
+		err = job.ErrNoSuchJob
+		return
+	}
+
+	err = archiveRows.Scan(&item.JobId, &item.Payload, &item.Ctime, &item.DueTime, &item.Etime, &item.ActorId, &item.Key, &item.Tag)
+	item.State = job.JobFired
+	return
+}
+
+func (this *mysqlStore) List(appid, topic string, opt job.ListOption) (jobs []job.JobItem, cursor string, err error) {
+	var (
+		afterDue int64
+		afterJid int64
+	)
+	if opt.Cursor != "" {
+		if afterDue, afterJid, err = job.ParseCursor(opt.Cursor); err != nil {
+			return
+		}
+	}
+	if opt.Limit <= 0 {
+		opt.Limit = defaultListLimit
+	}
+
+	table, aid := JobTable(topic), App_id(appid)
+	fields := "job_id,payload,ctime,mtime,due_time,0,'',job_key,tag"
+	if opt.State == job.JobFired {
+		table = HistoryTable(topic)
+		fields = "job_id,payload,ctime,0,due_time,etime,actor_id,job_key,tag"
+	}
+
+	where := []string{"1=1"}
+	args := make([]interface{}, 0, 6)
+	if opt.DueFrom > 0 {
+		where = append(where, "due_time>=?")
+		args = append(args, opt.DueFrom)
+	}
+	if opt.DueTo > 0 {
+		where = append(where, "due_time<?")
+		args = append(args, opt.DueTo)
+	}
+	if opt.Cursor != "" {
+		// keyset pagination is stable when jobs are added or fired between pages
+		where = append(where, "(due_time>? OR (due_time=? AND job_id>?))")
+		args = append(args, afterDue, afterDue, afterJid)
+	}
+	args = append(args, opt.Limit)
+
+	sql := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY due_time,job_id LIMIT ?",
+		fields, table, strings.Join(where, " AND "))
+	rows, err := this.mc.Query(AppPool, table, aid, sql, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now().Unix()
+	for rows.Next() {
+		var item job.JobItem
+		if err = rows.Scan(&item.JobId, &item.Payload, &item.Ctime, &item.Mtime, &item.DueTime, &item.Etime, &item.ActorId, &item.Key, &item.Tag); err != nil {
+			return
+		}
+
+		if opt.State == job.JobFired {
+			item.State = job.JobFired
+		} else {
+			item.State = job.PendingState(item.DueTime, now)
+		}
+		jobs = append(jobs, item)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	if len(jobs) == opt.Limit {
+		last := jobs[len(jobs)-1]
+		cursor = job.FormatCursor(last.DueTime, last.JobId)
+	}
+	return
+}
+
+func (this *mysqlStore) Reschedule(appid, topic, jobId string, due int64) (err error) {
+	return this.Update(appid, topic, jobId, due, nil)
+}
+
+func (this *mysqlStore) UpdatePayload(appid, topic, jobId string, payload []byte) (err error) {
+	return this.Update(appid, topic, jobId, 0, payload)
+}
+
+// Update bumps mtime so that actor which has fetched the job before will not fire it.
+// A job claimed by actor(negative mtime) is being fired and can not be updated.
+func (this *mysqlStore) Update(appid, topic, jobId string, due int64, payload []byte) (err error) {
+	var jid int64
+	jid, err = strconv.ParseInt(jobId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	var (
+		sets []string
+		args []interface{}
+	)
+	if payload != nil {
+		sets = append(sets, "payload=?")
+		args = append(args, payload)
+	}
+	if due > 0 {
+		sets = append(sets, "due_time=?")
+		args = append(args, due)
+	}
+	if len(sets) == 0 {
+		return
+	}
+
+	var affectedRows, cronAffectedRows int64
+	now := time.Now().Unix()
 	table, aid := JobTable(topic), App_id(appid)
-	sql := fmt.Sprintf("DELETE FROM %s WHERE job_id=?", table)
+	args = append(args, now, jid)
+	sql := fmt.Sprintf("UPDATE %s SET %s, mtime=GREATEST(mtime+1,?) WHERE job_id=? AND mtime>=0", table, strings.Join(sets, ", "))
+	affectedRows, _, err = this.mc.Exec(AppPool, table, aid, sql, args...)
+	if err != nil {
+		return
+	}
+	if affectedRows == 0 && due > 0 {
+		// fired, deleted or being fired
+		return job.ErrNoSuchJob
+	}
+	if payload == nil {
+		return
+	}
+
+	// the following occurrences of recurring job
+	cronTable := CronTable(topic)
+	sql = fmt.Sprintf("UPDATE %s SET payload=?, mtime=? WHERE job_id=?", cronTable)
+	cronAffectedRows, _, err = this.mc.Exec(AppPool, cronTable, aid, sql, payload, now, jid)
+	if err == nil && affectedRows == 0 && cronAffectedRows == 0 {
+		err = job.ErrNoSuchJob
+	}
+
+	return
+}
+
+func (this *mysqlStore) Delete(appid, topic, jobId string) (err error) {
+	var jid int64
+	jid, err = strconv.ParseInt(jobId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	var affectedRows, cronAffectedRows int64
+	table, aid := JobTable(topic), App_id(appid)
+	sql := fmt.Sprintf("DELETE FROM %s WHERE job_id=? AND mtime>=0", table)
 	affectedRows, _, err = this.mc.Exec(AppPool, table, aid, sql, jid)
-	if err == nil && affectedRows == 0 {
+	if err != nil {
+		return
+	}
+
+	// cancel the recurring job if it is
+	cronTable := CronTable(topic)
+	sql = fmt.Sprintf("DELETE FROM %s WHERE job_id=?", cronTable)
+	cronAffectedRows, _, err = this.mc.Exec(AppPool, cronTable, aid, sql, jid)
+	if err == nil && affectedRows == 0 && cronAffectedRows == 0 {
 		err = job.ErrNothingDeleted
 	}
 
 	return
 }
 
+func (this *mysqlStore) Prepare(appid, topic string, payload []byte) (xaId string, err error) {
+	xid := this.nextId()
+	table, aid := XaTable(topic), App_id(appid)
+	now := time.Now().Unix()
+	sql := fmt.Sprintf("INSERT INTO %s(xa_id, payload, state, ctime, mtime) VALUES(?,?,?,?,?)", table)
+	_, _, err = this.mc.Exec(AppPool, table, aid, sql,
+		xid, payload, job.XaPrepared, now, now)
+	xaId = strconv.FormatInt(xid, 10)
+	return
+}
+
+func (this *mysqlStore) Commit(appid, topic, xaId string) (err error) {
+	var xid int64
+	xid, err = strconv.ParseInt(xaId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	// actor will deliver the committed message
+	var affectedRows int64
+	table, aid := XaTable(topic), App_id(appid)
+	sql := fmt.Sprintf("UPDATE %s SET state=?, mtime=? WHERE xa_id=? AND state IN(?,?)", table)
+	affectedRows, _, err = this.mc.Exec(AppPool, table, aid, sql,
+		job.XaCommitted, time.Now().Unix(), xid, job.XaPrepared, job.XaUnresolved)
+	if err != nil {
+		return
+	}
+
+	if affectedRows == 0 {
+		// commit is idempotent before the message is delivered
+		var state int
+		sql = fmt.Sprintf("SELECT state FROM %s WHERE xa_id=?", table)
+		rows, e := this.mc.Query(AppPool, table, aid, sql, xid)
+		if e != nil {
+			return e
+		}
+		defer rows.Close()
+
+		if !rows.Next() {
+			return job.ErrNotPrepared
+		}
+		if err = rows.Scan(&state); err != nil {
+			return
+		}
+		if state != job.XaCommitted {
+			return job.ErrNotPrepared
+		}
+	}
+
+	return
+}
+
+func (this *mysqlStore) Rollback(appid, topic, xaId string) (err error) {
+	var xid int64
+	xid, err = strconv.ParseInt(xaId, 10, 64)
+	if err != nil {
+		return
+	}
+
+	var affectedRows int64
+	table, aid := XaTable(topic), App_id(appid)
+	sql := fmt.Sprintf("DELETE FROM %s WHERE xa_id=? AND state IN(?,?)", table)
+	affectedRows, _, err = this.mc.Exec(AppPool, table, aid, sql, xid, job.XaPrepared, job.XaUnresolved)
+	if err == nil && affectedRows == 0 {
+		err = job.ErrNotPrepared
+	}
+
+	return
+}
+
 func (this *mysqlStore) Name() string {
 	return "mysql"
 }
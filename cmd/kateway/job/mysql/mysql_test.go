@@ -0,0 +1,67 @@
+package mysql
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/funkygao/fae/config"
+	"github.com/funkygao/gafka/cmd/kateway/job"
+	"github.com/funkygao/gafka/cmd/kateway/job/storetest"
+)
+
+// GAFKA_JOB_MYSQL is the job mysql cluster config, the same as zk KatewayJobClusterConfig,
+// whose AppLookup table is initialized with db.sql.
+func TestStoreSuite(t *testing.T) {
+	cf := os.Getenv("GAFKA_JOB_MYSQL")
+	if cf == "" {
+		t.Skip("GAFKA_JOB_MYSQL not set")
+	}
+
+	store, err := newTestStore(cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Start()
+	defer store.Stop()
+
+	storetest.Run(t, store, "app1", fmt.Sprintf("app1.storetest%d.v1", time.Now().Unix()))
+}
+
+func newTestStore(cf string) (job.JobStore, error) {
+	var mcc = &config.ConfigMysql{}
+	if err := mcc.From([]byte(cf)); err != nil {
+		return nil, err
+	}
+
+	return New("1", mcc)
+}
+
+func benchmarkFire(b *testing.B, batchSize int) {
+	cf := os.Getenv("GAFKA_JOB_MYSQL")
+	if cf == "" {
+		b.Skip("GAFKA_JOB_MYSQL not set")
+	}
+
+	store, err := newTestStore(cf)
+	if err != nil {
+		b.Fatal(err)
+	}
+	store.Start()
+	defer store.Stop()
+
+	storetest.Bench(b, store, "app1", fmt.Sprintf("app1.storebench%d.v1", time.Now().UnixNano()), batchSize)
+}
+
+func BenchmarkFireOneByOne(b *testing.B) {
+	benchmarkFire(b, 0)
+}
+
+func BenchmarkFireBatch1(b *testing.B) {
+	benchmarkFire(b, 1)
+}
+
+func BenchmarkFireBatch100(b *testing.B) {
+	benchmarkFire(b, 100)
+}
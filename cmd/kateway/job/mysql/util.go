@@ -1,11 +1,15 @@
 package mysql
 
 import (
+	"fmt"
 	"hash/adler32"
 	"strings"
 )
 
-const jobTablePrefix = "job_"
+const (
+	jobTablePrefix = "job_"
+	xaTablePrefix  = "xa_"
+)
 
 // JobTable converts a topic name to a mysql table name.
 func JobTable(topic string) string {
@@ -14,10 +18,70 @@ func JobTable(topic string) string {
 
 // HistoryTable converts a topic name to a mysql history table name.
 func HistoryTable(topic string) string {
-	return JobTable(topic) + "_archive"
+	return historyTableOf(JobTable(topic))
+}
+
+// historyTableOf returns the history table name of a job table.
+func historyTableOf(table string) string {
+	return table + "_archive"
+}
+
+// CronTable converts a topic name to a mysql table name of recurring jobs.
+func CronTable(topic string) string {
+	return JobTable(topic) + "_cron"
+}
+
+// CronTableSchema returns the DDL of the recurring jobs table which is created on demand
+// because job queues created before recurring jobs have no such table.
+func CronTableSchema(topic string) string {
+	return fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    job_id bigint unsigned NOT NULL DEFAULT 0,
+    payload blob,
+    spec varchar(128) NOT NULL DEFAULT "",
+    paused tinyint unsigned NOT NULL DEFAULT 0,
+    ctime int NOT NULL DEFAULT 0,
+    mtime int NOT NULL DEFAULT 0,
+    job_key varchar(256) NOT NULL DEFAULT "",
+    tag varchar(1024) NOT NULL DEFAULT "",
+    PRIMARY KEY (job_id)
+) ENGINE = INNODB DEFAULT CHARSET utf8
+		`, CronTable(topic))
+}
+
+// XaTableSchema returns the DDL of the XA prepared messages table which is created on demand
+// because job queues created before XA have no such table.
+func XaTableSchema(topic string) string {
+	return fmt.Sprintf(`
+CREATE TABLE IF NOT EXISTS %s (
+    xa_id bigint unsigned NOT NULL DEFAULT 0,
+    payload blob,
+    checkback varchar(512) NOT NULL DEFAULT "",
+    state tinyint unsigned NOT NULL DEFAULT 0,
+    checks int NOT NULL DEFAULT 0,
+    ctime int NOT NULL DEFAULT 0,
+    mtime int NOT NULL DEFAULT 0,
+    PRIMARY KEY (xa_id),
+    KEY(state, mtime)
+) ENGINE = INNODB DEFAULT CHARSET utf8
+		`, XaTable(topic))
+}
+
+// XaTable converts a topic name to a mysql table name of XA prepared messages.
+func XaTable(topic string) string {
+	return xaTablePrefix + strings.Replace(topic, ".", "_", -1)
 }
 
 // App_id convert a string appid to hash int which is used to locate shard.
 func App_id(appid string) int {
 	return int(adler32.Checksum([]byte(appid)))
 }
+
+// placeholders returns n comma separated ph, e,g. (?,?),(?,?).
+func placeholders(ph string, n int) string {
+	return strings.TrimSuffix(strings.Repeat(ph+",", n), ",")
+}
+
+func orPlaceholders(ph string, n int) string {
+	return strings.TrimSuffix(strings.Repeat(ph+" OR ", n), " OR ")
+}
@@ -15,3 +15,13 @@ func TestJobTable(t *testing.T) {
 	assert.Equal(t, "job_app1_foobar_v1_34", JobTable("app1.foobar.v1.34"))
 	assert.Equal(t, "job_app1_foobar_v1_34_archive", HistoryTable("app1.foobar.v1.34"))
 }
+
+func TestXaTable(t *testing.T) {
+	assert.Equal(t, "xa_app1_foobar_v1", XaTable("app1.foobar.v1"))
+}
+
+func TestPlaceholders(t *testing.T) {
+	assert.Equal(t, "?", placeholders("?", 1))
+	assert.Equal(t, "(?,?),(?,?)", placeholders("(?,?)", 2))
+	assert.Equal(t, "(job_id=? AND mtime=?) OR (job_id=? AND mtime=?)", orPlaceholders("(job_id=? AND mtime=?)", 2))
+}
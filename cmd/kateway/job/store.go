@@ -14,10 +14,89 @@ type JobStore interface {
 	CreateJobQueue(shardId int, appid, topic string) (err error)
 
 	// Add pubs a schedulable message(job) synchronously.
-	Add(appid, topic string, payload []byte, due int64) (jobId string, err error)
+	// key is the optional partition key and tag the optional message tag.
+	Add(appid, topic string, payload []byte, key, tag string, due int64) (jobId string, err error)
 
-	// Delete removes a job by jobId.
+	// AddCron adds a recurring job which fires according to spec until deleted.
+	// The first firing is scheduled at once.
+	AddCron(appid, topic string, payload []byte, key, tag, spec string) (jobId string, err error)
+
+	// Crons lists all the recurring jobs of a topic.
+	Crons(appid, topic string) ([]CronItem, error)
+
+	// PauseCron stops a recurring job from firing until ResumeCron.
+	PauseCron(appid, topic, jobId string) (err error)
+
+	// ResumeCron schedules a paused recurring job again from now on.
+	ResumeCron(appid, topic, jobId string) (err error)
+
+	// Get returns a job with its lifecycle state, ErrNoSuchJob if not found.
+	Get(appid, topic, jobId string) (JobItem, error)
+
+	// List returns a page of jobs ordered by due time and the cursor of next page,
+	// which is empty if no more jobs.
+	List(appid, topic string, opt ListOption) (jobs []JobItem, cursor string, err error)
+
+	// Reschedule changes the due time of a pending job, ErrNoSuchJob if it has fired.
+	Reschedule(appid, topic, jobId string, due int64) (err error)
+
+	// UpdatePayload changes the payload of a pending job, ErrNoSuchJob if it has fired.
+	// For a recurring job, the following occurrences also use the new payload.
+	UpdatePayload(appid, topic, jobId string, payload []byte) (err error)
+
+	// Update reschedules and/or replaces the payload of a pending job at once,
+	// due 0 or nil payload leaves it unchanged.
+	// ErrNoSuchJob if it has fired, and a fired recurring job can only have its payload updated.
+	Update(appid, topic, jobId string, due int64, payload []byte) (err error)
+
+	// Delete removes a job by jobId, a recurring job is cancelled.
 	Delete(appid, topic, jobId string) (err error)
+
+	// Prepare persists a half message of a XA transaction which is invisible
+	// to consumers until committed. If neither Commit nor Rollback arrives in time,
+	// actor resolves the transaction state with the checkback url registered for the topic.
+	Prepare(appid, topic string, payload []byte) (xaId string, err error)
+
+	// Commit makes a prepared or unresolved message deliverable.
+	Commit(appid, topic, xaId string) (err error)
+
+	// Rollback discards a prepared or unresolved message.
+	Rollback(appid, topic, xaId string) (err error)
+
+	// The following is used by actor which fires the due jobs.
+
+	// Open prepares a job queue before firing its due jobs.
+	// The active recurring jobs without pending occurrence are scheduled again.
+	Open(appid, topic string) (err error)
+
+	// Due returns the jobs whose due time is not after the specified time.
+	Due(appid, topic string, due int64) ([]JobItem, error)
+
+	// Take removes a due job from job queue before firing it, false if the job
+	// has been deleted or updated since Due.
+	Take(appid, topic string, item JobItem) (ok bool, err error)
+
+	// Putback reinjects a taken job that failed to fire.
+	Putback(appid, topic string, item JobItem) (err error)
+
+	// Archive keeps a fired job for inspection.
+	Archive(appid, topic string, item JobItem, etime int64, actorId string) (err error)
+
+	// CronSpecs returns the specs of the active recurring jobs among jobIds, so that actor
+	// knows which fired jobs to re-enqueue.
+	CronSpecs(appid, topic string, jobIds []int64) (specs map[int64]string, err error)
+
+	// Reenqueue schedules the next occurrence of a recurring job unless it is paused or deleted.
+	Reenqueue(appid, topic string, jobId int64, due int64) (err error)
+
+	// TakeBatch is the batch version of Take, returns the taken jobs in the order of items.
+	// The taken jobs are settled by SettleBatch after firing.
+	TakeBatch(appid, topic string, items []JobItem) (taken []JobItem, err error)
+
+	// SettleBatch archives the fired jobs, reschedules the fired recurring jobs to their
+	// next occurrence in place and puts back the jobs that failed to fire.
+	// A job settled before a failure is never fired again.
+	SettleBatch(appid, topic string, batch FiredBatch, etime int64, actorId string) (err error)
 }
 
 var Default JobStore
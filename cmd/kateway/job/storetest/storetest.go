@@ -0,0 +1,446 @@
+// Package storetest is the behavioural test suite every job.JobStore must pass.
+package storetest
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/job"
+)
+
+// Run verifies the store with a newly created job queue of the topic.
+func Run(t *testing.T, store job.JobStore, appid, topic string) {
+	assert.Equal(t, nil, store.CreateJobQueue(1, appid, topic))
+	assert.Equal(t, nil, store.Open(appid, topic))
+
+	testFire(t, store, appid, topic)
+	testPutback(t, store, appid, topic)
+	testReschedule(t, store, appid, topic)
+	testList(t, store, appid, topic)
+	testCron(t, store, appid, topic)
+	testKeyTag(t, store, appid, topic)
+	testBatch(t, store, appid, topic)
+}
+
+func dueJob(t *testing.T, store job.JobStore, appid, topic, jobId string, due int64) (item job.JobItem) {
+	items, err := store.Due(appid, topic, due)
+	assert.Equal(t, nil, err)
+	for _, item = range items {
+		if strconv.FormatInt(item.JobId, 10) == jobId {
+			return
+		}
+	}
+
+	t.Fatalf("job %s not due at %d", jobId, due)
+	return
+}
+
+func notDue(t *testing.T, store job.JobStore, appid, topic, jobId string, due int64) {
+	items, err := store.Due(appid, topic, due)
+	assert.Equal(t, nil, err)
+	for _, item := range items {
+		if strconv.FormatInt(item.JobId, 10) == jobId {
+			t.Fatalf("job %s due at %d", jobId, due)
+		}
+	}
+}
+
+func testFire(t *testing.T, store job.JobStore, appid, topic string) {
+	due := time.Now().Unix() + 100
+	jobId, err := store.Add(appid, topic, []byte("hello"), "", "", due)
+	assert.Equal(t, nil, err)
+
+	item, err := store.Get(appid, topic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, job.JobPending, item.State)
+	assert.Equal(t, "hello", string(item.Payload))
+	assert.Equal(t, due, item.DueTime)
+
+	notDue(t, store, appid, topic, jobId, due-1)
+	item = dueJob(t, store, appid, topic, jobId, due)
+	ok, err := store.Take(appid, topic, item)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	ok, err = store.Take(appid, topic, item)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, ok)
+	notDue(t, store, appid, topic, jobId, due)
+
+	assert.Equal(t, nil, store.Archive(appid, topic, item, due+1, "actor1"))
+	item, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, job.JobFired, item.State)
+	assert.Equal(t, due+1, item.Etime)
+	assert.Equal(t, "actor1", item.ActorId)
+	assert.Equal(t, "hello", string(item.Payload))
+
+	// fired job can not be deleted or updated
+	assert.Equal(t, job.ErrNothingDeleted, store.Delete(appid, topic, jobId))
+	assert.Equal(t, job.ErrNoSuchJob, store.Reschedule(appid, topic, jobId, due+100))
+	assert.Equal(t, job.ErrNoSuchJob, store.UpdatePayload(appid, topic, jobId, []byte("world")))
+}
+
+func testPutback(t *testing.T, store job.JobStore, appid, topic string) {
+	due := time.Now().Unix() + 100
+	jobId, err := store.Add(appid, topic, []byte("hello"), "", "", due)
+	assert.Equal(t, nil, err)
+
+	item := dueJob(t, store, appid, topic, jobId, due)
+	ok, err := store.Take(appid, topic, item)
+	assert.Equal(t, true, ok)
+	_, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, job.ErrNoSuchJob, err)
+
+	assert.Equal(t, nil, store.Putback(appid, topic, item))
+	item, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, job.JobPending, item.State)
+
+	assert.Equal(t, nil, store.Delete(appid, topic, jobId))
+	_, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, job.ErrNoSuchJob, err)
+	assert.Equal(t, job.ErrNothingDeleted, store.Delete(appid, topic, jobId))
+}
+
+func testReschedule(t *testing.T, store job.JobStore, appid, topic string) {
+	due := time.Now().Unix() + 100
+	jobId, err := store.Add(appid, topic, []byte("hello"), "", "", due)
+	assert.Equal(t, nil, err)
+
+	stale := dueJob(t, store, appid, topic, jobId, due)
+	assert.Equal(t, nil, store.Reschedule(appid, topic, jobId, due+100))
+	ok, err := store.Take(appid, topic, stale)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, ok)
+	notDue(t, store, appid, topic, jobId, due)
+
+	assert.Equal(t, nil, store.UpdatePayload(appid, topic, jobId, []byte("world")))
+	item, err := store.Get(appid, topic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, due+100, item.DueTime)
+	assert.Equal(t, "world", string(item.Payload))
+
+	// both at once
+	stale = dueJob(t, store, appid, topic, jobId, due+100)
+	assert.Equal(t, nil, store.Update(appid, topic, jobId, due+200, []byte("hi")))
+	ok, err = store.Take(appid, topic, stale)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, ok)
+	item, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, due+200, item.DueTime)
+	assert.Equal(t, "hi", string(item.Payload))
+
+	item = dueJob(t, store, appid, topic, jobId, due+200)
+	ok, err = store.Take(appid, topic, item)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "hi", string(item.Payload))
+
+	assert.Equal(t, job.ErrNoSuchJob, store.Reschedule(appid, topic, jobId, due))
+	assert.Equal(t, job.ErrNoSuchJob, store.Update(appid, topic, jobId, due, []byte("world")))
+}
+
+func testList(t *testing.T, store job.JobStore, appid, topic string) {
+	due := time.Now().Unix() + 1000
+	var jobIds []string
+	for i := 0; i < 5; i++ {
+		jobId, err := store.Add(appid, topic, []byte("hello"), "", "", due+int64(i))
+		assert.Equal(t, nil, err)
+		jobIds = append(jobIds, jobId)
+	}
+
+	var (
+		listed []string
+		opt    = job.ListOption{State: job.JobPending, DueFrom: due, DueTo: due + 5, Limit: 2}
+	)
+	for pages := 0; pages < 5; pages++ {
+		items, cursor, err := store.List(appid, topic, opt)
+		assert.Equal(t, nil, err)
+		for _, item := range items {
+			assert.Equal(t, job.JobPending, item.State)
+			listed = append(listed, strconv.FormatInt(item.JobId, 10))
+		}
+
+		if cursor == "" {
+			break
+		}
+		opt.Cursor = cursor
+	}
+	assert.Equal(t, jobIds, listed)
+
+	opt = job.ListOption{State: job.JobPending, DueFrom: due + 1, DueTo: due + 3}
+	items, cursor, err := store.List(appid, topic, opt)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "", cursor)
+	assert.Equal(t, 2, len(items))
+
+	_, _, err = store.List(appid, topic, job.ListOption{Cursor: "bad"})
+	assert.Equal(t, job.ErrInvalidCursor, err)
+
+	for _, jobId := range jobIds {
+		assert.Equal(t, nil, store.Delete(appid, topic, jobId))
+	}
+}
+
+func findCron(t *testing.T, store job.JobStore, appid, topic, jobId string) (c job.CronItem, found bool) {
+	crons, err := store.Crons(appid, topic)
+	assert.Equal(t, nil, err)
+	for _, c = range crons {
+		if strconv.FormatInt(c.JobId, 10) == jobId {
+			return c, true
+		}
+	}
+
+	return
+}
+
+func testCron(t *testing.T, store job.JobStore, appid, topic string) {
+	_, err := store.AddCron(appid, topic, []byte("hello"), "", "", "@every 1d")
+	assert.Equal(t, job.ErrInvalidSchedule, err)
+
+	now := time.Now().Unix()
+	jobId, err := store.AddCron(appid, topic, []byte("hello"), "", "", "@every 1h")
+	assert.Equal(t, nil, err)
+
+	c, found := findCron(t, store, appid, topic, jobId)
+	assert.Equal(t, true, found)
+	assert.Equal(t, "@every 1h", c.Spec)
+	assert.Equal(t, false, c.Paused)
+	assert.Equal(t, true, c.NextTime >= now+3600 && c.NextTime <= now+3601)
+
+	item, err := store.Get(appid, topic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, job.JobPending, item.State)
+	specs, err := store.CronSpecs(appid, topic, []int64{c.JobId, c.JobId + 1})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, map[int64]string{c.JobId: "@every 1h"}, specs)
+
+	// pause cancels the pending occurrence
+	assert.Equal(t, nil, store.PauseCron(appid, topic, jobId))
+	assert.Equal(t, nil, store.PauseCron(appid, topic, jobId))
+	specs, err = store.CronSpecs(appid, topic, []int64{c.JobId})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(specs))
+	c, _ = findCron(t, store, appid, topic, jobId)
+	assert.Equal(t, true, c.Paused)
+	assert.Equal(t, int64(0), c.NextTime)
+	_, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, job.ErrNoSuchJob, err)
+	assert.Equal(t, nil, store.Reenqueue(appid, topic, c.JobId, now+10))
+	_, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, job.ErrNoSuchJob, err)
+
+	assert.Equal(t, nil, store.ResumeCron(appid, topic, jobId))
+	assert.Equal(t, nil, store.ResumeCron(appid, topic, jobId))
+	item, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, nil, err)
+
+	// fire and re-enqueue the next occurrence with the same job id
+	item = dueJob(t, store, appid, topic, jobId, item.DueTime)
+	ok, err := store.Take(appid, topic, item)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, nil, store.Reenqueue(appid, topic, item.JobId, now+7200))
+	item, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, now+7200, item.DueTime)
+
+	// the occurrence lost between Take and Reenqueue is restored by Open
+	item = dueJob(t, store, appid, topic, jobId, item.DueTime)
+	ok, err = store.Take(appid, topic, item)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, nil, store.Open(appid, topic))
+	item, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, item.DueTime >= now+3600 && item.DueTime <= time.Now().Unix()+3601)
+
+	assert.Equal(t, nil, store.UpdatePayload(appid, topic, jobId, []byte("world")))
+	c, _ = findCron(t, store, appid, topic, jobId)
+	assert.Equal(t, "world", string(c.Payload))
+
+	assert.Equal(t, nil, store.Delete(appid, topic, jobId))
+	_, found = findCron(t, store, appid, topic, jobId)
+	assert.Equal(t, false, found)
+	assert.Equal(t, job.ErrNoSuchCron, store.PauseCron(appid, topic, jobId))
+	assert.Equal(t, job.ErrNothingDeleted, store.Delete(appid, topic, jobId))
+}
+
+func testKeyTag(t *testing.T, store job.JobStore, appid, topic string) {
+	due := time.Now().Unix() + 100
+	jobId, err := store.Add(appid, topic, []byte("hello"), "order1", "a=b", due)
+	assert.Equal(t, nil, err)
+
+	item := dueJob(t, store, appid, topic, jobId, due)
+	assert.Equal(t, "order1", item.Key)
+	assert.Equal(t, "a=b", item.Tag)
+	ok, err := store.Take(appid, topic, item)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, nil, store.Putback(appid, topic, item))
+	item, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "order1", item.Key)
+	assert.Equal(t, "a=b", item.Tag)
+
+	item = dueJob(t, store, appid, topic, jobId, due)
+	ok, err = store.Take(appid, topic, item)
+	assert.Equal(t, nil, store.Archive(appid, topic, item, due, "actor1"))
+	item, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, job.JobFired, item.State)
+	assert.Equal(t, "order1", item.Key)
+	assert.Equal(t, "a=b", item.Tag)
+
+	// the following occurrences of recurring job inherit key and tag
+	jobId, err = store.AddCron(appid, topic, []byte("hello"), "order2", "c=d", "@every 1h")
+	assert.Equal(t, nil, err)
+	c, _ := findCron(t, store, appid, topic, jobId)
+	assert.Equal(t, "order2", c.Key)
+	assert.Equal(t, "c=d", c.Tag)
+	item, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, nil, err)
+	item = dueJob(t, store, appid, topic, jobId, item.DueTime)
+	ok, err = store.Take(appid, topic, item)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, nil, store.Reenqueue(appid, topic, item.JobId, due))
+	item, err = store.Get(appid, topic, jobId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "order2", item.Key)
+	assert.Equal(t, "c=d", item.Tag)
+	assert.Equal(t, nil, store.Delete(appid, topic, jobId))
+}
+
+func testBatch(t *testing.T, store job.JobStore, appid, topic string) {
+	due := time.Now().Unix() + 100
+	var jobIds []string
+	for i := 0; i < 3; i++ {
+		jobId, err := store.Add(appid, topic, []byte("hello"), "", "", due)
+		assert.Equal(t, nil, err)
+		jobIds = append(jobIds, jobId)
+	}
+	cronId, err := store.AddCron(appid, topic, []byte("hello"), "", "", "@every 1h")
+	assert.Equal(t, nil, err)
+	cron, err := store.Get(appid, topic, cronId)
+	assert.Equal(t, nil, err)
+
+	var items []job.JobItem
+	for _, jobId := range jobIds {
+		items = append(items, dueJob(t, store, appid, topic, jobId, due))
+	}
+	stale := items[2]
+	assert.Equal(t, nil, store.Reschedule(appid, topic, jobIds[2], due+100))
+	items = append(items, items[0]) // fetched twice
+	items = append(items, dueJob(t, store, appid, topic, cronId, cron.DueTime))
+
+	taken, err := store.TakeBatch(appid, topic, items)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(taken))
+	assert.Equal(t, items[0].JobId, taken[0].JobId)
+	assert.Equal(t, items[1].JobId, taken[1].JobId)
+	assert.Equal(t, cron.JobId, taken[2].JobId)
+	ok, err := store.Take(appid, topic, stale)
+	assert.Equal(t, false, ok)
+
+	// taken jobs are being fired
+	notDue(t, store, appid, topic, jobIds[0], due)
+	assert.Equal(t, job.ErrNothingDeleted, store.Delete(appid, topic, jobIds[0]))
+	assert.Equal(t, job.ErrNoSuchJob, store.Reschedule(appid, topic, jobIds[1], due))
+	taken2, err := store.TakeBatch(appid, topic, items)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(taken2))
+
+	recurring := taken[2]
+	recurring.DueTime = due + 100
+	batch := job.FiredBatch{
+		Fired:     taken[0:1],
+		Failed:    taken[1:2],
+		Recurring: []job.JobItem{recurring},
+	}
+	assert.Equal(t, nil, store.SettleBatch(appid, topic, batch, due+1, "actor1"))
+
+	item, err := store.Get(appid, topic, jobIds[0])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, job.JobFired, item.State)
+	assert.Equal(t, due+1, item.Etime)
+	assert.Equal(t, "actor1", item.ActorId)
+
+	// failed job is due again
+	item = dueJob(t, store, appid, topic, jobIds[1], due)
+	ok, err = store.Take(appid, topic, item)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+
+	// recurring job is rescheduled to its next occurrence
+	item, err = store.Get(appid, topic, cronId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, job.JobPending, item.State)
+	assert.Equal(t, due+100, item.DueTime)
+	item = dueJob(t, store, appid, topic, cronId, due+100)
+
+	// recurring job paused while being fired is not rescheduled
+	taken, err = store.TakeBatch(appid, topic, []job.JobItem{item})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(taken))
+	assert.Equal(t, nil, store.PauseCron(appid, topic, cronId))
+	taken[0].DueTime = due + 200
+	assert.Equal(t, nil, store.SettleBatch(appid, topic, job.FiredBatch{Recurring: taken}, due+100, "actor1"))
+	_, err = store.Get(appid, topic, cronId)
+	assert.Equal(t, job.ErrNoSuchJob, err)
+
+	assert.Equal(t, nil, store.Delete(appid, topic, jobIds[2]))
+	assert.Equal(t, nil, store.Delete(appid, topic, cronId))
+}
+
+// Bench fires b.N due jobs in batches of batchSize with TakeBatch and SettleBatch,
+// 0 means one by one with Take and Archive.
+func Bench(b *testing.B, store job.JobStore, appid, topic string, batchSize int) {
+	if err := store.CreateJobQueue(1, appid, topic); err != nil {
+		b.Fatal(err)
+	}
+	if err := store.Open(appid, topic); err != nil {
+		b.Fatal(err)
+	}
+
+	due := time.Now().Unix()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.Add(appid, topic, []byte("hello world"), "", "", due); err != nil {
+			b.Fatal(err)
+		}
+	}
+	items, err := store.Due(appid, topic, due)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for len(items) > 0 {
+		n := batchSize
+		if n == 0 {
+			n = 1
+		}
+		if n > len(items) {
+			n = len(items)
+		}
+		batch := items[:n]
+		items = items[n:]
+
+		if batchSize == 0 {
+			if ok, err := store.Take(appid, topic, batch[0]); err != nil || !ok {
+				b.Fatalf("%v %v", ok, err)
+			}
+			if err := store.Archive(appid, topic, batch[0], due, "actor1"); err != nil {
+				b.Fatal(err)
+			}
+			continue
+		}
+
+		taken, err := store.TakeBatch(appid, topic, batch)
+		if err != nil || len(taken) != n {
+			b.Fatalf("%d/%d %v", len(taken), n, err)
+		}
+		if err = store.SettleBatch(appid, topic, job.FiredBatch{Fired: taken}, due, "actor1"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
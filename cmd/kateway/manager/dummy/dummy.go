@@ -47,6 +47,14 @@ func (this *dummyStore) Signature(appid string) string {
 	return ""
 }
 
+func (this *dummyStore) Sign(appid string, data []byte) string {
+	return ""
+}
+
+func (this *dummyStore) Quota(appid, topic string) (manager.Quota, bool) {
+	return manager.Quota{}, false
+}
+
 func (this *dummyStore) TopicSchema(appid, topic, ver string) (string, error) {
 	return `
 {
@@ -98,7 +106,7 @@ func (this *dummyStore) ValidateGroupName(header http.Header, group string) bool
 	return true
 }
 
-func (this *dummyStore) OwnTopic(appid, pubkey, topic string) error {
+func (this *dummyStore) OwnTopic(appid, topic string) error {
 	if topic == "invalid" {
 		return manager.ErrAuthorizationFail
 	}
@@ -110,7 +118,7 @@ func (*dummyStore) AllowSubWithUnregisteredGroup(yes bool) {
 
 }
 
-func (this *dummyStore) AuthSub(appid, subkey, hisAppid, hisTopic, group string) error {
+func (this *dummyStore) AuthSub(appid, hisAppid, hisTopic, group string) error {
 	if group == "invalid" {
 		return manager.ErrInvalidGroup
 	}
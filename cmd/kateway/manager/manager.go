@@ -15,12 +15,16 @@ type Manager interface {
 	// AuthAdmin check if an app with the key has admin rights.
 	AuthAdmin(appid, pubkey string) (ok bool)
 
-	// OwnTopic checks if an appid owns a topic.
-	OwnTopic(appid, pubkey, topic string) error
+	// OwnTopic checks if an authenticated appid owns a topic.
+	OwnTopic(appid, topic string) error
 
 	// Signature returns the hashed appid:secret of an app for identification.
 	Signature(appid string) string
 
+	// Sign returns the hex encoded HMAC-SHA256 of data keyed by the secret of an app, empty if app not found.
+	Sign(appid string, data []byte) string
+
+	// Auth authenticates an app by its secret key.
 	Auth(appid, secret string) error
 
 	AllowSubWithUnregisteredGroup(bool)
@@ -31,14 +35,17 @@ type Manager interface {
 	// TopicAppid extracts appid info from kafka raw topic.
 	TopicAppid(kafkaTopic string) string
 
+	// Quota returns the rate quota of an app on a topic, falling back to the app level quota.
+	Quota(appid, topic string) (q Quota, found bool)
+
 	// TopicSchema returns the avro schema definition json string.
 	TopicSchema(appid, topic, ver string) (string, error)
 
 	// ShadowTopic returns raw kafka topic name of a shadowed topic.
 	ShadowTopic(shadow, myAppid, hisAppid, topic, ver, group string) string
 
-	// AuthSub checks if an appid is able to consume message from hisAppid.hisTopic.
-	AuthSub(appid, subkey, hisAppid, hisTopic, group string) error
+	// AuthSub checks if an authenticated appid is able to consume message from hisAppid.hisTopic.
+	AuthSub(appid, hisAppid, hisTopic, group string) error
 
 	// LookupCluster locate the cluster name of an appid.
 	LookupCluster(appid string) (cluster string, found bool)
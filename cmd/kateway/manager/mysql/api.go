@@ -61,6 +61,20 @@ func (this *mysqlStore) Signature(appid string) string {
 	}
 }
 
+func (this *mysqlStore) Sign(appid string, data []byte) string {
+	return manager.Sign(this.appSecretMap, appid, data)
+}
+
+func (this *mysqlStore) Quota(appid, topic string) (manager.Quota, bool) {
+	quotas := this.appQuotaMap[appid]
+	if q, present := quotas[topic]; present {
+		return q, true
+	}
+
+	q, present := quotas[""]
+	return q, present
+}
+
 func (this *mysqlStore) TopicSchema(appid, topic, ver string) (string, error) {
 	if schema, present := this.topicSchemaMap[appid][topic][ver]; present {
 		return schema, nil
@@ -81,6 +95,7 @@ func (this *mysqlStore) Dump() map[string]interface{} {
 	r["app_topic"] = this.appTopicsMap
 	r["groups"] = this.appConsumerGroupMap
 	r["shadows"] = this.shadowQueueMap
+	r["quotas"] = this.appQuotaMap
 	return r
 }
 
@@ -126,15 +141,11 @@ func (this *mysqlStore) AuthAdmin(appid, pubkey string) bool {
 	return false
 }
 
-func (this *mysqlStore) OwnTopic(appid, pubkey, topic string) error {
-	if topic == "" {
+func (this *mysqlStore) OwnTopic(appid, topic string) error {
+	if appid == "" || topic == "" {
 		return manager.ErrEmptyIdentity
 	}
 
-	if err := this.Auth(appid, pubkey); err != nil {
-		return err
-	}
-
 	// authorization
 	if topics, present := this.appTopicsMap[appid]; present {
 		if enabled, present := topics[topic]; present {
@@ -162,15 +173,11 @@ func (this *mysqlStore) Auth(appid, secret string) error {
 	return nil
 }
 
-func (this *mysqlStore) AuthSub(appid, subkey, hisAppid, hisTopic, group string) error {
-	if hisTopic == "" {
+func (this *mysqlStore) AuthSub(appid, hisAppid, hisTopic, group string) error {
+	if appid == "" || hisTopic == "" {
 		return manager.ErrEmptyIdentity
 	}
 
-	if err := this.Auth(appid, subkey); err != nil {
-		return err
-	}
-
 	// group verification
 	if !this.allowUnregisteredGroup {
 		if group == "" {
@@ -21,6 +21,16 @@ func TestAppSignature(t *testing.T) {
 	assert.Equal(t, "t9maByh7MhdSoPoJlgJ5XerJjvSju99Yb3EQxyHy0CE=", m.Signature("app1"))
 }
 
+func TestAppSign(t *testing.T) {
+	m := &mysqlStore{}
+	m.appSecretMap = map[string]string{
+		"app1": "31f0250df55743ee31efcf75db3d08a1",
+	}
+	assert.Equal(t, "", m.Sign("app2", []byte("hello")))
+	assert.Equal(t, 64, len(m.Sign("app1", []byte("hello"))))
+	assert.NotEqual(t, m.Sign("app1", []byte("hello")), m.Sign("app1", []byte("hello!")))
+}
+
 func TestKafkaTopic(t *testing.T) {
 	m := &mysqlStore{}
 
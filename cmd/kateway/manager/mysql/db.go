@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/gafka/ctx"
 	"github.com/funkygao/gafka/mpool"
 	"github.com/funkygao/gafka/zk"
@@ -33,6 +34,7 @@ type mysqlStore struct {
 	shadowQueueMap      map[string]string                       // hisappid.topic.ver.myappid:group
 	deadPartitionMap    map[string]map[int32]struct{}           // topic:partitionId
 	topicSchemaMap      map[string]map[string]map[string]string // appid:topic:ver:schema
+	appQuotaMap         map[string]map[string]manager.Quota     // appid:topic:quota, empty topic for the app
 
 	topicNames *mpool.Intern
 }
@@ -140,6 +142,11 @@ func (this *mysqlStore) refreshFromMysql() error {
 		return err
 	}
 
+	if err = this.fetchQuotas(db); err != nil {
+		// quota is optional, keep the old ones
+		log.Error("mysql manager store quota: %v", err)
+	}
+
 	if false {
 		if err = this.fetchSchemas(db); err != nil {
 			return err
@@ -188,6 +195,37 @@ func (this *mysqlStore) fetchSchemas(db *sql.DB) error {
 	return nil
 }
 
+func (this *mysqlStore) fetchQuotas(db *sql.DB) error {
+	rows, err := db.Query("SELECT AppId,TopicName,PubMsgs,PubBytes,SubBytes FROM app_quota")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	appQuotaMap := make(map[string]map[string]manager.Quota)
+	var q appQuotaRecord
+	for rows.Next() {
+		err = rows.Scan(&q.AppId, &q.TopicName, &q.PubMsgs, &q.PubBytes, &q.SubBytes)
+		if err != nil {
+			log.Error("mysql manager store: %v", err)
+			continue
+		}
+
+		if _, present := appQuotaMap[q.AppId]; !present {
+			appQuotaMap[q.AppId] = make(map[string]manager.Quota)
+		}
+		appQuotaMap[q.AppId][q.TopicName] = manager.Quota{
+			Topic:    q.TopicName,
+			PubMsgs:  q.PubMsgs,
+			PubBytes: q.PubBytes,
+			SubBytes: q.SubBytes,
+		}
+	}
+
+	this.appQuotaMap = appQuotaMap
+	return nil
+}
+
 func (this *mysqlStore) fetchDeadPartitions(db *sql.DB) error {
 	rows, err := db.Query("SELECT KafkaTopic,PartitionId FROM dead_partition")
 	if err != nil {
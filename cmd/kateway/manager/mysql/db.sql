@@ -141,3 +141,14 @@ CREATE TABLE `topic_schema` (
   `Status` tinyint(2) NOT NULL COMMENT '状态：1正常|-2废弃',
   PRIMARY KEY (`AppId`, `TopicName`, `Ver`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+DROP TABLE IF EXISTS `app_quota`;
+CREATE TABLE `app_quota` (
+  `AppId` bigint(18) NOT NULL,
+  `TopicName` varchar(64) NOT NULL DEFAULT '' COMMENT '主题名称，空为应用级配额',
+  `PubMsgs` bigint(20) NOT NULL DEFAULT '0' COMMENT '每秒发布消息数，0不限',
+  `PubBytes` bigint(20) NOT NULL DEFAULT '0' COMMENT '每秒发布字节数，0不限',
+  `SubBytes` bigint(20) NOT NULL DEFAULT '0' COMMENT '每秒订阅字节数，0不限',
+  `CreateTime` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`AppId`, `TopicName`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -63,6 +63,20 @@ func (this *mysqlStore) Signature(appid string) string {
 	}
 }
 
+func (this *mysqlStore) Sign(appid string, data []byte) string {
+	return manager.Sign(this.appSecretMap, appid, data)
+}
+
+func (this *mysqlStore) Quota(appid, topic string) (manager.Quota, bool) {
+	quotas := this.appQuotaMap[appid]
+	if q, present := quotas[topic]; present {
+		return q, true
+	}
+
+	q, present := quotas[""]
+	return q, present
+}
+
 func (this *mysqlStore) TopicSchema(appid, topic, ver string) (string, error) {
 	if schema, present := this.topicSchemaMap[appid][topic][ver]; present {
 		return schema, nil
@@ -83,6 +97,7 @@ func (this *mysqlStore) Dump() map[string]interface{} {
 	r["app_topic"] = this.appTopicsMap
 	r["groups"] = this.appConsumerGroupMap
 	r["shadows"] = this.shadowQueueMap
+	r["quotas"] = this.appQuotaMap
 	return r
 }
 
@@ -129,6 +144,11 @@ func (this *mysqlStore) Auth(appid, secret string) error {
 		return manager.ErrEmptyIdentity
 	}
 
+	if this.dev2app(appid) != "" {
+		// devid has no secret to verify yet, never let it in with any key
+		return manager.ErrAuthenticationFail
+	}
+
 	// authentication
 	if s, present := this.appSecretMap[appid]; !present || s != secret {
 		return manager.ErrAuthenticationFail
@@ -137,15 +157,13 @@ func (this *mysqlStore) Auth(appid, secret string) error {
 	return nil
 }
 
-func (this *mysqlStore) OwnTopic(appid, pubkey, topic string) error {
+func (this *mysqlStore) OwnTopic(appid, topic string) error {
 	appid = this.dev2app(appid)
 
-	if appid == "" || topic == "" || pubkey == "" {
+	if appid == "" || topic == "" {
 		return manager.ErrEmptyIdentity
 	}
 
-	// authentication TODO
-
 	// authorization
 	if topics, present := this.appTopicsMap[appid]; present {
 		if enabled, present := topics[topic]; present {
@@ -164,15 +182,13 @@ func (this *mysqlStore) AllowSubWithUnregisteredGroup(yesOrNo bool) {
 	this.allowUnregisteredGroup = yesOrNo
 }
 
-func (this *mysqlStore) AuthSub(appid, subkey, hisAppid, hisTopic, group string) error {
+func (this *mysqlStore) AuthSub(appid, hisAppid, hisTopic, group string) error {
 	appid = this.dev2app(appid)
 
 	if appid == "" || hisTopic == "" {
 		return manager.ErrEmptyIdentity
 	}
 
-	// authentication TODO
-
 	// group verification
 	if !this.allowUnregisteredGroup {
 		if group == "" {
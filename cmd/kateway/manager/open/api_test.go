@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/manager"
 	"github.com/funkygao/gafka/ctx"
 )
 
@@ -21,6 +22,20 @@ func TestAppSignature(t *testing.T) {
 	assert.Equal(t, "t9maByh7MhdSoPoJlgJ5XerJjvSju99Yb3EQxyHy0CE=", m.Signature("app1"))
 }
 
+func TestAuth(t *testing.T) {
+	m := &mysqlStore{}
+	m.appSecretMap = map[string]string{
+		"app1": "31f0250df55743ee31efcf75db3d08a1",
+	}
+	m.dev2appMap = map[string]string{
+		"dev1": "app1",
+	}
+	assert.Equal(t, nil, m.Auth("app1", "31f0250df55743ee31efcf75db3d08a1"))
+	assert.Equal(t, manager.ErrAuthenticationFail, m.Auth("app1", "bad"))
+	assert.Equal(t, manager.ErrEmptyIdentity, m.Auth("app1", ""))
+	assert.Equal(t, manager.ErrAuthenticationFail, m.Auth("dev1", "any"))
+}
+
 func TestKafkaTopic(t *testing.T) {
 	m := &mysqlStore{}
 
@@ -30,3 +30,8 @@ type topicSchemaRecord struct {
 	AppId, TopicName, Ver string
 	Schema                string
 }
+
+type appQuotaRecord struct {
+	AppId, TopicName            string
+	PubMsgs, PubBytes, SubBytes int64
+}
@@ -0,0 +1,10 @@
+package manager
+
+// Quota is the per second rate limits of an app, or of one of its topics if Topic is not empty.
+// Zero means unlimited.
+type Quota struct {
+	Topic    string `json:"topic,omitempty"`
+	PubMsgs  int64  `json:"pub_msgs"`  // pub messages
+	PubBytes int64  `json:"pub_bytes"` // pub payload bytes
+	SubBytes int64  `json:"sub_bytes"` // sub payload bytes of the subscriber app
+}
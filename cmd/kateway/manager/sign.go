@@ -0,0 +1,20 @@
+package manager
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Sign returns the hex encoded HMAC-SHA256 of data keyed by an app secret, empty if secret not found.
+// It is shared by the Manager implementations that keep the app secrets in an appid->secret map.
+func Sign(secrets map[string]string, appid string, data []byte) string {
+	secret, present := secrets[appid]
+	if !present {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,50 @@
+package quota
+
+import (
+	"time"
+)
+
+// Bucket is a token bucket holding at most 1s of its rate.
+// It is not goroutine safe.
+type Bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket creates a full bucket.
+func NewBucket(rate int64, now time.Time) *Bucket {
+	return &Bucket{tokens: float64(rate), last: now}
+}
+
+// Take consumes n tokens if there is at least 1 token left, which might put the bucket into debt.
+// Otherwise it returns how long it takes to refill 1 token.
+func (this *Bucket) Take(rate, n int64, now time.Time) (ok bool, retryAfter time.Duration) {
+	this.refill(rate, now)
+	if this.tokens < 1 {
+		return false, time.Duration((1 - this.tokens) / float64(rate) * float64(time.Second))
+	}
+
+	this.tokens -= float64(n)
+	return true, 0
+}
+
+// Used returns how many tokens are consumed out of the full bucket, more than rate if in debt.
+func (this *Bucket) Used(rate int64, now time.Time) int64 {
+	this.refill(rate, now)
+	return rate - int64(this.tokens)
+}
+
+// Idle returns how long the bucket has not been used.
+func (this *Bucket) Idle(now time.Time) time.Duration {
+	return now.Sub(this.last)
+}
+
+func (this *Bucket) refill(rate int64, now time.Time) {
+	if elapsed := now.Sub(this.last); elapsed > 0 {
+		this.tokens += elapsed.Seconds() * float64(rate)
+		if this.tokens > float64(rate) {
+			this.tokens = float64(rate)
+		}
+		this.last = now
+	}
+}
@@ -0,0 +1,50 @@
+package quota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func TestBucketTake(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(10, now)
+	for i := 0; i < 10; i++ {
+		ok, _ := b.Take(10, 1, now)
+		assert.Equal(t, true, ok)
+	}
+
+	ok, retryAfter := b.Take(10, 1, now)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, 100*time.Millisecond, retryAfter)
+
+	// refilled 1 token
+	now = now.Add(100 * time.Millisecond)
+	ok, _ = b.Take(10, 1, now)
+	assert.Equal(t, true, ok)
+
+	// never more than 1s of budget
+	now = now.Add(time.Hour)
+	assert.Equal(t, int64(0), b.Used(10, now))
+}
+
+func TestBucketDebt(t *testing.T) {
+	now := time.Now()
+	b := NewBucket(100, now)
+
+	// a batch bigger than the bucket passes and goes into debt
+	ok, _ := b.Take(100, 250, now)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(250), b.Used(100, now))
+
+	ok, retryAfter := b.Take(100, 1, now)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, 1510*time.Millisecond, retryAfter)
+
+	// a rejected take consumes nothing
+	now = now.Add(1510 * time.Millisecond)
+	ok, _ = b.Take(100, 0, now)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, time.Duration(0), b.Idle(now))
+}
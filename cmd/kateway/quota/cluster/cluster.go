@@ -0,0 +1,359 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+	"github.com/funkygao/gafka/zk"
+	log "github.com/funkygao/log4go"
+)
+
+const (
+	// evictIdle is how long an unused bucket is kept, after which the app gets a full bucket.
+	evictIdle = time.Minute * 5
+
+	// the peers are discovered every peerRefreshTicks gossip intervals.
+	peerRefreshTicks = 10
+
+	// a peer report older than staleTicks gossip intervals is ignored.
+	staleTicks = 3
+
+	gossipUri = "v1/quota/gossip"
+)
+
+type bucket struct {
+	sync.Mutex
+	*quota.Bucket
+
+	used int64 // taken since last gossip
+}
+
+type peer struct {
+	id   string
+	addr string
+	ips  []net.IP // the registered Ip and the host of ManAddr
+
+	usage map[string]float64
+	at    time.Time // when the latest report arrived
+}
+
+// clusterLimiter enforces the quotas across all the kateways of the zone.
+//
+// Every gossip interval each kateway reports its usage per second of each bucket to the
+// peers registered in zookeeper. A bucket is then refilled locally at what the peers
+// leave of the quota, but never less than a fair share so that no kateway starves.
+type clusterLimiter struct {
+	id       string
+	interval time.Duration
+	discover func() ([]*zk.KatewayMeta, error)
+	client   *http.Client
+
+	mu      sync.RWMutex
+	buckets map[string]*bucket
+
+	peersMu sync.RWMutex
+	peers   map[string]*peer // key is kateway id, myself excluded
+
+	lastReported bool
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// New creates a cluster limiter for the kateway id gossiping with its peers every interval.
+func New(id string, zkzone *zk.ZkZone, interval time.Duration) quota.Limiter {
+	return &clusterLimiter{
+		id:       id,
+		interval: interval,
+		discover: zkzone.KatewayInfos,
+		client: &http.Client{
+			Timeout: interval,
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: 1,
+				Proxy:               nil,
+				Dial: (&net.Dialer{
+					Timeout: interval,
+				}).Dial,
+				ResponseHeaderTimeout: interval,
+			},
+		},
+		buckets: make(map[string]*bucket),
+		peers:   make(map[string]*peer),
+		quit:    make(chan struct{}),
+	}
+}
+
+func (this *clusterLimiter) Name() string {
+	return "cluster"
+}
+
+func (this *clusterLimiter) Start() error {
+	if err := this.refreshPeers(); err != nil {
+		return err
+	}
+
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+
+		ticker := time.NewTicker(this.interval)
+		defer ticker.Stop()
+
+		var ticks int
+		lastEvict := time.Now()
+		for {
+			select {
+			case now := <-ticker.C:
+				ticks++
+				if ticks%peerRefreshTicks == 0 {
+					if err := this.refreshPeers(); err != nil {
+						// keep gossiping with the peers we know
+						log.Error("quota peers: %v", err)
+					}
+				}
+
+				this.gossip(this.report())
+
+				if now.Sub(lastEvict) >= evictIdle {
+					if n := this.evict(now); n > 0 {
+						log.Debug("quota evicted %d idle buckets", n)
+					}
+					lastEvict = now
+				}
+
+			case <-this.quit:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (this *clusterLimiter) Stop() {
+	close(this.quit)
+	this.wg.Wait()
+}
+
+func (this *clusterLimiter) Take(key string, rate, n int64) (bool, time.Duration) {
+	now := time.Now()
+
+	this.mu.RLock()
+	b, present := this.buckets[key]
+	this.mu.RUnlock()
+
+	if !present {
+		this.mu.Lock()
+		if b, present = this.buckets[key]; !present {
+			b = &bucket{Bucket: quota.NewBucket(this.share(key, rate, now), now)}
+			this.buckets[key] = b
+		}
+		this.mu.Unlock()
+	}
+
+	share := this.share(key, rate, now)
+	b.Lock()
+	ok, retryAfter := b.Take(share, n, now)
+	if ok {
+		b.used += n
+	}
+	b.Unlock()
+	return ok, retryAfter
+}
+
+func (this *clusterLimiter) Gossip(ip string, r quota.Report) error {
+	this.peersMu.Lock()
+	defer this.peersMu.Unlock()
+
+	p, present := this.peers[r.Id]
+	if !present {
+		return quota.ErrUnknownPeer
+	}
+	if !p.from(ip) {
+		return quota.ErrPeerSpoofed
+	}
+
+	p.usage = r.Usage
+	p.at = time.Now()
+	return nil
+}
+
+// share returns the rate of this kateway out of the cluster wide rate of a bucket.
+func (this *clusterLimiter) share(key string, rate int64, now time.Time) int64 {
+	var remote float64
+	this.peersMu.RLock()
+	n := int64(len(this.peers)) + 1
+	for _, p := range this.peers {
+		if now.Sub(p.at) <= this.interval*staleTicks {
+			remote += p.usage[key]
+		}
+	}
+	this.peersMu.RUnlock()
+
+	share := rate - int64(remote)
+	if fair := rate / n; share < fair {
+		share = fair
+	}
+	if share < 1 {
+		share = 1
+	}
+	return share
+}
+
+// report collects the usage per second of each bucket since last report.
+func (this *clusterLimiter) report() quota.Report {
+	r := quota.Report{Id: this.id, Usage: make(map[string]float64)}
+
+	this.mu.RLock()
+	for key, b := range this.buckets {
+		b.Lock()
+		if b.used > 0 {
+			r.Usage[key] = float64(b.used) / this.interval.Seconds()
+			b.used = 0
+		}
+		b.Unlock()
+	}
+	this.mu.RUnlock()
+
+	return r
+}
+
+// gossip sends the report to all the peers.
+// An empty report is sent only once to withdraw the previous one.
+func (this *clusterLimiter) gossip(r quota.Report) {
+	if len(r.Usage) == 0 && !this.lastReported {
+		return
+	}
+	this.lastReported = len(r.Usage) > 0
+
+	body, _ := json.Marshal(r)
+
+	this.peersMu.RLock()
+	addrs := make(map[string]string, len(this.peers))
+	for id, p := range this.peers {
+		addrs[id] = p.addr
+	}
+	this.peersMu.RUnlock()
+
+	var wg sync.WaitGroup
+	for id, addr := range addrs {
+		wg.Add(1)
+		go func(id, addr string) {
+			defer wg.Done()
+
+			if err := this.post(addr, body); err != nil {
+				// the peer will ignore our stale report soon
+				log.Debug("quota gossip to %s: %v", id, err)
+			}
+		}(id, addr)
+	}
+	wg.Wait()
+}
+
+func (this *clusterLimiter) post(addr string, body []byte) error {
+	url := fmt.Sprintf("http://%s/%s", addr, gossipUri)
+	response, err := this.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST[%s] -> %d", url, response.StatusCode)
+	}
+
+	return nil
+}
+
+// from checks if the peer is registered at the ip.
+func (this *peer) from(ip string) bool {
+	remote := net.ParseIP(ip)
+	if remote == nil {
+		return false
+	}
+
+	for _, p := range this.ips {
+		if p.Equal(remote) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIps returns the addresses a peer registered with ip and man server addr may gossip from.
+func peerIps(ip, addr string) (ips []net.IP) {
+	if p := net.ParseIP(ip); p != nil {
+		ips = append(ips, p)
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if p := net.ParseIP(host); p != nil {
+			ips = append(ips, p)
+		}
+	}
+	return
+}
+
+// refreshPeers syncs the peers with the kateways registered in zookeeper.
+func (this *clusterLimiter) refreshPeers() error {
+	kateways, err := this.discover()
+	if err != nil {
+		return err
+	}
+
+	this.peersMu.Lock()
+	defer this.peersMu.Unlock()
+
+	alive := make(map[string]struct{}, len(kateways))
+	for _, kw := range kateways {
+		if kw.Id == this.id || kw.ManAddr == "" {
+			continue
+		}
+
+		addr := kw.ManAddr
+		if strings.HasPrefix(addr, ":") {
+			addr = kw.Ip + addr
+		}
+
+		alive[kw.Id] = struct{}{}
+		ips := peerIps(kw.Ip, addr)
+		if p, present := this.peers[kw.Id]; present {
+			p.addr, p.ips = addr, ips
+		} else {
+			log.Trace("quota peer[%s] %s joined", kw.Id, addr)
+			this.peers[kw.Id] = &peer{id: kw.Id, addr: addr, ips: ips}
+		}
+	}
+
+	for id := range this.peers {
+		if _, present := alive[id]; !present {
+			log.Trace("quota peer[%s] left", id)
+			delete(this.peers, id)
+		}
+	}
+
+	return nil
+}
+
+func (this *clusterLimiter) evict(now time.Time) (n int) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for key, b := range this.buckets {
+		b.Lock()
+		idle := b.Idle(now)
+		b.Unlock()
+
+		if idle > evictIdle {
+			delete(this.buckets, key)
+			n++
+		}
+	}
+	return
+}
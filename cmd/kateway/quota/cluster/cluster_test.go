@@ -0,0 +1,109 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+	"github.com/funkygao/gafka/zk"
+)
+
+func newTestLimiter(id string, kateways ...*zk.KatewayMeta) *clusterLimiter {
+	l := New(id, nil, time.Second).(*clusterLimiter)
+	l.discover = func() ([]*zk.KatewayMeta, error) {
+		return kateways, nil
+	}
+	return l
+}
+
+func TestRefreshPeers(t *testing.T) {
+	l := newTestLimiter("1",
+		&zk.KatewayMeta{Id: "1", Ip: "10.0.0.1", ManAddr: ":9193"},
+		&zk.KatewayMeta{Id: "2", Ip: "10.0.0.2", ManAddr: ":9193"},
+		&zk.KatewayMeta{Id: "3", Ip: "10.0.0.3", ManAddr: "10.0.0.3:9193"},
+		&zk.KatewayMeta{Id: "4", Ip: "10.0.0.4"})
+	assert.Equal(t, nil, l.refreshPeers())
+	assert.Equal(t, 2, len(l.peers))
+	assert.Equal(t, "10.0.0.2:9193", l.peers["2"].addr)
+	assert.Equal(t, "10.0.0.3:9193", l.peers["3"].addr)
+
+	l.discover = func() ([]*zk.KatewayMeta, error) {
+		return []*zk.KatewayMeta{{Id: "3", ManAddr: "10.0.0.3:9193"}}, nil
+	}
+	assert.Equal(t, nil, l.refreshPeers())
+	assert.Equal(t, 1, len(l.peers))
+}
+
+func TestShare(t *testing.T) {
+	l := newTestLimiter("1",
+		&zk.KatewayMeta{Id: "2", ManAddr: "10.0.0.2:9193"},
+		&zk.KatewayMeta{Id: "3", ManAddr: "10.0.0.3:9193"})
+	assert.Equal(t, nil, l.refreshPeers())
+
+	now := time.Now()
+	// idle peers leave the whole rate to me
+	assert.Equal(t, int64(90), l.share("k", 90, now))
+
+	assert.Equal(t, quota.ErrUnknownPeer, l.Gossip("10.0.0.5", quota.Report{Id: "5"}))
+	assert.Equal(t, quota.ErrPeerSpoofed, l.Gossip("10.0.0.5", quota.Report{Id: "2"}))
+	assert.Equal(t, quota.ErrPeerSpoofed, l.Gossip("bad", quota.Report{Id: "2"}))
+	assert.Equal(t, nil, l.Gossip("10.0.0.2", quota.Report{Id: "2", Usage: map[string]float64{"k": 40}}))
+	assert.Equal(t, nil, l.Gossip("10.0.0.3", quota.Report{Id: "3", Usage: map[string]float64{"k": 20}}))
+	assert.Equal(t, int64(30), l.share("k", 90, now))
+	assert.Equal(t, int64(90), l.share("other", 90, now))
+
+	// never less than the fair share
+	assert.Equal(t, nil, l.Gossip("10.0.0.3", quota.Report{Id: "3", Usage: map[string]float64{"k": 80}}))
+	assert.Equal(t, int64(30), l.share("k", 90, now))
+	assert.Equal(t, int64(1), l.share("k", 2, now))
+
+	// stale reports are ignored
+	assert.Equal(t, int64(90), l.share("k", 90, now.Add(l.interval*(staleTicks+1))))
+}
+
+func TestReport(t *testing.T) {
+	l := newTestLimiter("1")
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Take("k", 10, 2)
+		assert.Equal(t, true, ok)
+	}
+
+	r := l.report()
+	assert.Equal(t, "1", r.Id)
+	assert.Equal(t, float64(6), r.Usage["k"])
+
+	// usage is reset after each report
+	assert.Equal(t, 0, len(l.report().Usage))
+}
+
+func TestGossip(t *testing.T) {
+	var received quota.Report
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/"+gossipUri, r.URL.Path)
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	l := newTestLimiter("1", &zk.KatewayMeta{Id: "2", ManAddr: strings.TrimPrefix(server.URL, "http://")})
+	assert.Equal(t, nil, l.refreshPeers())
+
+	l.Take("k", 10, 5)
+	l.gossip(l.report())
+	assert.Equal(t, "1", received.Id)
+	assert.Equal(t, float64(5), received.Usage["k"])
+
+	// withdraw the previous report once
+	received = quota.Report{}
+	l.gossip(l.report())
+	assert.Equal(t, "1", received.Id)
+	assert.Equal(t, 0, len(received.Usage))
+
+	received = quota.Report{}
+	l.gossip(l.report())
+	assert.Equal(t, "", received.Id)
+}
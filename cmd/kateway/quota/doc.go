@@ -0,0 +1,11 @@
+// Package quota enforces the per second rate quotas of apps configured in the manager.
+//
+// Each quota is a token bucket holding at most 1s of budget. A request passes as long as
+// the bucket is not exhausted and then takes what it actually used, which might put the
+// bucket into debt: a big batch or a sub response whose size is known only after
+// delivery is accounted in full and the app waits until the debt is paid back.
+//
+// The local limiter enforces the quotas within each kateway, so the effective limit grows
+// with the number of kateways. The cluster limiter makes the peers gossip their usage so
+// that the limit holds cluster wide.
+package quota
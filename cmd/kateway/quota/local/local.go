@@ -0,0 +1,105 @@
+package local
+
+import (
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/quota"
+	log "github.com/funkygao/log4go"
+)
+
+// evictIdle is how long an unused bucket is kept, after which the app gets a full bucket.
+const evictIdle = time.Minute * 5
+
+type bucket struct {
+	sync.Mutex
+	*quota.Bucket
+}
+
+// localLimiter enforces the quotas within this kateway only.
+type localLimiter struct {
+	mu      sync.RWMutex
+	buckets map[string]*bucket
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+func New() quota.Limiter {
+	return &localLimiter{
+		buckets: make(map[string]*bucket),
+		quit:    make(chan struct{}),
+	}
+}
+
+func (this *localLimiter) Name() string {
+	return "local"
+}
+
+func (this *localLimiter) Start() error {
+	this.wg.Add(1)
+	go func() {
+		defer this.wg.Done()
+
+		ticker := time.NewTicker(evictIdle)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				if n := this.evict(now); n > 0 {
+					log.Debug("quota evicted %d idle buckets", n)
+				}
+
+			case <-this.quit:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (this *localLimiter) Stop() {
+	close(this.quit)
+	this.wg.Wait()
+}
+
+func (this *localLimiter) Take(key string, rate, n int64) (bool, time.Duration) {
+	now := time.Now()
+
+	this.mu.RLock()
+	b, present := this.buckets[key]
+	this.mu.RUnlock()
+
+	if !present {
+		this.mu.Lock()
+		if b, present = this.buckets[key]; !present {
+			b = &bucket{Bucket: quota.NewBucket(rate, now)}
+			this.buckets[key] = b
+		}
+		this.mu.Unlock()
+	}
+
+	b.Lock()
+	ok, retryAfter := b.Take(rate, n, now)
+	b.Unlock()
+	return ok, retryAfter
+}
+
+func (this *localLimiter) evict(now time.Time) (n int) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for key, b := range this.buckets {
+		b.Lock()
+		idle := b.Idle(now)
+		b.Unlock()
+
+		if idle > evictIdle {
+			delete(this.buckets, key)
+			n++
+		}
+	}
+	return
+}
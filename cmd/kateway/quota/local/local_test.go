@@ -0,0 +1,27 @@
+package local
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funkygao/assert"
+)
+
+func TestLocalLimiter(t *testing.T) {
+	l := New().(*localLimiter)
+	for i := 0; i < 5; i++ {
+		ok, _ := l.Take("app1//pubmsgs", 5, 1)
+		assert.Equal(t, true, ok)
+	}
+	ok, retryAfter := l.Take("app1//pubmsgs", 5, 1)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, true, retryAfter > 0 && retryAfter <= 200*time.Millisecond)
+
+	// buckets are independent
+	ok, _ = l.Take("app2//pubmsgs", 5, 1)
+	assert.Equal(t, true, ok)
+
+	assert.Equal(t, 0, l.evict(time.Now()))
+	assert.Equal(t, 2, l.evict(time.Now().Add(evictIdle+time.Second)))
+	assert.Equal(t, 0, len(l.buckets))
+}
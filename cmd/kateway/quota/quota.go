@@ -0,0 +1,45 @@
+package quota
+
+import (
+	"errors"
+	"time"
+)
+
+// The rate limited resources of an app.
+const (
+	PubMsgs  = "pubmsgs"
+	PubBytes = "pubbytes"
+	SubBytes = "subbytes"
+)
+
+// Limiter keeps the token buckets of all the quotas.
+type Limiter interface {
+	Name() string
+
+	Start() error
+	Stop()
+
+	// Take consumes n from the bucket of key refilled at rate per second.
+	// If the bucket is exhausted, nothing is taken and retryAfter tells how long to wait.
+	Take(key string, rate, n int64) (ok bool, retryAfter time.Duration)
+}
+
+// Report is the usage per second of each bucket a kateway gossips to its peers.
+type Report struct {
+	Id    string             `json:"id"`
+	Usage map[string]float64 `json:"usage"`
+}
+
+// Gossiper is a Limiter that shares the budget of each bucket with the peer kateways.
+type Gossiper interface {
+	// Gossip merges the latest report of a peer sent from the ip, replacing its previous one.
+	// The ip must be where the peer is registered, so that anyone else can't fake the usage.
+	Gossip(ip string, r Report) error
+}
+
+var (
+	ErrUnknownPeer = errors.New("unknown peer")
+	ErrPeerSpoofed = errors.New("peer report from unregistered address")
+)
+
+var Default Limiter
@@ -0,0 +1,501 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// avroType is a parsed avro schema.
+type avroType struct {
+	kind    string // primitive name, record, enum, array, map, fixed or union
+	name    string // of named types
+	fields  []avroField
+	symbols []string
+	items   *avroType // of array and map
+	union   []*avroType
+	size    int // of fixed
+}
+
+type avroField struct {
+	name       string
+	typ        *avroType
+	hasDefault bool
+}
+
+var avroPrimitives = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"int":     true,
+	"long":    true,
+	"float":   true,
+	"double":  true,
+	"bytes":   true,
+	"string":  true,
+}
+
+func compileAvro(schema string) (*avroType, error) {
+	v, err := decodeJson([]byte(schema))
+	if err != nil {
+		// a bare primitive name is also a valid schema
+		s := strings.TrimSpace(schema)
+		if !avroPrimitives[s] {
+			return nil, fmt.Errorf("avro: %s", err)
+		}
+		v = s
+	}
+
+	return parseAvro(v, "", make(map[string]*avroType))
+}
+
+func parseAvro(v interface{}, namespace string, named map[string]*avroType) (*avroType, error) {
+	switch v := v.(type) {
+	case string:
+		if avroPrimitives[v] {
+			return &avroType{kind: v}, nil
+		}
+		if t, present := named[avroFullname(v, namespace)]; present {
+			return t, nil
+		}
+		if t, present := named[v]; present {
+			return t, nil
+		}
+		return nil, fmt.Errorf("avro: unknown type %s", v)
+
+	case []interface{}:
+		t := &avroType{kind: "union"}
+		for _, branch := range v {
+			bt, err := parseAvro(branch, namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			if bt.kind == "union" {
+				return nil, fmt.Errorf("avro: union in union")
+			}
+			t.union = append(t.union, bt)
+		}
+		return t, nil
+
+	case map[string]interface{}:
+		return parseAvroComplex(v, namespace, named)
+
+	default:
+		return nil, fmt.Errorf("avro: invalid schema %v", v)
+	}
+}
+
+func parseAvroComplex(v map[string]interface{}, namespace string, named map[string]*avroType) (*avroType, error) {
+	kind, _ := v["type"].(string)
+	if kind == "" {
+		if _, present := v["type"]; present {
+			// {"type": {...}} or {"type": [...]}
+			return parseAvro(v["type"], namespace, named)
+		}
+		return nil, fmt.Errorf("avro: missing type")
+	}
+
+	t := &avroType{kind: kind}
+	switch kind {
+	case "record", "error", "enum", "fixed":
+		name, _ := v["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("avro: %s without name", kind)
+		}
+		if ns, ok := v["namespace"].(string); ok && ns != "" {
+			namespace = ns
+		}
+		t.name = avroFullname(name, namespace)
+		if _, present := named[t.name]; present {
+			return nil, fmt.Errorf("avro: %s redefined", t.name)
+		}
+		if i := strings.LastIndex(t.name, "."); i > 0 {
+			namespace = t.name[:i]
+		}
+		// registered before the fields so that it can be recursive
+		named[t.name] = t
+
+	case "array", "map":
+
+	default:
+		// e,g. {"type": "long", "logicalType": "timestamp-millis"}
+		return parseAvro(kind, namespace, named)
+	}
+
+	switch kind {
+	case "record", "error":
+		t.kind = "record"
+		fields, ok := v["fields"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("avro: record %s without fields", t.name)
+		}
+		seen := make(map[string]bool, len(fields))
+		for _, f := range fields {
+			fm, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("avro: record %s invalid field", t.name)
+			}
+			name, _ := fm["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("avro: record %s field without name", t.name)
+			}
+			if seen[name] {
+				return nil, fmt.Errorf("avro: record %s duplicated field %s", t.name, name)
+			}
+			seen[name] = true
+
+			ft, err := parseAvro(fm["type"], namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			field := avroField{name: name, typ: ft}
+			if d, present := fm["default"]; present {
+				if err = ft.validateDefault(d); err != nil {
+					return nil, fmt.Errorf("avro: %s.%s default: %s", t.name, name, err)
+				}
+				field.hasDefault = true
+			}
+			t.fields = append(t.fields, field)
+		}
+
+	case "enum":
+		symbols, ok := v["symbols"].([]interface{})
+		if !ok || len(symbols) == 0 {
+			return nil, fmt.Errorf("avro: enum %s without symbols", t.name)
+		}
+		for _, s := range symbols {
+			symbol, ok := s.(string)
+			if !ok {
+				return nil, fmt.Errorf("avro: enum %s invalid symbol %v", t.name, s)
+			}
+			t.symbols = append(t.symbols, symbol)
+		}
+
+	case "fixed":
+		size, ok := v["size"].(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("avro: fixed %s without size", t.name)
+		}
+		n, err := size.Int64()
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("avro: fixed %s invalid size %s", t.name, size)
+		}
+		t.size = int(n)
+
+	case "array", "map":
+		key := "items"
+		if kind == "map" {
+			key = "values"
+		}
+		if _, present := v[key]; !present {
+			return nil, fmt.Errorf("avro: %s without %s", kind, key)
+		}
+		items, err := parseAvro(v[key], namespace, named)
+		if err != nil {
+			return nil, err
+		}
+		t.items = items
+	}
+
+	return t, nil
+}
+
+func avroFullname(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// validateDefault checks a field default, which for unions must match the 1st branch.
+func (this *avroType) validateDefault(d interface{}) error {
+	if this.kind == "union" {
+		return this.union[0].validate(d, "$")
+	}
+	return this.validate(d, "$")
+}
+
+// Validate checks a json encoded message against the avro schema.
+// Union values can be either plain or wrapped as {"type": value}, and
+// record fields that have defaults can be absent.
+func (this *avroType) Validate(msg []byte) error {
+	v, err := decodeJson(msg)
+	if err != nil {
+		return err
+	}
+
+	return this.validate(v, "$")
+}
+
+func (this *avroType) validate(v interface{}, path string) error {
+	switch this.kind {
+	case "null":
+		if v != nil {
+			return avroMismatch(path, this, v)
+		}
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return avroMismatch(path, this, v)
+		}
+
+	case "int", "long":
+		n, ok := v.(json.Number)
+		if !ok {
+			return avroMismatch(path, this, v)
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return avroMismatch(path, this, v)
+		}
+		if this.kind == "int" && (i < math.MinInt32 || i > math.MaxInt32) {
+			return fmt.Errorf("%s: %d out of int range", path, i)
+		}
+
+	case "float", "double":
+		if _, ok := v.(json.Number); !ok {
+			return avroMismatch(path, this, v)
+		}
+
+	case "string", "bytes":
+		if _, ok := v.(string); !ok {
+			return avroMismatch(path, this, v)
+		}
+
+	case "fixed":
+		s, ok := v.(string)
+		if !ok {
+			return avroMismatch(path, this, v)
+		}
+		if n := utf8.RuneCountInString(s); n != this.size {
+			return fmt.Errorf("%s: expected %d bytes, got %d", path, this.size, n)
+		}
+
+	case "enum":
+		s, ok := v.(string)
+		if !ok {
+			return avroMismatch(path, this, v)
+		}
+		for _, symbol := range this.symbols {
+			if s == symbol {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: %q not in enum %s", path, s, this.name)
+
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			return avroMismatch(path, this, v)
+		}
+		for i, item := range a {
+			if err := this.items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+
+	case "map":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return avroMismatch(path, this, v)
+		}
+		for k, item := range m {
+			if err := this.items.validate(item, path+"."+k); err != nil {
+				return err
+			}
+		}
+
+	case "record":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return avroMismatch(path, this, v)
+		}
+		for _, f := range this.fields {
+			fv, present := m[f.name]
+			if !present {
+				if f.hasDefault {
+					continue
+				}
+				return fmt.Errorf("%s: missing field %s", path, f.name)
+			}
+			if err := f.typ.validate(fv, path+"."+f.name); err != nil {
+				return err
+			}
+		}
+		for k := range m {
+			if this.field(k) == nil {
+				return fmt.Errorf("%s: unknown field %s", path, k)
+			}
+		}
+
+	case "union":
+		return this.validateUnion(v, path)
+	}
+
+	return nil
+}
+
+func (this *avroType) validateUnion(v interface{}, path string) error {
+	// the avro json encoding of a non-null union value: {"branch name": value}
+	if m, ok := v.(map[string]interface{}); ok && len(m) == 1 {
+		for name, bv := range m {
+			for _, branch := range this.union {
+				if branch.typeName() == name && branch.validate(bv, path) == nil {
+					return nil
+				}
+			}
+		}
+	}
+
+	var firstErr error
+	for _, branch := range this.union {
+		err := branch.validate(v, path)
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil && branch.kind != "null" {
+			firstErr = err
+		}
+	}
+
+	if firstErr != nil && len(this.union) <= 2 {
+		// nullable T: the error of T is the clearest
+		return firstErr
+	}
+	return fmt.Errorf("%s: %s matches none of %s", path, jsonKind(v), this.typeName())
+}
+
+func (this *avroType) field(name string) *avroField {
+	for i := range this.fields {
+		if this.fields[i].name == name {
+			return &this.fields[i]
+		}
+	}
+	return nil
+}
+
+func (this *avroType) typeName() string {
+	switch this.kind {
+	case "record", "enum", "fixed":
+		return this.name
+
+	case "union":
+		names := make([]string, 0, len(this.union))
+		for _, branch := range this.union {
+			names = append(names, branch.typeName())
+		}
+		return "[" + strings.Join(names, ",") + "]"
+
+	default:
+		return this.kind
+	}
+}
+
+func avroMismatch(path string, t *avroType, v interface{}) error {
+	return fmt.Errorf("%s: expected %s, got %s", path, t.typeName(), jsonKind(v))
+}
+
+// avroReadable checks if data written with the writer schema can be read with the reader schema,
+// following the avro schema resolution rules.
+func avroReadable(reader, writer *avroType, path string, seen map[[2]*avroType]bool) error {
+	pair := [2]*avroType{reader, writer}
+	if seen[pair] {
+		// recursive types
+		return nil
+	}
+	seen[pair] = true
+
+	if writer.kind == "union" {
+		for _, branch := range writer.union {
+			if err := avroReadable(reader, branch, path, seen); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if reader.kind == "union" {
+		for _, branch := range reader.union {
+			if avroReadable(branch, writer, path, seen) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: %s not in %s", path, writer.typeName(), reader.typeName())
+	}
+
+	if reader.kind != writer.kind && !avroPromotable(writer.kind, reader.kind) {
+		return fmt.Errorf("%s: %s changed to %s", path, writer.typeName(), reader.typeName())
+	}
+
+	switch reader.kind {
+	case "record":
+		if avroShortname(reader.name) != avroShortname(writer.name) {
+			return fmt.Errorf("%s: record %s renamed to %s", path, writer.name, reader.name)
+		}
+		for _, rf := range reader.fields {
+			wf := writer.field(rf.name)
+			if wf == nil {
+				if !rf.hasDefault {
+					return fmt.Errorf("%s: field %s added without default", path, rf.name)
+				}
+				continue
+			}
+			if err := avroReadable(rf.typ, wf.typ, path+"."+rf.name, seen); err != nil {
+				return err
+			}
+		}
+
+	case "enum":
+		for _, ws := range writer.symbols {
+			found := false
+			for _, rs := range reader.symbols {
+				if rs == ws {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("%s: enum symbol %s removed", path, ws)
+			}
+		}
+
+	case "fixed":
+		if reader.size != writer.size {
+			return fmt.Errorf("%s: fixed size changed from %d to %d", path, writer.size, reader.size)
+		}
+
+	case "array", "map":
+		suffix := "[]"
+		if reader.kind == "map" {
+			suffix = ".*"
+		}
+		return avroReadable(reader.items, writer.items, path+suffix, seen)
+	}
+
+	return nil
+}
+
+// avroPromotable checks if a writer primitive can be promoted to the reader one.
+func avroPromotable(writer, reader string) bool {
+	switch writer {
+	case "int":
+		return reader == "long" || reader == "float" || reader == "double"
+	case "long":
+		return reader == "float" || reader == "double"
+	case "float":
+		return reader == "double"
+	case "string":
+		return reader == "bytes"
+	case "bytes":
+		return reader == "string"
+	}
+	return false
+}
+
+func avroShortname(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
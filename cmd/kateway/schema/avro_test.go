@@ -0,0 +1,123 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+const userV1 = `{
+	"type": "record",
+	"name": "User",
+	"namespace": "com.foo",
+	"fields": [
+		{"name": "name", "type": "string"},
+		{"name": "age", "type": "int"},
+		{"name": "email", "type": ["null", "string"], "default": null},
+		{"name": "tags", "type": {"type": "array", "items": "string"}, "default": []},
+		{"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["A", "B"]}, "default": "A"}
+	]
+}`
+
+func TestAvroValidate(t *testing.T) {
+	v, err := Compile(FormatAvro, userV1)
+	assert.Equal(t, nil, err)
+
+	fixtures := []struct {
+		msg string
+		err string
+	}{
+		{`{"name": "funky", "age": 18}`, ""},
+		{`{"name": "funky", "age": 18, "email": "a@b.com", "tags": ["x"], "kind": "B"}`, ""},
+		{`{"name": "funky", "age": 18, "email": {"string": "a@b.com"}}`, ""},
+		{`{"name": "funky", "age": 18, "email": null}`, ""},
+		{`{"name": "funky", "age": "18"}`, "$.age: expected int, got string"},
+		{`{"name": "funky", "age": 1.5}`, "$.age: expected int, got number"},
+		{`{"name": "funky", "age": 3000000000}`, "$.age: 3000000000 out of int range"},
+		{`{"name": "funky"}`, "$: missing field age"},
+		{`{"name": "funky", "age": 18, "sex": 1}`, "$: unknown field sex"},
+		{`{"name": "funky", "age": 18, "email": 5}`, "$.email: expected string, got integer"},
+		{`{"name": "funky", "age": 18, "tags": [1]}`, "$.tags[0]: expected string, got integer"},
+		{`{"name": "funky", "age": 18, "kind": "C"}`, `$.kind: "C" not in enum com.foo.Kind`},
+		{`[1]`, "$: expected com.foo.User, got array"},
+		{`{"name": `, "invalid json: unexpected EOF"},
+	}
+	for _, f := range fixtures {
+		err = v.Validate([]byte(f.msg))
+		if f.err == "" {
+			assert.Equal(t, nil, err)
+		} else {
+			assert.Equal(t, f.err, err.Error())
+		}
+	}
+}
+
+func TestAvroCompile(t *testing.T) {
+	_, err := Compile(FormatAvro, `"long"`)
+	assert.Equal(t, nil, err)
+	_, err = Compile(FormatAvro, `long`)
+	assert.Equal(t, nil, err)
+
+	// recursive type
+	v, err := Compile(FormatAvro, `{"type": "record", "name": "Node", "fields": [
+		{"name": "value", "type": "long"},
+		{"name": "next", "type": ["null", "Node"]}]}`)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, v.Validate([]byte(`{"value": 1, "next": {"value": 2, "next": null}}`)))
+	assert.Equal(t, nil, Compatible(FormatAvro, CompatFull, v, v))
+
+	_, err = Compile(FormatAvro, `{"type": "record", "name": "X", "fields": [{"name": "a", "type": "Y"}]}`)
+	assert.Equal(t, "avro: unknown type Y", err.Error())
+	_, err = Compile(FormatAvro, `{"type": "record", "name": "X", "fields": [{"name": "a", "type": "int", "default": "x"}]}`)
+	assert.Equal(t, "avro: X.a default: $: expected int, got string", err.Error())
+	_, err = Compile(FormatAvro, `{"type": "enum", "name": "E", "symbols": []}`)
+	assert.Equal(t, "avro: enum E without symbols", err.Error())
+}
+
+func TestAvroCompatible(t *testing.T) {
+	v1, _ := Compile(FormatAvro, `{"type": "record", "name": "User", "fields": [
+		{"name": "name", "type": "string"},
+		{"name": "age", "type": "int"}]}`)
+
+	// add a field with default
+	v2, err := Compile(FormatAvro, `{"type": "record", "name": "User", "fields": [
+		{"name": "name", "type": "string"},
+		{"name": "age", "type": "int"},
+		{"name": "email", "type": ["null", "string"], "default": null}]}`)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, Compatible(FormatAvro, CompatBackward, v1, v2))
+	assert.Equal(t, nil, Compatible(FormatAvro, CompatForward, v1, v2))
+	assert.Equal(t, nil, Compatible(FormatAvro, CompatFull, v1, v2))
+
+	// add a field without default
+	v3, _ := Compile(FormatAvro, `{"type": "record", "name": "User", "fields": [
+		{"name": "name", "type": "string"},
+		{"name": "age", "type": "int"},
+		{"name": "email", "type": "string"}]}`)
+	assert.Equal(t, "not backward compatible: $: field email added without default",
+		Compatible(FormatAvro, CompatBackward, v1, v3).Error())
+	assert.Equal(t, nil, Compatible(FormatAvro, CompatForward, v1, v3))
+	assert.Equal(t, nil, Compatible(FormatAvro, CompatNone, v1, v3))
+
+	// remove a field without default
+	v4, _ := Compile(FormatAvro, `{"type": "record", "name": "User", "fields": [
+		{"name": "name", "type": "string"}]}`)
+	assert.Equal(t, nil, Compatible(FormatAvro, CompatBackward, v1, v4))
+	assert.Equal(t, "not forward compatible: $: field age added without default",
+		Compatible(FormatAvro, CompatForward, v1, v4).Error())
+
+	// type promotion
+	v5, _ := Compile(FormatAvro, `{"type": "record", "name": "User", "fields": [
+		{"name": "name", "type": "string"},
+		{"name": "age", "type": "long"}]}`)
+	assert.Equal(t, nil, Compatible(FormatAvro, CompatBackward, v1, v5))
+	assert.Equal(t, "not forward compatible: $.age: long changed to int",
+		Compatible(FormatAvro, CompatForward, v1, v5).Error())
+
+	// enum
+	e1, _ := Compile(FormatAvro, `{"type": "enum", "name": "E", "symbols": ["A", "B"]}`)
+	e2, _ := Compile(FormatAvro, `{"type": "enum", "name": "E", "symbols": ["A", "B", "C"]}`)
+	assert.Equal(t, nil, Compatible(FormatAvro, CompatBackward, e1, e2))
+	assert.Equal(t, "not forward compatible: $: enum symbol C removed",
+		Compatible(FormatAvro, CompatForward, e1, e2).Error())
+}
@@ -0,0 +1,17 @@
+// Package schema is a versioned registry of message schemas per appid/topic/ver.
+//
+// Each topic has a subject: the schema format, the compatibility level and the
+// ordered schema versions. A new version is accepted only if it is compatible with
+// the latest one:
+//
+//   backward  consumers using the new schema can read messages of the latest one
+//   forward   consumers using the latest schema can read messages of the new one
+//   full      both backward and forward
+//   none      no check
+//
+// When a subject is enforced, Pub validates each message against the latest
+// version and rejects the non-conforming ones.
+//
+// Avro schemas validate JSON encoded messages, and only a subset of JSON Schema
+// is supported: type, properties, required, additionalProperties, items and enum.
+package schema
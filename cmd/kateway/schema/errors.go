@@ -0,0 +1,25 @@
+package schema
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidFormat        = errors.New("invalid schema format, avro or json")
+	ErrInvalidCompatibility = errors.New("invalid compatibility, none|backward|forward|full")
+	ErrFormatMismatch       = errors.New("schema format differs from the registered one")
+	ErrSubjectNotFound      = errors.New("schema not registered")
+	ErrVersionNotFound      = errors.New("schema version not found")
+	ErrConcurrentUpdate     = errors.New("schema updated concurrently, try again")
+)
+
+// CompatibilityError is a schema version rejected by the compatibility check.
+type CompatibilityError struct {
+	Compatibility string
+	Reason        string
+}
+
+func (this *CompatibilityError) Error() string {
+	return fmt.Sprintf("not %s compatible: %s", this.Compatibility, this.Reason)
+}
@@ -0,0 +1,293 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// jsonSchema is a parsed JSON Schema subset, unsupported keywords are ignored.
+type jsonSchema struct {
+	types                map[string]bool // empty means any
+	properties           map[string]*jsonSchema
+	required             []string
+	additionalProperties bool
+	items                *jsonSchema
+	enum                 []interface{}
+}
+
+var jsonTypes = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"integer": true,
+	"number":  true,
+	"string":  true,
+	"array":   true,
+	"object":  true,
+}
+
+func compileJsonSchema(schema string) (*jsonSchema, error) {
+	v, err := decodeJson([]byte(schema))
+	if err != nil {
+		return nil, fmt.Errorf("json schema: %s", err)
+	}
+
+	return parseJsonSchema(v, "$")
+}
+
+func parseJsonSchema(v interface{}, path string) (*jsonSchema, error) {
+	if b, ok := v.(bool); ok {
+		// true accepts anything, false nothing
+		s := &jsonSchema{additionalProperties: true}
+		if !b {
+			s.types = map[string]bool{}
+			s.enum = []interface{}{}
+		}
+		return s, nil
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("json schema %s: not an object", path)
+	}
+
+	s := &jsonSchema{additionalProperties: true}
+	switch t := m["type"].(type) {
+	case nil:
+
+	case string:
+		if !jsonTypes[t] {
+			return nil, fmt.Errorf("json schema %s: unknown type %s", path, t)
+		}
+		s.types = map[string]bool{t: true}
+
+	case []interface{}:
+		s.types = make(map[string]bool, len(t))
+		for _, tt := range t {
+			name, _ := tt.(string)
+			if !jsonTypes[name] {
+				return nil, fmt.Errorf("json schema %s: unknown type %v", path, tt)
+			}
+			s.types[name] = true
+		}
+
+	default:
+		return nil, fmt.Errorf("json schema %s: invalid type", path)
+	}
+
+	if props, present := m["properties"]; present {
+		pm, ok := props.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("json schema %s: invalid properties", path)
+		}
+		s.properties = make(map[string]*jsonSchema, len(pm))
+		for name, p := range pm {
+			ps, err := parseJsonSchema(p, path+"."+name)
+			if err != nil {
+				return nil, err
+			}
+			s.properties[name] = ps
+		}
+	}
+
+	if required, present := m["required"]; present {
+		rs, ok := required.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("json schema %s: invalid required", path)
+		}
+		for _, r := range rs {
+			name, ok := r.(string)
+			if !ok {
+				return nil, fmt.Errorf("json schema %s: invalid required %v", path, r)
+			}
+			s.required = append(s.required, name)
+		}
+	}
+
+	if ap, present := m["additionalProperties"]; present {
+		b, ok := ap.(bool)
+		if !ok {
+			return nil, fmt.Errorf("json schema %s: only boolean additionalProperties supported", path)
+		}
+		s.additionalProperties = b
+	}
+
+	if items, present := m["items"]; present {
+		is, err := parseJsonSchema(items, path+"[]")
+		if err != nil {
+			return nil, err
+		}
+		s.items = is
+	}
+
+	if enum, present := m["enum"]; present {
+		es, ok := enum.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("json schema %s: invalid enum", path)
+		}
+		s.enum = es
+	}
+
+	return s, nil
+}
+
+func (this *jsonSchema) Validate(msg []byte) error {
+	v, err := decodeJson(msg)
+	if err != nil {
+		return err
+	}
+
+	return this.validate(v, "$")
+}
+
+func (this *jsonSchema) validate(v interface{}, path string) error {
+	if this.types != nil && !this.hasType(jsonKind(v)) {
+		return fmt.Errorf("%s: expected %s, got %s", path, this.typeNames(), jsonKind(v))
+	}
+
+	if this.enum != nil && !jsonEnumContains(this.enum, v) {
+		return fmt.Errorf("%s: value not in enum", path)
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range this.required {
+			if _, present := v[name]; !present {
+				return fmt.Errorf("%s: missing property %s", path, name)
+			}
+		}
+		for name, pv := range v {
+			ps, present := this.properties[name]
+			if !present {
+				if !this.additionalProperties {
+					return fmt.Errorf("%s: unknown property %s", path, name)
+				}
+				continue
+			}
+			if err := ps.validate(pv, path+"."+name); err != nil {
+				return err
+			}
+		}
+
+	case []interface{}:
+		if this.items == nil {
+			return nil
+		}
+		for i, item := range v {
+			if err := this.items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasType checks a json kind, integers are also numbers.
+func (this *jsonSchema) hasType(kind string) bool {
+	return this.types[kind] || (kind == "integer" && this.types["number"])
+}
+
+func (this *jsonSchema) typeNames() string {
+	names := make([]string, 0, len(this.types))
+	for t := range this.types {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	if len(names) == 1 {
+		return names[0]
+	}
+	return fmt.Sprintf("%v", names)
+}
+
+func jsonEnumContains(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if jsonEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonEqual(a, b interface{}) bool {
+	na, aok := a.(json.Number)
+	nb, bok := b.(json.Number)
+	if aok && bok {
+		fa, _ := na.Float64()
+		fb, _ := nb.Float64()
+		return fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// jsonReadable checks if every message valid under writer is also valid under reader.
+// Properties that writer does not declare are assumed absent, so that adding an
+// optional property is compatible even if the writer allows additional properties.
+func jsonReadable(reader, writer *jsonSchema, path string) error {
+	if reader.types != nil {
+		if writer.types == nil {
+			return fmt.Errorf("%s: any type restricted to %s", path, reader.typeNames())
+		}
+		for t := range writer.types {
+			if !reader.hasType(t) {
+				return fmt.Errorf("%s: type %s no longer allowed", path, t)
+			}
+		}
+	}
+
+	if reader.enum != nil {
+		if writer.enum == nil {
+			return fmt.Errorf("%s: enum added", path)
+		}
+		for _, e := range writer.enum {
+			if !jsonEnumContains(reader.enum, e) {
+				return fmt.Errorf("%s: enum value %v removed", path, e)
+			}
+		}
+	}
+
+	writerRequired := make(map[string]bool, len(writer.required))
+	for _, name := range writer.required {
+		writerRequired[name] = true
+	}
+	for _, name := range reader.required {
+		if !writerRequired[name] {
+			return fmt.Errorf("%s: property %s became required", path, name)
+		}
+	}
+
+	names := make([]string, 0, len(reader.properties))
+	for name := range reader.properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rp := reader.properties[name]
+		wp, present := writer.properties[name]
+		if !present {
+			continue
+		}
+		if err := jsonReadable(rp, wp, path+"."+name); err != nil {
+			return err
+		}
+	}
+
+	if !reader.additionalProperties {
+		for name := range writer.properties {
+			if _, present := reader.properties[name]; !present {
+				return fmt.Errorf("%s: property %s removed", path, name)
+			}
+		}
+	}
+
+	if reader.items != nil {
+		wi := writer.items
+		if wi == nil {
+			wi = &jsonSchema{additionalProperties: true}
+		}
+		return jsonReadable(reader.items, wi, path+"[]")
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/funkygao/assert"
+)
+
+func TestJsonSchemaValidate(t *testing.T) {
+	v, err := Compile(FormatJson, `{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type": "object",
+		"properties": {
+			"id": {"type": "integer"},
+			"price": {"type": "number"},
+			"status": {"enum": ["new", "paid"]},
+			"items": {"type": "array", "items": {"type": "string"}},
+			"note": {"type": ["string", "null"]}
+		},
+		"required": ["id", "status"],
+		"additionalProperties": false
+	}`)
+	assert.Equal(t, nil, err)
+
+	fixtures := []struct {
+		msg string
+		err string
+	}{
+		{`{"id": 1, "status": "new"}`, ""},
+		{`{"id": 1, "status": "paid", "price": 1, "items": ["a"], "note": null}`, ""},
+		{`{"id": 1, "status": "paid", "price": 9.9}`, ""},
+		{`{"id": 1.5, "status": "new"}`, "$.id: expected integer, got number"},
+		{`{"id": 1, "status": "old"}`, "$.status: value not in enum"},
+		{`{"id": 1}`, "$: missing property status"},
+		{`{"id": 1, "status": "new", "x": 1}`, "$: unknown property x"},
+		{`{"id": 1, "status": "new", "items": ["a", 2]}`, "$.items[1]: expected string, got integer"},
+		{`{"id": 1, "status": "new", "note": 1}`, "$.note: expected [null string], got integer"},
+		{`"hello"`, "$: expected object, got string"},
+	}
+	for _, f := range fixtures {
+		err = v.Validate([]byte(f.msg))
+		if f.err == "" {
+			assert.Equal(t, nil, err)
+		} else {
+			assert.Equal(t, f.err, err.Error())
+		}
+	}
+
+	_, err = Compile(FormatJson, `{"type": "date"}`)
+	assert.Equal(t, "json schema $: unknown type date", err.Error())
+	_, err = Compile(FormatJson, `{"additionalProperties": {"type": "string"}}`)
+	assert.Equal(t, "json schema $: only boolean additionalProperties supported", err.Error())
+}
+
+func TestJsonSchemaCompatible(t *testing.T) {
+	v1, _ := Compile(FormatJson, `{"type": "object",
+		"properties": {"id": {"type": "integer"}, "status": {"enum": ["new", "paid"]}},
+		"required": ["id"]}`)
+
+	// add an optional property
+	v2, _ := Compile(FormatJson, `{"type": "object",
+		"properties": {"id": {"type": "integer"}, "status": {"enum": ["new", "paid"]}, "note": {"type": "string"}},
+		"required": ["id"]}`)
+	assert.Equal(t, nil, Compatible(FormatJson, CompatFull, v1, v2))
+
+	// make a property required
+	v3, _ := Compile(FormatJson, `{"type": "object",
+		"properties": {"id": {"type": "integer"}, "status": {"enum": ["new", "paid"]}},
+		"required": ["id", "status"]}`)
+	assert.Equal(t, "not backward compatible: $: property status became required",
+		Compatible(FormatJson, CompatBackward, v1, v3).Error())
+	assert.Equal(t, nil, Compatible(FormatJson, CompatForward, v1, v3))
+
+	// widen a type
+	v4, _ := Compile(FormatJson, `{"type": "object",
+		"properties": {"id": {"type": "number"}, "status": {"enum": ["new", "paid", "refunded"]}},
+		"required": ["id"]}`)
+	assert.Equal(t, nil, Compatible(FormatJson, CompatBackward, v1, v4))
+	assert.Equal(t, "not forward compatible: $.id: type number no longer allowed",
+		Compatible(FormatJson, CompatForward, v1, v4).Error())
+
+	// close the content model
+	v5, _ := Compile(FormatJson, `{"type": "object",
+		"properties": {"id": {"type": "integer"}},
+		"additionalProperties": false}`)
+	assert.Equal(t, "not backward compatible: $: property status removed",
+		Compatible(FormatJson, CompatBackward, v1, v5).Error())
+}
+
+func TestSubject(t *testing.T) {
+	var sub Subject
+	assert.Equal(t, true, sub.Latest() == nil)
+
+	sub.Versions = []Version{{Version: 1, Schema: `"int"`}, {Version: 2, Schema: `"long"`}}
+	assert.Equal(t, 2, sub.Latest().Version)
+	v, err := sub.Version(1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `"int"`, v.Schema)
+	_, err = sub.Version(3)
+	assert.Equal(t, ErrVersionNotFound, err)
+
+	assert.Equal(t, true, Equal(`{"type": "int"}`, `{"type":"int"}`))
+	assert.Equal(t, false, Equal(`{"type": "int"}`, `{"type":"long"}`))
+	assert.Equal(t, ErrInvalidFormat, ValidateFormat("xml", CompatFull))
+	assert.Equal(t, ErrInvalidCompatibility, ValidateFormat(FormatAvro, "transitive"))
+}
@@ -0,0 +1,210 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	FormatAvro = "avro"
+	FormatJson = "json"
+
+	CompatNone     = "none"
+	CompatBackward = "backward"
+	CompatForward  = "forward"
+	CompatFull     = "full"
+)
+
+// Version is a registered schema.
+type Version struct {
+	Version int    `json:"version"`
+	Schema  string `json:"schema"`
+	Ctime   int64  `json:"ctime"`
+}
+
+// Subject is all the schema versions of a topic.
+type Subject struct {
+	Format        string    `json:"format"`
+	Compatibility string    `json:"compatibility"`
+	Enforce       bool      `json:"enforce"`
+	Versions      []Version `json:"versions"`
+}
+
+// Latest returns the latest schema version, nil if there is none.
+func (this *Subject) Latest() *Version {
+	if len(this.Versions) == 0 {
+		return nil
+	}
+
+	return &this.Versions[len(this.Versions)-1]
+}
+
+// Version returns the schema of version v.
+func (this *Subject) Version(v int) (*Version, error) {
+	for i := range this.Versions {
+		if this.Versions[i].Version == v {
+			return &this.Versions[i], nil
+		}
+	}
+
+	return nil, ErrVersionNotFound
+}
+
+func (this *Subject) Bytes() []byte {
+	b, _ := json.Marshal(this)
+	return b
+}
+
+func (this *Subject) From(b []byte) error {
+	return json.Unmarshal(b, this)
+}
+
+// Validator checks if a message conforms to a schema.
+type Validator interface {
+	Validate(msg []byte) error
+}
+
+// ValidateFormat checks the schema format and compatibility level.
+func ValidateFormat(format, compatibility string) error {
+	switch format {
+	case FormatAvro, FormatJson:
+	default:
+		return ErrInvalidFormat
+	}
+
+	switch compatibility {
+	case CompatNone, CompatBackward, CompatForward, CompatFull:
+		return nil
+	default:
+		return ErrInvalidCompatibility
+	}
+}
+
+// Compile parses a schema of the format into a validator.
+func Compile(format, schema string) (Validator, error) {
+	switch format {
+	case FormatAvro:
+		return compileAvro(schema)
+
+	case FormatJson:
+		return compileJsonSchema(schema)
+
+	default:
+		return nil, ErrInvalidFormat
+	}
+}
+
+// Compatible checks if schema next can follow schema prev under the compatibility level.
+func Compatible(format, compatibility string, prev, next Validator) error {
+	var reader, writer []Validator
+	switch compatibility {
+	case CompatNone:
+		return nil
+
+	case CompatBackward:
+		reader, writer = []Validator{next}, []Validator{prev}
+
+	case CompatForward:
+		reader, writer = []Validator{prev}, []Validator{next}
+
+	case CompatFull:
+		reader, writer = []Validator{next, prev}, []Validator{prev, next}
+
+	default:
+		return ErrInvalidCompatibility
+	}
+
+	for i := range reader {
+		var err error
+		switch format {
+		case FormatAvro:
+			err = avroReadable(reader[i].(*avroType), writer[i].(*avroType), "$", make(map[[2]*avroType]bool))
+
+		case FormatJson:
+			err = jsonReadable(reader[i].(*jsonSchema), writer[i].(*jsonSchema), "$")
+
+		default:
+			return ErrInvalidFormat
+		}
+
+		if err != nil {
+			return &CompatibilityError{Compatibility: compatibility, Reason: err.Error()}
+		}
+	}
+
+	return nil
+}
+
+// Equal checks if 2 schemas are the same regardless of the json formatting.
+func Equal(a, b string) bool {
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, []byte(a)) != nil || json.Compact(&cb, []byte(b)) != nil {
+		return strings.TrimSpace(a) == strings.TrimSpace(b)
+	}
+
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
+
+// decodeJson decodes a message keeping the numbers as json.Number.
+func decodeJson(msg []byte) (v interface{}, err error) {
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	if err = dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid json: %s", err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("invalid json: trailing data")
+	}
+
+	return
+}
+
+// jsonKind describes the json type of a decoded value for error messages.
+func jsonKind(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// Registry is the schema store of all topics.
+type Registry interface {
+	Name() string
+
+	Start() error
+	Stop()
+
+	// Register adds a schema version to the subject of a topic and returns the version.
+	// The compatibility applies only when the subject is created.
+	// Registering the latest schema again is a no-op.
+	Register(appid, topic, ver, format, compatibility, schema string) (int, error)
+
+	// Subject returns the schema subject of a topic, ErrSubjectNotFound if there is none.
+	Subject(appid, topic, ver string) (*Subject, error)
+
+	// Configure changes the compatibility and enforcement of a subject.
+	Configure(appid, topic, ver, compatibility string, enforce bool) error
+
+	// Validate checks a message against the registered schemas if the subject is enforced.
+	// A message conforming to any of the versions is valid, the error is of the latest version.
+	Validate(appid, topic, ver string, msg []byte) error
+}
+
+var Default Registry
@@ -0,0 +1,7 @@
+// Package zkschema is a schema registry stored in zookeeper.
+//
+// Each subject is a znode under /_kateway/schemas whose versions are updated with
+// compare-and-set. Pub validates against a local cache of the compiled schemas,
+// refreshed periodically, so a change made on another kateway takes effect after at
+// most the refresh interval.
+package zkschema
@@ -0,0 +1,302 @@
+package zkschema
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/funkygao/gafka/cmd/kateway/schema"
+	"github.com/funkygao/gafka/zk"
+	log "github.com/funkygao/log4go"
+	zklib "github.com/samuel/go-zookeeper/zk"
+)
+
+// maxCasRetries is how many times an update retries on concurrent updates.
+const maxCasRetries = 3
+
+type cachedSubject struct {
+	data       []byte
+	subject    *schema.Subject
+	validators []versionValidator // of all the registered schemas, the latest first
+}
+
+type versionValidator struct {
+	version int
+	schema.Validator
+}
+
+type zkRegistry struct {
+	zkzone  *zk.ZkZone
+	refresh time.Duration
+
+	mu       sync.RWMutex
+	subjects map[string]*cachedSubject // key is appid.topic.ver
+
+	wg         sync.WaitGroup
+	shutdownCh chan struct{}
+}
+
+func New(zkzone *zk.ZkZone, refresh time.Duration) schema.Registry {
+	return &zkRegistry{
+		zkzone:     zkzone,
+		refresh:    refresh,
+		subjects:   make(map[string]*cachedSubject),
+		shutdownCh: make(chan struct{}),
+	}
+}
+
+func (this *zkRegistry) Name() string {
+	return "zk"
+}
+
+func (this *zkRegistry) Start() error {
+	// warm up
+	if err := this.refreshCache(); err != nil {
+		return err
+	}
+
+	this.wg.Add(1)
+	go func() {
+		ticker := time.NewTicker(this.refresh)
+		defer func() {
+			ticker.Stop()
+			this.wg.Done()
+		}()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := this.refreshCache(); err != nil {
+					// keep enforcing with the local cache until zk recovers
+					log.Warn("schema refresh: %s", err)
+				}
+
+			case <-this.shutdownCh:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (this *zkRegistry) Stop() {
+	close(this.shutdownCh)
+	this.wg.Wait()
+}
+
+func (this *zkRegistry) refreshCache() error {
+	conn := this.zkzone.Conn()
+	names, _, err := conn.Children(zk.KatewaySchemas)
+	if err != nil {
+		if err == zklib.ErrNoNode {
+			names = nil
+		} else {
+			return err
+		}
+	}
+
+	this.mu.RLock()
+	old := this.subjects
+	this.mu.RUnlock()
+
+	subjects := make(map[string]*cachedSubject, len(names))
+	for _, name := range names {
+		data, _, err := conn.Get(fmt.Sprintf("%s/%s", zk.KatewaySchemas, name))
+		if err != nil {
+			if err == zklib.ErrNoNode {
+				continue
+			}
+			return err
+		}
+
+		if s, present := old[name]; present && bytes.Equal(s.data, data) {
+			subjects[name] = s
+			continue
+		}
+
+		s, err := compileSubject(data)
+		if err != nil {
+			log.Error("schema %s: %s", name, err)
+			continue
+		}
+		subjects[name] = s
+	}
+
+	this.mu.Lock()
+	this.subjects = subjects
+	this.mu.Unlock()
+	return nil
+}
+
+func compileSubject(data []byte) (*cachedSubject, error) {
+	s := &cachedSubject{data: data, subject: &schema.Subject{}}
+	if err := s.subject.From(data); err != nil {
+		return nil, err
+	}
+
+	for i := len(s.subject.Versions) - 1; i >= 0; i-- {
+		v := s.subject.Versions[i]
+		validator, err := schema.Compile(s.subject.Format, v.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("v%d: %s", v.Version, err)
+		}
+		s.validators = append(s.validators, versionValidator{version: v.Version, Validator: validator})
+	}
+
+	return s, nil
+}
+
+func subjectKey(appid, topic, ver string) string {
+	return fmt.Sprintf("%s.%s.%s", appid, topic, ver)
+}
+
+// update applies fn to the subject stored in zk with compare-and-set, retrying on concurrent updates.
+// fn receives an empty subject if it does not exist yet.
+func (this *zkRegistry) update(key string, fn func(sub *schema.Subject, exists bool) error) error {
+	for i := 0; i < maxCasRetries; i++ {
+		sub := &schema.Subject{}
+		data, version, err := this.zkzone.SchemaSubject(key)
+		switch err {
+		case nil:
+			if err = sub.From(data); err != nil {
+				return err
+			}
+
+		case zklib.ErrNoNode:
+			version = -1
+
+		default:
+			return err
+		}
+
+		if err = fn(sub, version != -1); err != nil {
+			return err
+		}
+
+		data = sub.Bytes()
+		switch err = this.zkzone.SetSchemaSubject(key, data, version); err {
+		case nil:
+			if s, err := compileSubject(data); err == nil {
+				this.mu.Lock()
+				this.subjects[key] = s
+				this.mu.Unlock()
+			}
+			return nil
+
+		case zklib.ErrNodeExists, zklib.ErrBadVersion:
+			log.Debug("schema %s updated concurrently, #%d retry", key, i+1)
+
+		default:
+			return err
+		}
+	}
+
+	return schema.ErrConcurrentUpdate
+}
+
+func (this *zkRegistry) Register(appid, topic, ver, format, compatibility, s string) (version int, err error) {
+	if compatibility == "" {
+		compatibility = schema.CompatBackward
+	}
+	if err = schema.ValidateFormat(format, compatibility); err != nil {
+		return
+	}
+
+	next, err := schema.Compile(format, s)
+	if err != nil {
+		return
+	}
+
+	err = this.update(subjectKey(appid, topic, ver), func(sub *schema.Subject, exists bool) error {
+		if !exists {
+			sub.Format, sub.Compatibility = format, compatibility
+		} else if sub.Format != format {
+			return schema.ErrFormatMismatch
+		}
+
+		version = 1
+		if latest := sub.Latest(); latest != nil {
+			if schema.Equal(latest.Schema, s) {
+				version = latest.Version
+				return nil
+			}
+
+			prev, err := schema.Compile(sub.Format, latest.Schema)
+			if err != nil {
+				return err
+			}
+			if err = schema.Compatible(sub.Format, sub.Compatibility, prev, next); err != nil {
+				return err
+			}
+			version = latest.Version + 1
+		}
+
+		sub.Versions = append(sub.Versions, schema.Version{
+			Version: version,
+			Schema:  s,
+			Ctime:   time.Now().Unix(),
+		})
+		return nil
+	})
+	return
+}
+
+func (this *zkRegistry) Subject(appid, topic, ver string) (*schema.Subject, error) {
+	data, _, err := this.zkzone.SchemaSubject(subjectKey(appid, topic, ver))
+	if err == zklib.ErrNoNode {
+		return nil, schema.ErrSubjectNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	sub := &schema.Subject{}
+	err = sub.From(data)
+	return sub, err
+}
+
+func (this *zkRegistry) Configure(appid, topic, ver, compatibility string, enforce bool) error {
+	if compatibility != "" {
+		if err := schema.ValidateFormat(schema.FormatAvro, compatibility); err != nil {
+			return err
+		}
+	}
+
+	return this.update(subjectKey(appid, topic, ver), func(sub *schema.Subject, exists bool) error {
+		if !exists {
+			return schema.ErrSubjectNotFound
+		}
+
+		if compatibility != "" {
+			sub.Compatibility = compatibility
+		}
+		sub.Enforce = enforce
+		return nil
+	})
+}
+
+func (this *zkRegistry) Validate(appid, topic, ver string, msg []byte) error {
+	this.mu.RLock()
+	s, present := this.subjects[subjectKey(appid, topic, ver)]
+	this.mu.RUnlock()
+
+	if !present || !s.subject.Enforce || len(s.validators) == 0 {
+		return nil
+	}
+
+	// publishers not upgraded yet keep publishing with the older schemas, e,g. a field
+	// removed by a backward compatible version is unknown to the latest schema
+	var latestErr error
+	for i, v := range s.validators {
+		err := v.Validate(msg)
+		if err == nil {
+			return nil
+		}
+		if i == 0 {
+			latestErr = fmt.Errorf("schema v%d: %s", v.version, err)
+		}
+	}
+
+	return latestErr
+}
@@ -1,5 +1,9 @@
 package dummy
 
+import (
+	"github.com/funkygao/gafka/cmd/kateway/store"
+)
+
 type pubStore struct {
 }
 
@@ -31,6 +35,14 @@ func (this *pubStore) SyncPub(cluster string, topic string, key,
 	return
 }
 
+func (this *pubStore) SyncPubBatch(cluster string, topic string, msgs []*store.PubMessage) error {
+	return nil
+}
+
+func (this *pubStore) SyncAllPubBatch(cluster string, topic string, msgs []*store.PubMessage) error {
+	return nil
+}
+
 func (this *pubStore) AsyncPub(cluster string, topic string, key,
 	msg []byte) (partition int32, offset int64, err error) {
 
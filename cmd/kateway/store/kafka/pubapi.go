@@ -102,6 +102,124 @@ func (this *pubStore) doSyncPub(allAck bool, cluster, topic string,
 	return
 }
 
+func (this *pubStore) doSyncPubBatch(allAck bool, cluster, topic string, msgs []*store.PubMessage) (err error) {
+	this.pubPoolsLock.RLock()
+	pool, present := this.pubPools[cluster]
+	this.pubPoolsLock.RUnlock()
+	if !present {
+		err = store.ErrInvalidCluster
+		return
+	}
+
+	if pool.breaker.Open() {
+		err = store.ErrCircuitOpen
+		return
+	}
+
+	var (
+		producer     *syncProducerClient
+		producerMsgs = make([]*sarama.ProducerMessage, len(msgs))
+		msgIdx       = make(map[*sarama.ProducerMessage]int, len(msgs))
+	)
+	for i, m := range msgs {
+		var keyEncoder sarama.Encoder = nil // will use random partitioner
+		if len(m.Key) > 0 {
+			keyEncoder = sarama.ByteEncoder(m.Key) // will use hash partition
+		}
+
+		producerMsgs[i] = &sarama.ProducerMessage{
+			Topic: topic,
+			Key:   keyEncoder,
+			Value: sarama.ByteEncoder(m.Value),
+		}
+		msgIdx[producerMsgs[i]] = i
+		m.Partition, m.Offset, m.Err = -1, -1, nil
+	}
+
+	getProducer := pool.GetSyncProducer
+	if allAck {
+		getProducer = pool.GetSyncAllProducer
+	}
+
+	if this.dryRun {
+		// ignore kafka I/O
+		producer, err = getProducer()
+		producer.Recycle()
+		return
+	}
+
+	producer, err = getProducer()
+	if err != nil {
+		pool.breaker.Fail()
+
+		if producer != nil {
+			// should never happen
+			producer.CloseAndRecycle()
+		}
+
+		return
+	}
+
+	err = producer.SendMessages(producerMsgs)
+	if err == nil {
+		pool.breaker.Succeed()
+		producer.Recycle()
+
+		for i, pm := range producerMsgs {
+			msgs[i].Partition, msgs[i].Offset = pm.Partition, pm.Offset
+		}
+		return
+	}
+
+	perrs, ok := err.(sarama.ProducerErrors)
+	if !ok {
+		// the whole batch failed
+		log.Error("cluster[%s] topic:%s batch:%d %v", cluster, topic, len(msgs), err)
+
+		pool.breaker.Fail()
+		producer.CloseAndRecycle()
+		return
+	}
+
+	// partial failure: the failed messages carry their own error
+	failed := make(map[int]struct{}, len(perrs))
+	systemErr := false
+	for _, perr := range perrs {
+		i, present := msgIdx[perr.Msg]
+		if !present {
+			continue
+		}
+
+		failed[i] = struct{}{}
+		switch perr.Err {
+		case sarama.ErrUnknownTopicOrPartition, sarama.ErrInvalidTopic:
+			msgs[i].Err = store.ErrInvalidTopic
+
+		default:
+			msgs[i].Err = perr.Err
+			systemErr = true
+		}
+	}
+	for i, pm := range producerMsgs {
+		if _, present := failed[i]; !present {
+			msgs[i].Partition, msgs[i].Offset = pm.Partition, pm.Offset
+		}
+	}
+
+	log.Error("cluster[%s] topic:%s batch:%d/%d %v", cluster, topic, len(perrs), len(msgs), err)
+
+	if systemErr {
+		pool.breaker.Fail()
+		producer.CloseAndRecycle()
+	} else {
+		// this conn is still valid
+		pool.breaker.Succeed()
+		producer.Recycle()
+	}
+
+	return nil
+}
+
 func (this *pubStore) IsSystemError(err error) bool {
 	switch err {
 	case store.ErrInvalidCluster, store.ErrInvalidTopic:
@@ -120,6 +238,14 @@ func (this *pubStore) SyncPub(cluster, topic string, key, msg []byte) (partition
 	return this.doSyncPub(false, cluster, topic, key, msg)
 }
 
+func (this *pubStore) SyncPubBatch(cluster, topic string, msgs []*store.PubMessage) error {
+	return this.doSyncPubBatch(false, cluster, topic, msgs)
+}
+
+func (this *pubStore) SyncAllPubBatch(cluster, topic string, msgs []*store.PubMessage) error {
+	return this.doSyncPubBatch(true, cluster, topic, msgs)
+}
+
 // FIXME not fully fault tolerant like SyncPub.
 func (this *pubStore) AsyncPub(cluster string, topic string, key []byte,
 	msg []byte) (partition int32, offset int64, err error) {
@@ -1,5 +1,14 @@
 package store
 
+// PubMessage is a keyed message of a batch Pub, it carries its own Pub result.
+type PubMessage struct {
+	Key, Value []byte
+
+	Partition int32
+	Offset    int64
+	Err       error
+}
+
 // A PubStore is a generic store that can Pub sync/async.
 type PubStore interface {
 	// Name returns the name of the underlying store.
@@ -17,6 +26,13 @@ type PubStore interface {
 	// AsyncPub pub a keyed message to a topic of a cluster asynchronously.
 	AsyncPub(cluster, topic string, key, msg []byte) (partition int32, offset int64, err error)
 
+	// SyncPubBatch pub keyed messages to a topic of a cluster in a single producer call.
+	// The returned error applies to the whole batch, while each message carries its own result.
+	SyncPubBatch(cluster, topic string, msgs []*PubMessage) error
+
+	// SyncAllPubBatch is SyncPubBatch that waits for all replicas before sending response.
+	SyncAllPubBatch(cluster, topic string, msgs []*PubMessage) error
+
 	IsSystemError(error) bool
 }
 
@@ -9,4 +9,7 @@ var (
 	ErrEmptyArg         = errors.New("empty argument")
 	ErrNotNumber        = errors.New("not number")
 	ErrTooBigPartitions = errors.New("too big partitions")
+	ErrNotDuration      = errors.New("not duration")
+	ErrTooManyRetries   = errors.New("too many retry attempts")
+	ErrTooLongBackoff   = errors.New("too long retry backoff")
 )
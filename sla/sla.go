@@ -3,6 +3,8 @@ package sla
 import (
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -10,6 +12,7 @@ const (
 	SlaKeyRetentionBytes = "retention.bytes"
 	SlaKeyPartitions     = "partitions"
 	SlaKeyReplicas       = "replicas"
+	SlaKeyRetryBackoff   = "retry.backoff" // e,g. 10s,1m,10m
 
 	SlaKeyRetryTopic      = "retry"
 	SlaKeyDeadLetterTopic = "dead"
@@ -25,6 +28,9 @@ const (
 	maxReplicas       = 3
 	maxPartitions     = 20
 	maxRetentionHours = 20 * 7 * 24
+
+	maxRetryAttempts = 10
+	maxRetryBackoff  = 7 * 24 * time.Hour
 )
 
 type TopicSla struct {
@@ -33,6 +39,12 @@ type TopicSla struct {
 	Partitions        int
 	Replicas          int
 	MinInsyncReplicas int
+
+	// RetryBackoff is the delay of each attempt before messages buried to
+	// the retry shadow topic are redelivered to the subscriber.
+	// After the last attempt, messages are buried to the dead shadow topic.
+	// Empty means subscriber consumes the retry shadow topic without delay.
+	RetryBackoff []time.Duration
 }
 
 func DefaultSla() *TopicSla {
@@ -50,7 +62,8 @@ func (this *TopicSla) IsDefault() bool {
 		this.Partitions == defaultPartitions &&
 		this.RetentionBytes == defaultRetentionBytes &&
 		this.RetentionHours == defaultRetentionHours &&
-		this.MinInsyncReplicas == defaultMinInsyncReplicas
+		this.MinInsyncReplicas == defaultMinInsyncReplicas &&
+		len(this.RetryBackoff) == 0
 }
 
 func (this *TopicSla) Validate() error {
@@ -80,6 +93,58 @@ func (this *TopicSla) ParseRetentionHours(s string) error {
 	return nil
 }
 
+// ParseRetryBackoff parses comma separated durations of each retry attempt, e,g. 10s,1m,10m
+func (this *TopicSla) ParseRetryBackoff(s string) error {
+	if len(s) == 0 {
+		return ErrEmptyArg
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) > maxRetryAttempts {
+		return ErrTooManyRetries
+	}
+
+	backoff := make([]time.Duration, 0, len(parts))
+	for _, p := range parts {
+		d, err := time.ParseDuration(strings.TrimSpace(p))
+		if err != nil {
+			return ErrNotDuration
+		}
+
+		if d <= 0 {
+			return ErrNegative
+		}
+
+		if d > maxRetryBackoff {
+			return ErrTooLongBackoff
+		}
+
+		backoff = append(backoff, d)
+	}
+
+	this.RetryBackoff = backoff
+	return nil
+}
+
+// RetryDelay returns the delay of the attempt'th retry, which starts from 1.
+// ok is false if all retry attempts are exhausted.
+func (this *TopicSla) RetryDelay(attempt int) (delay time.Duration, ok bool) {
+	if attempt < 1 || attempt > len(this.RetryBackoff) {
+		return 0, false
+	}
+
+	return this.RetryBackoff[attempt-1], true
+}
+
+// DumpRetryBackoff is the reverse of ParseRetryBackoff.
+func (this *TopicSla) DumpRetryBackoff() string {
+	r := make([]string, len(this.RetryBackoff))
+	for i, d := range this.RetryBackoff {
+		r[i] = d.String()
+	}
+	return strings.Join(r, ",")
+}
+
 // Dump the sla for kafka-topics.sh as arguments.
 func (this *TopicSla) DumpForCreateTopic() []string {
 	r := make([]string, 0)
@@ -3,6 +3,7 @@ package sla
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/funkygao/assert"
 )
@@ -61,3 +62,27 @@ func TestValidateShadowName(t *testing.T) {
 	assert.Equal(t, false, ValidateShadowName(""))
 	assert.Equal(t, false, ValidateShadowName("foo"))
 }
+
+func TestSlaRetryBackoff(t *testing.T) {
+	sla := DefaultSla()
+	_, ok := sla.RetryDelay(1)
+	assert.Equal(t, false, ok)
+
+	assert.Equal(t, nil, sla.ParseRetryBackoff("10s, 1m,10m"))
+	assert.Equal(t, false, sla.IsDefault())
+	assert.Equal(t, "10s,1m0s,10m0s", sla.DumpRetryBackoff())
+	d, ok := sla.RetryDelay(2)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, time.Minute, d)
+	_, ok = sla.RetryDelay(0)
+	assert.Equal(t, false, ok)
+	_, ok = sla.RetryDelay(4)
+	assert.Equal(t, false, ok)
+
+	assert.Equal(t, ErrEmptyArg, sla.ParseRetryBackoff(""))
+	assert.Equal(t, ErrNotDuration, sla.ParseRetryBackoff("10s,abc"))
+	assert.Equal(t, ErrNegative, sla.ParseRetryBackoff("-1s"))
+	assert.Equal(t, ErrTooLongBackoff, sla.ParseRetryBackoff("10000h"))
+	assert.Equal(t, ErrTooManyRetries, sla.ParseRetryBackoff("1s,1s,1s,1s,1s,1s,1s,1s,1s,1s,1s"))
+	assert.Equal(t, 3, len(sla.RetryBackoff)) // unchanged on error
+}
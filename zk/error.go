@@ -8,4 +8,9 @@ var (
 	ErrDupConnect      = errors.New("connect while being connected")
 	ErrClaimedByOthers = errors.New("claimed by others")
 	ErrNotClaimed      = errors.New("release non-claimed")
+	ErrInvalidRetries  = errors.New("invalid webhook retries")
+	ErrInvalidBackoff  = errors.New("invalid webhook backoff")
+	ErrInvalidTemplate = errors.New("invalid webhook template")
+	ErrInvalidMode     = errors.New("invalid webhook delivery mode")
+	ErrInvalidWorkers  = errors.New("invalid webhook workers")
 )
@@ -51,6 +51,99 @@ type ConsumerMeta struct {
 type WebhookMeta struct {
 	Cluster   string   `json:"cluster"`
 	Endpoints []string `json:"endpoints"`
+	Filter    string   `json:"filter,omitempty"`  // tag filter expression
+	Retries   *int     `json:"retries,omitempty"` // max retry attempts of a failed delivery, nil means default and 0 no retry
+	Backoff   string   `json:"backoff,omitempty"` // backoff of the 1st retry doubled on each attempt, e,g. 1s
+	Dead      string   `json:"dead,omitempty"`    // dead letter shadow topic, empty means drop
+
+	Appid    string            `json:"appid,omitempty"`    // subscriber whose secret signs the deliveries
+	Template string            `json:"template,omitempty"` // post body template, empty means raw
+	Index    string            `json:"index,omitempty"`    // elasticsearch index of es template, empty means the topic
+	Headers  map[string]string `json:"headers,omitempty"`  // custom http headers of each delivery
+
+	Mode    string `json:"mode,omitempty"`    // delivery mode, empty means ordered
+	Workers int    `json:"workers,omitempty"` // concurrent deliveries, 0 means default
+}
+
+// Webhook delivery modes.
+const (
+	WebhookModeOrdered  = "ordered"  // messages of a partition are delivered in order
+	WebhookModeParallel = "parallel" // messages are delivered concurrently without order
+	WebhookModeFanout   = "fanout"   // each endpoint gets every message in order with its own cursor
+
+	DefaultWebhookWorkers = 4 // partition lanes of ordered mode, or workers of parallel mode
+	MaxWebhookWorkers     = 64
+)
+
+// Webhook post body templates.
+const (
+	WebhookTemplateRaw  = "raw"  // the message body as is
+	WebhookTemplateJson = "json" // json envelope with key/partition/offset of the message
+	WebhookTemplateEs   = "es"   // elasticsearch bulk index format, the message body must be a json object
+)
+
+// Webhook delivery retry policy.
+const (
+	DefaultWebhookRetries = 3
+	MaxWebhookRetries     = 10
+	DefaultWebhookBackoff = time.Second
+	MaxWebhookBackoff     = time.Minute // the doubled backoff never exceeds it
+)
+
+// RetryPolicy returns the max retry attempts of a failed delivery and the backoff of the 1st retry.
+func (this *WebhookMeta) RetryPolicy() (retries int, backoff time.Duration, err error) {
+	retries, backoff = DefaultWebhookRetries, DefaultWebhookBackoff
+	if this.Retries != nil {
+		if retries = *this.Retries; retries < 0 || retries > MaxWebhookRetries {
+			return 0, 0, ErrInvalidRetries
+		}
+	}
+
+	if this.Backoff != "" {
+		if backoff, err = time.ParseDuration(this.Backoff); err != nil || backoff <= 0 || backoff > MaxWebhookBackoff {
+			return 0, 0, ErrInvalidBackoff
+		}
+	}
+
+	return
+}
+
+// BodyTemplate returns the post body template of the deliveries.
+func (this *WebhookMeta) BodyTemplate() (string, error) {
+	switch this.Template {
+	case "":
+		return WebhookTemplateRaw, nil
+
+	case WebhookTemplateRaw, WebhookTemplateJson, WebhookTemplateEs:
+		return this.Template, nil
+
+	default:
+		return "", ErrInvalidTemplate
+	}
+}
+
+// DeliveryMode returns the delivery mode and the number of concurrent deliveries.
+// In ordered and fanout mode, partitions are spread over the workers.
+func (this *WebhookMeta) DeliveryMode() (mode string, workers int, err error) {
+	switch this.Mode {
+	case "":
+		mode = WebhookModeOrdered
+
+	case WebhookModeOrdered, WebhookModeParallel, WebhookModeFanout:
+		mode = this.Mode
+
+	default:
+		return "", 0, ErrInvalidMode
+	}
+
+	workers = DefaultWebhookWorkers
+	if this.Workers < 0 || this.Workers > MaxWebhookWorkers {
+		return "", 0, ErrInvalidWorkers
+	} else if this.Workers > 0 {
+		workers = this.Workers
+	}
+
+	return
 }
 
 func (this *WebhookMeta) From(b []byte) error {
@@ -62,6 +155,36 @@ func (this *WebhookMeta) Bytes() []byte {
 	return b
 }
 
+// RetryMeta is the retry policy of a retry shadow topic.
+type RetryMeta struct {
+	Cluster string `json:"cluster"`
+	Backoff string `json:"backoff"` // sla retry.backoff, e,g. 10s,1m,10m
+	Dead    string `json:"dead"`    // dead letter shadow topic
+}
+
+func (this *RetryMeta) From(b []byte) error {
+	return json.Unmarshal(b, this)
+}
+
+func (this *RetryMeta) Bytes() []byte {
+	b, _ := json.Marshal(this)
+	return b
+}
+
+// XaMeta is the XA config of a topic registered through the man server.
+type XaMeta struct {
+	Checkback string `json:"checkback"` // producer url to resolve the prepared messages
+}
+
+func (this *XaMeta) From(b []byte) error {
+	return json.Unmarshal(b, this)
+}
+
+func (this *XaMeta) Bytes() []byte {
+	b, _ := json.Marshal(this)
+	return b
+}
+
 type ControllerMeta struct {
 	Broker *BrokerZnode
 	Mtime  ZkTimestamp
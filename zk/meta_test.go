@@ -2,6 +2,7 @@ package zk
 
 import (
 	"testing"
+	"time"
 
 	"github.com/funkygao/assert"
 	log "github.com/funkygao/log4go"
@@ -49,4 +50,57 @@ func TestWebhook(t *testing.T) {
 	hook.Cluster = "trade"
 	hook.Endpoints = []string{"http://localhost:9876"}
 	t.Logf("%s", string(hook.Bytes()))
+
+	retries, backoff, err := hook.RetryPolicy()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, DefaultWebhookRetries, retries)
+	assert.Equal(t, DefaultWebhookBackoff, backoff)
+
+	n := 5
+	hook.Retries, hook.Backoff = &n, "200ms"
+	retries, backoff, err = hook.RetryPolicy()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 5, retries)
+	assert.Equal(t, time.Millisecond*200, backoff)
+
+	// retries turned off
+	n = 0
+	retries, _, err = hook.RetryPolicy()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, retries)
+
+	n = MaxWebhookRetries + 1
+	_, _, err = hook.RetryPolicy()
+	assert.Equal(t, ErrInvalidRetries, err)
+
+	n, hook.Backoff = 1, "1h"
+	_, _, err = hook.RetryPolicy()
+	assert.Equal(t, ErrInvalidBackoff, err)
+
+	tpl, err := hook.BodyTemplate()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, WebhookTemplateRaw, tpl)
+	hook.Template = WebhookTemplateEs
+	tpl, err = hook.BodyTemplate()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, WebhookTemplateEs, tpl)
+	hook.Template = "xml"
+	_, err = hook.BodyTemplate()
+	assert.Equal(t, ErrInvalidTemplate, err)
+
+	mode, workers, err := hook.DeliveryMode()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, WebhookModeOrdered, mode)
+	assert.Equal(t, DefaultWebhookWorkers, workers)
+	hook.Mode, hook.Workers = WebhookModeFanout, 2
+	mode, workers, err = hook.DeliveryMode()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, WebhookModeFanout, mode)
+	assert.Equal(t, 2, workers)
+	hook.Workers = MaxWebhookWorkers + 1
+	_, _, err = hook.DeliveryMode()
+	assert.Equal(t, ErrInvalidWorkers, err)
+	hook.Mode = "random"
+	_, _, err = hook.DeliveryMode()
+	assert.Equal(t, ErrInvalidMode, err)
 }
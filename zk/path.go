@@ -11,6 +11,8 @@ const (
 	KatewayIdsRoot     = "/_kateway/ids"
 	katewayMetricsRoot = "/_kateway/metrics"
 	KatewayMysqlPath   = "/_kateway/mysql"
+	KatewaySchemas     = "/_kateway/schemas"
+	KatewayAcls        = "/_kateway/acls"
 
 	PubsubJobConfig      = "/_kateway/orchestrator/jobconfig"
 	PubsubJobQueues      = "/_kateway/orchestrator/jobs"
@@ -19,6 +21,9 @@ const (
 	PubsubWebhooks       = "/_kateway/orchestrator/webhooks"
 	PubsubWebhooksOff    = "/_kateway/orchestrator/webhooks_off"
 	PubsubWebhookOwners  = "/_kateway/orchestrator/actors/webhook_owners"
+	PubsubRetries        = "/_kateway/orchestrator/retries"
+	PubsubRetryOwners    = "/_kateway/orchestrator/actors/retry_owners"
+	PubsubXaCheckbacks   = "/_kateway/orchestrator/xa_checkbacks"
 	//PubsubActorRebalance = "/_kateway/orchestrator/rebalance"
 
 	KguardLeaderPath = "_kguard/leader"
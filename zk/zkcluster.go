@@ -677,6 +677,23 @@ func (this *ZkCluster) ResetConsumerGroupOffset(topic, group, partition string,
 	return this.zone.setZnode(path, []byte(data))
 }
 
+// InitConsumerGroupOffset creates the offset of a consumer group on a topic partition if not committed yet,
+// an existing offset is kept.
+func (this *ZkCluster) InitConsumerGroupOffset(topic, group, partition string, offset int64) error {
+	path := this.consumerGroupOffsetOfTopicPartitionPath(group, topic, partition)
+	this.zone.connectIfNeccessary()
+	if err := this.zone.ensureParentDirExists(path); err != nil {
+		return err
+	}
+
+	data := fmt.Sprintf("%d", offset)
+	if err := this.zone.createZnode(path, []byte(data)); err != nil && err != zk.ErrNodeExists {
+		return err
+	}
+
+	return nil
+}
+
 func (this *ZkCluster) ListChildren(recursive bool) ([]string, error) {
 	excludedPaths := map[string]struct{}{
 		"/zookeeper": {},
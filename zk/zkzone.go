@@ -295,6 +295,142 @@ func (this *Orchestrator) WebhookInfo(topic string) (*WebhookMeta, error) {
 	return hook, err
 }
 
+// WatchWebhook returns the webhook of a topic and watches its change, zk.ErrNoNode if not found.
+func (this *Orchestrator) WatchWebhook(topic string) (*WebhookMeta, <-chan zk.Event, error) {
+	this.connectIfNeccessary()
+
+	path := fmt.Sprintf("%s/%s", PubsubWebhooks, topic)
+	data, _, c, err := this.conn.GetW(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var hook = &WebhookMeta{}
+	if err = hook.From(data); err != nil {
+		return nil, nil, err
+	}
+	return hook, c, nil
+}
+
+func (this *ZkZone) DeleteWebhook(topic string) error {
+	this.connectIfNeccessary()
+
+	path := fmt.Sprintf("%s/%s", PubsubWebhooks, topic)
+	return this.conn.Delete(path, -1)
+}
+
+func (this *ZkZone) CreateOrUpdateRetry(topic string, retry RetryMeta) error {
+	this.connectIfNeccessary()
+
+	path := fmt.Sprintf("%s/%s", PubsubRetries, topic)
+	this.ensureParentDirExists(path)
+
+	data := retry.Bytes()
+	err := this.createZnode(path, data)
+	if err == zk.ErrNodeExists {
+		return this.setZnode(path, data)
+	}
+	return err
+}
+
+// RetryInfo returns the retry policy of a retry shadow topic, zk.ErrNoNode if not found.
+func (this *ZkZone) RetryInfo(topic string) (*RetryMeta, error) {
+	this.connectIfNeccessary()
+
+	path := fmt.Sprintf("%s/%s", PubsubRetries, topic)
+	data, _, err := this.conn.Get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var retry = &RetryMeta{}
+	err = retry.From(data)
+	return retry, err
+}
+
+func (this *ZkZone) CreateOrUpdateXa(topic string, xa XaMeta) error {
+	this.connectIfNeccessary()
+
+	path := fmt.Sprintf("%s/%s", PubsubXaCheckbacks, topic)
+	this.ensureParentDirExists(path)
+
+	data := xa.Bytes()
+	err := this.createZnode(path, data)
+	if err == zk.ErrNodeExists {
+		return this.setZnode(path, data)
+	}
+	return err
+}
+
+// XaInfo returns the XA config of a topic, zk.ErrNoNode if not found.
+func (this *ZkZone) XaInfo(topic string) (*XaMeta, error) {
+	this.connectIfNeccessary()
+
+	path := fmt.Sprintf("%s/%s", PubsubXaCheckbacks, topic)
+	data, _, err := this.conn.Get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var xa = &XaMeta{}
+	err = xa.From(data)
+	return xa, err
+}
+
+// SchemaSubject returns the data and znode version of a schema subject, zk.ErrNoNode if not found.
+func (this *ZkZone) SchemaSubject(subject string) ([]byte, int32, error) {
+	this.connectIfNeccessary()
+
+	path := fmt.Sprintf("%s/%s", KatewaySchemas, subject)
+	data, stat, err := this.conn.Get(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return data, stat.Version, nil
+}
+
+// SetSchemaSubject creates a schema subject if version is -1, otherwise updates it
+// only if its znode version is still version, zk.ErrNodeExists or zk.ErrBadVersion if not.
+func (this *ZkZone) SetSchemaSubject(subject string, data []byte, version int32) error {
+	this.connectIfNeccessary()
+
+	path := fmt.Sprintf("%s/%s", KatewaySchemas, subject)
+	if version == -1 {
+		this.ensureParentDirExists(path)
+		return this.createZnode(path, data)
+	}
+
+	_, err := this.conn.Set(path, data, version)
+	return err
+}
+
+// KatewayAcls returns the data and znode version of the kateway ACL rules, zk.ErrNoNode if not found.
+func (this *ZkZone) KatewayAcls() ([]byte, int32, error) {
+	this.connectIfNeccessary()
+
+	data, stat, err := this.conn.Get(KatewayAcls)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return data, stat.Version, nil
+}
+
+// SetKatewayAcls creates the kateway ACL rules if version is -1, otherwise updates them
+// only if the znode version is still version, zk.ErrNodeExists or zk.ErrBadVersion if not.
+func (this *ZkZone) SetKatewayAcls(data []byte, version int32) error {
+	this.connectIfNeccessary()
+
+	if version == -1 {
+		this.ensureParentDirExists(KatewayAcls)
+		return this.createZnode(KatewayAcls, data)
+	}
+
+	_, err := this.conn.Set(KatewayAcls, data, version)
+	return err
+}
+
 func (this *ZkZone) LoadKatewayMetrics(katewayId string, key string) ([]byte, error) {
 	this.connectIfNeccessary()
 